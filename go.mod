@@ -6,6 +6,7 @@ require (
 	github.com/DavidCai1993/request v0.0.0-20171115020405-aad722fa9b76
 	github.com/doug-martin/goqu/v9 v9.10.0
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/golang/protobuf v1.4.3
 	github.com/open-trust/ot-go-lib v0.3.0
 	github.com/opentracing/opentracing-go v1.2.0 // indirect
 	github.com/stretchr/testify v1.5.1
@@ -14,5 +15,7 @@ require (
 	github.com/teambition/gear-tracing v1.1.1
 	go.uber.org/dig v1.10.0
 	golang.org/x/tools v0.0.0-20200917221617-d56e4e40bc9d // indirect
+	google.golang.org/grpc v1.29.1
+	google.golang.org/protobuf v1.23.0
 	gopkg.in/yaml.v2 v2.3.0
 )
@@ -9,6 +9,7 @@ import (
 	"github.com/teambition/urbs-setting/src/api"
 	"github.com/teambition/urbs-setting/src/conf"
 	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/rpc"
 )
 
 var help = flag.Bool("help", false, "show help info")
@@ -28,6 +29,13 @@ func main() {
 
 	app := api.NewApp()
 	ctx := conf.Config.GlobalCtx
+	api.RunJobs(ctx)
+	if conf.Config.GRPCAddr != "" {
+		go func() {
+			logging.Infof("Urbs-Setting gRPC start on %s", conf.Config.GRPCAddr)
+			logging.Errf("Urbs-Setting gRPC closed %v", rpc.ListenWithContext(ctx, conf.Config.GRPCAddr))
+		}()
+	}
 	host := "http://" + conf.Config.SrvAddr
 	if conf.Config.CertFile != "" && conf.Config.KeyFile != "" {
 		host = "https://" + conf.Config.SrvAddr
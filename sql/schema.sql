@@ -35,6 +35,8 @@ CREATE TABLE IF NOT EXISTS `urbs`.`urbs_product` (
   `name` varchar(63) NOT NULL,
   `description` varchar(1022) NOT NULL DEFAULT '',
   `status` bigint NOT NULL  DEFAULT 0,
+  `conflict_policy` varchar(15) NOT NULL DEFAULT '',
+  `group_kinds` varchar(255) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_product_name` (`name`),
   KEY `idx_product_created_at` (`created_at`)
@@ -52,6 +54,8 @@ CREATE TABLE IF NOT EXISTS `urbs`.`urbs_label` (
   `clients` varchar(255) NOT NULL DEFAULT '', -- split by comma
   `status` bigint NOT NULL DEFAULT 0,
   `rls` bigint NOT NULL DEFAULT 0,
+  `min_version` varchar(31) NOT NULL DEFAULT '',
+  `max_version` varchar(31) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_label_product_id_name` (`product_id`,`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -82,6 +86,14 @@ CREATE TABLE IF NOT EXISTS `urbs`.`urbs_setting` (
   `vals` varchar(1022) NOT NULL DEFAULT '', -- split by comma
   `status` bigint NOT NULL DEFAULT 0,
   `rls` bigint NOT NULL DEFAULT 0,
+  `prereq_id` bigint NOT NULL DEFAULT 0,
+  `prereq_value` varchar(255) NOT NULL DEFAULT '',
+  `default_value` varchar(255) NOT NULL DEFAULT '',
+  `default_overrides` varchar(1022) NOT NULL DEFAULT '', -- JSON array
+  `value_type` varchar(15) NOT NULL DEFAULT 'string',
+  `value_schema` varchar(1022) NOT NULL DEFAULT '', -- JSON Schema
+  `min_version` varchar(31) NOT NULL DEFAULT '',
+  `max_version` varchar(31) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_setting_module_id_name` (`module_id`,`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -103,9 +115,12 @@ CREATE TABLE IF NOT EXISTS `urbs`.`user_label` (
   `user_id` bigint NOT NULL,
   `label_id` bigint NOT NULL,
   `rls` bigint NOT NULL DEFAULT 0,
+  `rule_id` bigint NOT NULL DEFAULT 0,
+  `bucket` int NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_user_label_user_id_label_id` (`user_id`,`label_id`),
-  KEY `idx_user_label_label_id` (`label_id`)
+  KEY `idx_user_label_label_id` (`label_id`),
+  KEY `idx_user_label_rule_id_bucket` (`rule_id`,`bucket`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
 
 CREATE TABLE IF NOT EXISTS `urbs`.`user_setting` (
@@ -117,9 +132,24 @@ CREATE TABLE IF NOT EXISTS `urbs`.`user_setting` (
   `value` varchar(255) NOT NULL DEFAULT '',
   `last_value` varchar(255) NOT NULL DEFAULT '',
   `rls` bigint NOT NULL DEFAULT 0,
+  `rule_id` bigint NOT NULL DEFAULT 0,
+  `bucket` int NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_user_setting_user_id_setting_id` (`user_id`,`setting_id`),
-  KEY `idx_user_setting_setting_id` (`setting_id`)
+  KEY `idx_user_setting_setting_id` (`setting_id`),
+  KEY `idx_user_setting_rule_id_bucket` (`rule_id`,`bucket`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`user_attribute` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `user_id` bigint NOT NULL,
+  `name` varchar(63) NOT NULL,
+  `value` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_user_attribute_user_id_name` (`user_id`,`name`),
+  KEY `idx_user_attribute_name_value` (`name`,`value`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
 
 CREATE TABLE IF NOT EXISTS `urbs`.`group_label` (
@@ -128,9 +158,12 @@ CREATE TABLE IF NOT EXISTS `urbs`.`group_label` (
   `group_id` bigint NOT NULL,
   `label_id` bigint NOT NULL,
   `rls` bigint NOT NULL DEFAULT 0,
+  `rule_id` bigint NOT NULL DEFAULT 0,
+  `bucket` int NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_group_label_group_id_label_id` (`group_id`,`label_id`),
-  KEY `idx_group_label_label_id` (`label_id`)
+  KEY `idx_group_label_label_id` (`label_id`),
+  KEY `idx_group_label_rule_id_bucket` (`rule_id`,`bucket`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
 
 CREATE TABLE IF NOT EXISTS `urbs`.`group_setting` (
@@ -142,9 +175,12 @@ CREATE TABLE IF NOT EXISTS `urbs`.`group_setting` (
   `value` varchar(255) NOT NULL DEFAULT '',
   `last_value` varchar(255) NOT NULL DEFAULT '',
   `rls` bigint NOT NULL DEFAULT 0,
+  `rule_id` bigint NOT NULL DEFAULT 0,
+  `bucket` int NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_group_setting_group_id_setting_id` (`group_id`,`setting_id`),
-  KEY `idx_group_setting_setting_id` (`setting_id`)
+  KEY `idx_group_setting_setting_id` (`setting_id`),
+  KEY `idx_group_setting_rule_id_bucket` (`rule_id`,`bucket`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
 
 CREATE TABLE IF NOT EXISTS `urbs`.`label_rule` (
@@ -156,6 +192,9 @@ CREATE TABLE IF NOT EXISTS `urbs`.`label_rule` (
   `kind` varchar(63) NOT NULL,
   `rule` varchar(1022) NOT NULL DEFAULT '',
   `rls` bigint NOT NULL DEFAULT 0,
+  `start_at` datetime(3) DEFAULT NULL,
+  `end_at` datetime(3) DEFAULT NULL,
+  `salt` varchar(63) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_label_rule_label_id_kind` (`label_id`,`kind`),
   KEY `idx_label_rule_product_id` (`product_id`),
@@ -172,12 +211,113 @@ CREATE TABLE IF NOT EXISTS `urbs`.`setting_rule` (
   `rule` varchar(1022) NOT NULL DEFAULT '',
   `value` varchar(255) NOT NULL DEFAULT '',
   `rls` bigint NOT NULL DEFAULT 0,
+  `start_at` datetime(3) DEFAULT NULL,
+  `end_at` datetime(3) DEFAULT NULL,
+  `salt` varchar(63) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_setting_rule_setting_id_kind` (`setting_id`,`kind`),
   KEY `idx_setting_rule_product_id` (`product_id`),
   KEY `idx_setting_rule_setting_id` (`setting_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
 
+CREATE TABLE IF NOT EXISTS `urbs`.`setting_variant` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `setting_id` bigint NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `size` int NOT NULL DEFAULT 0,
+  `hash` varchar(63) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_setting_variant_setting_id_name` (`setting_id`,`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`setting_override` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `setting_id` bigint NOT NULL,
+  `channel` varchar(31) NOT NULL DEFAULT '',
+  `client` varchar(31) NOT NULL DEFAULT '',
+  `value` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_setting_override_setting_id_channel_client` (`setting_id`,`channel`,`client`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`rule_ramp` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `target` varchar(15) NOT NULL,
+  `rule_id` bigint NOT NULL,
+  `steps` varchar(255) NOT NULL DEFAULT '',
+  `step_interval` bigint NOT NULL DEFAULT 0,
+  `step` int NOT NULL DEFAULT 0,
+  `status` varchar(15) NOT NULL,
+  `next_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  PRIMARY KEY (`id`),
+  KEY `idx_rule_ramp_target_rule_id` (`target`,`rule_id`),
+  KEY `idx_rule_ramp_status_next_at` (`status`,`next_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`rule_ramp_log` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `ramp_id` bigint NOT NULL,
+  `action` varchar(15) NOT NULL,
+  `value` decimal(5,2) NOT NULL DEFAULT 0,
+  `rls` bigint NOT NULL DEFAULT 0,
+  `message` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `idx_rule_ramp_log_ramp_id` (`ramp_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`rule_backfill` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `target` varchar(15) NOT NULL,
+  `rule_id` bigint NOT NULL,
+  `status` varchar(15) NOT NULL,
+  `cursor_id` bigint NOT NULL DEFAULT 0,
+  `total` bigint NOT NULL DEFAULT 0,
+  `processed` bigint NOT NULL DEFAULT 0,
+  `applied` bigint NOT NULL DEFAULT 0,
+  `batch_size` int NOT NULL DEFAULT 1000,
+  `batch_interval` bigint NOT NULL DEFAULT 1000,
+  `next_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `message` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `idx_rule_backfill_target_rule_id` (`target`,`rule_id`),
+  KEY `idx_rule_backfill_status_next_at` (`status`,`next_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`urbs_layer` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `product_id` bigint NOT NULL,
+  `name` varchar(63) NOT NULL,
+  `description` varchar(1022) NOT NULL DEFAULT '',
+  `salt` varchar(63) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_layer_product_id_name` (`product_id`,`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`layer_experiment` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `layer_id` bigint NOT NULL,
+  `target` varchar(15) NOT NULL,
+  `target_id` bigint NOT NULL,
+  `bucket_start` int NOT NULL DEFAULT 0,
+  `bucket_end` int NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_layer_experiment_target_target_id` (`target`,`target_id`),
+  KEY `idx_layer_experiment_layer_id` (`layer_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
 CREATE TABLE IF NOT EXISTS `urbs`.`urbs_statistic` (
   `id` bigint NOT NULL AUTO_INCREMENT,
   `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
@@ -196,3 +336,14 @@ CREATE TABLE IF NOT EXISTS `urbs`.`urbs_lock` (
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_urbs_lock_name` (`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`change_event` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `product_id` bigint NOT NULL DEFAULT 0,
+  `user_id` bigint NOT NULL DEFAULT 0,
+  `group_id` bigint NOT NULL DEFAULT 0,
+  `kind` varchar(15) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_change_event_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,160 @@
+CREATE TABLE IF NOT EXISTS `urbs`.`user_attribute` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `user_id` bigint NOT NULL,
+  `name` varchar(63) NOT NULL,
+  `value` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_user_attribute_user_id_name` (`user_id`,`name`),
+  KEY `idx_user_attribute_name_value` (`name`,`value`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+ALTER TABLE `label_rule` ADD COLUMN `start_at` datetime(3) DEFAULT NULL;
+ALTER TABLE `label_rule` ADD COLUMN `end_at` datetime(3) DEFAULT NULL;
+ALTER TABLE `setting_rule` ADD COLUMN `start_at` datetime(3) DEFAULT NULL;
+ALTER TABLE `setting_rule` ADD COLUMN `end_at` datetime(3) DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`rule_ramp` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `target` varchar(15) NOT NULL,
+  `rule_id` bigint NOT NULL,
+  `steps` varchar(255) NOT NULL DEFAULT '',
+  `step_interval` bigint NOT NULL DEFAULT 0,
+  `step` int NOT NULL DEFAULT 0,
+  `status` varchar(15) NOT NULL,
+  `next_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  PRIMARY KEY (`id`),
+  KEY `idx_rule_ramp_target_rule_id` (`target`,`rule_id`),
+  KEY `idx_rule_ramp_status_next_at` (`status`,`next_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`rule_ramp_log` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `ramp_id` bigint NOT NULL,
+  `action` varchar(15) NOT NULL,
+  `value` decimal(5,2) NOT NULL DEFAULT 0,
+  `rls` bigint NOT NULL DEFAULT 0,
+  `message` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `idx_rule_ramp_log_ramp_id` (`ramp_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- salt 为空的已有规则按 legacy 模式分桶，保持其已覆盖的用户不变
+ALTER TABLE `label_rule` ADD COLUMN `salt` varchar(63) NOT NULL DEFAULT '';
+ALTER TABLE `setting_rule` ADD COLUMN `salt` varchar(63) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS `urbs`.`urbs_layer` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `product_id` bigint NOT NULL,
+  `name` varchar(63) NOT NULL,
+  `description` varchar(1022) NOT NULL DEFAULT '',
+  `salt` varchar(63) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_layer_product_id_name` (`product_id`,`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`layer_experiment` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `layer_id` bigint NOT NULL,
+  `target` varchar(15) NOT NULL,
+  `target_id` bigint NOT NULL,
+  `bucket_start` int NOT NULL DEFAULT 0,
+  `bucket_end` int NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_layer_experiment_target_target_id` (`target`,`target_id`),
+  KEY `idx_layer_experiment_layer_id` (`layer_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS `urbs`.`rule_backfill` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `target` varchar(15) NOT NULL,
+  `rule_id` bigint NOT NULL,
+  `status` varchar(15) NOT NULL,
+  `cursor_id` bigint NOT NULL DEFAULT 0,
+  `total` bigint NOT NULL DEFAULT 0,
+  `processed` bigint NOT NULL DEFAULT 0,
+  `applied` bigint NOT NULL DEFAULT 0,
+  `batch_size` int NOT NULL DEFAULT 1000,
+  `batch_interval` bigint NOT NULL DEFAULT 1000,
+  `next_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `message` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `idx_rule_backfill_target_rule_id` (`target`,`rule_id`),
+  KEY `idx_rule_backfill_status_next_at` (`status`,`next_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- 记录由发布规则写入的指派来源，已有记录视为直接指派，不会被规则回收
+ALTER TABLE `user_label` ADD COLUMN `rule_id` bigint NOT NULL DEFAULT 0, ADD COLUMN `bucket` int NOT NULL DEFAULT 0,
+  ADD KEY `idx_user_label_rule_id_bucket` (`rule_id`,`bucket`);
+ALTER TABLE `user_setting` ADD COLUMN `rule_id` bigint NOT NULL DEFAULT 0, ADD COLUMN `bucket` int NOT NULL DEFAULT 0,
+  ADD KEY `idx_user_setting_rule_id_bucket` (`rule_id`,`bucket`);
+
+-- 记录由 groupPercent 发布规则写入的群组指派来源
+ALTER TABLE `group_label` ADD COLUMN `rule_id` bigint NOT NULL DEFAULT 0, ADD COLUMN `bucket` int NOT NULL DEFAULT 0,
+  ADD KEY `idx_group_label_rule_id_bucket` (`rule_id`,`bucket`);
+ALTER TABLE `group_setting` ADD COLUMN `rule_id` bigint NOT NULL DEFAULT 0, ADD COLUMN `bucket` int NOT NULL DEFAULT 0,
+  ADD KEY `idx_group_setting_rule_id_bucket` (`rule_id`,`bucket`);
+
+-- 配置项的前置条件：依赖同一产品线下另一配置项取指定值
+ALTER TABLE `urbs_setting` ADD COLUMN `prereq_id` bigint NOT NULL DEFAULT 0, ADD COLUMN `prereq_value` varchar(255) NOT NULL DEFAULT '';
+
+-- 配置项的默认值，及按 channel、client 覆盖的默认值
+ALTER TABLE `urbs_setting` ADD COLUMN `default_value` varchar(255) NOT NULL DEFAULT '', ADD COLUMN `default_overrides` varchar(1022) NOT NULL DEFAULT '';
+
+-- 配置项的值类型及约束
+ALTER TABLE `urbs_setting` ADD COLUMN `value_type` varchar(15) NOT NULL DEFAULT 'string', ADD COLUMN `value_schema` varchar(1022) NOT NULL DEFAULT '';
+
+-- 配置项的变体内容，指派值为变体名称时随配置项下发
+CREATE TABLE IF NOT EXISTS `urbs`.`setting_variant` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `setting_id` bigint NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `size` int NOT NULL DEFAULT 0,
+  `hash` varchar(63) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_setting_variant_setting_id_name` (`setting_id`,`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- 配置项按 channel、client 覆盖的值
+CREATE TABLE IF NOT EXISTS `urbs`.`setting_override` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+  `setting_id` bigint NOT NULL,
+  `channel` varchar(31) NOT NULL DEFAULT '',
+  `client` varchar(31) NOT NULL DEFAULT '',
+  `value` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_setting_override_setting_id_channel_client` (`setting_id`,`channel`,`client`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- 环境标签、配置项适用的客户端版本范围
+ALTER TABLE `urbs_label` ADD COLUMN `min_version` varchar(31) NOT NULL DEFAULT '', ADD COLUMN `max_version` varchar(31) NOT NULL DEFAULT '';
+ALTER TABLE `urbs_setting` ADD COLUMN `min_version` varchar(31) NOT NULL DEFAULT '', ADD COLUMN `max_version` varchar(31) NOT NULL DEFAULT '';
+
+-- 产品线的用户与群组配置项指派冲突优先策略
+ALTER TABLE `urbs_product` ADD COLUMN `conflict_policy` varchar(15) NOT NULL DEFAULT '', ADD COLUMN `group_kinds` varchar(255) NOT NULL DEFAULT '';
+
+-- 用户环境标签和配置项的变更事件，用于推送变更通知
+CREATE TABLE IF NOT EXISTS `urbs`.`change_event` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  `product_id` bigint NOT NULL DEFAULT 0,
+  `user_id` bigint NOT NULL DEFAULT 0,
+  `group_id` bigint NOT NULL DEFAULT 0,
+  `kind` varchar(15) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_change_event_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -1,12 +1,15 @@
 package api
 
 import (
+	"context"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/teambition/gear"
 	tracing "github.com/teambition/gear-tracing"
 
+	"github.com/teambition/urbs-setting/src/bll"
 	"github.com/teambition/urbs-setting/src/logging"
 	"github.com/teambition/urbs-setting/src/util"
 )
@@ -79,3 +82,18 @@ func NewApp() *gear.App {
 
 	return app
 }
+
+// RunJobs 启动后台定时任务，如规则的渐进发布计划，ctx 结束时退出
+func RunJobs(ctx context.Context) {
+	err := util.DigInvoke(func(blls *bll.Blls) error {
+		go blls.RuleRamp.Run(ctx, 10*time.Second)
+		go blls.RuleBackfill.Run(ctx, time.Second)
+		go blls.RuleSchedule.Run(ctx, 10*time.Second)
+		go blls.Change.Run(ctx, time.Second)
+		return nil
+	})
+
+	if err != nil {
+		logging.Panicf("DigInvoke error: %v", err)
+	}
+}
@@ -49,10 +49,19 @@ func TestMain(m *testing.M) {
 	tt.DB.Exec("TRUNCATE TABLE user_group;")
 	tt.DB.Exec("TRUNCATE TABLE user_label;")
 	tt.DB.Exec("TRUNCATE TABLE user_setting;")
+	tt.DB.Exec("TRUNCATE TABLE user_attribute;")
 	tt.DB.Exec("TRUNCATE TABLE group_label;")
 	tt.DB.Exec("TRUNCATE TABLE group_setting;")
 	tt.DB.Exec("TRUNCATE TABLE label_rule;")
 	tt.DB.Exec("TRUNCATE TABLE setting_rule;")
+	tt.DB.Exec("TRUNCATE TABLE setting_variant;")
+	tt.DB.Exec("TRUNCATE TABLE setting_override;")
+	tt.DB.Exec("TRUNCATE TABLE rule_ramp;")
+	tt.DB.Exec("TRUNCATE TABLE rule_ramp_log;")
+	tt.DB.Exec("TRUNCATE TABLE rule_backfill;")
+	tt.DB.Exec("TRUNCATE TABLE urbs_layer;")
+	tt.DB.Exec("TRUNCATE TABLE layer_experiment;")
+	tt.DB.Exec("TRUNCATE TABLE change_event;")
 	tt.DB.Exec("TRUNCATE TABLE urbs_statistic;")
 	tt.DB.Exec("TRUNCATE TABLE urbs_lock;")
 	cleanup()
@@ -22,5 +22,10 @@ func TestHealthzAPIs(t *testing.T) {
 		json := map[string]interface{}{}
 		res.JSON(&json)
 		assert.True(json["dbConnect"].(bool))
+
+		cache := json["entityCache"].(map[string]interface{})
+		assert.True(cache["enabled"].(bool))
+		assert.GreaterOrEqual(cache["hits"].(float64), float64(0))
+		assert.GreaterOrEqual(cache["misses"].(float64), float64(0))
 	})
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/teambition/gear"
 	"github.com/teambition/urbs-setting/src/bll"
 	"github.com/teambition/urbs-setting/src/dto"
+	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/service"
 	"github.com/teambition/urbs-setting/src/tpl"
 )
@@ -171,6 +172,26 @@ func (a *Label) CreateRule(ctx *gear.Context) error {
 	return ctx.OkJSON(res)
 }
 
+// EstimateRule 预估发布规则创建后命中的用户数，不会创建规则
+func (a *Label) EstimateRule(ctx *gear.Context) error {
+	req := tpl.ProductLabelURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.LabelRuleBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Label.EstimateRule(ctx, req.Product, req.Label, body)
+	if err != nil {
+		return err
+	}
+
+	return ctx.OkJSON(res)
+}
+
 // ListRules ..
 func (a *Label) ListRules(ctx *gear.Context) error {
 	req := tpl.ProductLabelURL{}
@@ -227,6 +248,144 @@ func (a *Label) DeleteRule(ctx *gear.Context) error {
 	return ctx.OkJSON(res)
 }
 
+// CreateRuleRamp 为环境标签发布规则创建渐进发布计划
+func (a *Label) CreateRuleRamp(ctx *gear.Context) error {
+	req := tpl.ProductLabelHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "label_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid label_rule hid: %s", req.HID)
+	}
+
+	body := tpl.RuleRampBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Label.CreateRuleRamp(ctx, req.Product, req.Label, ruleID, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// GetRuleRamp 返回环境标签发布规则最近的渐进发布计划及其执行历史
+func (a *Label) GetRuleRamp(ctx *gear.Context) error {
+	req := tpl.ProductLabelHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "label_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid label_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Label.GetRuleRamp(ctx, req.Product, req.Label, ruleID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// PauseRuleRamp ..
+func (a *Label) PauseRuleRamp(ctx *gear.Context) error {
+	return a.updateRuleRampStatus(ctx, schema.RampActionPause)
+}
+
+// ResumeRuleRamp ..
+func (a *Label) ResumeRuleRamp(ctx *gear.Context) error {
+	return a.updateRuleRampStatus(ctx, schema.RampActionResume)
+}
+
+// AbortRuleRamp ..
+func (a *Label) AbortRuleRamp(ctx *gear.Context) error {
+	return a.updateRuleRampStatus(ctx, schema.RampActionAbort)
+}
+
+func (a *Label) updateRuleRampStatus(ctx *gear.Context, action string) error {
+	req := tpl.ProductLabelHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "label_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid label_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Label.UpdateRuleRampStatus(ctx, req.Product, req.Label, ruleID, action)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// CreateRuleBackfill 为环境标签发布规则创建回填任务，立即执行第一个批次
+func (a *Label) CreateRuleBackfill(ctx *gear.Context) error {
+	req := tpl.ProductLabelHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "label_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid label_rule hid: %s", req.HID)
+	}
+
+	body := tpl.RuleBackfillBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Label.CreateRuleBackfill(ctx, req.Product, req.Label, ruleID, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// GetRuleBackfill 返回环境标签发布规则最近的回填任务及其进度
+func (a *Label) GetRuleBackfill(ctx *gear.Context) error {
+	req := tpl.ProductLabelHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "label_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid label_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Label.GetRuleBackfill(ctx, req.Product, req.Label, ruleID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// CancelRuleBackfill 取消环境标签发布规则执行中的回填任务
+func (a *Label) CancelRuleBackfill(ctx *gear.Context) error {
+	req := tpl.ProductLabelHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "label_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid label_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Label.CancelRuleBackfill(ctx, req.Product, req.Label, ruleID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
 // ListUsers ..
 func (a *Label) ListUsers(ctx *gear.Context) error {
 	req := tpl.ProductLabelURL{}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -8,9 +9,11 @@ import (
 
 	"github.com/DavidCai1993/request"
 	"github.com/stretchr/testify/assert"
+	"github.com/teambition/urbs-setting/src/bll"
 	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/service"
 	"github.com/teambition/urbs-setting/src/tpl"
+	"github.com/teambition/urbs-setting/src/util"
 )
 
 func createLabel(tt *TestTools, productName string) (label schema.Label, err error) {
@@ -727,6 +730,7 @@ func TestLabelAPIs(t *testing.T) {
 			assert.True(data.CreatedAt.UTC().Unix() > int64(0))
 			assert.True(data.UpdatedAt.UTC().Unix() > int64(0))
 			assert.Equal(int64(1), data.Release)
+			assert.Equal("hash", data.Bucketing)
 
 			rule = data
 		})
@@ -864,6 +868,98 @@ func TestLabelAPIs(t *testing.T) {
 			assert.Equal(label.Name, json.Result[1].Label)
 		})
 
+		t.Run(`"POST /users/labels:cache" should apply rules in batch`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			users2, err := createUsers(tt, 2)
+			assert.Nil(err)
+			unknown := tpl.RandUID()
+
+			res, err := request.Post(fmt.Sprintf("%s/users/labels:cache", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersCacheLabelsBody{
+					Product: product.Name,
+					Users:   []string{users2[0].UID, users2[1].UID, users2[0].UID, "anon-" + user.UID, unknown},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			text, err := res.Text()
+			assert.Nil(err)
+			assert.False(strings.Contains(text, `"id"`))
+
+			json := tpl.UsersCacheLabelsRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(4, len(json.Result))
+
+			assert.Equal(users2[0].UID, json.Result[0].UID)
+			assert.Equal(2, len(json.Result[0].Labels))
+			assert.True(json.Result[0].Timestamp > 0)
+			assert.Equal(users2[1].UID, json.Result[1].UID)
+			assert.Equal(2, len(json.Result[1].Labels))
+
+			assert.Equal("anon-"+user.UID, json.Result[2].UID)
+			assert.Equal(2, len(json.Result[2].Labels))
+			assert.Equal(label2.Name, json.Result[2].Labels[0].Label)
+			assert.Equal(label.Name, json.Result[2].Labels[1].Label)
+
+			assert.Equal(unknown, json.Result[3].UID)
+			assert.Equal(0, len(json.Result[3].Labels))
+
+			// 已刷新的用户与单用户接口的结果一致
+			res, err = request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users2[1].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&json2)
+			assert.Nil(err)
+			assert.Equal(json.Result[1].Labels, json2.Result)
+		})
+
+		t.Run(`"POST /users/labels:cache" should return 400`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			uids := make([]string, tpl.MaxCacheLabelsUsers+1)
+			for i := range uids {
+				uids[i] = tpl.RandUID()
+			}
+			res, err := request.Post(fmt.Sprintf("%s/users/labels:cache", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersCacheLabelsBody{Product: product.Name, Users: uids}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/users/labels:cache", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersCacheLabelsBody{Product: product.Name}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+
+			// 与单用户接口一致，产品不存在时返回空环境标签列表
+			uid := tpl.RandUID()
+			res, err = request.Post(fmt.Sprintf("%s/users/labels:cache", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersCacheLabelsBody{Product: tpl.RandName(), Users: []string{uid}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.UsersCacheLabelsRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+			assert.Equal(uid, json.Result[0].UID)
+			assert.Equal(0, len(json.Result[0].Labels))
+		})
+
 		t.Run(`"GET /v1/products/:product/labels/:label/rules" should work`, func(t *testing.T) {
 			assert := assert.New(t)
 			res, err := request.Get(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
@@ -921,6 +1017,54 @@ func TestLabelAPIs(t *testing.T) {
 			assert.Equal(rule.LabelHID, data.LabelHID)
 			assert.Equal("userPercent", data.Kind)
 			assert.Equal(int64(2), data.Release)
+			assert.Equal("hash", data.Bucketing)
+		})
+
+		t.Run(`"PUT /v1/products/:product/labels/:label/rules/:hid" should switch bucketing`, func(t *testing.T) {
+			assert := assert.New(t)
+			for i, bucketing := range []string{"legacy", "hash"} {
+				res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, rule.HID)).
+					Set("Content-Type", "application/json").
+					Send(map[string]interface{}{
+						"kind":      "userPercent",
+						"rule":      map[string]interface{}{"value": 1},
+						"bucketing": bucketing,
+					}).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+
+				json := tpl.LabelRuleInfoRes{}
+				_, err = res.JSON(&json)
+				assert.Nil(err)
+				assert.Equal(bucketing, json.Result.Bucketing)
+				assert.Equal(int64(3+i), json.Result.Release)
+			}
+
+			// legacy 分桶按整数百分比分桶，不支持小数百分比
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":      "userPercent",
+					"rule":      map[string]interface{}{"value": 0.01},
+					"bucketing": "legacy",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":      "userPercent",
+					"rule":      map[string]interface{}{"value": 0.01},
+					"bucketing": "md5",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
 		})
 
 		t.Run(`"DELETE /v1/products/:product/labels/:label/rules/:hid" should work`, func(t *testing.T) {
@@ -991,4 +1135,1003 @@ func TestLabelAPIs(t *testing.T) {
 			assert.False(json.Result)
 		})
 	})
+
+	t.Run(`label attribute rules`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		label, err := createLabel(tt, product.Name)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 2)
+		assert.Nil(t, err)
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules" should return 400 with invalid conditions`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userAttribute",
+					"rule": map[string]interface{}{
+						"value": 100,
+					},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules" should work with userAttribute`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userAttribute",
+					"rule": map[string]interface{}{
+						"value": 100,
+						"conditions": []map[string]interface{}{
+							{"attr": "uid", "op": "in", "values": []string{users[0].UID}},
+						},
+					},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			data := json.Result
+			assert.Equal("userAttribute", data.Kind)
+			assert.Equal(int64(1), data.Release)
+		})
+
+		t.Run(`"GET /users/:uid/labels:cache" should apply userAttribute rules`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+			assert.Equal(label.Name, json.Result[0].Label)
+
+			res, err = request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users[1].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json = tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(0, len(json.Result))
+		})
+
+		t.Run(`changing conditions should revoke all rule assignments`, func(t *testing.T) {
+			assert := assert.New(t)
+			url := fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)
+			res, err := request.Get(url).End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			rules := tpl.LabelRulesInfoRes{}
+			res.JSON(&rules)
+			assert.Equal(1, len(rules.Result))
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ? and `rule_id` > 0", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(1), count)
+
+			// 百分比不变，只改变 conditions，users[0] 不再命中规则
+			res, err = request.Put(fmt.Sprintf("%s/%s", url, rules.Result[0].HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userAttribute",
+					"rule": map[string]interface{}{
+						"value": 100,
+						"conditions": []map[string]interface{}{
+							{"attr": "uid", "op": "in", "values": []string{users[1].UID}},
+						},
+					},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ? and `rule_id` > 0", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+		})
+	})
+
+	t.Run(`label scheduled rules`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		label, err := createLabel(tt, product.Name)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		startAt := time.Now().UTC().Add(time.Hour)
+		var rule tpl.LabelRuleInfo
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules" should return 400 with invalid schedule`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":    "userPercent",
+					"rule":    map[string]interface{}{"value": 100},
+					"startAt": startAt,
+					"endAt":   startAt.Add(-time.Minute),
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules" should work with startAt`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":    "userPercent",
+					"rule":    map[string]interface{}{"value": 100},
+					"startAt": startAt,
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			rule = json.Result
+			assert.Equal("pending", rule.State)
+			assert.Equal(startAt.Unix(), rule.StartAt.Unix())
+			assert.Nil(rule.EndAt)
+		})
+
+		t.Run(`"GET /users/:uid/labels:cache" should skip pending rules`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(0, len(json.Result))
+		})
+
+		t.Run(`"PUT /v1/products/:product/labels/:label/rules/:hid" should clear schedule`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 100},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			assert.Equal("active", json.Result.State)
+			assert.Nil(json.Result.StartAt)
+			assert.Equal(int64(2), json.Result.Release)
+		})
+
+		t.Run(`assigned user should lose the label after endAt`, func(t *testing.T) {
+			assert := assert.New(t)
+			users, err := createUsers(tt, 1)
+			assert.Nil(err)
+
+			res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+
+			endAt := time.Now().UTC().Add(2 * time.Second)
+			res, err = request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":  "userPercent",
+					"rule":  map[string]interface{}{"value": 100},
+					"endAt": endAt,
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			time.Sleep(time.Until(endAt.Truncate(time.Second)) + 100*time.Millisecond)
+			err = util.DigInvoke(func(blls *bll.Blls) error {
+				assert.True(blls.RuleSchedule.RunDue(context.Background()) > 0)
+				return nil
+			})
+			assert.Nil(err)
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ? and `rule_id` > 0", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+
+			res, err = request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json = tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(0, len(json.Result))
+		})
+	})
+
+	t.Run(`label rule ramp`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		label, err := createLabel(tt, product.Name)
+		assert.Nil(t, err)
+
+		var rule tpl.LabelRuleInfo
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules/:hid/ramp" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 0},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			rule = json.Result
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(tpl.RuleRampBody{Steps: []float64{0.5, 50, 100}, Interval: "1h"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.RuleRampInfoRes{}
+			res.JSON(&json2)
+			data := json2.Result
+			assert.Equal(rule.HID, data.RuleHID)
+			assert.Equal([]float64{0.5, 50, 100}, data.Steps)
+			assert.Equal("running", data.Status)
+			assert.Equal(1, data.Step)
+			assert.NotNil(data.NextAt)
+			assert.Equal(2, len(data.History))
+			assert.Equal("start", data.History[0].Action)
+			assert.Equal("step", data.History[1].Action)
+			assert.Equal(0.5, data.History[1].Value)
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				End()
+			assert.Nil(err)
+			text, err := res.Text()
+			assert.Nil(err)
+			assert.True(strings.Contains(text, `"rule":{"value":0.5}`))
+		})
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules/:hid/ramp" should return 409 when running`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(tpl.RuleRampBody{Steps: []float64{10, 100}, Interval: "1h"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(409, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules/:hid/ramp" should return 400 for fractional steps with legacy bucketing`, func(t *testing.T) {
+			assert := assert.New(t)
+			label, err := createLabel(tt, product.Name)
+			assert.Nil(err)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":      "userPercent",
+					"rule":      map[string]interface{}{"value": 0},
+					"bucketing": "legacy",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp", tt.Host, product.Name, label.Name, json.Result.HID)).
+				Set("Content-Type", "application/json").
+				Send(tpl.RuleRampBody{Steps: []float64{0.5, 1.5, 2.5}, Interval: "1h"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`rule ramp should abort when the rule switches to legacy bucketing`, func(t *testing.T) {
+			assert := assert.New(t)
+			label, err := createLabel(tt, product.Name)
+			assert.Nil(err)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 0},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			rule := json.Result
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(tpl.RuleRampBody{Steps: []float64{1, 1.5, 100}, Interval: "1h"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":      "userPercent",
+					"rule":      map[string]interface{}{"value": 1},
+					"bucketing": "legacy",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			_, err = tt.DB.Exec("update `rule_ramp` set `next_at` = ? where `target` = ? and `rule_id` = ?",
+				time.Now().UTC().Add(-time.Second), schema.TableLabelRule, service.HIDToID(rule.HID, "label_rule"))
+			assert.Nil(err)
+			err = util.DigInvoke(func(blls *bll.Blls) error {
+				assert.Equal(0, blls.RuleRamp.RunDue(context.Background()))
+				return nil
+			})
+			assert.Nil(err)
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.RuleRampInfoRes{}
+			res.JSON(&json2)
+			data := json2.Result
+			assert.Equal("aborted", data.Status)
+			assert.Equal("abort", data.History[len(data.History)-1].Action)
+		})
+
+		t.Run(`"PUT /v1/products/:product/labels/:label/rules/:hid/ramp:pause" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp:pause", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.RuleRampInfoRes{}
+			res.JSON(&json)
+			assert.Equal("paused", json.Result.Status)
+			assert.Equal("pause", json.Result.History[len(json.Result.History)-1].Action)
+		})
+
+		t.Run(`"PUT /v1/products/:product/labels/:label/rules/:hid/ramp:resume" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp:resume", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.RuleRampInfoRes{}
+			res.JSON(&json)
+			assert.Equal("running", json.Result.Status)
+		})
+
+		t.Run(`"PUT /v1/products/:product/labels/:label/rules/:hid/ramp:abort" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp:abort", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.RuleRampInfoRes{}
+			res.JSON(&json)
+			assert.Equal("aborted", json.Result.Status)
+			assert.Nil(json.Result.NextAt)
+
+			res, err = request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp:resume", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"GET /v1/products/:product/labels/:label/rules/:hid/ramp" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/ramp", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.RuleRampInfoRes{}
+			res.JSON(&json)
+			assert.Equal("aborted", json.Result.Status)
+			assert.Equal(5, len(json.Result.History))
+		})
+	})
+
+	t.Run(`label rule backfill`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		label, err := createLabel(tt, product.Name)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 2)
+		assert.Nil(t, err)
+
+		var rule tpl.LabelRuleInfo
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules/:hid/backfill" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			_, err := tt.DB.Exec("update `urbs_user` set `labels` = ? where `id` in (?, ?)", `{"x":{"l":[]}}`, users[0].ID, users[1].ID)
+			assert.Nil(err)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 100},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			rule = json.Result
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/backfill", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(tpl.RuleBackfillBody{BatchSize: 5000, Interval: "10s"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.RuleBackfillInfoRes{}
+			res.JSON(&json2)
+			data := json2.Result
+			assert.Equal(rule.HID, data.RuleHID)
+			assert.Equal("completed", data.Status)
+			assert.Equal("10s", data.Interval)
+			assert.True(data.Total >= int64(2))
+			assert.Equal(data.Total, data.Processed)
+			assert.Equal(data.Total, data.Applied)
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ?", label.ID)
+			assert.Nil(err)
+			assert.Equal(data.Total, count)
+
+			// 回填后清空了用户的环境标签缓存
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `urbs_user` where `id` in (?, ?) and `labels` = ''", users[0].ID, users[1].ID)
+			assert.Nil(err)
+			assert.Equal(int64(2), count)
+		})
+
+		t.Run(`"POST /v1/products/:product/labels/:label/rules/:hid/backfill" should return 400 with invalid body`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/backfill", tt.Host, product.Name, label.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(tpl.RuleBackfillBody{BatchSize: 10000}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"GET /v1/products/:product/labels/:label/rules/:hid/backfill" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/backfill", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.RuleBackfillInfoRes{}
+			res.JSON(&json)
+			assert.Equal("completed", json.Result.Status)
+			assert.Equal(100, json.Result.Progress)
+		})
+
+		t.Run(`"PUT /v1/products/:product/labels/:label/rules/:hid/backfill:cancel" should return 400 when completed`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s/backfill:cancel", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`label rule revoke`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		label, err := createLabel(tt, product.Name)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 2)
+		assert.Nil(t, err)
+
+		t.Run(`deleting the rule should revoke only rule-sourced users`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s:assign", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":     "userPercent",
+					"rule":     map[string]interface{}{"value": 100},
+					"backfill": map[string]interface{}{"batchSize": 5000},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			rule := json.Result
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ? and `rule_id` = ?", label.ID, service.HIDToID(rule.HID, "label_rule"))
+			assert.Nil(err)
+			assert.True(count >= int64(1))
+
+			res, err = request.Delete(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ?", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(1), count)
+
+			var userID int64
+			_, err = tt.DB.ScanVal(&userID, "select `user_id` from `user_label` where `label_id` = ?", label.ID)
+			assert.Nil(err)
+			assert.Equal(users[0].ID, userID)
+		})
+	})
+
+	t.Run(`label groupPercent rule`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		label, err := createLabel(tt, product.Name)
+		assert.Nil(t, err)
+
+		group, users, err := createGroupWithUsers(tt, 2)
+		assert.Nil(t, err)
+
+		var ruleHID string
+
+		t.Run(`should require groupKind`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "groupPercent",
+					"rule": map[string]interface{}{"value": 100},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should assign the label to selected groups`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "groupPercent",
+					"rule": map[string]interface{}{"value": 100, "groupKind": group.Kind},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+			ruleHID = json.Result.HID
+
+			var ruleID int64
+			_, err = tt.DB.ScanVal(&ruleID, "select `rule_id` from `group_label` where `group_id` = ? and `label_id` = ?", group.ID, label.ID)
+			assert.Nil(err)
+			assert.Equal(service.HIDToID(ruleHID, "label_rule"), ruleID)
+
+			// 群组成员通过群组继承环境标签
+			res, err = request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			labels := tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&labels)
+			assert.Nil(err)
+			assert.Equal(1, len(labels.Result))
+			assert.Equal(label.Name, labels.Result[0].Label)
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ?", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+		})
+
+		t.Run(`should apply to new groups`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			group2, err := createGroup(tt)
+			assert.Nil(err)
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `group_label` where `group_id` = ? and `label_id` = ?", group2.ID, label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(1), count)
+		})
+
+		t.Run(`lowering the percent should revoke groups`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules/%s", tt.Host, product.Name, label.Name, ruleHID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "groupPercent",
+					"rule": map[string]interface{}{"value": 0, "groupKind": group.Kind},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `group_label` where `label_id` = ?", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+		})
+	})
+
+	t.Run(`label rule bucketBy`, func(t *testing.T) {
+		t.Run(`"key" should bucket anonymous and logged-in users consistently`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			product, err := createProduct(tt)
+			assert.Nil(err)
+			label, err := createLabel(tt, product.Name)
+			assert.Nil(err)
+			users, err := createUsers(tt, 10)
+			assert.Nil(err)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 50, "bucketBy": "key"},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			listLabels := func(uid, key string) int {
+				res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s&key=%s", tt.Host, uid, product.Name, key)).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+
+				json := tpl.CacheLabelsInfoRes{}
+				_, err = res.JSON(&json)
+				assert.Nil(err)
+				return len(json.Result)
+			}
+
+			for _, user := range users {
+				key := tpl.RandUID()
+				assert.Equal(listLabels("anon-"+tpl.RandUID(), key), listLabels(user.UID, key))
+			}
+
+			// 网关批量读取时每个用户使用各自的 key，与单个用户读取的分桶一致
+			uids := make([]string, 0, 20)
+			keys := make(map[string]string, 20)
+			for i := 0; i < 20; i++ {
+				uid := "anon-" + tpl.RandUID()
+				uids = append(uids, uid)
+				keys[uid] = tpl.RandUID()
+			}
+			res, err = request.Post(fmt.Sprintf("%s/users/labels:cache", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersCacheLabelsBody{Product: product.Name, Users: uids, Keys: keys}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.UsersCacheLabelsRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(len(uids), len(json.Result))
+			for _, u := range json.Result {
+				assert.Equal(listLabels("anon-"+tpl.RandUID(), keys[u.UID]), len(u.Labels))
+			}
+
+			res, err = request.Post(fmt.Sprintf("%s/users/labels:cache", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersCacheLabelsBody{Product: product.Name, Users: uids[:1], Keys: map[string]string{tpl.RandUID(): tpl.RandUID()}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"attr:*" should not match users without the attribute`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			product, err := createProduct(tt)
+			assert.Nil(err)
+			label, err := createLabel(tt, product.Name)
+			assert.Nil(err)
+			users, err := createUsers(tt, 2)
+			assert.Nil(err)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/users:attributes", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersAttributesBody{Users: []tpl.UserAttributesBody{
+					{UID: users[0].UID, Attributes: map[string]string{"tenantId": "t1"}},
+				}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 100, "bucketBy": "attr:tenantId"},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			for i, user := range users {
+				res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, user.UID, product.Name)).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+
+				json := tpl.CacheLabelsInfoRes{}
+				_, err = res.JSON(&json)
+				assert.Nil(err)
+				assert.Equal(1-i, len(json.Result))
+			}
+		})
+
+		t.Run(`changing bucketBy should revoke all rule assignments`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			product, err := createProduct(tt)
+			assert.Nil(err)
+			label, err := createLabel(tt, product.Name)
+			assert.Nil(err)
+			users, err := createUsers(tt, 4)
+			assert.Nil(err)
+
+			url := fmt.Sprintf("%s/v1/products/%s/labels/%s", tt.Host, product.Name, label.Name)
+			res, err := request.Post(url+":assign").
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(url+"/rules").
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 100},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			json := tpl.LabelRuleInfoRes{}
+			res.JSON(&json)
+
+			for _, user := range users {
+				res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, user.UID, product.Name)).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+				res.Content() // close http client
+			}
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ? and `rule_id` > 0", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(3), count)
+
+			// 百分比不变，只改变 bucketBy，已有的分桶全部失效
+			res, err = request.Put(fmt.Sprintf("%s/rules/%s", url, json.Result.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 100, "bucketBy": "attr:tenantId"},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ? and `rule_id` > 0", label.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+
+			// 直接指派的记录不受影响
+			var ruleID int64
+			_, err = tt.DB.ScanVal(&ruleID, "select `rule_id` from `user_label` where `label_id` = ? and `user_id` = ?", label.ID, users[0].ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), ruleID)
+		})
+	})
+
+	t.Run(`label version range`, func(t *testing.T) {
+		label, err := createLabel(tt, product.Name)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		url := fmt.Sprintf("%s/v1/products/%s/labels/%s", tt.Host, product.Name, label.Name)
+		cachedLabels := func(query string) int {
+			res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s%s", tt.Host, users[0].UID, product.Name, query)).
+				End()
+			assert.Nil(t, err)
+			assert.Equal(t, 200, res.StatusCode)
+			json := tpl.CacheLabelsInfoRes{}
+			res.JSON(&json)
+			return len(json.Result)
+		}
+
+		t.Run(`should return 400 with invalid range`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			minVersion := "x.1"
+			res, err := request.Put(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.LabelUpdateBody{MinVersion: &minVersion}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+
+			maxVersion := "1.0.0"
+			res, err = request.Put(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.LabelUpdateBody{MaxVersion: &maxVersion}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			minVersion = "2.0.0"
+			res, err = request.Put(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.LabelUpdateBody{MinVersion: &minVersion}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should filter labels:cache by version`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			minVersion := "2.0.0"
+			maxVersion := ""
+			res, err := request.Put(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.LabelUpdateBody{MinVersion: &minVersion, MaxVersion: &maxVersion}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LabelInfoRes{}
+			res.JSON(&json)
+			assert.Equal("2.0.0", json.Result.MinVersion)
+			assert.Equal("", json.Result.MaxVersion)
+
+			res, err = request.Post(url+":assign").
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			text, err := res.Text()
+			assert.Nil(err)
+			assert.True(strings.Contains(text, `"minv":"2.0.0"`))
+
+			assert.Equal(1, cachedLabels("&version=2.1.0"))
+			assert.Equal(0, cachedLabels("&version=1.9.9"))
+
+			evaluated := func(query string) bool {
+				res, err := request.Get(fmt.Sprintf("%s/v1/users/%s:evaluate?product=%s%s", tt.Host, users[0].UID, product.Name, query)).
+					End()
+				assert.Nil(err)
+				json := tpl.UserEvaluationRes{}
+				res.JSON(&json)
+				for _, l := range json.Result.Labels {
+					if l.Label == label.Name {
+						return true
+					}
+				}
+				return false
+			}
+			assert.True(evaluated(""))
+			assert.True(evaluated("&version=2.1.0"))
+			assert.False(evaluated("&version=1.9.9"))
+
+			res, err = request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s&version=abc", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
 }
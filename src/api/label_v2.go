@@ -23,3 +23,22 @@ func (a *Label) AssignV2(ctx *gear.Context) error {
 	}
 	return ctx.OkJSON(tpl.LabelReleaseInfoRes{Result: *res})
 }
+
+// EstimateAssignV2 预估批量指派影响的用户数，不会写入指派记录
+func (a *Label) EstimateAssignV2(ctx *gear.Context) error {
+	req := tpl.ProductLabelURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.UsersGroupsBodyV2{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Label.EstimateAssign(ctx, req.Product, req.Label, body.Users, body.Groups)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/bll"
+	"github.com/teambition/urbs-setting/src/service"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// Layer ..
+type Layer struct {
+	blls *bll.Blls
+}
+
+// List ..
+func (a *Layer) List(ctx *gear.Context) error {
+	req := tpl.ProductPaginationURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+	res, err := a.blls.Layer.List(ctx, req.Product, req.Pagination)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// Create ..
+func (a *Layer) Create(ctx *gear.Context) error {
+	req := tpl.ProductURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.NameDescBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Layer.Create(ctx, req.Product, body.Name, body.Desc)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// Get ..
+func (a *Layer) Get(ctx *gear.Context) error {
+	req := tpl.ProductLayerURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+	res, err := a.blls.Layer.Get(ctx, req.Product, req.Layer)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// Update ..
+func (a *Layer) Update(ctx *gear.Context) error {
+	req := tpl.ProductLayerURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.LayerUpdateBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Layer.Update(ctx, req.Product, req.Layer, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// Delete ..
+func (a *Layer) Delete(ctx *gear.Context) error {
+	req := tpl.ProductLayerURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+	res, err := a.blls.Layer.Delete(ctx, req.Product, req.Layer)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// AddExperiment ..
+func (a *Layer) AddExperiment(ctx *gear.Context) error {
+	req := tpl.ProductLayerURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.LayerExperimentBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Layer.AddExperiment(ctx, req.Product, req.Layer, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// RemoveExperiment ..
+func (a *Layer) RemoveExperiment(ctx *gear.Context) error {
+	req := tpl.ProductLayerHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	experimentID := service.HIDToID(req.HID, "layer_experiment")
+	if experimentID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid layer_experiment hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Layer.RemoveExperiment(ctx, req.Product, req.Layer, experimentID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DavidCai1993/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/teambition/urbs-setting/src/service"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+func TestLayerAPIs(t *testing.T) {
+	tt, cleanup := SetUpTestTools()
+	defer cleanup()
+
+	product, err := createProduct(tt)
+	assert.Nil(t, err)
+
+	label1, err := createLabel(tt, product.Name)
+	assert.Nil(t, err)
+
+	label2, err := createLabel(tt, product.Name)
+	assert.Nil(t, err)
+
+	module, err := createModule(tt, product.Name)
+	assert.Nil(t, err)
+
+	setting, err := createSetting(tt, product.Name, module.Name, "a", "b")
+	assert.Nil(t, err)
+
+	users, err := createUsers(tt, 10)
+	assert.Nil(t, err)
+
+	name := tpl.RandName()
+	var experiment tpl.LayerExperimentInfo
+
+	t.Run(`"POST /v1/products/:product/layers"`, func(t *testing.T) {
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers", tt.Host, product.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.NameDescBody{Name: name, Desc: name}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			text, err := res.Text()
+			assert.Nil(err)
+			assert.False(strings.Contains(text, `"id"`))
+			assert.False(strings.Contains(text, `"salt"`))
+
+			json := tpl.LayerRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(name, json.Result.Name)
+			assert.Equal(name, json.Result.Desc)
+		})
+
+		t.Run(`should return 409`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers", tt.Host, product.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.NameDescBody{Name: name, Desc: name}).
+				End()
+			assert.Nil(err)
+			assert.Equal(409, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`"GET /v1/products/:product/layers"`, func(t *testing.T) {
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/v1/products/%s/layers", tt.Host, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LayersRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+			assert.Equal(1, json.TotalSize)
+			assert.Equal(name, json.Result[0].Name)
+		})
+	})
+
+	t.Run(`"PUT /v1/products/:product/layers/:layer"`, func(t *testing.T) {
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/layers/%s", tt.Host, product.Name, name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"desc": "abc"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LayerRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal("abc", json.Result.Desc)
+		})
+	})
+
+	t.Run(`"POST /v1/products/:product/layers/:layer/experiments"`, func(t *testing.T) {
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+			for _, label := range []string{label1.Name, label2.Name} {
+				res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers/%s/experiments", tt.Host, product.Name, name)).
+					Set("Content-Type", "application/json").
+					Send(map[string]interface{}{"label": label, "percent": 40}).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+
+				json := tpl.LayerExperimentInfoRes{}
+				_, err = res.JSON(&json)
+				assert.Nil(err)
+				assert.True(service.HIDToID(json.Result.HID, "layer_experiment") > int64(0))
+				assert.Equal(label, json.Result.Label)
+				assert.Equal(float64(40), json.Result.Percent)
+				experiment = json.Result
+			}
+			assert.Equal(4000, experiment.BucketStart)
+			assert.Equal(8000, experiment.BucketEnd)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers/%s/experiments", tt.Host, product.Name, name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"module": module.Name, "setting": setting.Name, "percent": 20}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LayerExperimentInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(module.Name, json.Result.Module)
+			assert.Equal(setting.Name, json.Result.Setting)
+			assert.Equal(8000, json.Result.BucketStart)
+			assert.Equal(10000, json.Result.BucketEnd)
+		})
+
+		t.Run("should return 409", func(t *testing.T) {
+			assert := assert.New(t)
+			// 已加入实验层
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers/%s/experiments", tt.Host, product.Name, name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"label": label1.Name, "percent": 10}).
+				End()
+			assert.Nil(err)
+			assert.Equal(409, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run("should return 400", func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers/%s/experiments", tt.Host, product.Name, name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"label": label1.Name, "percent": 0.001}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`"GET /v1/products/:product/layers/:layer"`, func(t *testing.T) {
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/v1/products/%s/layers/%s", tt.Host, product.Name, name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.LayerInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(name, json.Result.Name)
+			assert.Equal(float64(0), json.Result.FreePercent)
+			assert.Equal(3, len(json.Result.Experiments))
+			assert.Equal(label1.Name, json.Result.Experiments[0].Label)
+			assert.Equal(label2.Name, json.Result.Experiments[1].Label)
+			assert.Equal(setting.Name, json.Result.Experiments[2].Setting)
+		})
+	})
+
+	t.Run(`label rules in layer should be mutually exclusive`, func(t *testing.T) {
+		assert := assert.New(t)
+		for _, label := range []string{label1.Name, label2.Name} {
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s/rules", tt.Host, product.Name, label)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userPercent",
+					"rule": map[string]interface{}{"value": 100},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+		}
+
+		for _, user := range users {
+			res, err := request.Get(fmt.Sprintf("%s/users/%s/labels:cache?product=%s", tt.Host, user.UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.CacheLabelsInfoRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.True(len(json.Result) <= 1)
+		}
+	})
+
+	t.Run(`"DELETE /v1/products/:product/layers/:layer/experiments/:hid"`, func(t *testing.T) {
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Delete(fmt.Sprintf("%s/v1/products/%s/layers/%s/experiments/%s", tt.Host, product.Name, name, experiment.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.BoolRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.True(json.Result)
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/products/%s/layers/%s", tt.Host, product.Name, name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.LayerInfoRes{}
+			_, err = res.JSON(&json2)
+			assert.Nil(err)
+			assert.Equal(float64(40), json2.Result.FreePercent)
+			assert.Equal(2, len(json2.Result.Experiments))
+
+			// 移出实验层后规则按独立分桶重新计算，已写入的记录被回收
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_label` where `label_id` = ? and `rule_id` > 0", label2.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+		})
+	})
+
+	t.Run(`"DELETE /v1/products/:product/layers/:layer"`, func(t *testing.T) {
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Delete(fmt.Sprintf("%s/v1/products/%s/layers/%s", tt.Host, product.Name, name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.BoolRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.True(json.Result)
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/products/%s/layers/%s", tt.Host, product.Name, name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(404, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`joining a layer should re-bucket existing rule assignments`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		product, err := createProduct(tt)
+		assert.Nil(err)
+		module, err := createModule(tt, product.Name)
+		assert.Nil(err)
+		setting1, err := createSetting(tt, product.Name, module.Name, "a", "b")
+		assert.Nil(err)
+		setting2, err := createSetting(tt, product.Name, module.Name, "a", "b")
+		assert.Nil(err)
+		_, err = createUsers(tt, 20)
+		assert.Nil(err)
+
+		for _, setting := range []string{setting1.Name, setting2.Name} {
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":     "userPercent",
+					"value":    "b",
+					"rule":     map[string]interface{}{"value": 50},
+					"backfill": map[string]interface{}{"batchSize": 5000},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+		}
+
+		overlap := func() int64 {
+			var count int64
+			_, err := tt.DB.ScanVal(&count, "select count(*) from `user_setting` t1, `user_setting` t2 where t1.`user_id` = t2.`user_id` and t1.`setting_id` = ? and t2.`setting_id` = ? and t1.`rule_id` > 0 and t2.`rule_id` > 0",
+				setting1.ID, setting2.ID)
+			assert.Nil(err)
+			return count
+		}
+		// 独立分桶时两个实验有共同的用户
+		assert.True(overlap() > 0)
+
+		layer := tpl.RandName()
+		res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers", tt.Host, product.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.NameDescBody{Name: layer, Desc: layer}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		res.Content() // close http client
+
+		for _, setting := range []string{setting1.Name, setting2.Name} {
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/layers/%s/experiments", tt.Host, product.Name, layer)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"module": module.Name, "setting": setting, "percent": 50}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+		}
+
+		// 加入实验层后旧记录被回收，回填任务按实验层的分桶重新写入，两个实验互斥
+		assert.Equal(int64(0), overlap())
+		var count int64
+		_, err = tt.DB.ScanVal(&count, "select count(*) from `user_setting` where `setting_id` = ? and `rule_id` > 0", setting1.ID)
+		assert.Nil(err)
+		assert.True(count > 0)
+	})
+}
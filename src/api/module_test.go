@@ -230,4 +230,40 @@ func TestModuleAPIs(t *testing.T) {
 			assert.NotNil(s.OfflineAt)
 		})
 	})
+
+	t.Run("module name resolution should be cached and invalidated", func(t *testing.T) {
+		assert := assert.New(t)
+
+		product, err := createProduct(tt)
+		assert.Nil(err)
+		module, err := createModule(tt, product.Name)
+		assert.Nil(err)
+
+		cacheHits := func() float64 {
+			res, err := request.Get(fmt.Sprintf("%s/healthz", tt.Host)).End()
+			assert.Nil(err)
+			json := map[string]interface{}{}
+			res.JSON(&json)
+			return json["entityCache"].(map[string]interface{})["hits"].(float64)
+		}
+		listSettings := func() int {
+			res, err := request.Get(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings", tt.Host, product.Name, module.Name)).End()
+			assert.Nil(err)
+			res.Content()
+			return res.StatusCode
+		}
+
+		assert.Equal(200, listSettings())
+		hits := cacheHits()
+		assert.Equal(200, listSettings())
+		assert.True(cacheHits() > hits)
+
+		res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s:offline", tt.Host, product.Name, module.Name)).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		res.Content()
+
+		assert.Equal(404, listSettings())
+	})
 }
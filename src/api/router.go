@@ -22,6 +22,7 @@ type APIs struct {
 	Module  *Module
 	Setting *Setting
 	Label   *Label
+	Layer   *Layer
 }
 
 func newAPIs(blls *bll.Blls) *APIs {
@@ -33,6 +34,7 @@ func newAPIs(blls *bll.Blls) *APIs {
 		Module:  &Module{blls: blls},
 		Setting: &Setting{blls: blls},
 		Label:   &Label{blls: blls},
+		Layer:   &Layer{blls: blls},
 	}
 }
 
@@ -43,6 +45,8 @@ func newRouters(apis *APIs) []*gear.Router {
 	router.Get("/healthz", apis.Healthz.Get)
 	// 读取指定用户的环境标签，包括继承自群组的标签，返回轻量级 labels，无身份验证，用于网关
 	router.Get("/users/:uid/labels:cache", apis.User.ListCachedLabels)
+	// 批量读取多个用户在指定产品下的环境标签，返回轻量级 labels，无身份验证，用于网关
+	router.Post("/users/labels:cache", apis.User.BatchListCachedLabels)
 
 	routerV1 := gear.NewRouter(gear.RouterOptions{
 		Root: "/v1",
@@ -50,7 +54,7 @@ func newRouters(apis *APIs) []*gear.Router {
 	routerV1.Use(middleware.Auth)
 
 	// ***** user ******
-	// 读取用户列表，支持条件筛选
+	// 读取用户列表，支持条件筛选，支持按属性过滤
 	routerV1.Get("/users", apis.User.List)
 	// 读取指定用户的环境标签，支持条件筛选
 	routerV1.Get("/users/:uid/labels", apis.User.ListLabels)
@@ -60,10 +64,18 @@ func newRouters(apis *APIs) []*gear.Router {
 	routerV1.Get("/users/:uid/settings", apis.User.ListSettings)
 	// 读取指定用户的功能配置项，支持条件筛选，数据用于客户端
 	routerV1.Get("/users/:uid/settings:unionAll", apis.User.ListSettingsUnionAll)
+	// 以 Server-Sent Events 推送指定用户在产品下的环境标签和配置项变更
+	routerV1.Get("/users/:uid/changes:watch", apis.User.Watch)
+	// 只读评估指定用户在产品线下的环境标签和配置项及其来源，不写入指派记录
+	routerV1.Get("/users/:uid+:evaluate", apis.User.Evaluate)
 	// 查询指定用户是否存在
 	routerV1.Get("/users/:uid+:exists", apis.User.CheckExists)
 	// 批量添加用户
 	routerV1.Post("/users:batch", apis.User.BatchAdd)
+	// 读取指定用户的属性
+	routerV1.Get("/users/:uid/attributes", apis.User.ListAttributes)
+	// 批量添加或更新用户属性，用户不存在时会被创建
+	routerV1.Post("/users:attributes", apis.User.BatchUpsertAttributes)
 
 	// ***** group ******
 	// 读取指定群组的环境标签，支持条件筛选
@@ -139,12 +151,48 @@ func newRouters(apis *APIs) []*gear.Router {
 	routerV1.Delete("/products/:product/modules/:module/settings/:setting+:cleanup", apis.Setting.Cleanup)
 	// 创建指定产品功能模块配置项的灰度发布规则
 	routerV1.Post("/products/:product/modules/:module/settings/:setting/rules", apis.Setting.CreateRule)
+	// 预估指定产品功能模块配置项的灰度发布规则命中的用户数，不会创建规则
+	routerV1.Post("/products/:product/modules/:module/settings/:setting/rules:estimate", apis.Setting.EstimateRule)
 	// 更新指定产品功能模块配置项的指定灰度发布规则
 	routerV1.Put("/products/:product/modules/:module/settings/:setting/rules/:hid", apis.Setting.UpdateRule)
 	// 删除指定产品功能模块配置项的指定灰度发布规则
 	routerV1.Delete("/products/:product/modules/:module/settings/:setting/rules/:hid", apis.Setting.DeleteRule)
+	// 为产品功能模块配置项的发布规则创建渐进发布计划
+	routerV1.Post("/products/:product/modules/:module/settings/:setting/rules/:hid/ramp", apis.Setting.CreateRuleRamp)
+	// 读取产品功能模块配置项发布规则最近的渐进发布计划及其执行历史
+	routerV1.Get("/products/:product/modules/:module/settings/:setting/rules/:hid/ramp", apis.Setting.GetRuleRamp)
+	// 暂停产品功能模块配置项发布规则的渐进发布计划
+	routerV1.Put("/products/:product/modules/:module/settings/:setting/rules/:hid/ramp:pause", apis.Setting.PauseRuleRamp)
+	// 恢复产品功能模块配置项发布规则的渐进发布计划
+	routerV1.Put("/products/:product/modules/:module/settings/:setting/rules/:hid/ramp:resume", apis.Setting.ResumeRuleRamp)
+	// 终止产品功能模块配置项发布规则的渐进发布计划
+	routerV1.Put("/products/:product/modules/:module/settings/:setting/rules/:hid/ramp:abort", apis.Setting.AbortRuleRamp)
+	// 为产品功能模块配置项的发布规则创建回填任务，将规则应用到已有用户
+	routerV1.Post("/products/:product/modules/:module/settings/:setting/rules/:hid/backfill", apis.Setting.CreateRuleBackfill)
+	// 读取产品功能模块配置项发布规则最近的回填任务及其进度
+	routerV1.Get("/products/:product/modules/:module/settings/:setting/rules/:hid/backfill", apis.Setting.GetRuleBackfill)
+	// 取消产品功能模块配置项发布规则执行中的回填任务
+	routerV1.Put("/products/:product/modules/:module/settings/:setting/rules/:hid/backfill:cancel", apis.Setting.CancelRuleBackfill)
 	// 读取指定产品功能模块配置项的灰度发布规则列表
 	routerV1.Get("/products/:product/modules/:module/settings/:setting/rules", apis.Setting.ListRules)
+	// 读取指定产品功能模块配置项的变体列表，不包含变体内容
+	routerV1.Get("/products/:product/modules/:module/settings/:setting/variants", apis.Setting.ListVariants)
+	// 为指定产品功能模块配置项的可选值创建变体内容
+	routerV1.Post("/products/:product/modules/:module/settings/:setting/variants", apis.Setting.CreateVariant)
+	// 读取指定产品功能模块配置项的变体及其内容
+	routerV1.Get("/products/:product/modules/:module/settings/:setting/variants/:variant", apis.Setting.GetVariant)
+	// 更新指定产品功能模块配置项的变体内容
+	routerV1.Put("/products/:product/modules/:module/settings/:setting/variants/:variant", apis.Setting.UpdateVariant)
+	// 删除指定产品功能模块配置项的变体
+	routerV1.Delete("/products/:product/modules/:module/settings/:setting/variants/:variant", apis.Setting.DeleteVariant)
+	// 读取指定产品功能模块配置项按 channel、client 覆盖的值
+	routerV1.Get("/products/:product/modules/:module/settings/:setting/overrides", apis.Setting.ListOverrides)
+	// 为指定产品功能模块配置项创建按 channel、client 覆盖的值
+	routerV1.Post("/products/:product/modules/:module/settings/:setting/overrides", apis.Setting.CreateOverride)
+	// 更新指定产品功能模块配置项按 channel、client 覆盖的值
+	routerV1.Put("/products/:product/modules/:module/settings/:setting/overrides/:hid", apis.Setting.UpdateOverride)
+	// 删除指定产品功能模块配置项按 channel、client 覆盖的值
+	routerV1.Delete("/products/:product/modules/:module/settings/:setting/overrides/:hid", apis.Setting.DeleteOverride)
 	// 读取指定产品功能模块配置项的用户列表
 	routerV1.Get("/products/:product/modules/:module/settings/:setting/users", apis.Setting.ListUsers)
 	// 回滚指定用户的指定配置项
@@ -158,6 +206,22 @@ func newRouters(apis *APIs) []*gear.Router {
 	// 移除指定群组的指定配置项
 	routerV1.Delete("/products/:product/modules/:module/settings/:setting/groups/:uid", apis.Setting.DeleteGroup)
 
+	// ***** layer ******
+	// 读取产品的实验层列表
+	routerV1.Get("/products/:product/layers", apis.Layer.List)
+	// 创建产品的实验层
+	routerV1.Post("/products/:product/layers", apis.Layer.Create)
+	// 读取指定实验层及其实验列表
+	routerV1.Get("/products/:product/layers/:layer", apis.Layer.Get)
+	// 更新指定实验层
+	routerV1.Put("/products/:product/layers/:layer", apis.Layer.Update)
+	// 删除指定实验层，其中的环境标签和配置项恢复为独立分桶
+	routerV1.Delete("/products/:product/layers/:layer", apis.Layer.Delete)
+	// 将环境标签或配置项加入实验层
+	routerV1.Post("/products/:product/layers/:layer/experiments", apis.Layer.AddExperiment)
+	// 将实验移出实验层
+	routerV1.Delete("/products/:product/layers/:layer/experiments/:hid", apis.Layer.RemoveExperiment)
+
 	// ***** label ******
 	// 读取指定产品环境标签
 	routerV1.Get("/products/:product/labels", apis.Label.List)
@@ -179,12 +243,30 @@ func newRouters(apis *APIs) []*gear.Router {
 	routerV1.Delete("/products/:product/labels/:label+:cleanup", apis.Label.Cleanup)
 	// 创建指定产品环境标签的灰度发布规则
 	routerV1.Post("/products/:product/labels/:label/rules", apis.Label.CreateRule)
+	// 预估指定产品环境标签的灰度发布规则命中的用户数，不会创建规则
+	routerV1.Post("/products/:product/labels/:label/rules:estimate", apis.Label.EstimateRule)
 	// 读取指定产品环境标签的灰度发布规则列表
 	routerV1.Get("/products/:product/labels/:label/rules", apis.Label.ListRules)
 	// 更新指定产品环境标签的指定灰度发布规则
 	routerV1.Put("/products/:product/labels/:label/rules/:hid", apis.Label.UpdateRule)
 	// 删除指定产品环境标签的指定灰度发布规则
 	routerV1.Delete("/products/:product/labels/:label/rules/:hid", apis.Label.DeleteRule)
+	// 为产品环境标签的发布规则创建渐进发布计划
+	routerV1.Post("/products/:product/labels/:label/rules/:hid/ramp", apis.Label.CreateRuleRamp)
+	// 读取产品环境标签发布规则最近的渐进发布计划及其执行历史
+	routerV1.Get("/products/:product/labels/:label/rules/:hid/ramp", apis.Label.GetRuleRamp)
+	// 暂停产品环境标签发布规则的渐进发布计划
+	routerV1.Put("/products/:product/labels/:label/rules/:hid/ramp:pause", apis.Label.PauseRuleRamp)
+	// 恢复产品环境标签发布规则的渐进发布计划
+	routerV1.Put("/products/:product/labels/:label/rules/:hid/ramp:resume", apis.Label.ResumeRuleRamp)
+	// 终止产品环境标签发布规则的渐进发布计划
+	routerV1.Put("/products/:product/labels/:label/rules/:hid/ramp:abort", apis.Label.AbortRuleRamp)
+	// 为产品环境标签的发布规则创建回填任务，将规则应用到已有用户
+	routerV1.Post("/products/:product/labels/:label/rules/:hid/backfill", apis.Label.CreateRuleBackfill)
+	// 读取产品环境标签发布规则最近的回填任务及其进度
+	routerV1.Get("/products/:product/labels/:label/rules/:hid/backfill", apis.Label.GetRuleBackfill)
+	// 取消产品环境标签发布规则执行中的回填任务
+	routerV1.Put("/products/:product/labels/:label/rules/:hid/backfill:cancel", apis.Label.CancelRuleBackfill)
 	// 读取指定产品环境标签的用户列表
 	routerV1.Get("/products/:product/labels/:label/users", apis.Label.ListUsers)
 	// 移除指定用户的指定环境标签
@@ -205,9 +287,13 @@ func newRoutersV2(apis *APIs) *gear.Router {
 	// ***** label ******
 	// 批量为用户或群组设置产品环境标签
 	routerV1.Post("/products/:product/labels/:label+:assign", apis.Label.AssignV2)
+	// 预估批量为用户或群组设置产品环境标签影响的用户数
+	routerV1.Post("/products/:product/labels/:label+:estimate", apis.Label.EstimateAssignV2)
 	// ***** setting ******
 	// 批量为用户或群组设置产品功能模块配置项
 	routerV1.Post("/products/:product/modules/:module/settings/:setting+:assign", apis.Setting.AssignV2)
+	// 预估批量为用户或群组设置产品功能模块配置项影响的用户数
+	routerV1.Post("/products/:product/modules/:module/settings/:setting+:estimate", apis.Setting.EstimateAssignV2)
 
 	return routerV1
 }
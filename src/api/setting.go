@@ -4,6 +4,7 @@ import (
 	"github.com/teambition/gear"
 	"github.com/teambition/urbs-setting/src/bll"
 	"github.com/teambition/urbs-setting/src/dto"
+	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/service"
 	"github.com/teambition/urbs-setting/src/tpl"
 )
@@ -197,6 +198,26 @@ func (a *Setting) CreateRule(ctx *gear.Context) error {
 	return ctx.OkJSON(res)
 }
 
+// EstimateRule 预估发布规则创建后命中的用户数，不会创建规则
+func (a *Setting) EstimateRule(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.SettingRuleBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Setting.EstimateRule(ctx, req.Product, req.Module, req.Setting, body)
+	if err != nil {
+		return err
+	}
+
+	return ctx.OkJSON(res)
+}
+
 // ListRules ..
 func (a *Setting) ListRules(ctx *gear.Context) error {
 	req := tpl.ProductModuleSettingURL{}
@@ -253,6 +274,144 @@ func (a *Setting) DeleteRule(ctx *gear.Context) error {
 	return ctx.OkJSON(res)
 }
 
+// CreateRuleRamp 为配置项发布规则创建渐进发布计划
+func (a *Setting) CreateRuleRamp(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "setting_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_rule hid: %s", req.HID)
+	}
+
+	body := tpl.RuleRampBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Setting.CreateRuleRamp(ctx, req.Product, req.Module, req.Setting, ruleID, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// GetRuleRamp 返回配置项发布规则最近的渐进发布计划及其执行历史
+func (a *Setting) GetRuleRamp(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "setting_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Setting.GetRuleRamp(ctx, req.Product, req.Module, req.Setting, ruleID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// PauseRuleRamp ..
+func (a *Setting) PauseRuleRamp(ctx *gear.Context) error {
+	return a.updateRuleRampStatus(ctx, schema.RampActionPause)
+}
+
+// ResumeRuleRamp ..
+func (a *Setting) ResumeRuleRamp(ctx *gear.Context) error {
+	return a.updateRuleRampStatus(ctx, schema.RampActionResume)
+}
+
+// AbortRuleRamp ..
+func (a *Setting) AbortRuleRamp(ctx *gear.Context) error {
+	return a.updateRuleRampStatus(ctx, schema.RampActionAbort)
+}
+
+func (a *Setting) updateRuleRampStatus(ctx *gear.Context, action string) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "setting_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Setting.UpdateRuleRampStatus(ctx, req.Product, req.Module, req.Setting, ruleID, action)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// CreateRuleBackfill 为配置项发布规则创建回填任务，立即执行第一个批次
+func (a *Setting) CreateRuleBackfill(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "setting_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_rule hid: %s", req.HID)
+	}
+
+	body := tpl.RuleBackfillBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Setting.CreateRuleBackfill(ctx, req.Product, req.Module, req.Setting, ruleID, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// GetRuleBackfill 返回配置项发布规则最近的回填任务及其进度
+func (a *Setting) GetRuleBackfill(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "setting_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Setting.GetRuleBackfill(ctx, req.Product, req.Module, req.Setting, ruleID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// CancelRuleBackfill 取消配置项发布规则执行中的回填任务
+func (a *Setting) CancelRuleBackfill(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	ruleID := service.HIDToID(req.HID, "setting_rule")
+	if ruleID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_rule hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Setting.CancelRuleBackfill(ctx, req.Product, req.Module, req.Setting, ruleID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
 // ListUsers ..
 func (a *Setting) ListUsers(ctx *gear.Context) error {
 	req := tpl.ProductModuleSettingURL{}
@@ -334,3 +493,155 @@ func (a *Setting) DeleteGroup(ctx *gear.Context) error {
 	}
 	return ctx.OkJSON(res)
 }
+
+// ListVariants ..
+func (a *Setting) ListVariants(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+	res, err := a.blls.Setting.ListVariants(ctx, req.Product, req.Module, req.Setting)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// CreateVariant ..
+func (a *Setting) CreateVariant(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.SettingVariantBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Setting.CreateVariant(ctx, req.Product, req.Module, req.Setting, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// GetVariant ..
+func (a *Setting) GetVariant(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingVariantURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+	res, err := a.blls.Setting.GetVariant(ctx, req.Product, req.Module, req.Setting, req.Variant)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// UpdateVariant ..
+func (a *Setting) UpdateVariant(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingVariantURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.SettingVariantUpdateBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Setting.UpdateVariant(ctx, req.Product, req.Module, req.Setting, req.Variant, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// DeleteVariant ..
+func (a *Setting) DeleteVariant(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingVariantURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+	res, err := a.blls.Setting.DeleteVariant(ctx, req.Product, req.Module, req.Setting, req.Variant)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// ListOverrides ..
+func (a *Setting) ListOverrides(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+	res, err := a.blls.Setting.ListOverrides(ctx, req.Product, req.Module, req.Setting)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// CreateOverride ..
+func (a *Setting) CreateOverride(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	body := tpl.SettingOverrideBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Setting.CreateOverride(ctx, req.Product, req.Module, req.Setting, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// UpdateOverride ..
+func (a *Setting) UpdateOverride(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	overrideID := service.HIDToID(req.HID, "setting_override")
+	if overrideID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_override hid: %s", req.HID)
+	}
+
+	body := tpl.SettingOverrideBody{}
+	if err := ctx.ParseBody(&body); err != nil {
+		return err
+	}
+
+	res, err := a.blls.Setting.UpdateOverride(ctx, req.Product, req.Module, req.Setting, overrideID, body)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
+
+// DeleteOverride ..
+func (a *Setting) DeleteOverride(ctx *gear.Context) error {
+	req := tpl.ProductModuleSettingHIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	overrideID := service.HIDToID(req.HID, "setting_override")
+	if overrideID <= 0 {
+		return gear.ErrBadRequest.WithMsgf("invalid setting_override hid: %s", req.HID)
+	}
+
+	res, err := a.blls.Setting.DeleteOverride(ctx, req.Product, req.Module, req.Setting, overrideID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkJSON(res)
+}
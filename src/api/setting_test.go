@@ -8,6 +8,7 @@ import (
 
 	"github.com/DavidCai1993/request"
 	"github.com/stretchr/testify/assert"
+	"github.com/teambition/urbs-setting/src/conf"
 	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/service"
 	"github.com/teambition/urbs-setting/src/tpl"
@@ -45,6 +46,25 @@ func createSetting(tt *TestTools, productName, moduleName string, values ...stri
 	return
 }
 
+// evaluateSetting 返回用户评估结果中的配置项，query 为附加的查询参数
+func evaluateSetting(tt *TestTools, uid, productName, settingName, query string) (setting tpl.EvaluatedSetting, ok bool, err error) {
+	res, err := request.Get(fmt.Sprintf("%s/v1/users/%s:evaluate?product=%s%s", tt.Host, uid, productName, query)).
+		End()
+	if err != nil {
+		return
+	}
+	json := tpl.UserEvaluationRes{}
+	if _, err = res.JSON(&json); err != nil {
+		return
+	}
+	for _, s := range json.Result.Settings {
+		if s.Setting == settingName {
+			return s, true, nil
+		}
+	}
+	return
+}
+
 func TestSettingAPIs(t *testing.T) {
 	tt, cleanup := SetUpTestTools()
 	defer cleanup()
@@ -1023,4 +1043,1324 @@ func TestSettingAPIs(t *testing.T) {
 			assert.False(json.Result)
 		})
 	})
+
+	t.Run(`setting variant rules`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "a", "b", "c")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+		user := users[0]
+
+		var rule tpl.SettingRuleInfo
+
+		t.Run(`"POST /v1/products/:product/modules/:module/settings/:setting/rules" should return 400 with invalid variants`, func(t *testing.T) {
+			assert := assert.New(t)
+			for _, variants := range [][]map[string]interface{}{
+				{{"value": "a", "weight": 50}},
+				{{"value": "a", "weight": 50}, {"value": "a", "weight": 50}},
+				{{"value": "a", "weight": 50}, {"value": "b", "weight": 51}},
+				{{"value": "a", "weight": 50}, {"value": "x", "weight": 50}},
+			} {
+				res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting.Name)).
+					Set("Content-Type", "application/json").
+					Send(map[string]interface{}{
+						"kind": "userVariant",
+						"rule": map[string]interface{}{
+							"variants": variants,
+						},
+					}).
+					End()
+				assert.Nil(err)
+				assert.Equal(400, res.StatusCode)
+				res.Content() // close http client
+			}
+		})
+
+		t.Run(`"POST /v1/products/:product/modules/:module/settings/:setting/rules" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind": "userVariant",
+					"rule": map[string]interface{}{
+						"variants": []map[string]interface{}{
+							{"value": "a", "weight": 33},
+							{"value": "b", "weight": 33},
+							{"value": "c", "weight": 34},
+						},
+					},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			text, err := res.Text()
+			assert.Nil(err)
+			assert.True(strings.Contains(text, `"rule":{"value":100,"variants":[{"value":"a","weight":33},{"value":"b","weight":33},{"value":"c","weight":34}]}`))
+
+			json := tpl.SettingRuleInfoRes{}
+			res.JSON(&json)
+			data := json.Result
+			assert.Equal("userVariant", data.Kind)
+			assert.Equal("", data.Value)
+
+			rule = data
+		})
+
+		t.Run(`"POST /v1/products/:product/modules/:module/settings/:setting/rules/:hid/ramp" should return 400`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules/%s/ramp", tt.Host, product.Name, module.Name, setting.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"steps":    []int{10, 50, 100},
+					"interval": "1h",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"GET /v1/users/:uid/settings:unionAll" should apply variant rule`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, user.UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			time.Sleep(time.Millisecond * 100)
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, user.UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.MySettingsRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+			assert.True(tpl.StringSliceHas([]string{"a", "b", "c"}, json.Result[0].Value))
+		})
+
+		t.Run(`"GET /v1/users/:uid/settings:unionAll" should apply variant rule to anonymous user`, func(t *testing.T) {
+			assert := assert.New(t)
+			res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, "anon-"+user.UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.MySettingsRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+			assert.True(tpl.StringSliceHas([]string{"a", "b", "c"}, json.Result[0].Value))
+		})
+	})
+
+	t.Run(`"POST /v1/products/:product/modules/:module/settings/:setting/rules:estimate"`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "x", "y")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 2)
+		assert.Nil(t, err)
+
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "x"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules:estimate", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":  "userPercent",
+					"value": "y",
+					"rule": map[string]interface{}{
+						"value": 100,
+					},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.EstimateInfoRes{}
+			res.JSON(&json)
+			data := json.Result
+			assert.True(data.Total >= int64(2))
+			assert.Equal(data.Total, data.Sampled)
+			assert.Equal(data.Total, data.Affected)
+			assert.Equal(int64(1), data.Overlap)
+			assert.False(data.Estimated)
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `setting_rule` where `setting_id` = ?", setting.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+		})
+
+		t.Run("should return 400 when value not in setting", func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules:estimate", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":  "userPercent",
+					"value": "z",
+					"rule": map[string]interface{}{
+						"value": 50,
+					},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`setting rule backfill`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "x", "y")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 2)
+		assert.Nil(t, err)
+
+		var rule tpl.SettingRuleInfo
+
+		t.Run(`"POST /v1/products/:product/modules/:module/settings/:setting/rules" with backfill should work`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "x"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":     "userPercent",
+					"value":    "y",
+					"rule":     map[string]interface{}{"value": 100},
+					"backfill": map[string]interface{}{"batchSize": 5000},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingRuleInfoRes{}
+			res.JSON(&json)
+			rule = json.Result
+		})
+
+		t.Run(`"GET /v1/products/:product/modules/:module/settings/:setting/rules/:hid/backfill" should work`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Get(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules/%s/backfill", tt.Host, product.Name, module.Name, setting.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.RuleBackfillInfoRes{}
+			res.JSON(&json)
+			data := json.Result
+			assert.Equal(rule.HID, data.RuleHID)
+			assert.Equal("completed", data.Status)
+			assert.Equal(100, data.Progress)
+			assert.Equal(5000, data.BatchSize)
+			assert.Equal("1s", data.Interval)
+			assert.True(data.Total >= int64(2))
+			assert.Equal(data.Total, data.Processed)
+			assert.Equal(data.Total-1, data.Applied)
+			assert.Nil(data.NextAt)
+
+			var value string
+			_, err = tt.DB.ScanVal(&value, "select `value` from `user_setting` where `user_id` = ? and `setting_id` = ?", users[0].ID, setting.ID)
+			assert.Nil(err)
+			assert.Equal("x", value)
+			_, err = tt.DB.ScanVal(&value, "select `value` from `user_setting` where `user_id` = ? and `setting_id` = ?", users[1].ID, setting.ID)
+			assert.Nil(err)
+			assert.Equal("y", value)
+
+			// 变更事件只记录回填新写入的用户，users[0] 的事件来自直接指派
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `change_event` where `user_id` = ? and `kind` = ?", users[0].ID, schema.ChangeKindSetting)
+			assert.Nil(err)
+			assert.Equal(int64(1), count)
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `change_event` where `user_id` = ? and `kind` = ?", users[1].ID, schema.ChangeKindSetting)
+			assert.Nil(err)
+			assert.Equal(int64(1), count)
+		})
+
+		t.Run(`"PUT /v1/products/:product/modules/:module/settings/:setting/rules/:hid/backfill:cancel" should return 400 when completed`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules/%s/backfill:cancel", tt.Host, product.Name, module.Name, setting.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`setting rule revoke`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "x", "y")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 2)
+		assert.Nil(t, err)
+
+		t.Run(`lowering the percent should revoke only rule-sourced users`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "x"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":     "userPercent",
+					"value":    "y",
+					"rule":     map[string]interface{}{"value": 100},
+					"backfill": map[string]interface{}{"batchSize": 5000},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingRuleInfoRes{}
+			res.JSON(&json)
+			rule := json.Result
+
+			var ruleID int64
+			_, err = tt.DB.ScanVal(&ruleID, "select `rule_id` from `user_setting` where `user_id` = ? and `setting_id` = ?", users[1].ID, setting.ID)
+			assert.Nil(err)
+			assert.Equal(service.HIDToID(rule.HID, "setting_rule"), ruleID)
+
+			res, err = request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules/%s", tt.Host, product.Name, module.Name, setting.Name, rule.HID)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":  "userPercent",
+					"value": "y",
+					"rule":  map[string]interface{}{"value": 0},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_setting` where `setting_id` = ?", setting.ID)
+			assert.Nil(err)
+			assert.Equal(int64(1), count)
+
+			var value string
+			_, err = tt.DB.ScanVal(&value, "select `value` from `user_setting` where `user_id` = ? and `setting_id` = ?", users[0].ID, setting.ID)
+			assert.Nil(err)
+			assert.Equal("x", value)
+		})
+	})
+
+	t.Run(`setting groupPercent rule`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "x", "y")
+		assert.Nil(t, err)
+
+		group, _, err := createGroupWithUsers(tt, 2)
+		assert.Nil(t, err)
+
+		t.Run(`should not support schedule`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":    "groupPercent",
+					"rule":    map[string]interface{}{"value": 100, "groupKind": group.Kind},
+					"value":   "y",
+					"startAt": time.Now().Add(time.Hour),
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should assign the setting to selected groups and revoke on delete`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"kind":  "groupPercent",
+					"rule":  map[string]interface{}{"value": 100, "groupKind": group.Kind},
+					"value": "y",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingRuleInfoRes{}
+			res.JSON(&json)
+			rule := json.Result
+
+			var value string
+			_, err = tt.DB.ScanVal(&value, "select `value` from `group_setting` where `group_id` = ? and `setting_id` = ?", group.ID, setting.ID)
+			assert.Nil(err)
+			assert.Equal("y", value)
+
+			res, err = request.Delete(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules/%s", tt.Host, product.Name, module.Name, setting.Name, rule.HID)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `group_setting` where `setting_id` = ?", setting.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+		})
+	})
+
+	t.Run(`setting prerequisite`, func(t *testing.T) {
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		prereq, err := createSetting(tt, product.Name, module.Name, "off", "on")
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "a", "b")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 2)
+		assert.Nil(t, err)
+
+		t.Run(`should return 400 with invalid prerequisite value`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"prerequisite": map[string]interface{}{"module": module.Name, "setting": prereq.Name, "value": "x"},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should suppress the setting until the prerequisite is met`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"prerequisite": map[string]interface{}{"module": module.Name, "setting": prereq.Name, "value": "on"},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingInfoRes{}
+			res.JSON(&json)
+			assert.NotNil(json.Result.Prerequisite)
+			assert.Equal(service.IDToHID(prereq.ID, "setting"), json.Result.Prerequisite.SettingHID)
+			assert.Equal("on", json.Result.Prerequisite.Value)
+
+			// users[0] 满足前置条件，users[1] 不满足
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, prereq.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "on"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: schema.GetUsersUID(users), Value: "b"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.SettingReleaseInfoRes{}
+			res.JSON(&json2)
+			assert.Equal([]string{users[0].UID}, json2.Result.Users)
+			assert.Equal([]string{users[1].UID}, json2.Result.SkippedUsers)
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json3 := tpl.MySettingsRes{}
+			res.JSON(&json3)
+			assert.Equal(2, len(json3.Result))
+
+			evaluated, ok, err := evaluateSetting(tt, users[0].UID, product.Name, setting.Name, "")
+			assert.Nil(err)
+			assert.True(ok)
+			assert.Equal("b", evaluated.Value)
+			_, ok, err = evaluateSetting(tt, users[1].UID, product.Name, setting.Name, "")
+			assert.Nil(err)
+			assert.False(ok)
+
+			// 前置条件改为不满足后，依赖的配置项不再返回
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, prereq.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "off"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json3 = tpl.MySettingsRes{}
+			res.JSON(&json3)
+			assert.Equal(1, len(json3.Result))
+			assert.Equal(prereq.Name, json3.Result[0].Name)
+
+			_, ok, err = evaluateSetting(tt, users[0].UID, product.Name, setting.Name, "")
+			assert.Nil(err)
+			assert.False(ok)
+		})
+
+		t.Run(`should reject cyclic prerequisite`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, prereq.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"prerequisite": map[string]interface{}{"module": module.Name, "setting": setting.Name, "value": "a"},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"prerequisite": map[string]interface{}{"module": module.Name, "setting": setting.Name, "value": "a"},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should count dependents in the prerequisite chain`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			setPrereq := func(name, prereq string) int {
+				res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, name)).
+					Set("Content-Type", "application/json").
+					Send(map[string]interface{}{
+						"prerequisite": map[string]interface{}{"module": module.Name, "setting": prereq, "value": "a"},
+					}).
+					End()
+				assert.Nil(err)
+				res.Content() // close http client
+				return res.StatusCode
+			}
+
+			chain := make([]schema.Setting, 10)
+			for i := range chain {
+				s, err := createSetting(tt, product.Name, module.Name, "a", "b")
+				assert.Nil(err)
+				chain[i] = s
+			}
+			// chain[7] -> chain[6] -> ... -> chain[0]
+			for i := 1; i < 8; i++ {
+				assert.Equal(200, setPrereq(chain[i].Name, chain[i-1].Name))
+			}
+
+			// chain[7] -> ... -> chain[0] -> chain[8]，共 8 层
+			assert.Equal(200, setPrereq(chain[0].Name, chain[8].Name))
+			// 再加一层超过限制
+			assert.Equal(400, setPrereq(chain[8].Name, chain[9].Name))
+			// 依赖方不能作为前置条件
+			assert.Equal(400, setPrereq(chain[8].Name, chain[7].Name))
+		})
+	})
+
+	t.Run(`setting defaults`, func(t *testing.T) {
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "a", "b")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		findSetting := func(settings []tpl.MySetting) *tpl.MySetting {
+			for i := range settings {
+				if settings[i].Name == setting.Name {
+					return &settings[i]
+				}
+			}
+			return nil
+		}
+
+		t.Run(`should return 400 when default not in values`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"defaultValue": "x"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should return defaults for unassigned users`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{
+					"defaultValue":     "a",
+					"defaultOverrides": []map[string]string{{"client": "ios", "value": "b"}},
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingInfoRes{}
+			res.JSON(&json)
+			assert.Equal("a", json.Result.DefaultValue)
+			assert.Equal(1, len(json.Result.DefaultOverrides))
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.MySettingsRes{}
+			res.JSON(&json2)
+			s := findSetting(json2.Result)
+			assert.NotNil(s)
+			assert.Equal("a", s.Value)
+			assert.Equal("default", s.Source)
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s&client=ios", tt.Host, "anon-"+users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 = tpl.MySettingsRes{}
+			res.JSON(&json2)
+			s = findSetting(json2.Result)
+			assert.NotNil(s)
+			assert.Equal("b", s.Value)
+			assert.Equal("default", s.Source)
+		})
+
+		t.Run(`should prefer the assignment over the default`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "b"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.MySettingsRes{}
+			res.JSON(&json)
+			count := 0
+			for _, s := range json.Result {
+				if s.Name == setting.Name {
+					count++
+					assert.Equal("b", s.Value)
+					assert.Equal("", s.Source)
+				}
+			}
+			assert.Equal(1, count)
+		})
+
+		t.Run(`should page defaults after the assignments`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			product, err := createProduct(tt)
+			assert.Nil(err)
+			module, err := createModule(tt, product.Name)
+			assert.Nil(err)
+			names := make(map[string]bool)
+			for i := 0; i < 5; i++ {
+				s, err := createSetting(tt, product.Name, module.Name, "a", "b")
+				assert.Nil(err)
+				res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, s.Name)).
+					Set("Content-Type", "application/json").
+					Send(map[string]interface{}{"defaultValue": "a"}).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+				res.Content() // close http client
+				names[s.Name] = true
+			}
+
+			seen := make(map[string]bool)
+			pageToken := ""
+			for i := 0; i < 5; i++ {
+				res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s&pageSize=2&pageToken=%s", tt.Host, users[0].UID, product.Name, pageToken)).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+
+				json := tpl.MySettingsRes{}
+				res.JSON(&json)
+				assert.True(len(json.Result) <= 2)
+				for _, s := range json.Result {
+					assert.False(seen[s.Name])
+					seen[s.Name] = true
+					assert.Equal("default", s.Source)
+				}
+				if pageToken = json.NextPageToken; pageToken == "" {
+					break
+				}
+			}
+			assert.Equal("", pageToken)
+			assert.Equal(names, seen)
+		})
+	})
+
+	t.Run(`setting value types`, func(t *testing.T) {
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		t.Run(`should return 400 when value schema invalid`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"valueType": "int", "valueSchema": map[string]interface{}{"minimum": "x"}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should work with int value type`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(map[string]interface{}{"valueType": "int", "valueSchema": map[string]interface{}{"minimum": 1, "maximum": 10}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingInfoRes{}
+			res.JSON(&json)
+			assert.Equal("int", json.Result.ValueType)
+			assert.NotEmpty(json.Result.ValueSchema)
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "11"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "abc"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "8"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.MySettingsRes{}
+			res.JSON(&json2)
+			count := 0
+			for _, s := range json2.Result {
+				if s.Name == setting.Name {
+					count++
+					assert.Equal("8", s.Value)
+					assert.Equal("int", s.ValueType)
+					assert.Equal("8", string(s.TypedValue))
+				}
+			}
+			assert.Equal(1, count)
+		})
+	})
+
+	t.Run(`setting variants`, func(t *testing.T) {
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "layout-a", "layout-b")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		payload := `{"blocks":["` + strings.Repeat("x", 1000) + `"]}`
+		url := fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/variants", tt.Host, product.Name, module.Name, setting.Name)
+
+		t.Run(`should return 400 when variant name not in values`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingVariantBody{Name: "layout-c", Payload: payload}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should return 400 when payload too large`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingVariantBody{Name: "layout-a", Payload: strings.Repeat("x", conf.Config.SettingVariant.MaxPayloadSize+1)}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should work`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingVariantBody{Name: "layout-a", Payload: payload}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingVariantInfoRes{}
+			res.JSON(&json)
+			assert.Equal("layout-a", json.Result.Name)
+			assert.Equal(len(payload), json.Result.Size)
+			assert.Equal(schema.PayloadHash(payload), json.Result.Hash)
+			assert.Equal(payload, *json.Result.Payload)
+
+			res, err = request.Get(url).End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.SettingVariantsInfoRes{}
+			res.JSON(&json2)
+			assert.Equal(1, len(json2.Result))
+			assert.Nil(json2.Result[0].Payload)
+		})
+
+		t.Run(`should deliver payload with settings`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "layout-a"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.MySettingsRes{}
+			res.JSON(&json)
+			count := 0
+			for _, s := range json.Result {
+				if s.Name == setting.Name {
+					count++
+					assert.Equal("layout-a", s.Value)
+					assert.Equal(payload, *s.Payload)
+					assert.Equal(schema.PayloadHash(payload), s.PayloadHash)
+				}
+			}
+			assert.Equal(1, count)
+		})
+
+		t.Run(`should update and delete variant`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			countEvents := func() int64 {
+				var count int64
+				_, err := tt.DB.ScanVal(&count, "select count(*) from `change_event` where `product_id` = ? and `kind` = ?", product.ID, schema.ChangeKindSetting)
+				assert.Nil(err)
+				return count
+			}
+			events := countEvents()
+
+			res, err := request.Put(url+"/layout-a").
+				Set("Content-Type", "application/json").
+				Send(map[string]string{"payload": "{}"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingVariantInfoRes{}
+			res.JSON(&json)
+			assert.Equal(2, json.Result.Size)
+			assert.Equal(schema.PayloadHash("{}"), json.Result.Hash)
+			assert.Equal(events+1, countEvents())
+
+			res, err = request.Delete(url + "/layout-a").End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+			assert.Equal(events+2, countEvents())
+
+			res, err = request.Get(url + "/layout-a").End()
+			assert.Nil(err)
+			assert.Equal(404, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`setting overrides`, func(t *testing.T) {
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "v1", "v2", "v3")
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		url := fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/overrides", tt.Host, product.Name, module.Name, setting.Name)
+		userValue := func(query string) string {
+			res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s%s", tt.Host, users[0].UID, product.Name, query)).
+				End()
+			assert.Nil(t, err)
+			json := tpl.MySettingsRes{}
+			res.JSON(&json)
+			for _, s := range json.Result {
+				if s.Name == setting.Name {
+					return s.Value
+				}
+			}
+			return ""
+		}
+
+		var override tpl.SettingOverrideInfo
+		t.Run(`should return 400 when value not in setting`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingOverrideBody{Client: "ios", Value: "v9"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should work`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingOverrideBody{Client: "ios", Value: "v2"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingOverrideInfoRes{}
+			res.JSON(&json)
+			override = json.Result
+			assert.True(service.HIDToID(override.HID, "setting_override") > int64(0))
+			assert.Equal("ios", override.Client)
+			assert.Equal("v2", override.Value)
+
+			res, err = request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingOverrideBody{Client: "ios", Value: "v3"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(409, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Get(url).End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json2 := tpl.SettingOverridesInfoRes{}
+			res.JSON(&json2)
+			assert.Equal(1, len(json2.Result))
+		})
+
+		t.Run(`should apply override after assignment`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "v1"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			assert.Equal("v1", userValue(""))
+			assert.Equal("v1", userValue("&client=web"))
+			assert.Equal("v2", userValue("&client=ios"))
+
+			evaluated, ok, err := evaluateSetting(tt, users[0].UID, product.Name, setting.Name, "&client=ios")
+			assert.Nil(err)
+			assert.True(ok)
+			assert.Equal("v2", evaluated.Value)
+			assert.Equal(tpl.EvaluationSourceUser, evaluated.Reason.Source)
+		})
+
+		t.Run(`should update and delete override`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Put(url+"/"+override.HID).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingOverrideBody{Channel: "beta", Client: "ios", Value: "v3"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			assert.Equal("v1", userValue("&client=ios"))
+			assert.Equal("v3", userValue("&client=ios&channel=beta"))
+
+			evaluated, _, err := evaluateSetting(tt, users[0].UID, product.Name, setting.Name, "&client=ios&channel=beta")
+			assert.Nil(err)
+			assert.Equal("v3", evaluated.Value)
+
+			res, err = request.Delete(url + "/" + override.HID).End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			assert.Equal("v1", userValue("&client=ios&channel=beta"))
+		})
+	})
+
+	t.Run(`setting version range`, func(t *testing.T) {
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		name := tpl.RandName()
+		url := fmt.Sprintf("%s/v1/products/%s/modules/%s/settings", tt.Host, product.Name, module.Name)
+		userValue := func(query string) string {
+			res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s%s", tt.Host, users[0].UID, product.Name, query)).
+				End()
+			assert.Nil(t, err)
+			json := tpl.MySettingsRes{}
+			res.JSON(&json)
+			for _, s := range json.Result {
+				if s.Name == name {
+					return s.Value
+				}
+			}
+			return ""
+		}
+
+		t.Run(`should return 400 with invalid range`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingBody{Name: name, MinVersion: "2.0", MaxVersion: "1.0"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`should filter settings by version`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			defaultValue := "a"
+			res, err := request.Post(url).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingBody{Name: name, Values: &[]string{"a", "b"}, DefaultValue: &defaultValue, MinVersion: "v1.2", MaxVersion: "2.0.0"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.SettingInfoRes{}
+			res.JSON(&json)
+			assert.Equal("v1.2", json.Result.MinVersion)
+			assert.Equal("2.0.0", json.Result.MaxVersion)
+
+			assert.Equal("", userValue(""))
+			assert.Equal("a", userValue("&version=1.2.0"))
+			assert.Equal("", userValue("&version=2.0.1"))
+
+			evaluated, ok, err := evaluateSetting(tt, users[0].UID, product.Name, name, "&version=1.2.0")
+			assert.Nil(err)
+			assert.True(ok)
+			assert.Equal("a", evaluated.Value)
+			assert.Equal(tpl.EvaluationSourceDefault, evaluated.Reason.Source)
+			_, ok, err = evaluateSetting(tt, users[0].UID, product.Name, name, "&version=2.0.1")
+			assert.Nil(err)
+			assert.False(ok)
+
+			res, err = request.Post(fmt.Sprintf("%s/%s:assign", url, name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "b"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			assert.Equal("b", userValue("&version=2.0.0"))
+			assert.Equal("", userValue("&version=1.1.9"))
+
+			_, ok, err = evaluateSetting(tt, users[0].UID, product.Name, name, "&version=1.1.9")
+			assert.Nil(err)
+			assert.False(ok)
+
+			maxVersion := ""
+			res, err = request.Put(fmt.Sprintf("%s/%s", url, name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingUpdateBody{MaxVersion: &maxVersion}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			assert.Equal("b", userValue("&version=3.0.0"))
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s&version=1..0", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
+
+	t.Run(`setting conflict policy`, func(t *testing.T) {
+		product, err := createProduct(tt)
+		assert.Nil(t, err)
+
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting1, err := createSetting(tt, product.Name, module.Name, "a", "b", "c")
+		assert.Nil(t, err)
+
+		setting2, err := createSetting(tt, product.Name, module.Name, "a", "b", "c")
+		assert.Nil(t, err)
+
+		group, users, err := createGroupWithUsers(tt, 1)
+		assert.Nil(t, err)
+
+		group2UID := tpl.RandUID()
+		res, err := request.Post(fmt.Sprintf("%s/v1/groups:batch", tt.Host)).
+			Set("Content-Type", "application/json").
+			Send(tpl.GroupsBody{Groups: []tpl.GroupBody{{UID: group2UID, Kind: "project", Desc: group2UID}}}).
+			End()
+		assert.Nil(t, err)
+		res.Content() // close http client
+		res, err = request.Post(fmt.Sprintf("%s/v1/groups/%s/members:batch", tt.Host, group2UID)).
+			Set("Content-Type", "application/json").
+			Send(tpl.UsersBody{Users: []string{users[0].UID}}).
+			End()
+		assert.Nil(t, err)
+		res.Content() // close http client
+
+		assign := func(setting schema.Setting, body tpl.UsersGroupsBody) {
+			res, err := request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(body).
+				End()
+			assert.Nil(t, err)
+			assert.Equal(t, 200, res.StatusCode)
+			res.Content() // close http client
+			time.Sleep(time.Millisecond * 10)
+		}
+		updatePolicy := func(body tpl.ProductUpdateBody) int {
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s", tt.Host, product.Name)).
+				Set("Content-Type", "application/json").
+				Send(body).
+				End()
+			assert.Nil(t, err)
+			res.Content() // close http client
+			return res.StatusCode
+		}
+		userSettings := func() map[string]tpl.MySetting {
+			res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s", tt.Host, users[0].UID, product.Name)).
+				End()
+			assert.Nil(t, err)
+			json := tpl.MySettingsRes{}
+			res.JSON(&json)
+			settings := make(map[string]tpl.MySetting)
+			for _, s := range json.Result {
+				_, ok := settings[s.Name]
+				assert.False(t, ok, "setting should appear once")
+				settings[s.Name] = s
+			}
+			return settings
+		}
+
+		assign(setting1, tpl.UsersGroupsBody{Users: []string{users[0].UID}, Value: "a"})
+		assign(setting1, tpl.UsersGroupsBody{Groups: []string{group.UID}, Value: "b"})
+		assign(setting2, tpl.UsersGroupsBody{Groups: []string{group.UID}, Value: "b"})
+		assign(setting1, tpl.UsersGroupsBody{Groups: []string{group2UID}, Value: "c"})
+		assign(setting2, tpl.UsersGroupsBody{Groups: []string{group2UID}, Value: "c"})
+
+		t.Run(`should return 400 with invalid policy`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			policy := "unknown"
+			assert.Equal(400, updatePolicy(tpl.ProductUpdateBody{ConflictPolicy: &policy}))
+			assert.Equal(400, updatePolicy(tpl.ProductUpdateBody{GroupKinds: &[]string{"project", "project"}}))
+		})
+
+		t.Run(`latest assignment should win by default`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			settings := userSettings()
+			s := settings[setting1.Name]
+			assert.Equal("c", s.Value)
+			assert.Equal(2, len(s.Conflicts))
+			assert.Equal("group", s.Conflicts[0].Source)
+			assert.Equal(group.UID, s.Conflicts[0].Group.UID)
+			assert.Equal("b", s.Conflicts[0].Value)
+			assert.Equal("user", s.Conflicts[1].Source)
+			assert.Equal("a", s.Conflicts[1].Value)
+			assert.Equal("c", settings[setting2.Name].Value)
+		})
+
+		t.Run(`direct user assignment should win with "user" policy`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			policy := schema.ConflictPolicyUser
+			assert.Equal(200, updatePolicy(tpl.ProductUpdateBody{ConflictPolicy: &policy}))
+
+			settings := userSettings()
+			s := settings[setting1.Name]
+			assert.Equal("a", s.Value)
+			assert.Equal(2, len(s.Conflicts))
+			assert.Equal("c", s.Conflicts[0].Value)
+			assert.Equal("c", settings[setting2.Name].Value)
+		})
+
+		t.Run(`group kind order should apply with "groupKind" policy`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			policy := schema.ConflictPolicyGroupKind
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s", tt.Host, product.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.ProductUpdateBody{ConflictPolicy: &policy, GroupKinds: &[]string{"organization", "project"}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			text, err := res.Text()
+			assert.Nil(err)
+			assert.True(strings.Contains(text, `"groupKinds":["organization","project"]`))
+
+			settings := userSettings()
+			assert.Equal("a", settings[setting1.Name].Value)
+			s := settings[setting2.Name]
+			assert.Equal("b", s.Value)
+			assert.Equal(1, len(s.Conflicts))
+			assert.Equal("project", s.Conflicts[0].Group.Kind)
+		})
+
+		t.Run(`each setting should appear on one page only`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			seen := make(map[string]bool)
+			pageToken := ""
+			for i := 0; i < 5; i++ {
+				res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?product=%s&pageSize=1&pageToken=%s", tt.Host, users[0].UID, product.Name, pageToken)).
+					End()
+				assert.Nil(err)
+				assert.Equal(200, res.StatusCode)
+
+				json := tpl.MySettingsRes{}
+				res.JSON(&json)
+				for _, s := range json.Result {
+					assert.False(seen[s.Name], "setting should appear once")
+					seen[s.Name] = true
+				}
+				if pageToken = json.NextPageToken; pageToken == "" {
+					break
+				}
+			}
+			assert.Equal("", pageToken)
+			assert.Equal(map[string]bool{setting1.Name: true, setting2.Name: true}, seen)
+		})
+	})
 }
@@ -122,4 +122,72 @@ func TestSettingAPIsV2(t *testing.T) {
 			assert.Equal("a", data.LastValue)
 		})
 	})
+
+	t.Run(`POST "/v2/products/:product/modules/:module/settings/:setting+:estimate"`, func(t *testing.T) {
+		module, err := createModule(tt, product.Name)
+		assert.Nil(t, err)
+
+		setting, err := createSetting(tt, product.Name, module.Name, "a", "b")
+		assert.Nil(t, err)
+
+		group, members, err := createGroupWithUsers(tt, 3)
+		assert.Nil(t, err)
+
+		users, err := createUsers(tt, 1)
+		assert.Nil(t, err)
+
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v2/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBodyV2{
+					Users: []string{members[0].UID},
+					Value: "a",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Post(fmt.Sprintf("%s/v2/products/%s/modules/%s/settings/%s:estimate", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBodyV2{
+					Users:  []string{members[1].UID, users[0].UID, tpl.RandUID()},
+					Groups: []*tpl.GroupKindUID{{UID: group.UID, Kind: group.Kind}},
+					Value:  "b",
+				}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.EstimateInfoRes{}
+			res.JSON(&json)
+			assert.Equal(int64(5), json.Result.Total)
+			assert.Equal(int64(4), json.Result.Affected)
+			assert.Equal(int64(1), json.Result.Overlap)
+			assert.False(json.Result.Estimated)
+
+			var count int64
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `user_setting` where `setting_id` = ?", setting.ID)
+			assert.Nil(err)
+			assert.Equal(int64(1), count)
+
+			_, err = tt.DB.ScanVal(&count, "select count(*) from `group_setting` where `setting_id` = ?", setting.ID)
+			assert.Nil(err)
+			assert.Equal(int64(0), count)
+		})
+
+		t.Run("should return 400 with empty users and groups", func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v2/products/%s/modules/%s/settings/%s:estimate", tt.Host, product.Name, module.Name, setting.Name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersGroupsBodyV2{}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+	})
 }
@@ -1,6 +1,12 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
 	"github.com/teambition/gear"
 	"github.com/teambition/urbs-setting/src/bll"
 	"github.com/teambition/urbs-setting/src/tpl"
@@ -13,7 +19,7 @@ type User struct {
 
 // List ..
 func (a *User) List(ctx *gear.Context) error {
-	req := tpl.Pagination{}
+	req := tpl.UsersURL{}
 	if err := ctx.ParseURL(&req); err != nil {
 		return err
 	}
@@ -33,10 +39,98 @@ func (a *User) ListCachedLabels(ctx *gear.Context) error {
 		return err
 	}
 
-	res := a.blls.User.ListCachedLabels(ctx, req.UID, req.Product)
+	res := a.blls.User.ListCachedLabels(ctx, req.UID, req.Product, req.Key, req.Version)
+	return ctx.OkJSON(res)
+}
+
+// BatchListCachedLabels 批量返回用户在指定产品下的环境标签，用于网关
+func (a *User) BatchListCachedLabels(ctx *gear.Context) error {
+	req := tpl.UsersCacheLabelsBody{}
+	if err := ctx.ParseBody(&req); err != nil {
+		return err
+	}
+
+	res := a.blls.User.BatchListCachedLabels(ctx, req.Users, req.Product, req.Keys, req.Version)
 	return ctx.OkJSON(res)
 }
 
+// watchPingInterval 变更通知流的心跳间隔
+var watchPingInterval = 30 * time.Second
+
+// Watch 以 Server-Sent Events 推送 user 在 product 下的环境标签和配置项变更。
+// 连接后先推送一次 labels 事件，之后环境标签变化时推送 labels 事件，配置项可能变化时推送 settings 事件，
+// 客户端收到 settings 事件后应重新读取配置项。
+func (a *User) Watch(ctx *gear.Context) error {
+	req := tpl.UIDProductURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	sub, err := a.blls.Change.Subscribe(ctx, req.UID, req.Product)
+	if err != nil {
+		return err
+	}
+	defer a.blls.Change.Unsubscribe(sub)
+
+	ctx.SetHeader(gear.HeaderContentType, "text/event-stream; charset=utf-8")
+	ctx.SetHeader(gear.HeaderCacheControl, "no-cache")
+	ctx.SetHeader("X-Accel-Buffering", "no") // 禁用 nginx 的响应缓冲
+	ctx.Res.WriteHeader(http.StatusOK)
+
+	labels := a.blls.User.ListCachedLabels(ctx, req.UID, req.Product, req.Key, req.Version)
+	if err := writeEvent(ctx, "labels", labels); err != nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(watchPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := ctx.Res.Write([]byte(": ping\n\n")); err != nil {
+				return nil
+			}
+			ctx.Res.Flush()
+		case <-sub.Notify():
+			labelChanged, targeted, settingChanged := sub.Take()
+			if labelChanged {
+				// 产品级的变更只读取缓存，避免每个订阅者都重新计算发布规则并写库
+				var res *tpl.CacheLabelsInfoRes
+				if targeted {
+					res = a.blls.User.RefreshListCachedLabels(ctx, req.UID, req.Product, req.Key, req.Version)
+				} else {
+					res = a.blls.User.ListCachedLabels(ctx, req.UID, req.Product, req.Key, req.Version)
+				}
+				if !reflect.DeepEqual(res.Result, labels.Result) {
+					labels = res
+					if err := writeEvent(ctx, "labels", labels); err != nil {
+						return nil
+					}
+				}
+			}
+			if settingChanged {
+				if err := writeEvent(ctx, "settings", tpl.WatchSettingsEvent{Timestamp: time.Now().Unix()}); err != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func writeEvent(ctx *gear.Context, event string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(ctx.Res, "event: %s\ndata: %s\n\n", event, buf); err != nil {
+		return err
+	}
+	ctx.Res.Flush()
+	return nil
+}
+
 // RefreshCachedLabels 强制更新 user 的 labels 缓存
 func (a *User) RefreshCachedLabels(ctx *gear.Context) error {
 	req := tpl.UIDAndProductURL{}
@@ -44,7 +138,7 @@ func (a *User) RefreshCachedLabels(ctx *gear.Context) error {
 		return err
 	}
 
-	user, err := a.blls.User.RefreshCachedLabels(ctx, req.Product, req.UID)
+	user, err := a.blls.User.RefreshCachedLabels(ctx, req.Product, req.UID, req.Key)
 	if err != nil {
 		return err
 	}
@@ -67,6 +161,21 @@ func (a *User) ListLabels(ctx *gear.Context) error {
 	return ctx.OkJSON(res)
 }
 
+// Evaluate 只读地返回 user 在 product 下的 labels 和 settings 及其来源，不会应用规则写入指派记录
+func (a *User) Evaluate(ctx *gear.Context) error {
+	req := tpl.UserEvaluationURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	res, err := a.blls.User.Evaluate(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return ctx.OkJSON(res)
+}
+
 // ListSettings 返回 user 的 settings，按照 setting 设置时间正序，支持分页
 func (a *User) ListSettings(ctx *gear.Context) error {
 	req := tpl.MySettingsQueryURL{}
@@ -127,6 +236,35 @@ func (a *User) BatchAdd(ctx *gear.Context) error {
 	return ctx.OkJSON(tpl.BoolRes{Result: true})
 }
 
+// ListAttributes 返回 user 的全部属性
+func (a *User) ListAttributes(ctx *gear.Context) error {
+	req := tpl.UIDURL{}
+	if err := ctx.ParseURL(&req); err != nil {
+		return err
+	}
+
+	res, err := a.blls.User.ListAttributes(ctx, req.UID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.OkJSON(res)
+}
+
+// BatchUpsertAttributes 批量添加或更新用户属性，用户不存在时会被创建
+func (a *User) BatchUpsertAttributes(ctx *gear.Context) error {
+	req := tpl.UsersAttributesBody{}
+	if err := ctx.ParseBody(&req); err != nil {
+		return err
+	}
+
+	if err := a.blls.User.BatchUpsertAttributes(ctx, req.Users); err != nil {
+		return err
+	}
+
+	return ctx.OkJSON(tpl.BoolRes{Result: true})
+}
+
 // ApplyRules ..
 func (a *User) ApplyRules(ctx *gear.Context) error {
 	req := &tpl.ProductURL{}
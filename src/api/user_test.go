@@ -1,7 +1,12 @@
 package api
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
@@ -9,9 +14,11 @@ import (
 	"github.com/DavidCai1993/request"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/teambition/urbs-setting/src/bll"
 	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/service"
 	"github.com/teambition/urbs-setting/src/tpl"
+	"github.com/teambition/urbs-setting/src/util"
 )
 
 var time2020 = time.Unix(1577836800, 0)
@@ -149,6 +156,88 @@ func TestUserAPIs(t *testing.T) {
 		})
 	})
 
+	t.Run(`"POST /v1/users:attributes"`, func(t *testing.T) {
+		uid3 := tpl.RandUID()
+
+		t.Run("should work", func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/users:attributes", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersAttributesBody{Users: []tpl.UserAttributesBody{
+					{UID: uid1, Attributes: map[string]string{"plan": "enterprise", "region": "cn"}},
+					{UID: uid3, Attributes: map[string]string{"plan": "free"}},
+				}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.BoolRes{}
+			res.JSON(&json)
+			assert.True(json.Result)
+		})
+
+		t.Run("should update and remove attributes", func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/users:attributes", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersAttributesBody{Users: []tpl.UserAttributesBody{
+					{UID: uid1, Attributes: map[string]string{"region": "us", "plan": ""}},
+				}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+
+			res, err = request.Get(fmt.Sprintf("%s/v1/users/%s/attributes", tt.Host, uid1)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			text, err := res.Text()
+			assert.Nil(err)
+			assert.False(strings.Contains(text, `"id"`))
+
+			json := tpl.UserAttributesRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+			assert.Equal("region", json.Result[0].Name)
+			assert.Equal("us", json.Result[0].Value)
+		})
+
+		t.Run(`should 400 with reserved attribute`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Post(fmt.Sprintf("%s/v1/users:attributes", tt.Host)).
+				Set("Content-Type", "application/json").
+				Send(tpl.UsersAttributesBody{Users: []tpl.UserAttributesBody{
+					{UID: uid1, Attributes: map[string]string{"uid": "x"}},
+				}}).
+				End()
+			assert.Nil(err)
+			assert.Equal(400, res.StatusCode)
+			res.Content() // close http client
+		})
+
+		t.Run(`"GET /v1/users" should filter by attribute`, func(t *testing.T) {
+			assert := assert.New(t)
+
+			res, err := request.Get(fmt.Sprintf("%s/v1/users?attr=plan&attrValue=free", tt.Host)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+
+			json := tpl.UsersRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			assert.Equal(1, len(json.Result))
+			assert.Equal(1, json.TotalSize)
+			assert.Equal(uid3, json.Result[0].UID)
+		})
+	})
+
 	t.Run("user, group, label", func(t *testing.T) {
 		group, users, err := createGroupWithUsers(tt, 4)
 		assert.Nil(t, err)
@@ -772,3 +861,459 @@ func TestUserApplyRulesAPIs(t *testing.T) {
 		})
 	})
 }
+
+func TestUserEvaluateAPIs(t *testing.T) {
+	tt, cleanup := SetUpTestTools()
+	defer cleanup()
+
+	group, users, err := createGroupWithUsers(tt, 2)
+	assert.Nil(t, err)
+	user := users[0]
+
+	product, err := createProduct(tt)
+	assert.Nil(t, err)
+
+	module, err := createModule(tt, product.Name)
+	assert.Nil(t, err)
+
+	label0, err := createLabel(tt, product.Name)
+	assert.Nil(t, err)
+
+	label1, err := createLabel(tt, product.Name)
+	assert.Nil(t, err)
+
+	setting0, err := createSetting(tt, product.Name, module.Name, "a", "b")
+	assert.Nil(t, err)
+
+	setting1, err := createSetting(tt, product.Name, module.Name, "x", "y")
+	assert.Nil(t, err)
+
+	setting2, err := createSetting(tt, product.Name, module.Name, "m", "n")
+	assert.Nil(t, err)
+
+	var rule tpl.SettingRuleInfo
+
+	t.Run("prepare assignments and rules", func(t *testing.T) {
+		assert := assert.New(t)
+
+		defaultValue := "m"
+		res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting2.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.SettingUpdateBody{DefaultValue: &defaultValue}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		res.Content() // close http client
+
+		res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s:assign", tt.Host, product.Name, label0.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.UsersGroupsBody{Users: []string{user.UID}}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		res.Content() // close http client
+
+		res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s:assign", tt.Host, product.Name, label1.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.UsersGroupsBody{Groups: []string{group.UID}}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		res.Content() // close http client
+
+		res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting0.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.UsersGroupsBody{Groups: []string{group.UID}, Value: "b"}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		res.Content() // close http client
+
+		res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/rules", tt.Host, product.Name, module.Name, setting1.Name)).
+			Set("Content-Type", "application/json").
+			Send(map[string]interface{}{
+				"kind":  "userPercent",
+				"value": "y",
+				"rule": map[string]interface{}{
+					"value": 100,
+				},
+			}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+
+		json := tpl.SettingRuleInfoRes{}
+		res.JSON(&json)
+		rule = json.Result
+	})
+
+	t.Run(`"GET /v1/users/:uid+:evaluate" should return 400 without product`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := request.Get(fmt.Sprintf("%s/v1/users/%s:evaluate", tt.Host, user.UID)).
+			End()
+		assert.Nil(err)
+		assert.Equal(400, res.StatusCode)
+		res.Content() // close http client
+	})
+
+	t.Run(`"GET /v1/users/:uid+:evaluate" should return 404 for invalid user`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := request.Get(fmt.Sprintf("%s/v1/users/%s:evaluate?product=%s", tt.Host, tpl.RandUID(), product.Name)).
+			End()
+		assert.Nil(err)
+		assert.Equal(404, res.StatusCode)
+		res.Content() // close http client
+	})
+
+	t.Run(`"GET /v1/users/:uid+:evaluate" should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := request.Get(fmt.Sprintf("%s/v1/users/%s:evaluate?product=%s", tt.Host, user.UID, product.Name)).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+
+		text, err := res.Text()
+		assert.Nil(err)
+		assert.False(strings.Contains(text, `"id"`))
+
+		json := tpl.UserEvaluationRes{}
+		_, err = res.JSON(&json)
+		assert.Nil(err)
+
+		data := json.Result
+		assert.Equal(user.UID, data.UID)
+		assert.Equal(product.Name, data.Product)
+
+		assert.Equal(2, len(data.Labels))
+		labels := make(map[string]tpl.EvaluatedLabel)
+		for _, l := range data.Labels {
+			labels[l.Label] = l
+		}
+		assert.Equal(tpl.EvaluationSourceUser, labels[label0.Name].Reason.Source)
+		assert.Equal(service.IDToHID(label0.ID, "label"), labels[label0.Name].HID)
+		assert.NotNil(labels[label0.Name].Reason.AssignedAt)
+		assert.Nil(labels[label0.Name].Reason.Rule)
+		assert.Equal(tpl.EvaluationSourceGroup, labels[label1.Name].Reason.Source)
+		assert.Equal(group.UID, labels[label1.Name].Reason.Group.UID)
+		assert.Equal(group.Kind, labels[label1.Name].Reason.Group.Kind)
+
+		assert.Equal(3, len(data.Settings))
+		settings := make(map[string]tpl.EvaluatedSetting)
+		for _, s := range data.Settings {
+			assert.Equal(module.Name, s.Module)
+			settings[s.Setting] = s
+		}
+
+		s := settings[setting0.Name]
+		assert.Equal("b", s.Value)
+		assert.Equal(tpl.EvaluationSourceGroup, s.Reason.Source)
+		assert.Equal(group.UID, s.Reason.Group.UID)
+
+		s = settings[setting1.Name]
+		assert.Equal("y", s.Value)
+		assert.Equal(tpl.EvaluationSourceRule, s.Reason.Source)
+		assert.Equal(rule.HID, s.Reason.Rule.HID)
+		assert.Equal(schema.RuleUserPercent, s.Reason.Rule.Kind)
+		assert.True(s.Reason.Rule.Bucket >= 0 && s.Reason.Rule.Bucket < schema.RuleBuckets)
+		assert.Nil(s.Reason.AssignedAt)
+
+		s = settings[setting2.Name]
+		assert.Equal("m", s.Value)
+		assert.Equal(tpl.EvaluationSourceDefault, s.Reason.Source)
+
+		time.Sleep(100 * time.Millisecond)
+		var count int64
+		_, err = tt.DB.ScanVal(&count, "select count(*) from `user_setting` where `user_id` = ?", user.ID)
+		assert.Nil(err)
+		assert.Equal(int64(0), count)
+	})
+
+	t.Run(`"GET /v1/users/:uid+:evaluate" should work for anonymous user`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := request.Get(fmt.Sprintf("%s/v1/users/%s:evaluate?product=%s", tt.Host, "anon-"+tpl.RandUID(), product.Name)).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+
+		json := tpl.UserEvaluationRes{}
+		_, err = res.JSON(&json)
+		assert.Nil(err)
+
+		// setting0 只指派给了群组且没有默认值，与 settings:unionAll 一致不返回
+		data := json.Result
+		assert.Equal(0, len(data.Labels))
+		assert.Equal(2, len(data.Settings))
+		for _, s := range data.Settings {
+			if s.Setting == setting1.Name {
+				assert.Equal("y", s.Value)
+				assert.Equal(tpl.EvaluationSourceRule, s.Reason.Source)
+			} else {
+				assert.Equal(setting2.Name, s.Setting)
+				assert.Equal(tpl.EvaluationSourceDefault, s.Reason.Source)
+			}
+		}
+	})
+
+	t.Run(`"GET /v1/users/:uid+:evaluate" should equal settings:unionAll with prerequisites, overrides and versions`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		setting3, err := createSetting(tt, product.Name, module.Name, "p", "q")
+		assert.Nil(err)
+		setting4, err := createSetting(tt, product.Name, module.Name, "p", "q")
+		assert.Nil(err)
+
+		// setting3 的前置条件满足，setting4 的不满足；setting2 只适用于 2.0.0 及以上版本
+		defaultValue := "p"
+		for _, body := range []tpl.SettingUpdateBody{
+			{DefaultValue: &defaultValue, Prerequisite: &tpl.SettingPrerequisiteBody{Module: module.Name, Setting: setting0.Name, Value: "b"}},
+			{DefaultValue: &defaultValue, Prerequisite: &tpl.SettingPrerequisiteBody{Module: module.Name, Setting: setting0.Name, Value: "a"}},
+		} {
+			name := setting3.Name
+			if body.Prerequisite.Value == "a" {
+				name = setting4.Name
+			}
+			res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, name)).
+				Set("Content-Type", "application/json").
+				Send(body).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+		}
+		minVersion := "2.0.0"
+		res, err := request.Put(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s", tt.Host, product.Name, module.Name, setting2.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.SettingUpdateBody{MinVersion: &minVersion}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		res.Content() // close http client
+
+		for _, name := range []string{setting0.Name, setting1.Name} {
+			res, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s/overrides", tt.Host, product.Name, module.Name, name)).
+				Set("Content-Type", "application/json").
+				Send(tpl.SettingOverrideBody{Channel: "beta", Value: "a"}).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			res.Content() // close http client
+		}
+
+		query := fmt.Sprintf("product=%s&channel=beta&client=ios&version=1.0.0", product.Name)
+		res, err = request.Get(fmt.Sprintf("%s/v1/users/%s:evaluate?%s", tt.Host, user.UID, query)).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		evaluation := tpl.UserEvaluationRes{}
+		_, err = res.JSON(&evaluation)
+		assert.Nil(err)
+		assert.Equal("1.0.0", evaluation.Result.Version)
+
+		// settings:unionAll 首次请求时写入规则，写入后再比较
+		listSettings := func() []tpl.MySetting {
+			res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/settings:unionAll?%s", tt.Host, user.UID, query)).
+				End()
+			assert.Nil(err)
+			assert.Equal(200, res.StatusCode)
+			json := tpl.MySettingsRes{}
+			_, err = res.JSON(&json)
+			assert.Nil(err)
+			return json.Result
+		}
+		listSettings()
+		assert.Eventually(func() bool {
+			var count int64
+			_, err := tt.DB.ScanVal(&count, "select count(*) from `user_setting` where `user_id` = ? and `setting_id` = ?", user.ID, setting1.ID)
+			return err == nil && count == 1
+		}, 5*time.Second, 50*time.Millisecond)
+		settings := listSettings()
+
+		evaluated := make(map[string]tpl.EvaluatedSetting)
+		for _, s := range evaluation.Result.Settings {
+			evaluated[s.Setting] = s
+		}
+		assert.Equal(len(settings), len(evaluated))
+		for _, s := range settings {
+			e, ok := evaluated[s.Name]
+			assert.True(ok, s.Name)
+			assert.Equal(s.Value, e.Value, s.Name)
+			assert.Equal(string(s.TypedValue), string(e.TypedValue), s.Name)
+			assert.Equal(s.Release, e.Release, s.Name)
+			assert.Equal(len(s.Conflicts), len(e.Conflicts), s.Name)
+		}
+
+		assert.Equal("a", evaluated[setting0.Name].Value)
+		assert.Equal(tpl.EvaluationSourceGroup, evaluated[setting0.Name].Reason.Source)
+		assert.Equal("a", evaluated[setting1.Name].Value)
+		assert.Equal(tpl.EvaluationSourceRule, evaluated[setting1.Name].Reason.Source)
+		assert.Equal("p", evaluated[setting3.Name].Value)
+		_, ok := evaluated[setting2.Name]
+		assert.False(ok)
+		_, ok = evaluated[setting4.Name]
+		assert.False(ok)
+	})
+}
+
+type watchEvent struct {
+	Event string
+	Data  string
+}
+
+// readWatchEvents 逐个解析 Server-Sent Events 事件，忽略注释行
+func readWatchEvents(r io.Reader) <-chan watchEvent {
+	ch := make(chan watchEvent, 10)
+	go func() {
+		defer close(ch)
+		scanner := bufio.NewScanner(r)
+		event := watchEvent{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event.Event != "" {
+					ch <- event
+				}
+				event = watchEvent{}
+			case strings.HasPrefix(line, "event: "):
+				event.Event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				event.Data = line[6:]
+			}
+		}
+	}()
+	return ch
+}
+
+func nextWatchEvent(ch <-chan watchEvent) (watchEvent, error) {
+	select {
+	case event, ok := <-ch:
+		if !ok {
+			return event, fmt.Errorf("stream closed")
+		}
+		return event, nil
+	case <-time.After(10 * time.Second):
+		return watchEvent{}, fmt.Errorf("timeout")
+	}
+}
+
+func TestUserWatchAPIs(t *testing.T) {
+	tt, cleanup := SetUpTestTools()
+	defer cleanup()
+
+	users, err := createUsers(tt, 1)
+	assert.Nil(t, err)
+	user := users[0]
+
+	product, err := createProduct(tt)
+	assert.Nil(t, err)
+
+	module, err := createModule(tt, product.Name)
+	assert.Nil(t, err)
+
+	label, err := createLabel(tt, product.Name)
+	assert.Nil(t, err)
+
+	setting, err := createSetting(tt, product.Name, module.Name, "a", "b")
+	assert.Nil(t, err)
+
+	t.Run(`"GET /v1/users/:uid/changes:watch" should return 503 before the change stream runs`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/changes:watch?product=%s", tt.Host, user.UID, product.Name)).
+			End()
+		assert.Nil(err)
+		assert.Equal(503, res.StatusCode)
+		res.Content() // close http client
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = util.DigInvoke(func(blls *bll.Blls) error {
+		go blls.Change.Run(ctx, 100*time.Millisecond)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	t.Run(`"GET /v1/users/:uid/changes:watch" should return 404 for invalid user`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.Eventually(func() bool {
+			res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/changes:watch?product=%s", tt.Host, tpl.RandUID(), product.Name)).
+				End()
+			if err != nil {
+				return false
+			}
+			res.Content() // close http client
+			return res.StatusCode != 503
+		}, time.Second, 10*time.Millisecond)
+
+		res, err := request.Get(fmt.Sprintf("%s/v1/users/%s/changes:watch?product=%s", tt.Host, tpl.RandUID(), product.Name)).
+			End()
+		assert.Nil(err)
+		assert.Equal(404, res.StatusCode)
+		res.Content() // close http client
+	})
+
+	t.Run(`"GET /v1/users/:uid/changes:watch" should push changes`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := http.Get(fmt.Sprintf("%s/v1/users/%s/changes:watch?product=%s", tt.Host, user.UID, product.Name))
+		assert.Nil(err)
+		defer res.Body.Close()
+		assert.Equal(200, res.StatusCode)
+		assert.True(strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream"))
+
+		events := readWatchEvents(res.Body)
+		event, err := nextWatchEvent(events)
+		assert.Nil(err)
+		assert.Equal("labels", event.Event)
+		labels := tpl.CacheLabelsInfoRes{}
+		assert.Nil(json.Unmarshal([]byte(event.Data), &labels))
+		assert.Equal(0, len(labels.Result))
+
+		res2, err := request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s:assign", tt.Host, product.Name, label.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.UsersGroupsBody{Users: []string{user.UID}}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res2.StatusCode)
+		res2.Content() // close http client
+
+		event, err = nextWatchEvent(events)
+		assert.Nil(err)
+		assert.Equal("labels", event.Event)
+		assert.Nil(json.Unmarshal([]byte(event.Data), &labels))
+		assert.Equal(1, len(labels.Result))
+		assert.Equal(label.Name, labels.Result[0].Label)
+
+		res2, err = request.Post(fmt.Sprintf("%s/v1/products/%s/modules/%s/settings/%s:assign", tt.Host, product.Name, module.Name, setting.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.UsersGroupsBody{Users: []string{user.UID}, Value: "b"}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res2.StatusCode)
+		res2.Content() // close http client
+
+		event, err = nextWatchEvent(events)
+		assert.Nil(err)
+		assert.Equal("settings", event.Event)
+
+		res2, err = request.Post(fmt.Sprintf("%s/v1/products/%s/labels/%s:recall", tt.Host, product.Name, label.Name)).
+			Set("Content-Type", "application/json").
+			Send(tpl.RecallBody{Release: 1}).
+			End()
+		assert.Nil(err)
+		assert.Equal(200, res2.StatusCode)
+		res2.Content() // close http client
+
+		event, err = nextWatchEvent(events)
+		assert.Nil(err)
+		assert.Equal("labels", event.Event)
+		assert.Nil(json.Unmarshal([]byte(event.Data), &labels))
+		assert.Equal(0, len(labels.Result))
+	})
+}
@@ -0,0 +1,352 @@
+package bll
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/model"
+	"github.com/teambition/urbs-setting/src/schema"
+)
+
+// changeEventTTL 变更事件的保留时间，超过后由 Change.Run 清理
+const changeEventTTL = 10 * time.Minute
+
+// changeGapTimeout 变更事件 ID 出现空缺时等待的最长时间。事件 ID 在插入时分配而非提交时，
+// 较小 ID 的事件可能晚于较大 ID 的事件可见，超时仍未出现的 ID 视为不存在（如插入失败）
+const changeGapTimeout = 10 * time.Second
+
+// Change 用户环境标签和配置项变更通知的订阅中心，轮询 change_event 表并分发给本实例的订阅者
+type Change struct {
+	ms      *model.Models
+	running int32
+
+	mu   sync.Mutex
+	subs map[int64]map[*ChangeSubscription]struct{} // productID -> subscriptions
+
+	cursorMu sync.Mutex
+	cursor   int64 // 该 ID 及之前的事件都已分发或已放弃等待
+	ready    bool  // cursor 是否已确定，只分发确定之后的变更事件
+
+	// 以下字段只在 loop 中访问
+	seen  map[int64]bool // cursor 之后已分发的事件 ID
+	gapAt time.Time      // cursor 之后的空缺被发现的时间
+}
+
+// ChangeSubscription 用户在产品下的变更订阅
+type ChangeSubscription struct {
+	UID       string
+	Product   string
+	ProductID int64
+	UserID    int64 // 匿名用户为 0，只接收产品级的变更
+
+	mu       sync.Mutex
+	label    bool
+	targeted bool // 是否有指向该用户或其所在群组的环境标签变更
+	setting  bool
+	groupIDs map[int64]bool
+	notify   chan struct{}
+}
+
+// Notify 有待处理的变更时可读
+func (s *ChangeSubscription) Notify() <-chan struct{} {
+	return s.notify
+}
+
+// Take 取出并清空待处理的变更类型，targeted 表示环境标签变更指向该用户或其所在群组，
+// 需要强制刷新用户的环境标签；否则为产品级的变更，读取缓存即可
+func (s *ChangeSubscription) Take() (label, targeted, setting bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	label, targeted, setting = s.label, s.targeted, s.setting
+	s.label, s.targeted, s.setting = false, false, false
+	return
+}
+
+func (s *ChangeSubscription) push(kind string, targeted bool) {
+	s.mu.Lock()
+	if kind != schema.ChangeKindSetting {
+		s.label = true
+		if targeted {
+			s.targeted = true
+		}
+	}
+	if kind != schema.ChangeKindLabel {
+		s.setting = true
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default: // 已有未处理的通知，变更类型已合并
+	}
+}
+
+func (s *ChangeSubscription) inGroup(groupID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.groupIDs[groupID]
+}
+
+// setGroup 更新订阅者的群组成员关系，返回是否发生了变化
+func (s *ChangeSubscription) setGroup(groupID int64, member bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.groupIDs[groupID] == member {
+		return false
+	}
+	if member {
+		s.groupIDs[groupID] = true
+	} else {
+		delete(s.groupIDs, groupID)
+	}
+	return true
+}
+
+// Subscribe 订阅用户在产品下的变更，产品不存在或用户不存在（匿名用户除外）时返回 404，
+// 未通过 Run 启动变更分发时返回 503
+func (b *Change) Subscribe(ctx context.Context, uid, product string) (*ChangeSubscription, error) {
+	if atomic.LoadInt32(&b.running) == 0 {
+		return nil, gear.ErrServiceUnavailable.WithMsg("change stream is not running")
+	}
+
+	readCtx := context.WithValue(ctx, model.ReadDB, true)
+	productID, err := b.ms.Product.AcquireID(readCtx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &ChangeSubscription{
+		UID:       uid,
+		Product:   product,
+		ProductID: productID,
+		groupIDs:  make(map[int64]bool),
+		notify:    make(chan struct{}, 1),
+	}
+	user, err := b.ms.User.Acquire(readCtx, uid)
+	if err != nil {
+		if !strings.HasPrefix(uid, "anon-") {
+			return nil, err
+		}
+	} else {
+		sub.UserID = user.ID
+		groupIDs, err := b.ms.Group.FindIDsByUser(readCtx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range groupIDs {
+			sub.groupIDs[id] = true
+		}
+	}
+
+	// 订阅前确定起始事件，避免错过订阅之后的变更
+	if err := b.initCursor(ctx); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[int64]map[*ChangeSubscription]struct{})
+	}
+	if b.subs[productID] == nil {
+		b.subs[productID] = make(map[*ChangeSubscription]struct{})
+	}
+	b.subs[productID][sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe 取消订阅
+func (b *Change) Unsubscribe(sub *ChangeSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if subs := b.subs[sub.ProductID]; subs != nil {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(b.subs, sub.ProductID)
+		}
+	}
+}
+
+// Subscriptions 返回本实例当前的订阅数
+func (b *Change) Subscriptions() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, subs := range b.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+// Run 按 interval 轮询新的变更事件并分发给订阅者，直到 ctx 结束，同一进程内只会运行一个
+func (b *Change) Run(ctx context.Context, interval time.Duration) {
+	if atomic.CompareAndSwapInt32(&b.running, 0, 1) {
+		b.loop(ctx, interval)
+	}
+}
+
+// initCursor 以当前最大的事件 ID 作为起始事件，已确定时不做处理
+func (b *Change) initCursor(ctx context.Context) error {
+	b.cursorMu.Lock()
+	defer b.cursorMu.Unlock()
+	if b.ready {
+		return nil
+	}
+	id, err := b.ms.ChangeEvent.MaxID(ctx)
+	if err != nil {
+		return err
+	}
+	b.cursor, b.ready = id, true
+	b.seen, b.gapAt = make(map[int64]bool), time.Time{}
+	return nil
+}
+
+func (b *Change) loop(ctx context.Context, interval time.Duration) {
+	defer func() {
+		b.cursorMu.Lock()
+		b.ready = false
+		b.cursorMu.Unlock()
+		atomic.StoreInt32(&b.running, 0)
+	}()
+
+	cleanedAt := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// 只分发启动之后的变更事件
+			if err := b.initCursor(ctx); err != nil {
+				logging.Warningf("ChangeEvent.MaxID error: %v", err)
+				continue
+			}
+			b.cursorMu.Lock()
+			cursor := b.cursor
+			b.cursorMu.Unlock()
+			cursor = b.dispatch(ctx, cursor)
+			b.cursorMu.Lock()
+			b.cursor = cursor
+			b.cursorMu.Unlock()
+
+			if time.Since(cleanedAt) > time.Minute {
+				cleanedAt = time.Now()
+				if _, err := b.ms.ChangeEvent.Cleanup(ctx, time.Now().UTC().Add(-changeEventTTL)); err != nil {
+					logging.Warningf("ChangeEvent.Cleanup error: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// dispatch 分发 cursor 之后尚未分发的变更事件，返回新的 cursor
+func (b *Change) dispatch(ctx context.Context, cursor int64) int64 {
+	last := cursor
+	for {
+		events, err := b.ms.ChangeEvent.FindAfter(ctx, last, 1000)
+		if err != nil {
+			logging.Warningf("ChangeEvent.FindAfter error: %v", err)
+			break
+		}
+		for _, event := range events {
+			if !b.seen[event.ID] {
+				b.dispatchEvent(ctx, event)
+				b.seen[event.ID] = true
+			}
+			last = event.ID
+		}
+		if len(events) < 1000 {
+			break
+		}
+	}
+	return b.advance(cursor, last)
+}
+
+// advance 将 cursor 推进到连续已分发的最后一个事件，last 为已读取的最大事件 ID。
+// 遇到空缺时停在空缺之前，下次轮询重新读取空缺之后的事件，空缺超过 changeGapTimeout 后跳过。
+func (b *Change) advance(cursor, last int64) int64 {
+	for cursor < last {
+		if b.seen[cursor+1] {
+			cursor++
+			delete(b.seen, cursor)
+			b.gapAt = time.Time{}
+			continue
+		}
+		if b.gapAt.IsZero() {
+			b.gapAt = time.Now()
+		}
+		if time.Since(b.gapAt) < changeGapTimeout {
+			break
+		}
+		cursor++
+	}
+	return cursor
+}
+
+func (b *Change) dispatchEvent(ctx context.Context, event schema.ChangeEvent) {
+	if event.ProductID == 0 && event.GroupID > 0 {
+		b.dispatchMembership(ctx, event)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for productID, subs := range b.subs {
+		if event.ProductID > 0 && event.ProductID != productID {
+			continue
+		}
+		for sub := range subs {
+			switch {
+			case event.UserID > 0:
+				if sub.UserID != event.UserID {
+					continue
+				}
+			case event.GroupID > 0:
+				if !sub.inGroup(event.GroupID) {
+					continue
+				}
+			}
+			sub.push(event.Kind, event.UserID > 0 || event.GroupID > 0)
+		}
+	}
+}
+
+// dispatchMembership 群组成员变更，通知加入或离开该群组的订阅者
+func (b *Change) dispatchMembership(ctx context.Context, event schema.ChangeEvent) {
+	b.mu.Lock()
+	subs := make([]*ChangeSubscription, 0)
+	userIDs := make([]int64, 0)
+	for _, ss := range b.subs {
+		for sub := range ss {
+			if sub.UserID > 0 {
+				subs = append(subs, sub)
+				userIDs = append(userIDs, sub.UserID)
+			}
+		}
+	}
+	b.mu.Unlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	ids, err := b.ms.Group.FindMemberIDs(ctx, event.GroupID, userIDs)
+	if err != nil {
+		logging.Warningf("Group.FindMemberIDs: group %d, error %v", event.GroupID, err)
+		return
+	}
+	members := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		members[id] = true
+	}
+	for _, sub := range subs {
+		if sub.setGroup(event.GroupID, members[sub.UserID]) {
+			sub.push(event.Kind, event.UserID > 0 || event.GroupID > 0)
+		}
+	}
+}
@@ -11,24 +11,34 @@ func init() {
 
 // Blls ...
 type Blls struct {
-	User    *User
-	Group   *Group
-	Product *Product
-	Label   *Label
-	Module  *Module
-	Setting *Setting
-	Models  *model.Models
+	User         *User
+	Group        *Group
+	Product      *Product
+	Label        *Label
+	Module       *Module
+	Setting      *Setting
+	Layer        *Layer
+	RuleRamp     *RuleRamp
+	RuleBackfill *RuleBackfill
+	RuleSchedule *RuleSchedule
+	Change       *Change
+	Models       *model.Models
 }
 
 // NewBlls ...
 func NewBlls(models *model.Models) *Blls {
 	return &Blls{
-		User:    &User{ms: models},
-		Group:   &Group{ms: models},
-		Product: &Product{ms: models},
-		Label:   &Label{ms: models},
-		Module:  &Module{ms: models},
-		Setting: &Setting{ms: models},
-		Models:  models,
+		User:         &User{ms: models},
+		Group:        &Group{ms: models},
+		Product:      &Product{ms: models},
+		Label:        &Label{ms: models},
+		Module:       &Module{ms: models},
+		Setting:      &Setting{ms: models},
+		Layer:        &Layer{ms: models},
+		RuleRamp:     &RuleRamp{ms: models},
+		RuleBackfill: &RuleBackfill{ms: models},
+		RuleSchedule: &RuleSchedule{ms: models},
+		Change:       &Change{ms: models},
+		Models:       models,
 	}
 }
@@ -3,6 +3,7 @@ package bll
 import (
 	"context"
 
+	"github.com/teambition/urbs-setting/src/logging"
 	"github.com/teambition/urbs-setting/src/model"
 	"github.com/teambition/urbs-setting/src/tpl"
 )
@@ -107,6 +108,10 @@ func (b *Group) ListSettings(ctx context.Context, req tpl.MySettingsQueryURL) (*
 		return nil, err
 	}
 
+	tpl.SetTypedValues(settings)
+	if err = b.ms.SettingVariant.FillPayloads(readCtx, settings); err != nil {
+		return nil, err
+	}
 	res := &tpl.MySettingsRes{Result: settings}
 	res.TotalSize = total
 	if len(res.Result) > pg.PageSize {
@@ -122,9 +127,22 @@ func (b *Group) CheckExists(ctx context.Context, kind, uid string) bool {
 	return group != nil
 }
 
-// BatchAdd ...
+// BatchAdd 批量添加群组，并将对应群组类型的 groupPercent 发布规则应用到新群组
 func (b *Group) BatchAdd(ctx context.Context, groups []tpl.GroupBody) error {
-	return b.ms.Group.BatchAdd(ctx, groups)
+	if err := b.ms.Group.BatchAdd(ctx, groups); err != nil {
+		return err
+	}
+
+	uidsMap := map[string][]string{}
+	for _, g := range groups {
+		uidsMap[g.Kind] = append(uidsMap[g.Kind], g.UID)
+	}
+	for kind, uids := range uidsMap {
+		if err := b.ms.Group.ApplyRules(ctx, kind, uids); err != nil {
+			logging.Warningf("Group.ApplyRules: kind %s, error %v", kind, err)
+		}
+	}
+	return nil
 }
 
 // BatchAddMembers 批量给群组添加成员，如果用户未加入系统，则会自动加入
@@ -51,6 +51,8 @@ func (b *Label) Create(ctx context.Context, productName string, body *tpl.LabelB
 	if body.Clients != nil {
 		label.Clients = strings.Join(*body.Clients, ",")
 	}
+	label.MinVersion = body.MinVersion
+	label.MaxVersion = body.MaxVersion
 	if err = b.ms.Label.Create(ctx, label); err != nil {
 		return nil, err
 	}
@@ -65,6 +67,12 @@ func (b *Label) Update(ctx context.Context, productName, labelName string, body
 	}
 
 	label, err := b.ms.Label.Acquire(ctx, productID, labelName)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVersionRange(label.MinVersion, label.MaxVersion, body.MinVersion, body.MaxVersion); err != nil {
+		return nil, err
+	}
 	label, err = b.ms.Label.Update(ctx, label.ID, body.ToMap())
 	if err != nil {
 		return nil, err
@@ -107,6 +115,26 @@ func (b *Label) Assign(ctx context.Context, productName, labelName string, users
 	return b.ms.Label.Assign(ctx, label.ID, users, groups)
 }
 
+// EstimateAssign 预估将环境标签批量分配给用户或群组时影响的用户数，不会写入指派记录
+func (b *Label) EstimateAssign(ctx context.Context, productName, labelName string, users []string, groups []*tpl.GroupKindUID) (*tpl.EstimateInfoRes, error) {
+	readCtx := context.WithValue(ctx, model.ReadDB, true)
+	productID, err := b.ms.Product.AcquireID(readCtx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	label, err := b.ms.Label.Acquire(readCtx, productID, labelName)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.ms.Label.EstimateAssign(ctx, label.ID, users, groups)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.EstimateInfoRes{Result: *res}, nil
+}
+
 // Delete 物理删除标签
 func (b *Label) Delete(ctx context.Context, productName, labelName string) (*tpl.BoolRes, error) {
 	productID, err := b.ms.Product.AcquireID(ctx, productName)
@@ -191,6 +219,9 @@ func (b *Label) CreateRule(ctx context.Context, productName, labelName string, b
 		Kind:      body.Kind,
 		Rule:      body.ToRule(),
 		Release:   0,
+		StartAt:   body.StartAt,
+		EndAt:     body.EndAt,
+		Salt:      body.ToSalt(nil),
 	}
 	if err = b.ms.LabelRule.Create(ctx, labelRule); err != nil {
 		return nil, err
@@ -206,9 +237,45 @@ func (b *Label) CreateRule(ctx context.Context, productName, labelName string, b
 	if err != nil {
 		return nil, err
 	}
+	// groupPercent 规则由回填任务分批写入命中的群组
+	if body.Backfill != nil || labelRule.Kind == schema.RuleGroupPercent {
+		if err = startRuleBackfill(ctx, b.ms, schema.TableLabelRule, labelRule.ID, labelRule.Kind, labelRule.Rule, body.Backfill); err != nil {
+			return nil, err
+		}
+	}
 	return &tpl.LabelRuleInfoRes{Result: tpl.LabelRuleInfoFrom(*labelRule)}, nil
 }
 
+// EstimateRule 预估环境标签发布规则创建后命中的用户数，不会创建规则
+func (b *Label) EstimateRule(ctx context.Context, productName, labelName string, body tpl.LabelRuleBody) (*tpl.EstimateInfoRes, error) {
+	if body.Kind == schema.RuleChildLabelUserPercent || body.Kind == schema.RuleGroupPercent {
+		return nil, gear.ErrBadRequest.WithMsgf("estimate is not supported for kind %s", body.Kind)
+	}
+
+	readCtx := context.WithValue(ctx, model.ReadDB, true)
+	productID, err := b.ms.Product.AcquireID(readCtx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	label, err := b.ms.Label.Acquire(readCtx, productID, labelName)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.ms.LabelRule.Estimate(ctx, &schema.LabelRule{
+		ProductID: productID,
+		LabelID:   label.ID,
+		Kind:      body.Kind,
+		Rule:      body.ToRule(),
+		Salt:      body.ToSalt(nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.EstimateInfoRes{Result: *res}, nil
+}
+
 // ListRules ...
 func (b *Label) ListRules(ctx context.Context, productName, labelName string) (*tpl.LabelRulesInfoRes, error) {
 	productID, err := b.ms.Product.AcquireID(ctx, productName)
@@ -257,17 +324,47 @@ func (b *Label) UpdateRule(ctx context.Context, productName, labelName string, r
 	if rule != labelRule.Rule {
 		changed["rule"] = rule
 	}
+	for k, v := range body.RuleScheduleBody.ToMap(labelRule.StartAt, labelRule.EndAt) {
+		changed[k] = v
+	}
+	salt := body.ToSalt(&labelRule.Salt)
+	if err = tpl.ValidateRulePercent(salt, body.Rule.Value); err != nil {
+		return nil, err
+	}
+	if salt != labelRule.Salt {
+		changed["salt"] = salt
+	}
 
+	applyGroups := false
 	if len(changed) > 0 {
 		release, err := b.ms.Label.AcquireRelease(ctx, label.ID)
 		if err != nil {
 			return nil, err
 		}
 		changed["rls"] = release
+		oldRule := labelRule.Rule
 		labelRule, err = b.ms.LabelRule.Update(ctx, labelRule.ID, changed)
 		if err != nil {
 			return nil, err
 		}
+		_, ruleChanged := changed["rule"]
+		_, saltChanged := changed["salt"]
+		rebucket := saltChanged || (ruleChanged && bucketByChanged(labelRule.Kind, oldRule, labelRule.Rule))
+		revokeAll := rebucket || (ruleChanged && conditionsChanged(labelRule.Kind, oldRule, labelRule.Rule))
+		if ruleChanged || rebucket {
+			// 回收不再命中规则的用户，salt 或 bucketBy 改变时分桶改变，conditions 改变时无法按分桶判断是否命中，
+			// 规则写入的记录全部回收，由后续请求重新计算
+			if _, err = b.ms.LabelRule.Revoke(ctx, labelRule, revokeAll); err != nil {
+				return nil, err
+			}
+			// 群组指派不会在请求时计算，由回填任务重新写入命中的群组
+			applyGroups = labelRule.Kind == schema.RuleGroupPercent
+		}
+	}
+	if body.Backfill != nil || applyGroups {
+		if err = startRuleBackfill(ctx, b.ms, schema.TableLabelRule, labelRule.ID, labelRule.Kind, labelRule.Rule, body.Backfill); err != nil {
+			return nil, err
+		}
 	}
 
 	return &tpl.LabelRuleInfoRes{Result: tpl.LabelRuleInfoFrom(*labelRule)}, nil
@@ -295,6 +392,10 @@ func (b *Label) DeleteRule(ctx context.Context, productName, labelName string, r
 		return nil, gear.ErrNotFound.WithMsgf("label rule not matched!")
 	}
 
+	// 先回收规则写入的指派记录，直接指派和群组指派的记录不受影响
+	if _, err = b.ms.LabelRule.Revoke(ctx, labelRule, true); err != nil {
+		return nil, err
+	}
 	rowsAffected, err := b.ms.LabelRule.Delete(ctx, labelRule.ID)
 	if err != nil {
 		return nil, err
@@ -303,6 +404,85 @@ func (b *Label) DeleteRule(ctx context.Context, productName, labelName string, r
 	return res, nil
 }
 
+// CreateRuleRamp 为环境标签的发布规则创建渐进发布计划
+func (b *Label) CreateRuleRamp(ctx context.Context, productName, labelName string, ruleID int64, body tpl.RuleRampBody) (*tpl.RuleRampInfoRes, error) {
+	labelRule, err := b.acquireRule(ctx, productName, labelName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return createRuleRamp(ctx, b.ms, schema.TableLabelRule, labelRule.ID, labelRule.Salt, body)
+}
+
+// GetRuleRamp 返回环境标签发布规则最近的渐进发布计划
+func (b *Label) GetRuleRamp(ctx context.Context, productName, labelName string, ruleID int64) (*tpl.RuleRampInfoRes, error) {
+	labelRule, err := b.acquireRule(ctx, productName, labelName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return getRuleRamp(ctx, b.ms, schema.TableLabelRule, labelRule.ID)
+}
+
+// UpdateRuleRampStatus 暂停、恢复或终止环境标签发布规则的渐进发布计划
+func (b *Label) UpdateRuleRampStatus(ctx context.Context, productName, labelName string, ruleID int64, action string) (*tpl.RuleRampInfoRes, error) {
+	labelRule, err := b.acquireRule(ctx, productName, labelName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return updateRuleRampStatus(ctx, b.ms, schema.TableLabelRule, labelRule.ID, action)
+}
+
+// CreateRuleBackfill 为环境标签的发布规则创建回填任务
+func (b *Label) CreateRuleBackfill(ctx context.Context, productName, labelName string, ruleID int64, body tpl.RuleBackfillBody) (*tpl.RuleBackfillInfoRes, error) {
+	labelRule, err := b.acquireRule(ctx, productName, labelName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if labelRule.Kind == schema.RuleChildLabelUserPercent {
+		return nil, gear.ErrBadRequest.WithMsgf("rule backfill is not supported for kind %s", labelRule.Kind)
+	}
+	return createRuleBackfill(ctx, b.ms, schema.TableLabelRule, labelRule.ID, ruleGroupKind(labelRule.Kind, labelRule.Rule), body)
+}
+
+// GetRuleBackfill 返回环境标签发布规则最近的回填任务
+func (b *Label) GetRuleBackfill(ctx context.Context, productName, labelName string, ruleID int64) (*tpl.RuleBackfillInfoRes, error) {
+	labelRule, err := b.acquireRule(ctx, productName, labelName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return getRuleBackfill(ctx, b.ms, schema.TableLabelRule, labelRule.ID)
+}
+
+// CancelRuleBackfill 取消环境标签发布规则执行中的回填任务
+func (b *Label) CancelRuleBackfill(ctx context.Context, productName, labelName string, ruleID int64) (*tpl.RuleBackfillInfoRes, error) {
+	labelRule, err := b.acquireRule(ctx, productName, labelName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return cancelRuleBackfill(ctx, b.ms, schema.TableLabelRule, labelRule.ID)
+}
+
+func (b *Label) acquireRule(ctx context.Context, productName, labelName string, ruleID int64) (*schema.LabelRule, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	label, err := b.ms.Label.Acquire(ctx, productID, labelName)
+	if err != nil {
+		return nil, err
+	}
+
+	labelRule, err := b.ms.LabelRule.Acquire(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if labelRule.LabelID != label.ID {
+		return nil, gear.ErrNotFound.WithMsgf("label rule not matched!")
+	}
+	return labelRule, nil
+}
+
 // ListUsers 返回产品下环境标签的用户列表
 func (b *Label) ListUsers(ctx context.Context, productName, labelName string, pg tpl.Pagination) (*tpl.LabelUsersInfoRes, error) {
 	productID, err := b.ms.Product.AcquireID(ctx, productName)
@@ -0,0 +1,241 @@
+package bll
+
+import (
+	"context"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/model"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// Layer ...
+type Layer struct {
+	ms *model.Models
+}
+
+// List 返回产品下的实验层列表
+func (b *Layer) List(ctx context.Context, productName string, pg tpl.Pagination) (*tpl.LayersRes, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+	layers, total, err := b.ms.Layer.Find(ctx, productID, pg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &tpl.LayersRes{Result: layers}
+	res.TotalSize = total
+	if len(res.Result) > pg.PageSize {
+		res.NextPageToken = tpl.IDToPageToken(res.Result[pg.PageSize].ID)
+		res.Result = res.Result[:pg.PageSize]
+	}
+	return res, nil
+}
+
+// Create 创建实验层
+func (b *Layer) Create(ctx context.Context, productName, layerName, desc string) (*tpl.LayerRes, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &schema.Layer{ProductID: productID, Name: layerName, Desc: desc, Salt: schema.NewRuleSalt()}
+	if err = b.ms.Layer.Create(ctx, layer); err != nil {
+		return nil, err
+	}
+	return &tpl.LayerRes{Result: *layer}, nil
+}
+
+// Get 返回实验层及其全部实验
+func (b *Layer) Get(ctx context.Context, productName, layerName string) (*tpl.LayerInfoRes, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := b.ms.Layer.Acquire(ctx, productID, layerName)
+	if err != nil {
+		return nil, err
+	}
+
+	experiments, err := b.ms.Layer.FindExperimentInfos(ctx, layer.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.LayerInfoRes{Result: tpl.LayerInfoFrom(*layer, experiments)}, nil
+}
+
+// Update ...
+func (b *Layer) Update(ctx context.Context, productName, layerName string, body tpl.LayerUpdateBody) (*tpl.LayerRes, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := b.ms.Layer.Acquire(ctx, productID, layerName)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err = b.ms.Layer.Update(ctx, layer.ID, body.ToMap())
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.LayerRes{Result: *layer}, nil
+}
+
+// Delete 删除实验层，其中的环境标签和配置项恢复为独立分桶，规则写入的记录按新的分桶重新计算
+func (b *Layer) Delete(ctx context.Context, productName, layerName string) (*tpl.BoolRes, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &tpl.BoolRes{Result: false}
+	layer, err := b.ms.Layer.FindByName(ctx, productID, layerName)
+	if err != nil {
+		return nil, err
+	}
+	if layer != nil {
+		experiments, err := b.ms.Layer.FindExperiments(ctx, layer.ID)
+		if err != nil {
+			return nil, err
+		}
+		rowsAffected, err := b.ms.Layer.Delete(ctx, layer.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err = resetExperimentRules(ctx, b.ms, experiments); err != nil {
+			return nil, err
+		}
+		res.Result = rowsAffected > 0
+	}
+	return res, nil
+}
+
+// AddExperiment 将环境标签或配置项加入实验层，规则已写入的记录被回收并按实验层的分桶重新计算
+func (b *Layer) AddExperiment(ctx context.Context, productName, layerName string, body tpl.LayerExperimentBody) (*tpl.LayerExperimentInfoRes, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := b.ms.Layer.Acquire(ctx, productID, layerName)
+	if err != nil {
+		return nil, err
+	}
+
+	info := tpl.LayerExperimentInfo{}
+	var experiment *schema.LayerExperiment
+	if body.Label != "" {
+		label, err := b.ms.Label.Acquire(ctx, productID, body.Label)
+		if err != nil {
+			return nil, err
+		}
+		experiment, err = b.ms.Layer.AddExperiment(ctx, layer.ID, schema.TableLabel, label.ID, body.Buckets())
+		if err != nil {
+			return nil, err
+		}
+		info.Label = label.Name
+	} else {
+		module, err := b.ms.Module.Acquire(ctx, productID, body.Module)
+		if err != nil {
+			return nil, err
+		}
+		setting, err := b.ms.Setting.Acquire(ctx, module.ID, body.Setting)
+		if err != nil {
+			return nil, err
+		}
+		experiment, err = b.ms.Layer.AddExperiment(ctx, layer.ID, schema.TableSetting, setting.ID, body.Buckets())
+		if err != nil {
+			return nil, err
+		}
+		info.Module = module.Name
+		info.Setting = setting.Name
+	}
+
+	if err = resetExperimentRules(ctx, b.ms, []schema.LayerExperiment{*experiment}); err != nil {
+		return nil, err
+	}
+
+	info.ID = experiment.ID
+	info.BucketStart = experiment.BucketStart
+	info.BucketEnd = experiment.BucketEnd
+	info.CreatedAt = experiment.CreatedAt
+	info.Fill()
+	return &tpl.LayerExperimentInfoRes{Result: info}, nil
+}
+
+// RemoveExperiment 将实验移出实验层，规则写入的记录被回收并按独立分桶重新计算
+func (b *Layer) RemoveExperiment(ctx context.Context, productName, layerName string, experimentID int64) (*tpl.BoolRes, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := b.ms.Layer.Acquire(ctx, productID, layerName)
+	if err != nil {
+		return nil, err
+	}
+
+	experiment, err := b.ms.Layer.AcquireExperiment(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	if experiment.LayerID != layer.ID {
+		return nil, gear.ErrNotFound.WithMsgf("layer experiment not matched!")
+	}
+
+	rowsAffected, err := b.ms.Layer.RemoveExperiment(ctx, experiment.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err = resetExperimentRules(ctx, b.ms, []schema.LayerExperiment{*experiment}); err != nil {
+		return nil, err
+	}
+	return &tpl.BoolRes{Result: rowsAffected > 0}, nil
+}
+
+// resetExperimentRules 实验对象加入或移出实验层后分桶空间改变，回收其用户类发布规则写入的全部记录，
+// 与修改 salt 相同，再为有回填任务的规则重新回填。groupPercent 规则不参与实验层，不受影响。
+func resetExperimentRules(ctx context.Context, ms *model.Models, experiments []schema.LayerExperiment) error {
+	for _, e := range experiments {
+		if e.Target == schema.TableLabel {
+			rules, err := ms.LabelRule.FindByLabel(ctx, e.TargetID)
+			if err != nil {
+				return err
+			}
+			for i := range rules {
+				if rules[i].Kind == schema.RuleGroupPercent {
+					continue
+				}
+				if _, err = ms.LabelRule.Revoke(ctx, &rules[i], true); err != nil {
+					return err
+				}
+				if err = restartRuleBackfill(ctx, ms, schema.TableLabelRule, rules[i].ID, rules[i].Kind, rules[i].Rule); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		rules, err := ms.SettingRule.FindBySetting(ctx, e.TargetID)
+		if err != nil {
+			return err
+		}
+		for i := range rules {
+			if rules[i].Kind == schema.RuleGroupPercent {
+				continue
+			}
+			if _, err = ms.SettingRule.Revoke(ctx, &rules[i], true); err != nil {
+				return err
+			}
+			if err = restartRuleBackfill(ctx, ms, schema.TableSettingRule, rules[i].ID, rules[i].Kind, rules[i].Rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -21,7 +21,7 @@ func (b *Product) List(ctx context.Context, pg tpl.Pagination) (*tpl.ProductsRes
 		return nil, err
 	}
 
-	res := &tpl.ProductsRes{Result: products}
+	res := &tpl.ProductsRes{Result: tpl.ProductsInfoFrom(products)}
 	res.TotalSize = total
 	if len(res.Result) > pg.PageSize {
 		res.NextPageToken = tpl.IDToPageToken(res.Result[pg.PageSize].ID)
@@ -36,7 +36,7 @@ func (b *Product) Create(ctx context.Context, name, desc string) (*tpl.ProductRe
 	if err := b.ms.Product.Create(ctx, product); err != nil {
 		return nil, err
 	}
-	res := &tpl.ProductRes{Result: *product}
+	res := &tpl.ProductRes{Result: tpl.ProductInfoFrom(*product)}
 	return res, nil
 }
 
@@ -51,7 +51,7 @@ func (b *Product) Update(ctx context.Context, productName string, body tpl.Produ
 	if err != nil {
 		return nil, err
 	}
-	return &tpl.ProductRes{Result: *product}, nil
+	return &tpl.ProductRes{Result: tpl.ProductInfoFrom(*product)}, nil
 }
 
 // Offline 下线产品
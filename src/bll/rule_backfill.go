@@ -0,0 +1,275 @@
+package bll
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/model"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// RuleBackfill 规则回填任务的后台执行
+type RuleBackfill struct {
+	ms *model.Models
+}
+
+// Run 按 interval 定时执行到期的回填任务批次，直到 ctx 结束
+func (b *RuleBackfill) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.RunDue(ctx)
+		}
+	}
+}
+
+// RunDue 为每个到期的回填任务执行一个批次，返回执行的批次数
+func (b *RuleBackfill) RunDue(ctx context.Context) int {
+	backfills, err := b.ms.RuleBackfill.FindDue(ctx, time.Now().UTC(), 100)
+	if err != nil {
+		logging.Warningf("RuleBackfill.FindDue error: %v", err)
+		return 0
+	}
+
+	count := 0
+	for _, backfill := range backfills {
+		if err := b.ms.RuleBackfill.Lock(ctx, backfill.ID); err != nil {
+			continue // 其它实例正在执行
+		}
+		// 加锁后重新读取，确认状态未被改变
+		r, err := b.ms.RuleBackfill.Acquire(ctx, backfill.ID)
+		if err == nil && r.Status == schema.BackfillStatusRunning && !r.NextAt.After(time.Now().UTC()) {
+			if _, err = stepRuleBackfill(ctx, b.ms, r); err == nil {
+				count++
+			}
+		}
+		if err != nil {
+			logging.Warningf("RuleBackfill.step: backfill %d, error %v", backfill.ID, err)
+		}
+		b.ms.RuleBackfill.Unlock(ctx, backfill.ID)
+	}
+	return count
+}
+
+// startRuleBackfill 创建或更新规则后启动回填任务，body 为空时使用默认参数。
+// groupPercent 规则的群组指派只由回填任务写入，创建失败时返回错误；其它规则回填失败不影响规则本身。
+func startRuleBackfill(ctx context.Context, ms *model.Models, target string, ruleID int64, kind, rule string, body *tpl.RuleBackfillBody) error {
+	backfill := tpl.DefaultRuleBackfillBody()
+	if body != nil {
+		backfill = *body
+	}
+	if _, err := createRuleBackfill(ctx, ms, target, ruleID, ruleGroupKind(kind, rule), backfill); err != nil {
+		if kind == schema.RuleGroupPercent {
+			return err
+		}
+		logging.Warningf("startRuleBackfill: %s %d, error %v", target, ruleID, err)
+	}
+	return nil
+}
+
+// restartRuleBackfill 规则写入的记录被全部回收后，按最近一次回填任务的参数重新回填，没有回填任务的规则不处理
+func restartRuleBackfill(ctx context.Context, ms *model.Models, target string, ruleID int64, kind, rule string) error {
+	latest, err := ms.RuleBackfill.AcquireLatest(ctx, target, ruleID)
+	if err != nil {
+		if gear.ParseError(err).Status() == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	body := tpl.RuleBackfillBody{
+		BatchSize: latest.BatchSize,
+		Interval:  (time.Duration(latest.Interval) * time.Millisecond).String(),
+	}
+	_, err = createRuleBackfill(ctx, ms, target, ruleID, ruleGroupKind(kind, rule), body)
+	return err
+}
+
+// ruleGroupKind 返回 groupPercent 规则作用的群组类型，其它规则返回空字符串
+func ruleGroupKind(kind, rule string) string {
+	if kind != schema.RuleGroupPercent {
+		return ""
+	}
+	return schema.ToPercentRule(kind, rule).Rule.GroupKind
+}
+
+// bucketByChanged 判断规则更新是否改变了 bucketBy，改变后用户的分桶全部改变，与修改 salt 相同
+func bucketByChanged(kind, oldRule, newRule string) bool {
+	return schema.ToPercentRule(kind, oldRule).Rule.BucketBy != schema.ToPercentRule(kind, newRule).Rule.BucketBy
+}
+
+// conditionsChanged 判断 userAttribute 规则更新是否改变了 conditions，
+// 已写入的记录无法按分桶判断是否仍命中新的条件，需全部回收
+func conditionsChanged(kind, oldRule, newRule string) bool {
+	if kind != schema.RuleUserAttribute {
+		return false
+	}
+	return !reflect.DeepEqual(schema.ToPercentRule(kind, oldRule).Rule.Conditions, schema.ToPercentRule(kind, newRule).Rule.Conditions)
+}
+
+// createRuleBackfill 为规则创建回填任务，并立即执行第一个批次。groupKind 非空时遍历该类型的群组，否则遍历用户。
+// 规则已有执行中的回填任务时将其取消，由新任务从头遍历。
+func createRuleBackfill(ctx context.Context, ms *model.Models, target string, ruleID int64, groupKind string, body tpl.RuleBackfillBody) (*tpl.RuleBackfillInfoRes, error) {
+	if latest, err := ms.RuleBackfill.AcquireLatest(ctx, target, ruleID); err == nil && latest.Status == schema.BackfillStatusRunning {
+		if err := ms.RuleBackfill.Lock(ctx, latest.ID); err != nil {
+			return nil, gear.ErrConflict.From(err)
+		}
+		_, err := ms.RuleBackfill.Update(ctx, latest.ID, map[string]interface{}{
+			"status":  schema.BackfillStatusCancelled,
+			"message": "superseded by a new backfill",
+		})
+		ms.RuleBackfill.Unlock(ctx, latest.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var total int64
+	var err error
+	if groupKind != "" {
+		total, err = ms.RuleBackfill.CountGroups(ctx, groupKind)
+	} else {
+		total, err = ms.RuleBackfill.CountUsers(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	backfill := &schema.RuleBackfill{
+		Target:    target,
+		RuleID:    ruleID,
+		Status:    schema.BackfillStatusRunning,
+		Total:     total,
+		BatchSize: body.BatchSize,
+		Interval:  body.IntervalMilliseconds(),
+		NextAt:    time.Now().UTC(),
+	}
+	if err := ms.RuleBackfill.Create(ctx, backfill); err != nil {
+		return nil, err
+	}
+
+	if err := ms.RuleBackfill.Lock(ctx, backfill.ID); err != nil {
+		return nil, err
+	}
+	defer ms.RuleBackfill.Unlock(ctx, backfill.ID)
+
+	if backfill, err = stepRuleBackfill(ctx, ms, backfill); err != nil {
+		return nil, err
+	}
+	return &tpl.RuleBackfillInfoRes{Result: tpl.RuleBackfillInfoFrom(*backfill)}, nil
+}
+
+// getRuleBackfill 返回规则最近的回填任务及其进度
+func getRuleBackfill(ctx context.Context, ms *model.Models, target string, ruleID int64) (*tpl.RuleBackfillInfoRes, error) {
+	backfill, err := ms.RuleBackfill.AcquireLatest(ctx, target, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.RuleBackfillInfoRes{Result: tpl.RuleBackfillInfoFrom(*backfill)}, nil
+}
+
+// cancelRuleBackfill 取消规则执行中的回填任务，已写入的指派记录不会撤销
+func cancelRuleBackfill(ctx context.Context, ms *model.Models, target string, ruleID int64) (*tpl.RuleBackfillInfoRes, error) {
+	backfill, err := ms.RuleBackfill.AcquireLatest(ctx, target, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if backfill.Status != schema.BackfillStatusRunning {
+		return nil, gear.ErrBadRequest.WithMsgf("can not cancel rule backfill, it is %s", backfill.Status)
+	}
+
+	if err := ms.RuleBackfill.Lock(ctx, backfill.ID); err != nil {
+		return nil, gear.ErrConflict.From(err)
+	}
+	defer ms.RuleBackfill.Unlock(ctx, backfill.ID)
+
+	if backfill, err = ms.RuleBackfill.Update(ctx, backfill.ID, map[string]interface{}{"status": schema.BackfillStatusCancelled}); err != nil {
+		return nil, err
+	}
+	return &tpl.RuleBackfillInfoRes{Result: tpl.RuleBackfillInfoFrom(*backfill)}, nil
+}
+
+// stepRuleBackfill 执行回填任务的下一个批次，调用方需持有任务的锁。
+// 规则尚未生效时推迟到生效时间执行，规则已结束或被删除时结束任务。
+func stepRuleBackfill(ctx context.Context, ms *model.Models, backfill *schema.RuleBackfill) (*schema.RuleBackfill, error) {
+	now := time.Now().UTC()
+	var batch *model.BackfillBatch
+	var startAt, endAt *time.Time
+	var err error
+
+	switch backfill.Target {
+	case schema.TableLabelRule:
+		var labelRule *schema.LabelRule
+		if labelRule, err = ms.LabelRule.Acquire(ctx, backfill.RuleID); err == nil {
+			startAt, endAt = labelRule.StartAt, labelRule.EndAt
+			if schema.RuleScheduleState(startAt, endAt, now) == schema.RuleStateActive {
+				batch, err = ms.LabelRule.Backfill(ctx, labelRule, backfill.Cursor, backfill.BatchSize)
+			}
+		}
+	case schema.TableSettingRule:
+		var settingRule *schema.SettingRule
+		if settingRule, err = ms.SettingRule.Acquire(ctx, backfill.RuleID); err == nil {
+			startAt, endAt = settingRule.StartAt, settingRule.EndAt
+			if schema.RuleScheduleState(startAt, endAt, now) == schema.RuleStateActive {
+				batch, err = ms.SettingRule.Backfill(ctx, settingRule, backfill.Cursor, backfill.BatchSize)
+			}
+		}
+	default:
+		err = gear.ErrBadRequest.WithMsgf("invalid rule backfill target: %s", backfill.Target)
+	}
+
+	if err != nil {
+		if gear.ParseError(err).Status() == http.StatusNotFound {
+			// 规则已被删除，结束任务
+			return ms.RuleBackfill.Update(ctx, backfill.ID, map[string]interface{}{
+				"status":  schema.BackfillStatusFailed,
+				"message": "rule not found",
+			})
+		}
+		// 其它错误在下一批次时间重试
+		if _, e := ms.RuleBackfill.Update(ctx, backfill.ID, map[string]interface{}{
+			"next_at": now.Add(time.Duration(backfill.Interval) * time.Millisecond),
+			"message": truncateMessage(err.Error()),
+		}); e != nil {
+			logging.Warningf("RuleBackfill.Update: backfill %d, error %v", backfill.ID, e)
+		}
+		return nil, err
+	}
+
+	if batch == nil {
+		if schema.RuleScheduleState(startAt, endAt, now) == schema.RuleStatePending {
+			return ms.RuleBackfill.Update(ctx, backfill.ID, map[string]interface{}{"next_at": *startAt})
+		}
+		return ms.RuleBackfill.Update(ctx, backfill.ID, map[string]interface{}{
+			"status":  schema.BackfillStatusCompleted,
+			"message": "rule ended",
+		})
+	}
+
+	changed := map[string]interface{}{
+		"cursor_id": batch.Cursor,
+		"processed": backfill.Processed + int64(batch.Processed),
+		"applied":   backfill.Applied + int64(batch.Applied),
+		"next_at":   now.Add(time.Duration(backfill.Interval) * time.Millisecond),
+		"message":   "",
+	}
+	if batch.Processed < backfill.BatchSize {
+		changed["status"] = schema.BackfillStatusCompleted
+	}
+	return ms.RuleBackfill.Update(ctx, backfill.ID, changed)
+}
+
+// truncateMessage 截断备注，适配 varchar(255)
+func truncateMessage(msg string) string {
+	if r := []rune(msg); len(r) > 255 {
+		return string(r[:255])
+	}
+	return msg
+}
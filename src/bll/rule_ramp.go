@@ -0,0 +1,285 @@
+package bll
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/model"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// rampRetryDelay 渐进发布计划的步骤执行出错时，到下次重试的间隔
+const rampRetryDelay = time.Minute
+
+// RuleRamp 规则渐进发布计划的后台执行
+type RuleRamp struct {
+	ms *model.Models
+}
+
+// Run 按 interval 定时执行到期的渐进发布计划，直到 ctx 结束
+func (b *RuleRamp) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.RunDue(ctx)
+		}
+	}
+}
+
+// RunDue 执行到期的渐进发布计划，返回执行的计划数
+func (b *RuleRamp) RunDue(ctx context.Context) int {
+	ramps, err := b.ms.RuleRamp.FindDue(ctx, time.Now().UTC(), 100)
+	if err != nil {
+		logging.Warningf("RuleRamp.FindDue error: %v", err)
+		return 0
+	}
+
+	count := 0
+	for _, ramp := range ramps {
+		if err := b.ms.RuleRamp.Lock(ctx, ramp.ID); err != nil {
+			continue // 其它实例正在执行
+		}
+		// 加锁后重新读取，确认状态未被改变
+		r, err := b.ms.RuleRamp.Acquire(ctx, ramp.ID)
+		if err == nil && r.Status == schema.RampStatusRunning && !r.NextAt.After(time.Now().UTC()) {
+			if _, err = stepRuleRamp(ctx, b.ms, r); err == nil {
+				count++
+			}
+		}
+		if err != nil {
+			logging.Warningf("RuleRamp.step: ramp %d, error %v", ramp.ID, err)
+		}
+		b.ms.RuleRamp.Unlock(ctx, ramp.ID)
+	}
+	return count
+}
+
+// createRuleRamp 为规则创建渐进发布计划，并立即执行第一步，salt 为规则的分桶盐值
+func createRuleRamp(ctx context.Context, ms *model.Models, target string, ruleID int64, salt string, body tpl.RuleRampBody) (*tpl.RuleRampInfoRes, error) {
+	// legacy 分桶按整数百分比分桶，小数步骤不会改变实际覆盖的用户
+	for _, v := range body.Steps {
+		if err := tpl.ValidateRulePercent(salt, v); err != nil {
+			return nil, err
+		}
+	}
+	if latest, err := ms.RuleRamp.AcquireLatest(ctx, target, ruleID); err == nil {
+		if latest.Status == schema.RampStatusRunning || latest.Status == schema.RampStatusPaused {
+			return nil, gear.ErrConflict.WithMsgf("rule ramp is %s", latest.Status)
+		}
+	}
+
+	ramp := &schema.RuleRamp{
+		Target:   target,
+		RuleID:   ruleID,
+		Steps:    body.StepsString(),
+		Interval: body.IntervalSeconds(),
+		Step:     0,
+		Status:   schema.RampStatusRunning,
+		NextAt:   time.Now().UTC(),
+	}
+	if err := ms.RuleRamp.Create(ctx, ramp); err != nil {
+		return nil, err
+	}
+	if err := ms.RuleRamp.AddLog(ctx, &schema.RuleRampLog{RampID: ramp.ID, Action: schema.RampActionStart}); err != nil {
+		return nil, err
+	}
+
+	if err := ms.RuleRamp.Lock(ctx, ramp.ID); err != nil {
+		return nil, err
+	}
+	defer ms.RuleRamp.Unlock(ctx, ramp.ID)
+
+	ramp, err := stepRuleRamp(ctx, ms, ramp)
+	if err != nil {
+		return nil, err
+	}
+	return ruleRampInfoRes(ctx, ms, ramp)
+}
+
+// getRuleRamp 返回规则最近的渐进发布计划及其执行历史
+func getRuleRamp(ctx context.Context, ms *model.Models, target string, ruleID int64) (*tpl.RuleRampInfoRes, error) {
+	ramp, err := ms.RuleRamp.AcquireLatest(ctx, target, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return ruleRampInfoRes(ctx, ms, ramp)
+}
+
+// updateRuleRampStatus 暂停、恢复或终止规则最近的渐进发布计划
+func updateRuleRampStatus(ctx context.Context, ms *model.Models, target string, ruleID int64, action string) (*tpl.RuleRampInfoRes, error) {
+	ramp, err := ms.RuleRamp.AcquireLatest(ctx, target, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ms.RuleRamp.Lock(ctx, ramp.ID); err != nil {
+		return nil, gear.ErrConflict.From(err)
+	}
+	defer ms.RuleRamp.Unlock(ctx, ramp.ID)
+
+	// 加锁后重新读取，后台任务可能已推进或完成该计划
+	if ramp, err = ms.RuleRamp.Acquire(ctx, ramp.ID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	changed := map[string]interface{}{}
+	switch {
+	case action == schema.RampActionPause && ramp.Status == schema.RampStatusRunning:
+		changed["status"] = schema.RampStatusPaused
+	case action == schema.RampActionResume && ramp.Status == schema.RampStatusPaused:
+		changed["status"] = schema.RampStatusRunning
+		if ramp.NextAt.Before(now) {
+			changed["next_at"] = now
+		}
+	case action == schema.RampActionAbort && (ramp.Status == schema.RampStatusRunning || ramp.Status == schema.RampStatusPaused):
+		changed["status"] = schema.RampStatusAborted
+	default:
+		return nil, gear.ErrBadRequest.WithMsgf("can not %s rule ramp, it is %s", action, ramp.Status)
+	}
+
+	if ramp, err = ms.RuleRamp.Update(ctx, ramp.ID, changed); err != nil {
+		return nil, err
+	}
+	log := &schema.RuleRampLog{RampID: ramp.ID, Action: action}
+	if ramp.Step > 0 {
+		log.Value = ramp.StepValues()[ramp.Step-1]
+	}
+	if err := ms.RuleRamp.AddLog(ctx, log); err != nil {
+		return nil, err
+	}
+	return ruleRampInfoRes(ctx, ms, ramp)
+}
+
+// stepRuleRamp 执行渐进发布计划的下一步，更新规则的百分比并获取新的发布批次，调用方需持有计划的锁
+func stepRuleRamp(ctx context.Context, ms *model.Models, ramp *schema.RuleRamp) (*schema.RuleRamp, error) {
+	steps := ramp.StepValues()
+	if ramp.Step >= len(steps) {
+		return ms.RuleRamp.Update(ctx, ramp.ID, map[string]interface{}{"status": schema.RampStatusCompleted})
+	}
+
+	value := steps[ramp.Step]
+	release, err := setRulePercent(ctx, ms, ramp.Target, ramp.RuleID, value)
+	if err != nil {
+		changed := map[string]interface{}{"next_at": time.Now().UTC().Add(rampRetryDelay)}
+		message := ""
+		switch gear.ParseError(err).Status() {
+		case http.StatusNotFound:
+			// 规则已被删除，终止计划
+			changed, message = map[string]interface{}{"status": schema.RampStatusAborted}, "rule not found"
+		case http.StatusBadRequest:
+			// 规则已不再适用该步骤，如切换到了 legacy 分桶，重试不会成功，终止计划
+			changed, message = map[string]interface{}{"status": schema.RampStatusAborted}, err.Error()
+		}
+		// 其它错误推迟到 rampRetryDelay 之后重试
+		if _, e := ms.RuleRamp.Update(ctx, ramp.ID, changed); e != nil {
+			return nil, e
+		}
+		if message != "" {
+			ms.RuleRamp.AddLog(ctx, &schema.RuleRampLog{RampID: ramp.ID, Action: schema.RampActionAbort, Message: message})
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	changed := map[string]interface{}{
+		"step":    ramp.Step + 1,
+		"next_at": now.Add(time.Duration(ramp.Interval) * time.Second),
+	}
+	action := schema.RampActionStep
+	if ramp.Step+1 >= len(steps) {
+		changed["status"] = schema.RampStatusCompleted
+		action = schema.RampActionComplete
+	}
+	if ramp, err = ms.RuleRamp.Update(ctx, ramp.ID, changed); err != nil {
+		return nil, err
+	}
+	if err := ms.RuleRamp.AddLog(ctx, &schema.RuleRampLog{RampID: ramp.ID, Action: action, Value: value, Release: release}); err != nil {
+		return nil, err
+	}
+	return ramp, nil
+}
+
+// setRulePercent 更新规则的百分比，返回新的发布批次
+func setRulePercent(ctx context.Context, ms *model.Models, target string, ruleID int64, value float64) (int64, error) {
+	switch target {
+	case schema.TableLabelRule:
+		labelRule, err := ms.LabelRule.Acquire(ctx, ruleID)
+		if err != nil {
+			return 0, err
+		}
+		// 计划创建后规则可能已切换到 legacy 分桶
+		if err = tpl.ValidateRulePercent(labelRule.Salt, value); err != nil {
+			return 0, err
+		}
+		r := schema.ToPercentRule(labelRule.Kind, labelRule.Rule)
+		if r.Rule.Value < 0 {
+			return 0, gear.ErrBadRequest.WithMsgf("invalid label rule %d", ruleID)
+		}
+		r.Rule.Value = value
+		release, err := ms.Label.AcquireRelease(ctx, labelRule.LabelID)
+		if err != nil {
+			return 0, err
+		}
+		if labelRule, err = ms.LabelRule.Update(ctx, ruleID, map[string]interface{}{"rule": r.ToRule(), "rls": release}); err != nil {
+			return 0, err
+		}
+		// 百分比降低时回收不再命中规则的用户
+		if _, err = ms.LabelRule.Revoke(ctx, labelRule, false); err != nil {
+			return release, err
+		}
+		// groupPercent 规则的百分比提高时由回填任务写入新命中的群组
+		if labelRule.Kind == schema.RuleGroupPercent {
+			err = startRuleBackfill(ctx, ms, schema.TableLabelRule, ruleID, labelRule.Kind, labelRule.Rule, nil)
+		}
+		return release, err
+
+	case schema.TableSettingRule:
+		settingRule, err := ms.SettingRule.Acquire(ctx, ruleID)
+		if err != nil {
+			return 0, err
+		}
+		// 计划创建后规则可能已切换到 legacy 分桶
+		if err = tpl.ValidateRulePercent(settingRule.Salt, value); err != nil {
+			return 0, err
+		}
+		r := schema.ToPercentRule(settingRule.Kind, settingRule.Rule)
+		if r.Rule.Value < 0 {
+			return 0, gear.ErrBadRequest.WithMsgf("invalid setting rule %d", ruleID)
+		}
+		r.Rule.Value = value
+		release, err := ms.Setting.AcquireRelease(ctx, settingRule.SettingID)
+		if err != nil {
+			return 0, err
+		}
+		if settingRule, err = ms.SettingRule.Update(ctx, ruleID, map[string]interface{}{"rule": r.ToRule(), "rls": release}); err != nil {
+			return 0, err
+		}
+		// 百分比降低时回收不再命中规则的用户
+		if _, err = ms.SettingRule.Revoke(ctx, settingRule, false); err != nil {
+			return release, err
+		}
+		// groupPercent 规则的百分比提高时由回填任务写入新命中的群组
+		if settingRule.Kind == schema.RuleGroupPercent {
+			err = startRuleBackfill(ctx, ms, schema.TableSettingRule, ruleID, settingRule.Kind, settingRule.Rule, nil)
+		}
+		return release, err
+	}
+	return 0, gear.ErrBadRequest.WithMsgf("invalid rule ramp target: %s", target)
+}
+
+func ruleRampInfoRes(ctx context.Context, ms *model.Models, ramp *schema.RuleRamp) (*tpl.RuleRampInfoRes, error) {
+	logs, err := ms.RuleRamp.FindLogs(ctx, ramp.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.RuleRampInfoRes{Result: tpl.RuleRampInfoFrom(*ramp, logs)}, nil
+}
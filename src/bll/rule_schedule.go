@@ -0,0 +1,58 @@
+package bll
+
+import (
+	"context"
+	"time"
+
+	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/model"
+)
+
+// RuleSchedule 规则定时生效的后台执行，规则失效后回收其写入的指派记录
+type RuleSchedule struct {
+	ms *model.Models
+}
+
+// Run 按 interval 定时回收已失效规则写入的指派记录，直到 ctx 结束
+func (b *RuleSchedule) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.RunDue(ctx)
+		}
+	}
+}
+
+// RunDue 回收已失效规则写入的全部指派记录，直接指派的记录不受影响，返回处理的规则数
+func (b *RuleSchedule) RunDue(ctx context.Context) int {
+	now := time.Now().UTC()
+	count := 0
+	labelRules, err := b.ms.LabelRule.FindEnded(ctx, now, 100)
+	if err != nil {
+		logging.Warningf("LabelRule.FindEnded error: %v", err)
+	}
+	for i := range labelRules {
+		if _, err := b.ms.LabelRule.Revoke(ctx, &labelRules[i], true); err != nil {
+			logging.Warningf("LabelRule.Revoke: rule %d, error %v", labelRules[i].ID, err)
+			continue
+		}
+		count++
+	}
+
+	settingRules, err := b.ms.SettingRule.FindEnded(ctx, now, 100)
+	if err != nil {
+		logging.Warningf("SettingRule.FindEnded error: %v", err)
+	}
+	for i := range settingRules {
+		if _, err := b.ms.SettingRule.Revoke(ctx, &settingRules[i], true); err != nil {
+			logging.Warningf("SettingRule.Revoke: rule %d, error %v", settingRules[i].ID, err)
+			continue
+		}
+		count++
+	}
+	return count
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/conf"
 	"github.com/teambition/urbs-setting/src/model"
 	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/tpl"
@@ -87,6 +88,32 @@ func (b *Setting) Create(ctx context.Context, productName, moduleName string, bo
 	if body.Values != nil {
 		setting.Values = strings.Join(*body.Values, ",")
 	}
+	if body.DefaultValue != nil {
+		setting.DefaultValue = *body.DefaultValue
+	}
+	if body.DefaultOverrides != nil {
+		setting.DefaultOverrides = schema.DefaultOverridesToString(*body.DefaultOverrides)
+	}
+	if body.ValueType != "" {
+		setting.ValueType = body.ValueType
+		setting.ValueSchema, _ = tpl.ValueSchemaToString(body.ValueSchema)
+	}
+	setting.MinVersion = body.MinVersion
+	setting.MaxVersion = body.MaxVersion
+	if err = checkSettingValues(setting); err != nil {
+		return nil, err
+	}
+	if body.Prerequisite != nil {
+		prereq, err := b.acquirePrerequisite(ctx, productID, body.Prerequisite)
+		if err != nil {
+			return nil, err
+		}
+		if err = b.ms.Setting.CheckPrerequisite(ctx, 0, prereq.ID); err != nil {
+			return nil, err
+		}
+		setting.PrereqID = prereq.ID
+		setting.PrereqValue = body.Prerequisite.Value
+	}
 
 	if err = b.ms.Setting.Create(ctx, setting); err != nil {
 		return nil, err
@@ -111,13 +138,131 @@ func (b *Setting) Update(ctx context.Context, productName, moduleName, settingNa
 		return nil, err
 	}
 
-	setting, err = b.ms.Setting.Update(ctx, setting.ID, body.ToMap())
+	if err = checkVersionRange(setting.MinVersion, setting.MaxVersion, body.MinVersion, body.MaxVersion); err != nil {
+		return nil, err
+	}
+	changed := body.ToMap()
+	if body.Values != nil || body.DefaultValue != nil || body.DefaultOverrides != nil ||
+		body.ValueType != nil || body.ValueSchema != nil {
+		next := *setting
+		if v, ok := changed["vals"]; ok {
+			next.Values = v.(string)
+		}
+		if v, ok := changed["default_value"]; ok {
+			next.DefaultValue = v.(string)
+		}
+		if v, ok := changed["default_overrides"]; ok {
+			next.DefaultOverrides = v.(string)
+		}
+		if v, ok := changed["value_type"]; ok {
+			next.ValueType = v.(string)
+		}
+		if v, ok := changed["value_schema"]; ok {
+			next.ValueSchema = v.(string)
+		}
+		if err = checkSettingValues(&next); err != nil {
+			return nil, err
+		}
+	}
+	if body.Prerequisite != nil {
+		changed["prereq_id"] = 0
+		changed["prereq_value"] = ""
+		if body.Prerequisite.Setting != "" {
+			prereq, err := b.acquirePrerequisite(ctx, productID, body.Prerequisite)
+			if err != nil {
+				return nil, err
+			}
+			if err = b.ms.Setting.CheckPrerequisite(ctx, setting.ID, prereq.ID); err != nil {
+				return nil, err
+			}
+			changed["prereq_id"] = prereq.ID
+			changed["prereq_value"] = body.Prerequisite.Value
+		}
+	}
+
+	setting, err = b.ms.Setting.Update(ctx, setting.ID, changed)
 	if err != nil {
 		return nil, err
 	}
 	return &tpl.SettingInfoRes{Result: tpl.SettingInfoFrom(*setting, productName, moduleName)}, nil
 }
 
+// checkVersionRange 检查更新后的客户端版本范围，min、max 为 nil 时沿用已有值
+func checkVersionRange(curMin, curMax string, min, max *string) error {
+	if min == nil && max == nil {
+		return nil
+	}
+	if min != nil {
+		curMin = *min
+	}
+	if max != nil {
+		curMax = *max
+	}
+	return tpl.ValidateVersionRange(curMin, curMax)
+}
+
+// checkSettingValues 检查配置项的可选值及默认值符合值类型和约束，设置了可选值列表时默认值必须在列表中
+func checkSettingValues(setting *schema.Setting) error {
+	vals := tpl.StringToSlice(setting.Values)
+	if setting.ValueType == schema.SettingTypeJSON && len(vals) > 0 {
+		return gear.ErrBadRequest.WithMsg("values not supported for json valueType")
+	}
+	for _, v := range vals {
+		if err := setting.ValidateValue(v); err != nil {
+			return gear.ErrBadRequest.WithMsg(err.Error())
+		}
+	}
+
+	defaults := []string{}
+	if setting.DefaultValue != "" {
+		defaults = append(defaults, setting.DefaultValue)
+	}
+	for _, o := range schema.ToDefaultOverrides(setting.DefaultOverrides) {
+		defaults = append(defaults, o.Value)
+	}
+	for _, v := range defaults {
+		if len(vals) > 0 && !tpl.StringSliceHas(vals, v) {
+			return gear.ErrBadRequest.WithMsgf("default value %s is not in setting values", v)
+		}
+		if err := setting.ValidateValue(v); err != nil {
+			return gear.ErrBadRequest.WithMsg(err.Error())
+		}
+	}
+	return nil
+}
+
+// checkSettingValue 检查指派给用户、群组或发布规则的配置项值：
+// 值类型为 string 或设置了可选值列表时必须在列表中，且符合值类型和约束
+func checkSettingValue(setting *schema.Setting, value string) error {
+	vals := tpl.StringToSlice(setting.Values)
+	isString := setting.ValueType == "" || setting.ValueType == schema.SettingTypeString
+	if (isString || len(vals) > 0) && !tpl.StringSliceHas(vals, value) {
+		return gear.ErrBadRequest.WithMsgf("value %s is not in setting", value)
+	}
+	if err := setting.ValidateValue(value); err != nil {
+		return gear.ErrBadRequest.WithMsg(err.Error())
+	}
+	return nil
+}
+
+// acquirePrerequisite 查找同一产品线下作为前置条件的配置项，并检查前置条件的取值
+func (b *Setting) acquirePrerequisite(ctx context.Context, productID int64, body *tpl.SettingPrerequisiteBody) (*schema.Setting, error) {
+	module, err := b.ms.Module.Acquire(ctx, productID, body.Module)
+	if err != nil {
+		return nil, err
+	}
+
+	prereq, err := b.ms.Setting.Acquire(ctx, module.ID, body.Setting)
+	if err != nil {
+		return nil, err
+	}
+	vals := tpl.StringToSlice(prereq.Values)
+	if len(vals) > 0 && !tpl.StringSliceHas(vals, body.Value) {
+		return nil, gear.ErrBadRequest.WithMsgf("prerequisite value %s is not in setting %s", body.Value, body.Setting)
+	}
+	return prereq, nil
+}
+
 // Offline 下线功能模块配置项
 func (b *Setting) Offline(ctx context.Context, productName, moduleName, settingName string) (*tpl.BoolRes, error) {
 	productID, err := b.ms.Product.AcquireID(ctx, productName)
@@ -163,11 +308,43 @@ func (b *Setting) Assign(ctx context.Context, productName, moduleName, settingNa
 	if err != nil {
 		return nil, err
 	}
-	vals := tpl.StringToSlice(setting.Values)
-	if value != "" && !tpl.StringSliceHas(vals, value) {
-		return nil, gear.ErrBadRequest.WithMsgf("value %s is not in setting", value)
+	if value != "" {
+		if err = checkSettingValue(setting, value); err != nil {
+			return nil, err
+		}
+	}
+
+	// 前置条件不满足的用户和群组不指派
+	users, skippedUsers, err := b.ms.Setting.FilterUsersByPrerequisites(ctx, productID, setting, users)
+	if err != nil {
+		return nil, err
 	}
-	return b.ms.Setting.Assign(ctx, setting.ID, value, users, groups)
+	groups, skippedGroups, err := b.ms.Setting.FilterGroupsByPrerequisites(ctx, productID, setting, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.ms.Setting.Assign(ctx, setting.ID, value, users, groups)
+	if err != nil {
+		return nil, err
+	}
+	res.SkippedUsers = skippedUsers
+	res.SkippedGroups = skippedGroups
+	return res, nil
+}
+
+// EstimateAssign 预估将配置项批量分配给用户或群组时影响的用户数，不会写入指派记录
+func (b *Setting) EstimateAssign(ctx context.Context, productName, moduleName, settingName string, users []string, groups []*tpl.GroupKindUID) (*tpl.EstimateInfoRes, error) {
+	setting, err := b.acquire(context.WithValue(ctx, model.ReadDB, true), productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.ms.Setting.EstimateAssign(ctx, setting.ID, users, groups)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.EstimateInfoRes{Result: *res}, nil
 }
 
 // Delete 物理删除配置项
@@ -266,9 +443,13 @@ func (b *Setting) CreateRule(ctx context.Context, productName, moduleName, setti
 	if err != nil {
 		return nil, err
 	}
-	vals := tpl.StringToSlice(setting.Values)
-	if body.Value != "" && !tpl.StringSliceHas(vals, body.Value) {
-		return nil, gear.ErrBadRequest.WithMsgf("value %s is not in setting", body.Value)
+	if body.Value != "" {
+		if err = checkSettingValue(setting, body.Value); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkRuleVariants(setting, body.Rule.Variants); err != nil {
+		return nil, err
 	}
 
 	settingRule := &schema.SettingRule{
@@ -278,6 +459,9 @@ func (b *Setting) CreateRule(ctx context.Context, productName, moduleName, setti
 		Rule:      body.ToRule(),
 		Value:     body.Value,
 		Release:   0,
+		StartAt:   body.StartAt,
+		EndAt:     body.EndAt,
+		Salt:      body.ToSalt(nil),
 	}
 	if err = b.ms.SettingRule.Create(ctx, settingRule); err != nil {
 		return nil, err
@@ -292,9 +476,48 @@ func (b *Setting) CreateRule(ctx context.Context, productName, moduleName, setti
 	if err != nil {
 		return nil, err
 	}
+	// groupPercent 规则由回填任务分批写入命中的群组
+	if body.Backfill != nil || settingRule.Kind == schema.RuleGroupPercent {
+		if err = startRuleBackfill(ctx, b.ms, schema.TableSettingRule, settingRule.ID, settingRule.Kind, settingRule.Rule, body.Backfill); err != nil {
+			return nil, err
+		}
+	}
 	return &tpl.SettingRuleInfoRes{Result: tpl.SettingRuleInfoFrom(*settingRule)}, nil
 }
 
+// EstimateRule 预估配置项发布规则创建后命中的用户数，不会创建规则
+func (b *Setting) EstimateRule(ctx context.Context, productName, moduleName, settingName string, body tpl.SettingRuleBody) (*tpl.EstimateInfoRes, error) {
+	if body.Kind == schema.RuleChildLabelUserPercent || body.Kind == schema.RuleGroupPercent {
+		return nil, gear.ErrBadRequest.WithMsgf("estimate is not supported for kind %s", body.Kind)
+	}
+
+	readCtx := context.WithValue(ctx, model.ReadDB, true)
+	setting, err := b.acquire(readCtx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+	if body.Value != "" {
+		if err = checkSettingValue(setting, body.Value); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkRuleVariants(setting, body.Rule.Variants); err != nil {
+		return nil, err
+	}
+
+	res, err := b.ms.SettingRule.Estimate(ctx, &schema.SettingRule{
+		SettingID: setting.ID,
+		Kind:      body.Kind,
+		Rule:      body.ToRule(),
+		Value:     body.Value,
+		Salt:      body.ToSalt(nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.EstimateInfoRes{Result: *res}, nil
+}
+
 // ListRules ...
 func (b *Setting) ListRules(ctx context.Context, productName, moduleName, settingName string) (*tpl.SettingRulesInfoRes, error) {
 	productID, err := b.ms.Product.AcquireID(ctx, productName)
@@ -350,19 +573,31 @@ func (b *Setting) UpdateRule(ctx context.Context, productName, moduleName, setti
 
 	changed := map[string]interface{}{}
 	if body.Value != "" {
-		vals := tpl.StringToSlice(setting.Values)
-		if !tpl.StringSliceHas(vals, body.Value) {
-			return nil, gear.ErrBadRequest.WithMsgf("value %s is not in setting", body.Value)
+		if err = checkSettingValue(setting, body.Value); err != nil {
+			return nil, err
 		}
 		if body.Value != settingRule.Value {
 			changed["value"] = body.Value
 		}
 	}
 
+	if err = checkRuleVariants(setting, body.Rule.Variants); err != nil {
+		return nil, err
+	}
 	rule := body.ToRule()
 	if rule != settingRule.Rule {
 		changed["rule"] = rule
 	}
+	for k, v := range body.RuleScheduleBody.ToMap(settingRule.StartAt, settingRule.EndAt) {
+		changed[k] = v
+	}
+	salt := body.ToSalt(&settingRule.Salt)
+	if err = tpl.ValidateRulePercent(salt, body.Rule.Value); err != nil {
+		return nil, err
+	}
+	if salt != settingRule.Salt {
+		changed["salt"] = salt
+	}
 
 	if len(changed) > 0 {
 		release, err := b.ms.Setting.AcquireRelease(ctx, setting.ID)
@@ -370,10 +605,29 @@ func (b *Setting) UpdateRule(ctx context.Context, productName, moduleName, setti
 			return nil, err
 		}
 		changed["rls"] = release
+		oldRule := settingRule.Rule
 		settingRule, err = b.ms.SettingRule.Update(ctx, settingRule.ID, changed)
 		if err != nil {
 			return nil, err
 		}
+		_, ruleChanged := changed["rule"]
+		_, saltChanged := changed["salt"]
+		_, valueChanged := changed["value"]
+		rebucket := saltChanged || (ruleChanged && bucketByChanged(settingRule.Kind, oldRule, settingRule.Rule))
+		revokeAll := rebucket || valueChanged || (ruleChanged && conditionsChanged(settingRule.Kind, oldRule, settingRule.Rule))
+		if ruleChanged || revokeAll {
+			// 回收不再命中规则的用户，salt 或 bucketBy 改变时分桶改变，conditions 改变时无法按分桶判断是否命中，
+			// 配置值改变时已写入的记录均为旧值，规则写入的记录全部回收，由后续请求重新计算。
+			// 群组指派不会在请求时计算，由回填任务重新写入命中的群组
+			if _, err = b.ms.SettingRule.Revoke(ctx, settingRule, revokeAll); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if body.Backfill != nil || (len(changed) > 0 && settingRule.Kind == schema.RuleGroupPercent) {
+		if err = startRuleBackfill(ctx, b.ms, schema.TableSettingRule, settingRule.ID, settingRule.Kind, settingRule.Rule, body.Backfill); err != nil {
+			return nil, err
+		}
 	}
 
 	return &tpl.SettingRuleInfoRes{Result: tpl.SettingRuleInfoFrom(*settingRule)}, nil
@@ -406,6 +660,10 @@ func (b *Setting) DeleteRule(ctx context.Context, productName, moduleName, setti
 		return nil, gear.ErrNotFound.WithMsgf("setting rule not matched!")
 	}
 
+	// 先回收规则写入的指派记录，直接指派和群组指派的记录不受影响
+	if _, err = b.ms.SettingRule.Revoke(ctx, settingRule, true); err != nil {
+		return nil, err
+	}
 	rowsAffected, err := b.ms.SettingRule.Delete(ctx, settingRule.ID)
 	if err != nil {
 		return nil, err
@@ -414,6 +672,277 @@ func (b *Setting) DeleteRule(ctx context.Context, productName, moduleName, setti
 	return res, nil
 }
 
+// CreateRuleRamp 为配置项的发布规则创建渐进发布计划
+func (b *Setting) CreateRuleRamp(ctx context.Context, productName, moduleName, settingName string, ruleID int64, body tpl.RuleRampBody) (*tpl.RuleRampInfoRes, error) {
+	settingRule, err := b.acquireRule(ctx, productName, moduleName, settingName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if settingRule.Kind == schema.RuleUserVariant {
+		return nil, gear.ErrBadRequest.WithMsgf("rule ramp is not supported for kind %s", settingRule.Kind)
+	}
+	return createRuleRamp(ctx, b.ms, schema.TableSettingRule, settingRule.ID, settingRule.Salt, body)
+}
+
+// GetRuleRamp 返回配置项发布规则最近的渐进发布计划
+func (b *Setting) GetRuleRamp(ctx context.Context, productName, moduleName, settingName string, ruleID int64) (*tpl.RuleRampInfoRes, error) {
+	settingRule, err := b.acquireRule(ctx, productName, moduleName, settingName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return getRuleRamp(ctx, b.ms, schema.TableSettingRule, settingRule.ID)
+}
+
+// UpdateRuleRampStatus 暂停、恢复或终止配置项发布规则的渐进发布计划
+func (b *Setting) UpdateRuleRampStatus(ctx context.Context, productName, moduleName, settingName string, ruleID int64, action string) (*tpl.RuleRampInfoRes, error) {
+	settingRule, err := b.acquireRule(ctx, productName, moduleName, settingName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return updateRuleRampStatus(ctx, b.ms, schema.TableSettingRule, settingRule.ID, action)
+}
+
+// CreateRuleBackfill 为配置项的发布规则创建回填任务
+func (b *Setting) CreateRuleBackfill(ctx context.Context, productName, moduleName, settingName string, ruleID int64, body tpl.RuleBackfillBody) (*tpl.RuleBackfillInfoRes, error) {
+	settingRule, err := b.acquireRule(ctx, productName, moduleName, settingName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return createRuleBackfill(ctx, b.ms, schema.TableSettingRule, settingRule.ID, ruleGroupKind(settingRule.Kind, settingRule.Rule), body)
+}
+
+// GetRuleBackfill 返回配置项发布规则最近的回填任务
+func (b *Setting) GetRuleBackfill(ctx context.Context, productName, moduleName, settingName string, ruleID int64) (*tpl.RuleBackfillInfoRes, error) {
+	settingRule, err := b.acquireRule(ctx, productName, moduleName, settingName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return getRuleBackfill(ctx, b.ms, schema.TableSettingRule, settingRule.ID)
+}
+
+// CancelRuleBackfill 取消配置项发布规则执行中的回填任务
+func (b *Setting) CancelRuleBackfill(ctx context.Context, productName, moduleName, settingName string, ruleID int64) (*tpl.RuleBackfillInfoRes, error) {
+	settingRule, err := b.acquireRule(ctx, productName, moduleName, settingName, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	return cancelRuleBackfill(ctx, b.ms, schema.TableSettingRule, settingRule.ID)
+}
+
+// ListVariants 返回配置项的变体列表，不包含变体内容
+func (b *Setting) ListVariants(ctx context.Context, productName, moduleName, settingName string) (*tpl.SettingVariantsInfoRes, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := b.ms.SettingVariant.Find(ctx, setting.ID)
+	if err != nil {
+		return nil, err
+	}
+	res := &tpl.SettingVariantsInfoRes{Result: tpl.SettingVariantsInfoFrom(variants)}
+	res.TotalSize = len(variants)
+	return res, nil
+}
+
+// CreateVariant 为配置项的可选值创建变体内容
+func (b *Setting) CreateVariant(ctx context.Context, productName, moduleName, settingName string, body tpl.SettingVariantBody) (*tpl.SettingVariantInfoRes, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+	if !tpl.StringSliceHas(tpl.StringToSlice(setting.Values), body.Name) {
+		return nil, gear.ErrBadRequest.WithMsgf("variant name %s is not in setting values", body.Name)
+	}
+
+	count, err := b.ms.SettingVariant.Count(ctx, setting.ID)
+	if err != nil {
+		return nil, err
+	}
+	if max := conf.Config.SettingVariant.MaxCount; count >= max {
+		return nil, gear.ErrBadRequest.WithMsgf("too many variants (<= %d)", max)
+	}
+
+	variant := &schema.SettingVariant{SettingID: setting.ID, Name: body.Name, Payload: body.Payload}
+	if err = b.ms.SettingVariant.Create(ctx, variant); err != nil {
+		return nil, err
+	}
+	return &tpl.SettingVariantInfoRes{Result: tpl.SettingVariantInfoFrom(*variant, true)}, nil
+}
+
+// GetVariant 返回配置项的变体及其内容
+func (b *Setting) GetVariant(ctx context.Context, productName, moduleName, settingName, variantName string) (*tpl.SettingVariantInfoRes, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+
+	variant, err := b.ms.SettingVariant.Acquire(ctx, setting.ID, variantName)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.SettingVariantInfoRes{Result: tpl.SettingVariantInfoFrom(*variant, true)}, nil
+}
+
+// UpdateVariant 更新配置项的变体内容
+func (b *Setting) UpdateVariant(ctx context.Context, productName, moduleName, settingName, variantName string, body tpl.SettingVariantUpdateBody) (*tpl.SettingVariantInfoRes, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+
+	variant, err := b.ms.SettingVariant.Acquire(ctx, setting.ID, variantName)
+	if err != nil {
+		return nil, err
+	}
+	if variant, err = b.ms.SettingVariant.UpdatePayload(ctx, variant.ID, *body.Payload); err != nil {
+		return nil, err
+	}
+	return &tpl.SettingVariantInfoRes{Result: tpl.SettingVariantInfoFrom(*variant, true)}, nil
+}
+
+// DeleteVariant 删除配置项的变体，已指派该值的用户和群组不再获得变体内容
+func (b *Setting) DeleteVariant(ctx context.Context, productName, moduleName, settingName, variantName string) (*tpl.BoolRes, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+
+	variant, err := b.ms.SettingVariant.Acquire(ctx, setting.ID, variantName)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := b.ms.SettingVariant.Delete(ctx, variant.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.BoolRes{Result: rowsAffected > 0}, nil
+}
+
+// ListOverrides 返回配置项按 channel、client 覆盖的值
+func (b *Setting) ListOverrides(ctx context.Context, productName, moduleName, settingName string) (*tpl.SettingOverridesInfoRes, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := b.ms.SettingOverride.Find(ctx, setting.ID)
+	if err != nil {
+		return nil, err
+	}
+	res := &tpl.SettingOverridesInfoRes{Result: tpl.SettingOverridesInfoFrom(overrides)}
+	res.TotalSize = len(overrides)
+	return res, nil
+}
+
+// CreateOverride 为配置项创建按 channel、client 覆盖的值
+func (b *Setting) CreateOverride(ctx context.Context, productName, moduleName, settingName string, body tpl.SettingOverrideBody) (*tpl.SettingOverrideInfoRes, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSettingValue(setting, body.Value); err != nil {
+		return nil, err
+	}
+
+	override := &schema.SettingOverride{
+		SettingID: setting.ID,
+		Channel:   body.Channel,
+		Client:    body.Client,
+		Value:     body.Value,
+	}
+	if err = b.ms.SettingOverride.Create(ctx, override); err != nil {
+		return nil, err
+	}
+	return &tpl.SettingOverrideInfoRes{Result: tpl.SettingOverrideInfoFrom(*override)}, nil
+}
+
+// UpdateOverride 更新配置项按 channel、client 覆盖的值
+func (b *Setting) UpdateOverride(ctx context.Context, productName, moduleName, settingName string, overrideID int64, body tpl.SettingOverrideBody) (*tpl.SettingOverrideInfoRes, error) {
+	setting, override, err := b.acquireOverride(ctx, productName, moduleName, settingName, overrideID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSettingValue(setting, body.Value); err != nil {
+		return nil, err
+	}
+
+	if override, err = b.ms.SettingOverride.Update(ctx, override.ID, body.ToMap()); err != nil {
+		return nil, err
+	}
+	return &tpl.SettingOverrideInfoRes{Result: tpl.SettingOverrideInfoFrom(*override)}, nil
+}
+
+// DeleteOverride 删除配置项按 channel、client 覆盖的值
+func (b *Setting) DeleteOverride(ctx context.Context, productName, moduleName, settingName string, overrideID int64) (*tpl.BoolRes, error) {
+	_, override, err := b.acquireOverride(ctx, productName, moduleName, settingName, overrideID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := b.ms.SettingOverride.Delete(ctx, override.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.BoolRes{Result: rowsAffected > 0}, nil
+}
+
+func (b *Setting) acquireOverride(ctx context.Context, productName, moduleName, settingName string, overrideID int64) (*schema.Setting, *schema.SettingOverride, error) {
+	setting, err := b.acquire(ctx, productName, moduleName, settingName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	override, err := b.ms.SettingOverride.Acquire(ctx, overrideID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if override.SettingID != setting.ID {
+		return nil, nil, gear.ErrNotFound.WithMsgf("setting override not matched!")
+	}
+	return setting, override, nil
+}
+
+func (b *Setting) acquire(ctx context.Context, productName, moduleName, settingName string) (*schema.Setting, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := b.ms.Module.Acquire(ctx, productID, moduleName)
+	if err != nil {
+		return nil, err
+	}
+	return b.ms.Setting.Acquire(ctx, module.ID, settingName)
+}
+
+func (b *Setting) acquireRule(ctx context.Context, productName, moduleName, settingName string, ruleID int64) (*schema.SettingRule, error) {
+	productID, err := b.ms.Product.AcquireID(ctx, productName)
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := b.ms.Module.Acquire(ctx, productID, moduleName)
+	if err != nil {
+		return nil, err
+	}
+
+	setting, err := b.ms.Setting.Acquire(ctx, module.ID, settingName)
+	if err != nil {
+		return nil, err
+	}
+
+	settingRule, err := b.ms.SettingRule.Acquire(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if settingRule.SettingID != setting.ID {
+		return nil, gear.ErrNotFound.WithMsgf("setting rule not matched!")
+	}
+	return settingRule, nil
+}
+
 // ListUsers 返回产品下功能配置项的用户列表
 func (b *Setting) ListUsers(ctx context.Context, productName, moduleName, settingName string, pg tpl.Pagination) (*tpl.SettingUsersInfoRes, error) {
 	productID, err := b.ms.Product.AcquireID(ctx, productName)
@@ -589,3 +1118,13 @@ func (b *Setting) DeleteGroup(ctx context.Context, productName, moduleName, sett
 	}
 	return &tpl.BoolRes{Result: rowsAffected > 0}, nil
 }
+
+// checkRuleVariants 校验多变量规则的各配置值均为配置项的合法值
+func checkRuleVariants(setting *schema.Setting, variants []schema.RuleVariant) error {
+	for _, v := range variants {
+		if err := checkSettingValue(setting, v.Value); err != nil {
+			return gear.ErrBadRequest.WithMsgf("invalid variant value %s: %v", v.Value, err)
+		}
+	}
+	return nil
+}
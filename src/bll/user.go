@@ -3,8 +3,10 @@ package bll
 import (
 	"context"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/teambition/gear"
 	"github.com/teambition/urbs-setting/src/conf"
 	"github.com/teambition/urbs-setting/src/logging"
 	"github.com/teambition/urbs-setting/src/model"
@@ -18,16 +20,24 @@ type User struct {
 	ms *model.Models
 }
 
-// List 返回用户列表
-func (b *User) List(ctx context.Context, pg tpl.Pagination) (*tpl.UsersRes, error) {
-	users, total, err := b.ms.User.Find(ctx, pg)
+// List 返回用户列表，支持按属性过滤
+func (b *User) List(ctx context.Context, req tpl.UsersURL) (*tpl.UsersRes, error) {
+	var users []schema.User
+	var total int
+	var err error
+	pg := req.Pagination
+	if req.Attr != "" {
+		users, total, err = b.ms.User.FindByAttribute(ctx, req.Attr, req.AttrValue, pg)
+	} else {
+		users, total, err = b.ms.User.Find(ctx, pg)
+	}
 	if err != nil {
 		return nil, err
 	}
 	res := &tpl.UsersRes{Result: users}
 	res.TotalSize = total
 
-	if res.TotalSize == 0 && pg.Q == "" {
+	if res.TotalSize == 0 && pg.Q == "" && req.Attr == "" {
 		statistic, _ := b.ms.Statistic.FindByKey(ctx, schema.UsersTotalSize)
 		if statistic != nil {
 			res.TotalSize = int(statistic.Status)
@@ -40,8 +50,19 @@ func (b *User) List(ctx context.Context, pg tpl.Pagination) (*tpl.UsersRes, erro
 	return res, nil
 }
 
-// ListCachedLabels ... 该接口不返回错误
-func (b *User) ListCachedLabels(ctx context.Context, uid, product string) *tpl.CacheLabelsInfoRes {
+// ListCachedLabels ... 该接口不返回错误，key 为请求方提供的分桶 key，可能为空；
+// version 为请求方的客户端版本，不为空时过滤掉版本范围不包含该版本的环境标签
+func (b *User) ListCachedLabels(ctx context.Context, uid, product, key, version string) *tpl.CacheLabelsInfoRes {
+	return b.listCachedLabels(ctx, uid, product, key, version, false)
+}
+
+// RefreshListCachedLabels 强制刷新并返回 user 在 product 下的环境标签，用于推送变更通知
+func (b *User) RefreshListCachedLabels(ctx context.Context, uid, product, key, version string) *tpl.CacheLabelsInfoRes {
+	return b.listCachedLabels(ctx, uid, product, key, version, true)
+}
+
+func (b *User) listCachedLabels(ctx context.Context, uid, product, key, version string, force bool) *tpl.CacheLabelsInfoRes {
+	ctx = model.WithBucketKey(ctx, key)
 	now := time.Now().UTC()
 	res := &tpl.CacheLabelsInfoRes{Result: []schema.UserCacheLabel{}, Timestamp: now.Unix()}
 
@@ -58,33 +79,143 @@ func (b *User) ListCachedLabels(ctx context.Context, uid, product string) *tpl.C
 	user, err := b.ms.User.Acquire(readCtx, uid)
 	if err != nil {
 		if strings.HasPrefix(uid, "anon-") {
-			if labels, err := b.ms.LabelRule.ApplyRulesToAnonymous(ctx, uid, productID, schema.RuleUserPercent); err == nil {
+			if labels, err := b.ms.LabelRule.ApplyRulesToAnonymous(ctx, uid, productID, schema.RuleUserPercent, schema.RuleUserAttribute); err == nil {
 				res.Result = labels
 			}
+			if version != "" {
+				res.Result = schema.FilterCacheLabelsByVersion(res.Result, version)
+			}
 		}
 		return res
 	}
 
 	activeAt := user.GetCache(product).ActiveAt
 	// user 上缓存的 labels 过期，则刷新获取最新，RefreshUser 要考虑并发场景
-	if activeAt == 0 {
+	if activeAt == 0 || force {
 		if user = b.ms.TryApplyLabelRulesAndRefreshUserLabels(ctx, productID, product, user.ID, now, true); user == nil {
 			return res
 		}
 	} else if conf.Config.IsCacheLabelExpired(now.Unix()-5, activeAt) { // 提前 5s 异步处理
 		util.Go(10*time.Second, func(gctx context.Context) {
-			b.ms.TryApplyLabelRulesAndRefreshUserLabels(gctx, productID, product, user.ID, now, false)
+			b.ms.TryApplyLabelRulesAndRefreshUserLabels(model.WithBucketKey(gctx, key), productID, product, user.ID, now, false)
 		})
 	}
 	userCache := user.GetCache(product)
 
 	res.Result = userCache.Labels
+	if version != "" {
+		res.Result = schema.FilterCacheLabelsByVersion(res.Result, version)
+	}
 	res.Timestamp = userCache.ActiveAt
 	return res
 }
 
+// BatchListCachedLabels 批量返回用户在指定产品下的环境标签，供网关使用，keys 为 uid 到分桶 key 的映射，与 ListCachedLabels 的 key 一致。
+// 产品只读取一次，需要刷新的用户批量刷新和计算发布规则。与 ListCachedLabels 一致，该接口不返回错误，
+// 产品不存在或读取、刷新出错时记录日志，受影响的用户返回空环境标签列表。
+func (b *User) BatchListCachedLabels(ctx context.Context, uids []string, product string, keys map[string]string, version string) *tpl.UsersCacheLabelsRes {
+	now := time.Now().UTC()
+	res := &tpl.UsersCacheLabelsRes{Result: make([]tpl.UserCacheLabels, 0, len(uids))}
+	index := make(map[string]int, len(uids))
+	for _, uid := range uids {
+		if _, ok := index[uid]; ok {
+			continue
+		}
+		index[uid] = len(res.Result)
+		res.Result = append(res.Result, tpl.UserCacheLabels{
+			UID:       uid,
+			Timestamp: now.Unix(),
+			Labels:    []schema.UserCacheLabel{},
+		})
+	}
+	setLabels := func(uid string, labels []schema.UserCacheLabel, timestamp int64) {
+		if version != "" {
+			labels = schema.FilterCacheLabelsByVersion(labels, version)
+		}
+		item := &res.Result[index[uid]]
+		item.Labels = labels
+		item.Timestamp = timestamp
+	}
+
+	readCtx := context.WithValue(ctx, model.ReadDB, true)
+	productID, err := b.ms.Product.AcquireID(readCtx, product)
+	if err != nil {
+		logging.Warningf("BatchListCachedLabels: product %s, error %v", product, err)
+		return res
+	}
+	users, err := b.ms.User.FindByUIDs(readCtx, uids)
+	if err != nil {
+		logging.Warningf("BatchListCachedLabels: product %s, %d users, error %v", product, len(uids), err)
+		return res
+	}
+
+	found := make(map[string]bool, len(users))
+	refreshIDs := make([]int64, 0)
+	expiredIDs := make([]int64, 0)
+	userKeys := make(map[int64]string)
+	for _, user := range users {
+		found[user.UID] = true
+		if key := keys[user.UID]; key != "" {
+			userKeys[user.ID] = key
+		}
+		activeAt := user.GetCache(product).ActiveAt
+		// user 上缓存的 labels 过期，则刷新获取最新
+		if activeAt == 0 {
+			refreshIDs = append(refreshIDs, user.ID)
+			continue
+		}
+		if conf.Config.IsCacheLabelExpired(now.Unix()-5, activeAt) { // 提前 5s 异步处理
+			expiredIDs = append(expiredIDs, user.ID)
+		}
+		userCache := user.GetCache(product)
+		setLabels(user.UID, userCache.Labels, userCache.ActiveAt)
+	}
+
+	anonymousKeys := make(map[string]string)
+	for uid, key := range keys {
+		if !found[uid] {
+			anonymousKeys[uid] = key
+		}
+	}
+	ctx = model.WithBucketKeys(ctx, userKeys, anonymousKeys)
+
+	if len(refreshIDs) > 0 {
+		refreshed, err := b.ms.ApplyLabelRulesAndRefreshUsersLabels(ctx, productID, product, refreshIDs, now, true)
+		if err != nil {
+			logging.Warningf("ApplyLabelRulesAndRefreshUsersLabels: %d users, error %v", len(refreshIDs), err)
+		}
+		for _, user := range refreshed {
+			userCache := user.GetCache(product)
+			setLabels(user.UID, userCache.Labels, userCache.ActiveAt)
+		}
+	}
+	if len(expiredIDs) > 0 {
+		util.Go(10*time.Second, func(gctx context.Context) {
+			b.ms.TryApplyLabelRulesAndRefreshUsersLabels(model.WithBucketKeys(gctx, userKeys, nil), productID, product, expiredIDs, now, false)
+		})
+	}
+
+	anonymousIDs := make([]string, 0)
+	for uid := range index {
+		if !found[uid] && strings.HasPrefix(uid, "anon-") {
+			anonymousIDs = append(anonymousIDs, uid)
+		}
+	}
+	if len(anonymousIDs) > 0 {
+		data, err := b.ms.LabelRule.ApplyRulesToAnonymousUsers(ctx, anonymousIDs, productID, schema.RuleUserPercent, schema.RuleUserAttribute)
+		if err != nil {
+			logging.Warningf("ApplyRulesToAnonymousUsers: %d users, error %v", len(anonymousIDs), err)
+		}
+		for uid, labels := range data {
+			setLabels(uid, labels, now.Unix())
+		}
+	}
+	return res
+}
+
 // RefreshCachedLabels ...
-func (b *User) RefreshCachedLabels(ctx context.Context, product, uid string) (*schema.User, error) {
+func (b *User) RefreshCachedLabels(ctx context.Context, product, uid, key string) (*schema.User, error) {
+	ctx = model.WithBucketKey(ctx, key)
 	user, err := b.ms.User.Acquire(ctx, uid)
 	if err != nil {
 		return nil, err
@@ -162,6 +293,10 @@ func (b *User) ListSettings(ctx context.Context, req tpl.MySettingsQueryURL) (*t
 		return nil, err
 	}
 
+	tpl.SetTypedValues(settings)
+	if err = b.ms.SettingVariant.FillPayloads(readCtx, settings); err != nil {
+		return nil, err
+	}
 	res := &tpl.MySettingsRes{Result: settings}
 	res.TotalSize = total
 	if len(res.Result) > pg.PageSize {
@@ -173,6 +308,7 @@ func (b *User) ListSettings(ctx context.Context, req tpl.MySettingsQueryURL) (*t
 
 // ListSettingsUnionAll ...
 func (b *User) ListSettingsUnionAll(ctx context.Context, req tpl.MySettingsQueryURL) (*tpl.MySettingsRes, error) {
+	ctx = model.WithBucketKey(ctx, req.Key)
 	res := &tpl.MySettingsRes{Result: []tpl.MySetting{}}
 	readCtx := context.WithValue(ctx, model.ReadDB, true)
 
@@ -186,14 +322,39 @@ func (b *User) ListSettingsUnionAll(ctx context.Context, req tpl.MySettingsQuery
 
 	user, err := b.ms.User.Acquire(readCtx, req.UID)
 	if err != nil {
+		settings := []tpl.MySetting{}
 		if strings.HasPrefix(req.UID, "anon-") {
-			if settings, err := b.ms.SettingRule.ApplyRulesToAnonymous(ctx, req.UID, productID, req.Channel, req.Client, schema.RuleUserPercent); err == nil {
-				for i := range settings {
-					settings[i].Product = req.Product
-				}
-				res.Result = settings
+			if rs, err := b.ms.SettingRule.ApplyRulesToAnonymous(ctx, req.UID, productID, req.Channel, req.Client, req.Version, schema.RuleUserPercent, schema.RuleUserAttribute, schema.RuleUserVariant); err == nil {
+				settings = rs
+			}
+		}
+
+		// 未命中规则的配置项返回默认值
+		defaults, err := b.ms.Setting.FindDefaults(readCtx, productID, 0, 0, 0, nil, "", req.Channel, req.Client, req.Version)
+		if err != nil {
+			return nil, err
+		}
+		matched := make(map[int64]struct{}, len(settings))
+		for _, s := range settings {
+			matched[s.ID] = struct{}{}
+		}
+		for _, s := range defaults {
+			if _, ok := matched[s.ID]; !ok {
+				settings = append(settings, s)
 			}
 		}
+
+		if settings, err = b.ms.Setting.FilterAnonymousByPrerequisites(readCtx, productID, settings); err != nil {
+			return nil, err
+		}
+		for i := range settings {
+			settings[i].Product = req.Product
+		}
+		tpl.SetTypedValues(settings)
+		if err = b.ms.SettingVariant.FillPayloads(readCtx, settings); err != nil {
+			return nil, err
+		}
+		res.Result = settings
 		return res, nil
 	}
 
@@ -216,27 +377,183 @@ func (b *User) ListSettingsUnionAll(ctx context.Context, req tpl.MySettingsQuery
 	}
 
 	pg := req.Pagination
-	settings, err := b.ms.User.FindSettingsUnionAll(readCtx, groupIDs, user.ID, productID, moduleID, settingID, pg, req.Channel, req.Client)
-	if err != nil {
-		return nil, err
-	}
-	for i := range settings {
-		settings[i].Product = req.Product
-	}
 	if pg.PageToken == "" { // 请求首页时尝试应用 SettingRules
 		util.Go(10*time.Second, func(gctx context.Context) {
-			b.ms.TryApplySettingRules(gctx, productID, user.ID)
+			b.ms.TryApplySettingRules(model.WithBucketKey(gctx, req.Key), productID, user.ID)
 		})
 	}
 
-	res.Result = settings
-	if len(res.Result) > pg.PageSize {
-		res.NextPageToken = tpl.TimeToPageToken(res.Result[pg.PageSize].AssignedAt)
-		res.Result = res.Result[:pg.PageSize]
+	// 先按指派时间分页返回已指派的配置项，取完后再按配置项 ID 分页追加未被指派的配置项的默认值，
+	// 前置条件不满足的配置项在分页前过滤，不占用 pageSize
+	afterID, inDefaults := tpl.PageTokenToDefaults(pg.PageToken)
+	if !inDefaults {
+		token := pg.PageToken
+		for i := 0; i < 7; i++ { // 过滤后不足一页时继续读取，最多 7 次
+			q := pg
+			q.PageToken = token
+			settings, err := b.ms.User.FindSettingsUnionAll(readCtx, groupIDs, user.ID, productID, moduleID, settingID, q, req.Channel, req.Client, req.Version)
+			if err != nil {
+				return nil, err
+			}
+			token = ""
+			if len(settings) > pg.PageSize {
+				token = tpl.TimeToPageToken(settings[pg.PageSize].AssignedAt)
+				settings = settings[:pg.PageSize]
+			}
+			if settings, err = b.ms.Setting.FilterByPrerequisites(readCtx, productID, user.ID, req.Channel, req.Client, settings); err != nil {
+				return nil, err
+			}
+			res.Result = append(res.Result, settings...)
+			if token == "" || len(res.Result) > pg.PageSize {
+				break
+			}
+		}
+		if len(res.Result) > pg.PageSize {
+			res.NextPageToken = tpl.TimeToPageToken(res.Result[pg.PageSize].AssignedAt)
+			res.Result = res.Result[:pg.PageSize]
+		} else if token != "" {
+			res.NextPageToken = token
+		}
+	}
+
+	if res.NextPageToken == "" && len(res.Result) < pg.PageSize+1 {
+		// 已指派的配置项已取完，追加未被指派的配置项的默认值
+		assigned := len(res.Result)
+		for i := 0; i < 7; i++ {
+			limit := pg.PageSize + 1 - len(res.Result)
+			defaults, err := b.ms.Setting.FindDefaultsAfter(readCtx, productID, moduleID, settingID, user.ID, groupIDs, pg.Q, req.Channel, req.Client, req.Version, afterID, limit)
+			if err != nil {
+				return nil, err
+			}
+			if len(defaults) == 0 {
+				break
+			}
+			afterID = defaults[len(defaults)-1].ID
+			if defaults, err = b.ms.Setting.FilterByPrerequisites(readCtx, productID, user.ID, req.Channel, req.Client, defaults); err != nil {
+				return nil, err
+			}
+			res.Result = append(res.Result, defaults...)
+			if len(res.Result) > pg.PageSize {
+				res.Result = res.Result[:pg.PageSize]
+				last := int64(0) // 本页全部为已指派的配置项时，下一页从第一个默认值开始
+				if pg.PageSize > assigned {
+					last = res.Result[pg.PageSize-1].ID
+				}
+				res.NextPageToken = tpl.DefaultsPageToken(last)
+				break
+			}
+			if i == 6 {
+				res.NextPageToken = tpl.DefaultsPageToken(afterID)
+			}
+		}
+	}
+	for i := range res.Result {
+		res.Result[i].Product = req.Product
+	}
+	tpl.SetTypedValues(res.Result)
+	if err = b.ms.SettingVariant.FillPayloads(readCtx, res.Result); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// batchSettingsConcurrency 批量读取配置项时同时处理的用户数
+const batchSettingsConcurrency = 8
+
+// batchSettingsMaxSize 批量读取配置项时单个用户最多的配置项数，超过时应使用分页接口
+const batchSettingsMaxSize = 1000
+
+// BatchListSettingsUnionAll 批量返回多个用户在产品下的全部配置项，包括继承自群组的配置项和默认值。
+// keys 为 uid 到分桶 key 的映射，忽略 req 中的 UID、Key 和分页参数，重复的 uid 只返回一次；
+// 用户的配置项超过 batchSettingsMaxSize 时返回 400。
+func (b *User) BatchListSettingsUnionAll(ctx context.Context, uids []string, keys map[string]string, req tpl.MySettingsQueryURL) ([]tpl.UserMySettings, error) {
+	res := make([]tpl.UserMySettings, 0, len(uids))
+	seen := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		if !seen[uid] {
+			seen[uid] = true
+			res = append(res, tpl.UserMySettings{UID: uid, Result: []tpl.MySetting{}})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	var firstErr error
+	sem := make(chan struct{}, batchSettingsConcurrency)
+	wg := sync.WaitGroup{}
+	for i := range res {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(item *tpl.UserMySettings) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			q := req
+			q.UID = item.UID
+			q.Key = keys[item.UID]
+			q.PageToken = ""
+			q.PageSize = batchSettingsMaxSize
+			r, err := b.ListSettingsUnionAll(ctx, q)
+			if err == nil && r.NextPageToken != "" {
+				err = gear.ErrBadRequest.WithMsgf("too many settings for user %s, use GetSettings with pagination", item.UID)
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			item.Result = r.Result
+		}(&res[i])
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
 	}
 	return res, nil
 }
 
+// Evaluate 只读地计算用户在产品线下的环境标签和配置项及其来源，不会写入指派记录
+func (b *User) Evaluate(ctx context.Context, req tpl.UserEvaluationURL) (*tpl.UserEvaluationRes, error) {
+	readCtx := context.WithValue(model.WithBucketKey(ctx, req.Key), model.ReadDB, true)
+	productID, err := b.ms.Product.AcquireID(readCtx, req.Product)
+	if err != nil {
+		return nil, err
+	}
+
+	var userID int64
+	user, err := b.ms.User.FindByUID(readCtx, req.UID, "id")
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		userID = user.ID
+	} else if !strings.HasPrefix(req.UID, "anon-") {
+		return nil, gear.ErrNotFound.WithMsgf("user %s not found", req.UID)
+	}
+
+	labels, settings, err := b.ms.EvaluateUser(readCtx, productID, userID, req.UID, req.Channel, req.Client, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &tpl.UserEvaluationRes{Result: tpl.UserEvaluation{
+		UID:      req.UID,
+		Product:  req.Product,
+		Channel:  req.Channel,
+		Client:   req.Client,
+		Version:  req.Version,
+		Labels:   labels,
+		Settings: settings,
+	}}, nil
+}
+
 // CheckExists ...
 func (b *User) CheckExists(ctx context.Context, uid string) bool {
 	user, _ := b.ms.User.FindByUID(context.WithValue(ctx, model.ReadDB, true), uid, "id")
@@ -248,6 +565,28 @@ func (b *User) BatchAdd(ctx context.Context, users []string) error {
 	return b.ms.User.BatchAdd(ctx, users)
 }
 
+// ListAttributes 返回用户的全部属性
+func (b *User) ListAttributes(ctx context.Context, uid string) (*tpl.UserAttributesRes, error) {
+	readCtx := context.WithValue(ctx, model.ReadDB, true)
+	userID, err := b.ms.User.AcquireID(readCtx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := b.ms.User.FindAttributes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := &tpl.UserAttributesRes{Result: attrs}
+	res.TotalSize = len(attrs)
+	return res, nil
+}
+
+// BatchUpsertAttributes 批量添加或更新用户属性
+func (b *User) BatchUpsertAttributes(ctx context.Context, users []tpl.UserAttributesBody) error {
+	return b.ms.User.BatchUpsertAttributes(ctx, users)
+}
+
 // ApplyRules ...
 func (b *User) ApplyRules(ctx context.Context, product string, body *tpl.ApplyRulesBody) error {
 	readCtx := context.WithValue(ctx, model.ReadDB, true)
@@ -213,12 +213,12 @@ func TestUserListCachedLabels(t *testing.T) {
 		err = user.ms.LabelRule.Create(ctx, labelRule)
 		require.Nil(err)
 
-		res1 := user.ListCachedLabels(ctx, userObj.UID, productName)
+		res1 := user.ListCachedLabels(ctx, userObj.UID, productName, "", "")
 		require.Equal(1, len(res1.Result), i)
 		require.Equal(label.Name, res1.Result[0].Label)
 		time.Sleep(time.Millisecond * 1100)
 		// test cache
-		res2 := user.ListCachedLabels(ctx, userObj.UID, productName)
+		res2 := user.ListCachedLabels(ctx, userObj.UID, productName, "", "")
 		require.Equal(1, len(res2.Result))
 		require.Equal(res1.Timestamp, res2.Timestamp)
 	}
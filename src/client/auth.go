@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	otgo "github.com/open-trust/ot-go-lib"
+	authjwt "github.com/teambition/gear-auth/jwt"
+)
+
+// TokenSource 为每个请求提供 Authorization: Bearer 的 token，返回空字符串时不设置
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenFunc 把函数适配为 TokenSource
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Token 实现 TokenSource
+func (f TokenFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticToken 每次返回同一个 token
+func StaticToken(token string) TokenSource {
+	return TokenFunc(func(ctx context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// JWT 用服务端 auth_keys 中的密钥签发 JWT，sub 为调用方标识，token 在过期前 1 分钟重新签发
+func JWT(sub string, keys ...string) TokenSource {
+	j := authjwt.New(authjwt.StrToKeys(keys...)...)
+	j.SetExpiresIn(10 * time.Minute)
+	return &jwtSource{jwt: j, sub: sub}
+}
+
+type jwtSource struct {
+	jwt *authjwt.JWT
+	sub string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (s *jwtSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	token, err := s.jwt.Sign(map[string]interface{}{"sub": s.sub})
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.expiresAt = time.Now().Add(s.jwt.GetExpiresIn() - time.Minute)
+	return token, nil
+}
+
+// OTVID 通过 Open Trust holder 获取签发给 aud（urbs-setting 服务的 OTID）的 OTVID，holder 会缓存未过期的 token
+func OTVID(holder *otgo.Holder, aud otgo.OTID) TokenSource {
+	return TokenFunc(func(ctx context.Context) (string, error) {
+		return holder.GetOTVIDToken(aud)
+	})
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshTimeout 后台刷新缓存的超时时间
+const refreshTimeout = 10 * time.Second
+
+// retryInterval 同步加载失败后，在此间隔内不再重试，直接返回旧值或错误，避免服务不可用时每次读取都等待超时
+const retryInterval = 5 * time.Second
+
+type loadFunc func(ctx context.Context) (interface{}, error)
+
+type cacheEntry struct {
+	value     interface{}
+	fetchedAt time.Time
+	loading   chan struct{} // 非 nil 时表示正在加载，加载完成后关闭
+	err       error         // 最近一次加载的错误
+	failedAt  time.Time
+}
+
+// cache 带 stale-while-revalidate 的本地缓存：
+// 新鲜期内直接返回；过期可用期内返回旧值并在后台刷新；
+// 超过过期可用期时同步加载，加载失败则继续返回旧值。
+type cache struct {
+	ttl, stale time.Duration
+	maxEntries int
+	onError    func(err error)
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+func newCache(ttl, stale time.Duration, maxEntries int, onError func(err error)) *cache {
+	return &cache{
+		ttl:        ttl,
+		stale:      stale,
+		maxEntries: maxEntries,
+		onError:    onError,
+		entries:    make(map[string]*cacheEntry),
+	}
+}
+
+// get 返回 key 的缓存值，可能是过期的旧值，没有可用的值时返回 nil
+func (c *cache) get(ctx context.Context, key string, load loadFunc) interface{} {
+	c.mu.Lock()
+	e := c.entries[key]
+	if e == nil {
+		c.evict()
+		e = &cacheEntry{}
+		c.entries[key] = e
+	}
+
+	value := e.value
+	if value != nil {
+		age := time.Since(e.fetchedAt)
+		if age < c.ttl {
+			c.mu.Unlock()
+			return value
+		}
+		if age < c.ttl+c.stale {
+			if e.loading == nil {
+				e.loading = make(chan struct{})
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+					defer cancel()
+					c.load(ctx, e, load)
+				}()
+			}
+			c.mu.Unlock()
+			return value
+		}
+	}
+
+	if e.err != nil && time.Since(e.failedAt) < retryInterval {
+		c.mu.Unlock()
+		return value
+	}
+
+	ch := e.loading
+	if ch == nil {
+		e.loading = make(chan struct{})
+		c.mu.Unlock()
+		c.load(ctx, e, load)
+	} else {
+		c.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return e.value
+}
+
+// load 调用 load 更新缓存，失败时保留旧值并回调 onError
+func (c *cache) load(ctx context.Context, e *cacheEntry, load loadFunc) {
+	value, err := load(ctx)
+	if err != nil && c.onError != nil {
+		c.onError(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.err = err
+	if err == nil {
+		e.value = value
+		e.fetchedAt = time.Now()
+	} else {
+		e.failedAt = time.Now()
+	}
+	close(e.loading)
+	e.loading = nil
+}
+
+// removeUsers 清除用户在产品下的全部缓存条目
+func (c *cache) removeUsers(product string, uids []string) {
+	if len(uids) == 0 {
+		return
+	}
+	keys := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		keys[userProductKey(uid, product)] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if keys[keyUserProduct(key)] {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// userProductKey 返回缓存 key 中用户和产品的部分
+func userProductKey(uid, product string) string {
+	return uid + "\x00" + product
+}
+
+// keyUserProduct 从缓存 key 中取出用户和产品的部分
+func keyUserProduct(key string) string {
+	i := strings.IndexByte(key, 0)
+	if i < 0 {
+		return key
+	}
+	if j := strings.IndexByte(key[i+1:], 0); j >= 0 {
+		return key[:i+1+j]
+	}
+	return key
+}
+
+// evict 条目数达到上限时清理超过过期可用期的条目，仍超过上限则随机清理，需持有锁
+func (c *cache) evict() {
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	for key, e := range c.entries {
+		if e.loading == nil && time.Since(e.fetchedAt) >= c.ttl+c.stale {
+			delete(c.entries, key)
+		}
+	}
+	for key, e := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+		if e.loading == nil {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Package client 是 urbs-setting 的 Go 客户端，封装了 v1/v2 HTTP API，
+// 并在本地缓存用户的环境标签和配置项，服务不可用时返回缓存或默认值。
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options 客户端配置
+type Options struct {
+	// Endpoint urbs-setting 服务地址，如 http://urbs-setting:8080
+	Endpoint string
+	// HTTPClient 可选，默认为 5 秒超时的 http.Client
+	HTTPClient *http.Client
+	// Token 可选，未设置时不发送 Authorization
+	Token TokenSource
+	// TTL 缓存的新鲜期，默认 1 分钟，期间直接返回缓存
+	TTL time.Duration
+	// StaleTTL 新鲜期之后的过期可用期，默认 10 分钟，期间返回缓存并在后台刷新
+	StaleTTL time.Duration
+	// MaxEntries 缓存的最大条目数（用户 × 产品 × ReadOptions），默认 10000
+	MaxEntries int
+	// OnError 可选，加载或后台刷新缓存失败时回调，用于记录日志
+	OnError func(err error)
+}
+
+// ReadOptions 读取环境标签和配置项时的可选参数，不同参数的结果分别缓存
+type ReadOptions struct {
+	// Channel 只返回该渠道的配置项
+	Channel string
+	// Client 只返回该客户端的配置项
+	Client string
+	// Version 客户端版本，过滤掉版本范围不包含该版本的环境标签和配置项
+	Version string
+	// Key 分桶 key，用于 bucketBy 为 "key" 的发布规则
+	Key string
+}
+
+func readOptions(opts []ReadOptions) ReadOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return ReadOptions{}
+}
+
+// Error urbs-setting 服务返回的错误
+type Error struct {
+	Status int
+	ErrorResponseType
+}
+
+// Error 实现 error
+func (e *Error) Error() string {
+	return fmt.Sprintf("urbs-setting: %d %s, %s", e.Status, e.ErrorResponseType.Error, e.Message)
+}
+
+// Client urbs-setting 客户端，可并发使用
+type Client struct {
+	endpoint string
+	hc       *http.Client
+	token    TokenSource
+	labels   *cache
+	settings *cache
+}
+
+// New 创建客户端
+func New(opts Options) (*Client, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("urbs-setting: invalid endpoint %q", opts.Endpoint)
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.StaleTTL <= 0 {
+		opts.StaleTTL = 10 * time.Minute
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 10000
+	}
+
+	return &Client{
+		endpoint: strings.TrimRight(opts.Endpoint, "/"),
+		hc:       opts.HTTPClient,
+		token:    opts.Token,
+		labels:   newCache(opts.TTL, opts.StaleTTL, opts.MaxEntries, opts.OnError),
+		settings: newCache(opts.TTL, opts.StaleTTL, opts.MaxEntries, opts.OnError),
+	}, nil
+}
+
+// Do 发送请求，body 不为 nil 时编码为 JSON，成功时把响应解码到 result（不为 nil 时）
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	uri := c.endpoint + path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, uri, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != nil {
+		token, err := c.token.Token(ctx)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		e := &Error{Status: res.StatusCode}
+		if json.Unmarshal(data, &e.ErrorResponseType) != nil || e.ErrorResponseType.Error == "" {
+			e.ErrorResponseType.Error = http.StatusText(res.StatusCode)
+			e.Message = string(data)
+		}
+		return e
+	}
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// GetLabels 读取用户在产品下的环境标签，对应 GET /users/:uid/labels:cache
+func (c *Client) GetLabels(ctx context.Context, q UIDProductURL) (*CacheLabelsInfoRes, error) {
+	query := url.Values{}
+	query.Set("product", q.Product)
+	setQuery(query, "key", q.Key)
+	setQuery(query, "version", q.Version)
+
+	res := &CacheLabelsInfoRes{}
+	if err := c.Do(ctx, http.MethodGet, "/users/"+url.PathEscape(q.UID)+"/labels:cache", query, nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// BatchGetLabels 批量读取用户在产品下的环境标签，对应 POST /users/labels:cache
+func (c *Client) BatchGetLabels(ctx context.Context, body UsersCacheLabelsBody) (*UsersCacheLabelsRes, error) {
+	res := &UsersCacheLabelsRes{}
+	if err := c.Do(ctx, http.MethodPost, "/users/labels:cache", nil, body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetSettings 读取用户的配置项（一页），对应 GET /v1/users/:uid/settings:unionAll
+func (c *Client) GetSettings(ctx context.Context, q MySettingsQueryURL) (*MySettingsRes, error) {
+	query := url.Values{}
+	setQuery(query, "product", q.Product)
+	setQuery(query, "module", q.Module)
+	setQuery(query, "setting", q.Setting)
+	setQuery(query, "channel", q.Channel)
+	setQuery(query, "client", q.Client)
+	setQuery(query, "version", q.Version)
+	setQuery(query, "key", q.Key)
+	setQuery(query, "pageToken", q.PageToken)
+	if q.PageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(q.PageSize))
+	}
+
+	res := &MySettingsRes{}
+	if err := c.Do(ctx, http.MethodGet, "/v1/users/"+url.PathEscape(q.UID)+"/settings:unionAll", query, nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ListSettings 读取用户的全部配置项，自动翻页
+func (c *Client) ListSettings(ctx context.Context, q MySettingsQueryURL) ([]MySetting, error) {
+	q.PageSize = 1000
+	q.PageToken = ""
+	settings := make([]MySetting, 0)
+	for {
+		res, err := c.GetSettings(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, res.Result...)
+		if res.NextPageToken == "" {
+			return settings, nil
+		}
+		q.PageToken = res.NextPageToken
+	}
+}
+
+// BatchAddUsers 批量添加用户，对应 POST /v1/users:batch
+func (c *Client) BatchAddUsers(ctx context.Context, users []string) error {
+	return c.Do(ctx, http.MethodPost, "/v1/users:batch", nil, usersBody{Users: users}, nil)
+}
+
+// AssignLabel 为用户或群组指派环境标签，对应 POST /v2/products/:product/labels/:label:assign，
+// 成功后清除本地缓存中这些用户的环境标签
+func (c *Client) AssignLabel(ctx context.Context, product, label string, body UsersGroupsBodyV2) (*LabelReleaseInfo, error) {
+	res := &labelReleaseInfoRes{}
+	path := fmt.Sprintf("/v2/products/%s/labels/%s:assign", url.PathEscape(product), url.PathEscape(label))
+	if err := c.Do(ctx, http.MethodPost, path, nil, body, res); err != nil {
+		return nil, err
+	}
+	c.labels.removeUsers(product, body.Users)
+	return &res.Result, nil
+}
+
+// RecallLabel 撤回环境标签的一次指派，对应 POST /v1/products/:product/labels/:label:recall
+func (c *Client) RecallLabel(ctx context.Context, product, label string, release int64) (bool, error) {
+	res := &boolRes{}
+	path := fmt.Sprintf("/v1/products/%s/labels/%s:recall", url.PathEscape(product), url.PathEscape(label))
+	if err := c.Do(ctx, http.MethodPost, path, nil, recallBody{Release: release}, res); err != nil {
+		return false, err
+	}
+	return res.Result, nil
+}
+
+// AssignSetting 为用户或群组指派配置项的值，对应 POST /v2/products/:product/modules/:module/settings/:setting:assign，
+// 成功后清除本地缓存中这些用户的配置项
+func (c *Client) AssignSetting(ctx context.Context, product, module, setting string, body UsersGroupsBodyV2) (*SettingReleaseInfo, error) {
+	res := &settingReleaseInfoRes{}
+	path := fmt.Sprintf("/v2/products/%s/modules/%s/settings/%s:assign",
+		url.PathEscape(product), url.PathEscape(module), url.PathEscape(setting))
+	if err := c.Do(ctx, http.MethodPost, path, nil, body, res); err != nil {
+		return nil, err
+	}
+	c.settings.removeUsers(product, body.Users)
+	return &res.Result, nil
+}
+
+// RecallSetting 撤回配置项的一次指派，对应 POST /v1/products/:product/modules/:module/settings/:setting:recall
+func (c *Client) RecallSetting(ctx context.Context, product, module, setting string, release int64) (bool, error) {
+	res := &boolRes{}
+	path := fmt.Sprintf("/v1/products/%s/modules/%s/settings/%s:recall",
+		url.PathEscape(product), url.PathEscape(module), url.PathEscape(setting))
+	if err := c.Do(ctx, http.MethodPost, path, nil, recallBody{Release: release}, res); err != nil {
+		return false, err
+	}
+	return res.Result, nil
+}
+
+// Labels 返回用户在产品下的环境标签，优先使用本地缓存，服务不可用且无缓存时返回 nil。
+// opts 中只有 Version 和 Key 对环境标签生效
+func (c *Client) Labels(ctx context.Context, uid, product string, opts ...ReadOptions) []UserCacheLabel {
+	o := readOptions(opts)
+	o.Channel, o.Client = "", ""
+	v := c.labels.get(ctx, cacheKey(uid, product, o), func(ctx context.Context) (interface{}, error) {
+		res, err := c.GetLabels(ctx, UIDProductURL{UID: uid, Product: product, Key: o.Key, Version: o.Version})
+		if err != nil {
+			return nil, err
+		}
+		return res.Result, nil
+	})
+	if v == nil {
+		return nil
+	}
+	return v.([]UserCacheLabel)
+}
+
+// HasLabel 判断用户在产品下是否有该环境标签
+func (c *Client) HasLabel(ctx context.Context, uid, product, label string, opts ...ReadOptions) bool {
+	for _, l := range c.Labels(ctx, uid, product, opts...) {
+		if l.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings 返回用户在产品下的全部配置项，key 为 "module/setting"，
+// 优先使用本地缓存，服务不可用且无缓存时返回 nil
+func (c *Client) Settings(ctx context.Context, uid, product string, opts ...ReadOptions) map[string]MySetting {
+	o := readOptions(opts)
+	v := c.settings.get(ctx, cacheKey(uid, product, o), func(ctx context.Context) (interface{}, error) {
+		settings, err := c.ListSettings(ctx, MySettingsQueryURL{
+			UID:     uid,
+			Product: product,
+			Channel: o.Channel,
+			Client:  o.Client,
+			Version: o.Version,
+			Key:     o.Key,
+		})
+		if err != nil {
+			return nil, err
+		}
+		res := make(map[string]MySetting, len(settings))
+		for _, s := range settings {
+			res[s.Module+"/"+s.Name] = s
+		}
+		return res, nil
+	})
+	if v == nil {
+		return nil
+	}
+	return v.(map[string]MySetting)
+}
+
+// Value 返回用户的配置项的值，配置项不存在或无法获取时 ok 为 false
+func (c *Client) Value(ctx context.Context, uid, product, module, setting string, opts ...ReadOptions) (value string, ok bool) {
+	s, ok := c.Settings(ctx, uid, product, opts...)[module+"/"+setting]
+	return s.Value, ok
+}
+
+// String 返回字符串配置项的值，无法获取时返回 def
+func (c *Client) String(ctx context.Context, uid, product, module, setting, def string, opts ...ReadOptions) string {
+	if v, ok := c.Value(ctx, uid, product, module, setting, opts...); ok {
+		return v
+	}
+	return def
+}
+
+// Bool 返回 bool 配置项的值，无法获取或无法解析时返回 def
+func (c *Client) Bool(ctx context.Context, uid, product, module, setting string, def bool, opts ...ReadOptions) bool {
+	if v, ok := c.Value(ctx, uid, product, module, setting, opts...); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// Int 返回 int 配置项的值，无法获取或无法解析时返回 def
+func (c *Client) Int(ctx context.Context, uid, product, module, setting string, def int64, opts ...ReadOptions) int64 {
+	if v, ok := c.Value(ctx, uid, product, module, setting, opts...); ok {
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// Float 返回 float 配置项的值，无法获取或无法解析时返回 def
+func (c *Client) Float(ctx context.Context, uid, product, module, setting string, def float64, opts ...ReadOptions) float64 {
+	if v, ok := c.Value(ctx, uid, product, module, setting, opts...); ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// JSON 把 json 配置项的值解码到 v，无法获取或无法解码时返回错误
+func (c *Client) JSON(ctx context.Context, uid, product, module, setting string, v interface{}, opts ...ReadOptions) error {
+	s, ok := c.Value(ctx, uid, product, module, setting, opts...)
+	if !ok {
+		return fmt.Errorf("urbs-setting: setting %s/%s/%s not found", product, module, setting)
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+// Invalidate 清除用户在产品下的本地缓存，包括全部 ReadOptions 的结果
+func (c *Client) Invalidate(uid, product string) {
+	users := []string{uid}
+	c.labels.removeUsers(product, users)
+	c.settings.removeUsers(product, users)
+}
+
+// cacheKey 以 userProductKey 为前缀，按用户和产品清除缓存时包括全部 ReadOptions
+func cacheKey(uid, product string, o ReadOptions) string {
+	return strings.Join([]string{uid, product, o.Channel, o.Client, o.Version, o.Key}, "\x00")
+}
+
+func setQuery(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authjwt "github.com/teambition/gear-auth/jwt"
+
+	"github.com/teambition/urbs-setting/src/api"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// switchTransport 在 down 时模拟服务不可用
+type switchTransport struct {
+	down int32
+}
+
+func (t *switchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&t.down) == 1 {
+		return nil, errors.New("connection refused")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClient(t *testing.T) {
+	srv := api.NewApp().Start()
+	defer srv.Close()
+	endpoint := "http://" + srv.Addr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	admin, err := New(Options{Endpoint: endpoint})
+	assert.Nil(t, err)
+
+	product, module, setting, label := tpl.RandName(), tpl.RandName(), tpl.RandName(), tpl.RandLabel()
+	uids := []string{tpl.RandUID(), tpl.RandUID()}
+	assert.Nil(t, admin.BatchAddUsers(ctx, uids))
+	assert.Nil(t, admin.Do(ctx, http.MethodPost, "/v1/products", nil, tpl.NameDescBody{Name: product, Desc: product}, nil))
+	assert.Nil(t, admin.Do(ctx, http.MethodPost, fmt.Sprintf("/v1/products/%s/labels", product), nil, tpl.LabelBody{Name: label, Desc: label}, nil))
+	assert.Nil(t, admin.Do(ctx, http.MethodPost, fmt.Sprintf("/v1/products/%s/modules", product), nil, tpl.NameDescBody{Name: module, Desc: module}, nil))
+	assert.Nil(t, admin.Do(ctx, http.MethodPost, fmt.Sprintf("/v1/products/%s/modules/%s/settings", product, module), nil, tpl.NameDescBody{Name: setting, Desc: setting}, nil))
+	values := []string{"true", "false"}
+	assert.Nil(t, admin.Do(ctx, http.MethodPut, fmt.Sprintf("/v1/products/%s/modules/%s/settings/%s", product, module, setting), nil, tpl.SettingUpdateBody{Values: &values}, nil))
+
+	t.Run("New should validate endpoint", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := New(Options{Endpoint: "localhost:8080"})
+		assert.NotNil(err)
+	})
+
+	t.Run("Do should return Error", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := admin.GetLabels(ctx, UIDProductURL{UID: uids[0]})
+		e, ok := err.(*Error)
+		assert.True(ok)
+		assert.Equal(400, e.Status)
+		assert.Equal("BadRequest", e.ErrorResponseType.Error)
+
+		_, err = admin.GetSettings(ctx, MySettingsQueryURL{UID: uids[0], Product: tpl.RandName()})
+		e, ok = err.(*Error)
+		assert.True(ok)
+		assert.Equal(404, e.Status)
+	})
+
+	t.Run("AssignLabel and AssignSetting should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.False(admin.HasLabel(ctx, uids[0], product, label))
+		assert.True(admin.Bool(ctx, uids[0], product, module, setting, true))
+
+		lr, err := admin.AssignLabel(ctx, product, label, UsersGroupsBodyV2{Users: uids[:1]})
+		assert.Nil(err)
+		assert.True(lr.Release > 0)
+		sr, err := admin.AssignSetting(ctx, product, module, setting, UsersGroupsBodyV2{Users: uids[:1], Value: "false"})
+		assert.Nil(err)
+		assert.Equal("false", sr.Value)
+
+		// 指派成功后清除了这些用户的缓存
+		assert.True(admin.HasLabel(ctx, uids[0], product, label))
+		assert.False(admin.Bool(ctx, uids[0], product, module, setting, true))
+		assert.False(admin.HasLabel(ctx, uids[1], product, label))
+	})
+
+	t.Run("GetLabels, BatchGetLabels and ListSettings should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := admin.GetLabels(ctx, UIDProductURL{UID: uids[0], Product: product})
+		assert.Nil(err)
+		assert.True(res.Timestamp > 0)
+		assert.Equal(label, res.Result[0].Label)
+
+		batch, err := admin.BatchGetLabels(ctx, UsersCacheLabelsBody{Product: product, Users: uids})
+		assert.Nil(err)
+		assert.Equal(2, len(batch.Result))
+		assert.Equal(1, len(batch.Result[0].Labels))
+		assert.Equal(0, len(batch.Result[1].Labels))
+
+		settings, err := admin.ListSettings(ctx, MySettingsQueryURL{UID: uids[0], Product: product})
+		assert.Nil(err)
+		assert.Equal(1, len(settings))
+		assert.Equal("false", settings[0].Value)
+	})
+
+	t.Run("typed accessors should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		v, ok := admin.Value(ctx, uids[0], product, module, setting)
+		assert.True(ok)
+		assert.Equal("false", v)
+		assert.Equal("false", admin.String(ctx, uids[0], product, module, setting, "x"))
+		assert.Equal(int64(7), admin.Int(ctx, uids[0], product, module, setting, 7))
+		assert.Equal(1.5, admin.Float(ctx, uids[0], product, module, setting, 1.5))
+		var b bool
+		assert.Nil(admin.JSON(ctx, uids[0], product, module, setting, &b))
+		assert.False(b)
+
+		_, ok = admin.Value(ctx, uids[0], product, module, tpl.RandName())
+		assert.False(ok)
+		assert.True(admin.Bool(ctx, uids[0], product, module, tpl.RandName(), true))
+		assert.NotNil(admin.JSON(ctx, uids[0], product, module, tpl.RandName(), &b))
+	})
+
+	t.Run("cache should serve fresh values until TTL", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c, err := New(Options{Endpoint: endpoint, TTL: time.Minute})
+		assert.Nil(err)
+		assert.False(c.Bool(ctx, uids[0], product, module, setting, true))
+
+		_, err = admin.AssignSetting(ctx, product, module, setting, UsersGroupsBodyV2{Users: uids[:1], Value: "true"})
+		assert.Nil(err)
+		assert.False(c.Bool(ctx, uids[0], product, module, setting, true))
+
+		c.Invalidate(uids[0], product)
+		assert.True(c.Bool(ctx, uids[0], product, module, setting, false))
+	})
+
+	t.Run("cache should keep ReadOptions apart and clear them together", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c, err := New(Options{Endpoint: endpoint, TTL: time.Minute})
+		assert.Nil(err)
+		opts := ReadOptions{Client: "ios", Version: "1.0.0", Key: tpl.RandUID()}
+		assert.True(c.Bool(ctx, uids[0], product, module, setting, false))
+		assert.True(c.Bool(ctx, uids[0], product, module, setting, false, opts))
+		assert.True(c.HasLabel(ctx, uids[0], product, label, opts))
+
+		_, err = c.AssignSetting(ctx, product, module, setting, UsersGroupsBodyV2{Users: uids[:1], Value: "false"})
+		assert.Nil(err)
+		assert.False(c.Bool(ctx, uids[0], product, module, setting, true))
+		assert.False(c.Bool(ctx, uids[0], product, module, setting, true, opts))
+
+		_, err = admin.AssignSetting(ctx, product, module, setting, UsersGroupsBodyV2{Users: uids[:1], Value: "true"})
+		assert.Nil(err)
+		assert.False(c.Bool(ctx, uids[0], product, module, setting, true, opts))
+		c.Invalidate(uids[0], product)
+		assert.True(c.Bool(ctx, uids[0], product, module, setting, false))
+		assert.True(c.Bool(ctx, uids[0], product, module, setting, false, opts))
+	})
+
+	t.Run("cache should return stale values and revalidate in background", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c, err := New(Options{Endpoint: endpoint, TTL: 10 * time.Millisecond, StaleTTL: time.Minute})
+		assert.Nil(err)
+		assert.True(c.Bool(ctx, uids[0], product, module, setting, false))
+
+		_, err = admin.AssignSetting(ctx, product, module, setting, UsersGroupsBodyV2{Users: uids[:1], Value: "false"})
+		assert.Nil(err)
+		time.Sleep(20 * time.Millisecond)
+		assert.True(c.Bool(ctx, uids[0], product, module, setting, false))
+		assert.Eventually(func() bool {
+			return !c.Bool(ctx, uids[0], product, module, setting, true)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should fall back to stale values or defaults when unreachable", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tr := &switchTransport{}
+		var errs int32
+		c, err := New(Options{
+			Endpoint:   endpoint,
+			HTTPClient: &http.Client{Transport: tr},
+			TTL:        time.Millisecond,
+			StaleTTL:   time.Millisecond,
+			OnError:    func(err error) { atomic.AddInt32(&errs, 1) },
+		})
+		assert.Nil(err)
+		assert.True(c.HasLabel(ctx, uids[0], product, label))
+		assert.False(c.Bool(ctx, uids[0], product, module, setting, true))
+
+		atomic.StoreInt32(&tr.down, 1)
+		time.Sleep(5 * time.Millisecond)
+		assert.True(c.HasLabel(ctx, uids[0], product, label))
+		assert.False(c.Bool(ctx, uids[0], product, module, setting, true))
+		assert.Equal(int32(2), atomic.LoadInt32(&errs))
+
+		assert.Nil(c.Labels(ctx, uids[1], product))
+		assert.Nil(c.Settings(ctx, uids[1], product))
+		assert.True(c.Bool(ctx, uids[1], product, module, setting, true))
+		assert.Equal("x", c.String(ctx, uids[1], product, module, setting, "x"))
+
+		atomic.StoreInt32(&tr.down, 0)
+		c.Invalidate(uids[1], product)
+		assert.False(c.HasLabel(ctx, uids[1], product, label))
+		assert.NotNil(c.Settings(ctx, uids[1], product))
+	})
+
+	t.Run("JWT should sign tokens verified by auth_keys", func(t *testing.T) {
+		assert := assert.New(t)
+
+		key := "kqGuLsiKT1J5ANFDKXUHc2lAYfdzWBnriL1iHgBbYQ"
+		ts := JWT("my-service", key)
+		token, err := ts.Token(ctx)
+		assert.Nil(err)
+		token2, _ := ts.Token(ctx)
+		assert.Equal(token, token2)
+
+		claims, err := authjwt.New(authjwt.StrToKeys(key)...).Verify(token)
+		assert.Nil(err)
+		sub, _ := claims.Subject()
+		assert.Equal("my-service", sub)
+
+		c, err := New(Options{Endpoint: endpoint, Token: ts})
+		assert.Nil(err)
+		_, err = c.GetLabels(ctx, UIDProductURL{UID: uids[0], Product: product})
+		assert.Nil(err)
+	})
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 以下为 HTTP API 的请求和响应类型，与服务端 tpl、schema 中的同名类型保持 JSON 兼容。
+// 客户端不依赖服务端的包，导入时不读取服务配置。
+
+// ErrorResponseType 错误响应
+type ErrorResponseType struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// UIDProductURL 读取用户环境标签的参数
+type UIDProductURL struct {
+	UID     string `json:"uid"`
+	Product string `json:"product"`
+	Key     string `json:"key"`     // 可选，用于 bucketBy 为 "key" 的发布规则的分桶 key，如设备 ID
+	Version string `json:"version"` // 可选，客户端版本，指定时过滤掉版本范围不包含该版本的环境标签
+}
+
+// UserCacheLabel 用户缓存的环境标签
+type UserCacheLabel struct {
+	Label      string   `json:"l"`
+	Clients    []string `json:"cls,omitempty"`
+	Channels   []string `json:"chs,omitempty"`
+	MinVersion string   `json:"minv,omitempty"`
+	MaxVersion string   `json:"maxv,omitempty"`
+}
+
+// CacheLabelsInfoRes ...
+type CacheLabelsInfoRes struct {
+	Timestamp int64            `json:"timestamp"` // labels 数组生成时间
+	Result    []UserCacheLabel `json:"result"`
+}
+
+// UsersCacheLabelsBody 批量读取用户环境标签的参数
+type UsersCacheLabelsBody struct {
+	Product string            `json:"product"`
+	Users   []string          `json:"users"`
+	Version string            `json:"version"` // 可选，客户端版本，指定时过滤掉版本范围不包含该版本的环境标签
+	Keys    map[string]string `json:"keys"`    // 可选，uid 到分桶 key 的映射，用于 bucketBy 为 "key" 的发布规则
+}
+
+// UserCacheLabels 单个用户的环境标签
+type UserCacheLabels struct {
+	UID       string           `json:"uid"`
+	Timestamp int64            `json:"timestamp"` // labels 数组生成时间
+	Labels    []UserCacheLabel `json:"labels"`
+}
+
+// UsersCacheLabelsRes ...
+type UsersCacheLabelsRes struct {
+	Result []UserCacheLabels `json:"result"` // 按请求的用户顺序，重复的用户只返回一次
+}
+
+// MySettingsQueryURL 读取用户配置项的参数
+type MySettingsQueryURL struct {
+	UID       string `json:"uid"`
+	Product   string `json:"product"`
+	Module    string `json:"module"`
+	Setting   string `json:"setting"`
+	Channel   string `json:"channel"`
+	Client    string `json:"client"`
+	Version   string `json:"version"` // 可选，客户端版本，用于过滤限定了版本范围的配置项
+	Key       string `json:"key"`     // 可选，用于 bucketBy 为 "key" 的发布规则的分桶 key
+	PageToken string `json:"pageToken"`
+	PageSize  int    `json:"pageSize,omitempty"`
+}
+
+// SettingGroup 配置项指派来源的群组
+type SettingGroup struct {
+	UID  string `json:"uid"`
+	Kind string `json:"kind"`
+}
+
+// SettingConflict 用户与其群组对配置项的指派冲突时落选的来源
+type SettingConflict struct {
+	Source     string        `json:"source"` // user 或 group
+	Group      *SettingGroup `json:"group,omitempty"`
+	Value      string        `json:"value"`
+	AssignedAt time.Time     `json:"assignedAt"`
+}
+
+// MySetting 用户的配置项
+type MySetting struct {
+	HID        string          `json:"hid"`
+	Product    string          `json:"product"`
+	Module     string          `json:"module"`
+	Name       string          `json:"name"`
+	Desc       string          `json:"desc"`
+	Value      string          `json:"value"`
+	LastValue  string          `json:"lastValue"`
+	Release    int64           `json:"release"`
+	AssignedAt time.Time       `json:"assignedAt"`
+	Source     string          `json:"source,omitempty"` // 未被指派而返回默认值时为 "default"
+	ValueType  string          `json:"valueType"`
+	TypedValue json.RawMessage `json:"typedValue"` // 按值类型编码的值，值不符合类型时为 null
+	// 取值为变体名称时下发的变体内容及其 sha256 摘要
+	Payload     *string `json:"payload,omitempty"`
+	PayloadHash string  `json:"payloadHash,omitempty"`
+	// 用户与其群组对配置项的指派冲突时，按产品线的优先策略落选的来源
+	Conflicts []SettingConflict `json:"conflicts,omitempty"`
+}
+
+// MySettingsRes ...
+type MySettingsRes struct {
+	NextPageToken string      `json:"nextPageToken"`
+	Result        []MySetting `json:"result"`
+}
+
+// GroupKindUID 群组类型和 uid
+type GroupKindUID struct {
+	Kind string `json:"kind"`
+	UID  string `json:"uid"`
+}
+
+// UsersGroupsBodyV2 指派环境标签或配置项的参数
+type UsersGroupsBodyV2 struct {
+	Users  []string        `json:"users"`
+	Groups []*GroupKindUID `json:"groups"`
+	Value  string          `json:"value"`
+}
+
+// LabelReleaseInfo 环境标签的一次指派
+type LabelReleaseInfo struct {
+	Release int64    `json:"release"`
+	Users   []string `json:"users"`
+	Groups  []string `json:"groups"`
+}
+
+// SettingReleaseInfo 配置项的一次指派
+type SettingReleaseInfo struct {
+	Release int64    `json:"release"`
+	Users   []string `json:"users"`
+	Groups  []string `json:"groups"`
+	Value   string   `json:"value"`
+	// 前置条件不满足而未指派的用户和群组，都满足时不返回
+	SkippedUsers  []string `json:"skippedUsers,omitempty"`
+	SkippedGroups []string `json:"skippedGroups,omitempty"`
+}
+
+type labelReleaseInfoRes struct {
+	Result LabelReleaseInfo `json:"result"`
+}
+
+type settingReleaseInfoRes struct {
+	Result SettingReleaseInfo `json:"result"`
+}
+
+type boolRes struct {
+	Result bool `json:"result"`
+}
+
+type usersBody struct {
+	Users []string `json:"users"`
+}
+
+type recallBody struct {
+	Release int64 `json:"release"`
+}
@@ -42,22 +42,76 @@ type OpenTrust struct {
 	DomainPublicKeys []string  `json:"domain_public_keys" yaml:"domain_public_keys"`
 }
 
+// SettingVariant 配置项变体内容的限制
+type SettingVariant struct {
+	MaxPayloadSize int `json:"max_payload_size" yaml:"max_payload_size"` // 单个变体内容的最大字节数，默认 64KB，最大 16MB
+	MaxCount       int `json:"max_count" yaml:"max_count"`               // 单个配置项的最大变体数，默认 20
+}
+
+// EntityCache 产品、功能模块、配置项和标签按名称解析的进程内缓存
+type EntityCache struct {
+	TTL           string `json:"ttl" yaml:"ttl"`                       // 缓存有效期，默认 30s，设置为 0 时禁用缓存
+	CheckInterval string `json:"check_interval" yaml:"check_interval"` // 检查其它实例变更的间隔，默认 2s
+	ttl           time.Duration
+	checkInterval time.Duration
+}
+
+// TTLDuration 返回缓存有效期，为 0 表示禁用缓存
+func (c EntityCache) TTLDuration() time.Duration {
+	return c.ttl
+}
+
+// CheckDuration 返回检查其它实例变更的间隔
+func (c EntityCache) CheckDuration() time.Duration {
+	return c.checkInterval
+}
+
+func (c *EntityCache) validate() error {
+	c.ttl = 30 * time.Second
+	if c.TTL != "" {
+		du, err := time.ParseDuration(c.TTL)
+		if err != nil {
+			return err
+		}
+		if du < 0 {
+			du = 0
+		}
+		c.ttl = du
+	}
+
+	c.checkInterval = 2 * time.Second
+	if c.CheckInterval != "" {
+		du, err := time.ParseDuration(c.CheckInterval)
+		if err != nil {
+			return err
+		}
+		if du < 100*time.Millisecond {
+			du = 100 * time.Millisecond
+		}
+		c.checkInterval = du
+	}
+	return nil
+}
+
 // ConfigTpl ...
 type ConfigTpl struct {
 	GlobalCtx        context.Context
-	SrvAddr          string    `json:"addr" yaml:"addr"`
-	CertFile         string    `json:"cert_file" yaml:"cert_file"`
-	KeyFile          string    `json:"key_file" yaml:"key_file"`
-	Logger           Logger    `json:"logger" yaml:"logger"`
-	MySQL            SQL       `json:"mysql" yaml:"mysql"`
-	MySQLRd          SQL       `json:"mysql_read" yaml:"mysql_read"`
-	CacheLabelExpire string    `json:"cache_label_expire" yaml:"cache_label_expire"`
-	Channels         []string  `json:"channels" yaml:"channels"`
-	Clients          []string  `json:"clients" yaml:"clients"`
-	HIDKey           string    `json:"hid_key" yaml:"hid_key"`
-	AuthKeys         []string  `json:"auth_keys" yaml:"auth_keys"`
-	OpenTrust        OpenTrust `json:"open_trust" yaml:"open_trust"`
-	cacheLabelExpire int64     // seconds, default to 60 seconds
+	SrvAddr          string         `json:"addr" yaml:"addr"`
+	GRPCAddr         string         `json:"grpc_addr" yaml:"grpc_addr"` // gRPC 服务地址，为空时不启动
+	CertFile         string         `json:"cert_file" yaml:"cert_file"`
+	KeyFile          string         `json:"key_file" yaml:"key_file"`
+	Logger           Logger         `json:"logger" yaml:"logger"`
+	MySQL            SQL            `json:"mysql" yaml:"mysql"`
+	MySQLRd          SQL            `json:"mysql_read" yaml:"mysql_read"`
+	CacheLabelExpire string         `json:"cache_label_expire" yaml:"cache_label_expire"`
+	Channels         []string       `json:"channels" yaml:"channels"`
+	Clients          []string       `json:"clients" yaml:"clients"`
+	HIDKey           string         `json:"hid_key" yaml:"hid_key"`
+	AuthKeys         []string       `json:"auth_keys" yaml:"auth_keys"`
+	OpenTrust        OpenTrust      `json:"open_trust" yaml:"open_trust"`
+	SettingVariant   SettingVariant `json:"setting_variant" yaml:"setting_variant"`
+	EntityCache      EntityCache    `json:"entity_cache" yaml:"entity_cache"`
+	cacheLabelExpire int64          // seconds, default to 60 seconds
 }
 
 // Validate 用于完成基本的配置验证和初始化工作。业务相关的配置验证建议放到相关代码中实现，如 mysql 的配置。
@@ -70,7 +124,17 @@ func (c *ConfigTpl) Validate() error {
 		du = time.Minute
 	}
 	c.cacheLabelExpire = int64(du / time.Second)
-	return nil
+
+	if c.SettingVariant.MaxPayloadSize <= 0 {
+		c.SettingVariant.MaxPayloadSize = 64 * 1024
+	}
+	if c.SettingVariant.MaxPayloadSize > 16*1024*1024-1 {
+		c.SettingVariant.MaxPayloadSize = 16*1024*1024 - 1
+	}
+	if c.SettingVariant.MaxCount <= 0 {
+		c.SettingVariant.MaxCount = 20
+	}
+	return c.EntityCache.validate()
 }
 
 // IsCacheLabelExpired 判断用户缓存的 labels 是否超过有效期
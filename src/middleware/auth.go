@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"net/http"
 	"time"
 
 	otgo "github.com/open-trust/ot-go-lib"
@@ -48,41 +49,79 @@ var Auther *auth.Auth
 
 // Auth 验证请求者身份，如果验证失败，则返回 401 的 gear.HTTPError
 func Auth(ctx *gear.Context) error {
-	if otVerifier != nil {
-		token := otgo.ExtractTokenFromHeader(ctx.Req.Header)
-		if token == "" {
-			return gear.ErrUnauthorized.WithMsg("invalid authorization token")
+	h := ctx.Req.Header
+	if h.Get("Authorization") == "" {
+		// 兼容 gear-auth 从 access_token 参数读取 token
+		if token := ctx.Query("access_token"); token != "" {
+			h = h.Clone()
+			h.Set("Authorization", "Bearer "+token)
 		}
+	}
 
-		vid, err := otVerifier.ParseOTVID(token)
-		if err != nil && otLegacyVerifier != nil {
-			vid, err = otLegacyVerifier.ParseOTVID(token)
+	id, err := verifyHeader(h)
+	if err != nil {
+		return err
+	}
+	switch {
+	case id.jwt:
+		if id.subject != "" {
+			logging.AccessLogger.SetTo(ctx, "jwt_sub", id.subject)
 		}
-		if err != nil {
-			if Auther != nil { // 兼容老的 jwt 验证
-				return oldAuth(ctx)
-			}
-			return gear.ErrUnauthorized.WithMsg("authorization token verification failed")
+		if id.jwtID != "" {
+			logging.AccessLogger.SetTo(ctx, "jwt_id", id.jwtID)
 		}
+	case id.subject != "":
+		logging.AccessLogger.SetTo(ctx, "subject", id.subject)
+	}
+	return nil
+}
 
-		logging.AccessLogger.SetTo(ctx, "subject", vid.ID.String())
-		return nil
+// VerifyHeader 按与 Auth 相同的规则验证 gear 之外的请求（如 gRPC）的身份，返回请求者标识。
+// 未配置 open_trust 和 auth_keys 时不验证。
+func VerifyHeader(h http.Header) (string, error) {
+	id, err := verifyHeader(h)
+	if err != nil {
+		return "", err
 	}
-	return oldAuth(ctx)
+	return id.subject, nil
 }
 
-func oldAuth(ctx *gear.Context) error {
-	if Auther != nil {
-		claims, err := Auther.FromCtx(ctx)
-		if err != nil {
-			return err
+// identity 验证通过的请求者身份，jwt 为 true 时是老的 jwt 验证
+type identity struct {
+	subject string
+	jwt     bool
+	jwtID   string
+}
+
+// verifyHeader 优先验证 Open Trust 的 OTVID，失败时兼容老的 jwt 验证
+func verifyHeader(h http.Header) (identity, error) {
+	if otVerifier == nil && Auther == nil {
+		return identity{}, nil
+	}
+
+	token := otgo.ExtractTokenFromHeader(h)
+	if token == "" {
+		return identity{}, gear.ErrUnauthorized.WithMsg("invalid authorization token")
+	}
+	if otVerifier != nil {
+		vid, err := otVerifier.ParseOTVID(token)
+		if err != nil && otLegacyVerifier != nil {
+			vid, err = otLegacyVerifier.ParseOTVID(token)
 		}
-		if sub, ok := claims.Subject(); ok {
-			logging.AccessLogger.SetTo(ctx, "jwt_sub", sub)
+		if err == nil {
+			return identity{subject: vid.ID.String()}, nil
 		}
-		if jti, ok := claims.JWTID(); ok {
-			logging.AccessLogger.SetTo(ctx, "jwt_id", jti)
+		if Auther == nil {
+			return identity{}, gear.ErrUnauthorized.WithMsg("authorization token verification failed")
 		}
 	}
-	return nil
+
+	claims, err := Auther.JWT().Verify(token)
+	if err != nil {
+		return identity{}, gear.ErrUnauthorized.From(err)
+	}
+	id := identity{jwt: true}
+	id.subject, _ = claims.Subject()
+	id.jwtID, _ = claims.JWTID()
+	return id, nil
 }
@@ -0,0 +1,256 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/urbs-setting/src/conf"
+	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/schema"
+)
+
+// entityCache 产品、功能模块、配置项和标签按名称解析的进程内缓存，进程内所有 Models 共享。
+// 本实例对这些表的写操作经 updateByCols、deleteByCols 即时失效本地缓存，
+// 其它实例的 Update、Offline、Delete 操作则通过 urbs_statistic 中的 EntityCacheVersion 感知。
+// status、release 等计数在其它实例变更时不递增变更版本，最长在缓存有效期后刷新。
+var entityCache = newCache(conf.Config.EntityCache.TTLDuration(), conf.Config.EntityCache.CheckDuration())
+
+// counterCols 计数列，缓存容忍其短暂过期，写操作只更新这些列时不失效缓存
+var counterCols = map[string]bool{
+	"status": true,
+	"rls":    true,
+}
+
+// onlyCounters 判断写操作是否只更新计数列
+func onlyCounters(changed goqu.Record) bool {
+	for col := range changed {
+		if !counterCols[col] {
+			return false
+		}
+	}
+	return len(changed) > 0
+}
+
+// maxInvalidated 失效记录的最大条数，超过时清空失效记录并丢弃全部进行中的读取
+const maxInvalidated = 1024
+
+// cachedTables 使用名称解析缓存的表
+var cachedTables = map[string]bool{
+	schema.TableProduct: true,
+	schema.TableModule:  true,
+	schema.TableSetting: true,
+	schema.TableLabel:   true,
+}
+
+// CacheStats 名称解析缓存的统计数据
+type CacheStats struct {
+	Enabled       bool  `json:"enabled"`
+	Size          int   `json:"size"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"` // 本实例写操作失效的缓存次数
+	Resets        int64 `json:"resets"`        // 因其它实例变更而清空缓存的次数
+	Version       int64 `json:"version"`       // 已同步的变更版本
+}
+
+type cacheItem struct {
+	table  string
+	id     int64
+	value  interface{}
+	expire time.Time
+}
+
+type cache struct {
+	ttl           time.Duration
+	checkInterval time.Duration
+
+	mu          sync.RWMutex
+	items       map[string]*cacheItem
+	keys        map[string]string // "table:id" -> key
+	gen         int64             // 每次失效都会递增，用于丢弃失效前读取的数据
+	floor       int64             // 读取时的 gen 小于 floor 时放弃写入，清空缓存或失效记录时更新
+	invalidated map[string]int64  // "table:id" 或 "table:*" -> 最近一次失效时的 gen
+	version     int64
+
+	checking      int32
+	checkedAt     int64 // unix nano
+	hits          int64
+	misses        int64
+	invalidations int64
+	resets        int64
+}
+
+func newCache(ttl, checkInterval time.Duration) *cache {
+	return &cache{
+		ttl:           ttl,
+		checkInterval: checkInterval,
+		items:         make(map[string]*cacheItem),
+		keys:          make(map[string]string),
+		invalidated:   make(map[string]int64),
+	}
+}
+
+func (c *cache) enabled() bool {
+	return c.ttl > 0
+}
+
+func (c *cache) get(key string) (interface{}, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	c.mu.RLock()
+	item := c.items[key]
+	c.mu.RUnlock()
+	if item == nil || time.Now().After(item.expire) {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	return item.value, true
+}
+
+// generation 返回当前的失效计数，应在读取数据库之前获取，并传给 set
+func (c *cache) generation() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gen
+}
+
+// set 写入缓存，如果读取数据库期间该数据或其所在的表发生过失效则放弃写入，避免缓存旧数据
+func (c *cache) set(gen int64, key, table string, id int64, value interface{}) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	idKey := fmt.Sprintf("%s:%d", table, id)
+	if gen < c.floor || c.invalidated[table+":*"] > gen || c.invalidated[idKey] > gen {
+		return
+	}
+	c.items[key] = &cacheItem{table: table, id: id, value: value, expire: time.Now().Add(c.ttl)}
+	c.keys[idKey] = key
+}
+
+// invalidate 失效指定表中指定 ID 的缓存，ids 为空时失效该表的全部缓存
+func (c *cache) invalidate(table string, ids ...int64) {
+	if !cachedTables[table] {
+		return
+	}
+	atomic.AddInt64(&c.invalidations, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if len(c.invalidated)+len(ids) > maxInvalidated {
+		c.floor = c.gen
+		c.invalidated = make(map[string]int64)
+	}
+	if len(ids) == 0 {
+		c.invalidated[table+":*"] = c.gen
+		for key, item := range c.items {
+			if item.table == table {
+				delete(c.items, key)
+				delete(c.keys, fmt.Sprintf("%s:%d", table, item.id))
+			}
+		}
+		return
+	}
+	for _, id := range ids {
+		idKey := fmt.Sprintf("%s:%d", table, id)
+		c.invalidated[idKey] = c.gen
+		if key, ok := c.keys[idKey]; ok {
+			delete(c.items, key)
+			delete(c.keys, idKey)
+		}
+	}
+}
+
+// invalidateByCols 根据写操作的条件失效缓存，条件中没有明确的 id 时失效该表的全部缓存
+func (c *cache) invalidateByCols(table string, cls goqu.Ex) {
+	switch id := cls["id"].(type) {
+	case int64:
+		c.invalidate(table, id)
+	case []int64:
+		c.invalidate(table, id...)
+	default:
+		c.invalidate(table)
+	}
+}
+
+// shouldCheck 判断是否需要检查变更版本，同一时刻只有一个请求执行检查
+func (c *cache) shouldCheck() bool {
+	if !c.enabled() ||
+		time.Now().UnixNano()-atomic.LoadInt64(&c.checkedAt) < int64(c.checkInterval) {
+		return false
+	}
+	return atomic.CompareAndSwapInt32(&c.checking, 0, 1)
+}
+
+func (c *cache) checked() {
+	atomic.StoreInt64(&c.checkedAt, time.Now().UnixNano())
+	atomic.StoreInt32(&c.checking, 0)
+}
+
+// syncVersion 变更版本与本地不一致时清空全部缓存
+func (c *cache) syncVersion(version int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version == c.version {
+		return
+	}
+	c.version = version
+	c.gen++
+	c.floor = c.gen
+	c.items = make(map[string]*cacheItem)
+	c.keys = make(map[string]string)
+	c.invalidated = make(map[string]int64)
+	atomic.AddInt64(&c.resets, 1)
+}
+
+func (c *cache) stats() CacheStats {
+	c.mu.RLock()
+	size, version := len(c.items), c.version
+	c.mu.RUnlock()
+	return CacheStats{
+		Enabled:       c.enabled(),
+		Size:          size,
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Invalidations: atomic.LoadInt64(&c.invalidations),
+		Resets:        atomic.LoadInt64(&c.resets),
+		Version:       version,
+	}
+}
+
+// syncEntityCache 按间隔检查变更版本，其它实例有变更时清空本地缓存
+func (m *Model) syncEntityCache(ctx context.Context) {
+	if !entityCache.shouldCheck() {
+		return
+	}
+	defer entityCache.checked()
+
+	var version int64
+	sd := m.DB.From(schema.TableStatistic).Select(goqu.C("status")).
+		Where(goqu.C("name").Eq(schema.EntityCacheVersion))
+	if _, err := sd.Executor().ScanValContext(ctx, &version); err != nil {
+		logging.Debugf("syncEntityCache: error %v", err)
+		return
+	}
+	entityCache.syncVersion(version)
+}
+
+// tryBumpEntityCacheVersion 递增变更版本，通知其它实例清空名称解析缓存
+func (m *Model) tryBumpEntityCacheVersion(ctx context.Context) {
+	m.tryIncreaseStatisticStatus(ctx, schema.EntityCacheVersion, 1)
+}
+
+// primaryCtx 缓存未命中时从主库读取，避免从库延迟导致缓存旧数据
+func primaryCtx(ctx context.Context) context.Context {
+	if ctx.Value(ReadDB) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ReadDB, nil)
+}
@@ -0,0 +1,130 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// ChangeEvent ...
+type ChangeEvent struct {
+	*Model
+}
+
+// FindAfter 返回 id 大于 cursor 的变更事件，按 id 正序
+func (m *ChangeEvent) FindAfter(ctx context.Context, cursor int64, limit int) ([]schema.ChangeEvent, error) {
+	events := make([]schema.ChangeEvent, 0)
+	sd := m.DB.From(schema.TableChangeEvent).
+		Where(goqu.C("id").Gt(cursor)).
+		Order(goqu.C("id").Asc()).Limit(uint(limit))
+	if err := sd.Executor().ScanStructsContext(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// MaxID 返回最新变更事件的 id，没有事件时返回 0
+func (m *ChangeEvent) MaxID(ctx context.Context) (int64, error) {
+	var id int64
+	sd := m.DB.From(schema.TableChangeEvent).Select(goqu.C("id")).
+		Order(goqu.C("id").Desc()).Limit(1)
+	if _, err := sd.Executor().ScanValContext(ctx, &id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Cleanup 删除 before 之前的变更事件
+func (m *ChangeEvent) Cleanup(ctx context.Context, before time.Time) (int64, error) {
+	sd := m.DB.Delete(schema.TableChangeEvent).Where(goqu.C("created_at").Lt(before)).Limit(10000)
+	return service.DeResult(sd.Executor().ExecContext(ctx))
+}
+
+// tryEmitChanges 记录产品下的变更事件，userIDs、groupIDs 都为空时影响产品下的所有用户
+func (m *Model) tryEmitChanges(ctx context.Context, kind string, productID int64, userIDs, groupIDs []int64) {
+	if err := m.emitChanges(ctx, kind, productID, userIDs, groupIDs); err != nil {
+		logging.Warningf("emitChanges: kind %s, productID %d, error %v", kind, productID, err)
+	}
+}
+
+func (m *Model) emitChanges(ctx context.Context, kind string, productID int64, userIDs, groupIDs []int64) error {
+	rows := make([]interface{}, 0, len(userIDs)+len(groupIDs)+1)
+	for _, id := range userIDs {
+		rows = append(rows, schema.ChangeEvent{ProductID: productID, UserID: id, Kind: kind})
+	}
+	for _, id := range groupIDs {
+		rows = append(rows, schema.ChangeEvent{ProductID: productID, GroupID: id, Kind: kind})
+	}
+	if len(rows) == 0 {
+		rows = append(rows, schema.ChangeEvent{ProductID: productID, Kind: kind})
+	}
+	_, err := service.DeResult(m.DB.Insert(schema.TableChangeEvent).Rows(rows...).Executor().ExecContext(ctx))
+	return err
+}
+
+// tryEmitTargetChanges 记录环境标签或配置项引起的变更事件，kind 为 label 时 targetID 为环境标签 ID，否则为配置项 ID
+func (m *Model) tryEmitTargetChanges(ctx context.Context, kind string, targetID int64, userIDs, groupIDs []int64) {
+	productID, err := m.findTargetProductID(ctx, kind, targetID)
+	if err == nil {
+		err = m.emitChanges(ctx, kind, productID, userIDs, groupIDs)
+	}
+	if err != nil {
+		logging.Warningf("emitTargetChanges: kind %s, targetID %d, error %v", kind, targetID, err)
+	}
+}
+
+// tryEmitAssignChanges 记录向用户、群组指派环境标签或配置项引起的变更事件
+func (m *Model) tryEmitAssignChanges(ctx context.Context, kind string, targetID int64, users []string, groups []*tpl.GroupKindUID) {
+	userIDs := make([]int64, 0, len(users))
+	groupIDs := make([]int64, 0, len(groups))
+	if len(users) > 0 {
+		sd := m.DB.From(schema.TableUser).Select(goqu.C("id")).
+			Where(goqu.C("uid").In(tpl.StrSliceToInterface(users)...))
+		if err := sd.Executor().ScanValsContext(ctx, &userIDs); err != nil {
+			logging.Warningf("emitAssignChanges: kind %s, targetID %d, error %v", kind, targetID, err)
+			return
+		}
+	}
+	for _, group := range groups {
+		var id int64
+		sd := m.DB.From(schema.TableGroup).Select(goqu.C("id")).
+			Where(goqu.C("kind").Eq(group.Kind), goqu.C("uid").Eq(group.UID)).Limit(1)
+		if ok, err := sd.Executor().ScanValContext(ctx, &id); err == nil && ok {
+			groupIDs = append(groupIDs, id)
+		}
+	}
+	if len(userIDs) == 0 && len(groupIDs) == 0 {
+		return
+	}
+	m.tryEmitTargetChanges(ctx, kind, targetID, userIDs, groupIDs)
+}
+
+// targetChangeKind 返回 schema.TableLabel 或 schema.TableSetting 对应的变更类型
+func targetChangeKind(target string) string {
+	if target == schema.TableSetting {
+		return schema.ChangeKindSetting
+	}
+	return schema.ChangeKindLabel
+}
+
+// findTargetProductID 返回环境标签或配置项所属的产品 ID
+func (m *Model) findTargetProductID(ctx context.Context, kind string, targetID int64) (int64, error) {
+	var productID int64
+	sd := m.DB.From(schema.TableLabel).Select(goqu.C("product_id")).Where(goqu.C("id").Eq(targetID))
+	if kind == schema.ChangeKindSetting {
+		sd = m.DB.From(goqu.T(schema.TableSetting).As("t1"), goqu.T(schema.TableModule).As("t2")).
+			Select(goqu.I("t2.product_id")).
+			Where(goqu.I("t1.id").Eq(targetID), goqu.I("t1.module_id").Eq(goqu.I("t2.id")))
+	}
+	ok, err := sd.Limit(1).Executor().ScanValContext(ctx, &productID)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s %d not found", kind, targetID)
+	}
+	return productID, err
+}
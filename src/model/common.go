@@ -34,34 +34,46 @@ type Model struct {
 
 // Models ...
 type Models struct {
-	Model       *Model
-	Healthz     *Healthz
-	User        *User
-	Group       *Group
-	Product     *Product
-	Label       *Label
-	Module      *Module
-	Setting     *Setting
-	LabelRule   *LabelRule
-	SettingRule *SettingRule
-	Statistic   *Statistic
+	Model           *Model
+	Healthz         *Healthz
+	User            *User
+	Group           *Group
+	Product         *Product
+	Label           *Label
+	Module          *Module
+	Setting         *Setting
+	LabelRule       *LabelRule
+	SettingRule     *SettingRule
+	SettingVariant  *SettingVariant
+	SettingOverride *SettingOverride
+	Layer           *Layer
+	RuleRamp        *RuleRamp
+	RuleBackfill    *RuleBackfill
+	Statistic       *Statistic
+	ChangeEvent     *ChangeEvent
 }
 
 // NewModels ...
 func NewModels(sql *service.SQL) *Models {
 	m := &Model{SQL: sql, DB: sql.DB, RdDB: sql.RdDB}
 	return &Models{
-		Model:       m,
-		Healthz:     &Healthz{m},
-		User:        &User{m},
-		Group:       &Group{m},
-		Product:     &Product{m},
-		Label:       &Label{m},
-		Module:      &Module{m},
-		Setting:     &Setting{m},
-		LabelRule:   &LabelRule{m},
-		SettingRule: &SettingRule{m},
-		Statistic:   &Statistic{m},
+		Model:           m,
+		Healthz:         &Healthz{m},
+		User:            &User{m},
+		Group:           &Group{m},
+		Product:         &Product{m},
+		Label:           &Label{m},
+		Module:          &Module{m},
+		Setting:         &Setting{m},
+		LabelRule:       &LabelRule{m},
+		SettingRule:     &SettingRule{m},
+		SettingVariant:  &SettingVariant{m},
+		SettingOverride: &SettingOverride{m},
+		Layer:           &Layer{m},
+		RuleRamp:        &RuleRamp{m},
+		RuleBackfill:    &RuleBackfill{m},
+		Statistic:       &Statistic{m},
+		ChangeEvent:     &ChangeEvent{m},
 	}
 }
 
@@ -72,7 +84,7 @@ func (ms *Models) ApplyLabelRulesAndRefreshUserLabels(ctx context.Context, produ
 	user, labelIDs, ok, err := ms.User.RefreshLabels(ctx, userID, now.Unix(), force, product)
 	userProductLables := user.GetLabels(product)
 	if ok && len(userProductLables) == 0 {
-		hit, err := ms.LabelRule.ApplyRules(ctx, productID, userID, labelIDs, schema.RuleUserPercent)
+		hit, err := ms.LabelRule.ApplyRules(ctx, productID, userID, labelIDs, schema.RuleUserPercent, schema.RuleUserAttribute)
 		if err != nil {
 			return nil, err
 		}
@@ -81,27 +93,18 @@ func (ms *Models) ApplyLabelRulesAndRefreshUserLabels(ctx context.Context, produ
 			user, labelIDs, ok, err = ms.User.RefreshLabels(ctx, userID, now.Unix(), true, product)
 		}
 	} else if len(userProductLables) > 0 {
-		pg := tpl.Pagination{PageSize: 200}
-		pg.Q = userProductLables[0].Label + "%"
-		labels, _, err := ms.Label.Find(ctx, productID, pg)
+		child, err := ms.findChildLabel(ctx, productID, userProductLables[0].Label)
 		if err != nil {
 			return nil, err
 		}
-		sort.SliceStable(labels, func(i, j int) bool {
-			return len(labels[i].Name) < len(labels[j].Name)
-		})
-		for _, item := range labels {
-			if !strings.HasPrefix(item.Name, userProductLables[0].Label+"-") {
-				continue
-			}
-			hit, err := ms.LabelRule.ApplyRule(ctx, productID, userID, item.ID, schema.RuleChildLabelUserPercent)
+		if child != nil {
+			hit, err := ms.LabelRule.ApplyRule(ctx, productID, userID, child.ID, schema.RuleChildLabelUserPercent)
 			if err != nil {
 				return nil, err
 			}
 			if hit > 0 {
 				user, labelIDs, ok, err = ms.User.RefreshLabels(ctx, userID, now.Unix(), true, product)
 			}
-			break
 		}
 	}
 
@@ -112,6 +115,25 @@ func (ms *Models) ApplyLabelRulesAndRefreshUserLabels(ctx context.Context, produ
 	return user, err
 }
 
+// findChildLabel 返回名称最短的子标签（名称以 "label-" 为前缀），不存在时返回 nil
+func (ms *Models) findChildLabel(ctx context.Context, productID int64, label string) (*schema.Label, error) {
+	pg := tpl.Pagination{PageSize: 200}
+	pg.Q = label + "%"
+	labels, _, err := ms.Label.Find(ctx, productID, pg)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return len(labels[i].Name) < len(labels[j].Name)
+	})
+	for _, item := range labels {
+		if strings.HasPrefix(item.Name, label+"-") {
+			return &item, nil
+		}
+	}
+	return nil, nil
+}
+
 // TryApplyLabelRulesAndRefreshUserLabels ...
 func (ms *Models) TryApplyLabelRulesAndRefreshUserLabels(ctx context.Context, productID int64, product string, userID int64, now time.Time, force bool) *schema.User {
 	user, err := ms.ApplyLabelRulesAndRefreshUserLabels(ctx, productID, product, userID, now, force)
@@ -122,6 +144,79 @@ func (ms *Models) TryApplyLabelRulesAndRefreshUserLabels(ctx context.Context, pr
 	return user
 }
 
+// ApplyLabelRulesAndRefreshUsersLabels 批量版本的 ApplyLabelRulesAndRefreshUserLabels，
+// 刷新、规则计算与写入均按批次执行，返回 userIDs 中存在的 users
+func (ms *Models) ApplyLabelRulesAndRefreshUsersLabels(ctx context.Context, productID int64, product string, userIDs []int64, now time.Time, force bool) ([]schema.User, error) {
+	users, labelIDs, err := ms.User.RefreshUsersLabels(ctx, userIDs, now.Unix(), force, product)
+	if err != nil {
+		return nil, err
+	}
+
+	unlabeled := make([]schema.User, 0)
+	children := make(map[string][]schema.User) // 按首个环境标签分组，计算其子标签的规则
+	for _, user := range users {
+		userProductLables := user.GetLabels(product)
+		if _, ok := labelIDs[user.ID]; ok && len(userProductLables) == 0 {
+			unlabeled = append(unlabeled, user)
+		} else if len(userProductLables) > 0 {
+			label := userProductLables[0].Label
+			children[label] = append(children[label], user)
+		}
+	}
+
+	hitIDs, err := ms.LabelRule.ApplyRulesToUsers(ctx, productID, unlabeled, labelIDs, schema.RuleUserPercent, schema.RuleUserAttribute)
+	if err != nil {
+		return nil, err
+	}
+	for label, group := range children {
+		child, err := ms.findChildLabel(ctx, productID, label)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			continue
+		}
+		ids, err := ms.LabelRule.ApplyRuleToUsers(ctx, productID, group, child.ID, schema.RuleChildLabelUserPercent)
+		if err != nil {
+			return nil, err
+		}
+		hitIDs = append(hitIDs, ids...)
+	}
+
+	if len(hitIDs) > 0 {
+		// refresh label again
+		refreshed, _, err := ms.User.RefreshUsersLabels(ctx, hitIDs, now.Unix(), true, product)
+		if err != nil {
+			return nil, err
+		}
+		index := make(map[int64]schema.User, len(refreshed))
+		for _, user := range refreshed {
+			index[user.ID] = user
+		}
+		for i := range users {
+			if user, ok := index[users[i].ID]; ok {
+				users[i] = user
+			}
+		}
+	}
+
+	if elapsed := time.Now().UTC().Sub(now) / time.Millisecond; elapsed > 500 {
+		logging.Warningf("ApplyLabelRulesAndRefreshUsersLabels: %d users, consumed %d ms, start %v\n",
+			len(userIDs), elapsed, now)
+	}
+	return users, nil
+}
+
+// TryApplyLabelRulesAndRefreshUsersLabels ...
+func (ms *Models) TryApplyLabelRulesAndRefreshUsersLabels(ctx context.Context, productID int64, product string, userIDs []int64, now time.Time, force bool) []schema.User {
+	users, err := ms.ApplyLabelRulesAndRefreshUsersLabels(ctx, productID, product, userIDs, now, force)
+	if err != nil {
+		logging.Warningf("ApplyLabelRulesAndRefreshUsersLabels: %d users, error %v", len(userIDs), err)
+		return nil
+	}
+	return users
+}
+
 // TryApplySettingRules ...
 func (ms *Models) TryApplySettingRules(ctx context.Context, productID, userID int64) {
 	key := fmt.Sprintf("TryApplySettingRules:%d:%d", productID, userID)
@@ -131,7 +226,7 @@ func (ms *Models) TryApplySettingRules(ctx context.Context, productID, userID in
 
 	// 此处不要释放锁，锁期不再执行对应 setting rule
 	// defer ms.Model.unlock(ctx, key)
-	if err := ms.SettingRule.ApplyRules(ctx, productID, userID, schema.RuleUserPercent); err != nil {
+	if err := ms.SettingRule.ApplyRules(ctx, productID, userID, schema.RuleUserPercent, schema.RuleUserAttribute, schema.RuleUserVariant); err != nil {
 		logging.Warningf("%s error: %v", key, err)
 	}
 }
@@ -219,6 +314,9 @@ func (m *Model) updateByCols(ctx context.Context, table string, cls goqu.Ex, cha
 		return 0, nil
 	}
 	sd := m.DB.Update(table).Where(cls).Set(changed)
+	if !onlyCounters(changed) { // 缓存容忍计数过期
+		defer entityCache.invalidateByCols(table, cls)
+	}
 	return service.DeResult(sd.Executor().ExecContext(ctx))
 }
 
@@ -236,6 +334,7 @@ func (m *Model) deleteByCols(ctx context.Context, table string, cls goqu.Ex) (in
 	}
 
 	sd := m.DB.Delete(table).Where(cls)
+	defer entityCache.invalidateByCols(table, cls)
 	return service.DeResult(sd.Executor().ExecContext(ctx))
 }
 
@@ -356,6 +455,57 @@ func (m *Model) unlock(ctx context.Context, key string) {
 	}
 }
 
+// findUserAttributes 返回用于 userAttribute 规则匹配的用户属性
+func (m *Model) findUserAttributes(ctx context.Context, userID int64) (map[string]string, error) {
+	user := schema.User{}
+	sd := m.RdDB.From(schema.TableUser).Select(goqu.C("id"), goqu.C("uid"), goqu.C("created_at")).
+		Where(goqu.C("id").Eq(userID)).Limit(1)
+	ok, err := sd.Executor().ScanStructContext(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, gear.ErrNotFound.WithMsgf("user %d not found", userID)
+	}
+
+	res, err := m.findUsersAttributes(ctx, []schema.User{user})
+	if err != nil {
+		return nil, err
+	}
+	return res[userID], nil
+}
+
+// findUsersAttributes 批量返回用于 userAttribute 规则匹配的用户属性，users 须包含 id、uid 和 created_at
+func (m *Model) findUsersAttributes(ctx context.Context, users []schema.User) (map[int64]map[string]string, error) {
+	res := make(map[int64]map[string]string, len(users))
+	if len(users) == 0 {
+		return res, nil
+	}
+
+	userIDs := make([]int64, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+		res[user.ID] = make(map[string]string)
+	}
+
+	attrs := make([]schema.UserAttribute, 0)
+	sd := m.RdDB.From(schema.TableUserAttribute).Select(goqu.C("user_id"), goqu.C("name"), goqu.C("value")).
+		Where(goqu.C("user_id").In(userIDs)).Limit(uint(1000 * len(userIDs)))
+	if err := sd.Executor().ScanStructsContext(ctx, &attrs); err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		res[attr.UserID][attr.Name] = attr.Value
+	}
+
+	for _, user := range users {
+		// 内置属性不能被覆盖
+		res[user.ID][schema.AttrUID] = user.UID
+		res[user.ID][schema.AttrCreatedAt] = user.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return res, nil
+}
+
 // tryRefreshLabelStatus 更新指定 label 的 Status（环境标签灰度进度，被作用的用户数，非精确）值
 // 比如用户因为属于 n 个群组而被重复设置环境标签
 func (m *Model) tryRefreshLabelStatus(ctx context.Context, labelID int64) {
@@ -720,6 +870,9 @@ func (m *Model) tryDeleteLabelsRules(ctx context.Context, labelIDs []int64) {
 	var err error
 	if len(labelIDs) > 0 {
 		_, err = m.deleteByCols(ctx, schema.TableLabelRule, goqu.Ex{"label_id": labelIDs})
+		if err == nil {
+			_, err = m.deleteByCols(ctx, schema.TableLayerExperiment, goqu.Ex{"target": schema.TableLabel, "target_id": labelIDs})
+		}
 	}
 	if err != nil {
 		logging.Warningf("deleteLabelsRules with label_id [%v] error: %v", labelIDs, err)
@@ -743,6 +896,9 @@ func (m *Model) tryDeleteSettingsRules(ctx context.Context, settingIDs []int64)
 	var err error
 	if len(settingIDs) > 0 {
 		_, err = m.deleteByCols(ctx, schema.TableSettingRule, goqu.Ex{"setting_id": settingIDs})
+		if err == nil {
+			_, err = m.deleteByCols(ctx, schema.TableLayerExperiment, goqu.Ex{"target": schema.TableSetting, "target_id": settingIDs})
+		}
 	}
 	if err != nil {
 		logging.Warningf("deleteSettingsRules with setting_id [%v] error: %v", settingIDs, err)
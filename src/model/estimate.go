@@ -0,0 +1,204 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// estimateSampleSize 规则预估时最多参与计算的用户数，用户总数超过该值时按 id 等间隔抽样
+const estimateSampleSize = 100000
+
+// assignTables 返回环境标签或配置项的用户、群组指派表及关联字段
+func assignTables(target string) (userTable, groupTable, col string) {
+	if target == schema.TableSetting {
+		return schema.TableUserSetting, schema.TableGroupSetting, "setting_id"
+	}
+	return schema.TableUserLabel, schema.TableGroupLabel, "label_id"
+}
+
+// assignedUsers 返回已被指派（直接或通过群组）指定环境标签或配置项的用户 ID 子查询，userIDs 非空时只在其中查找
+func (m *Model) assignedUsers(target string, targetID int64, userIDs []int64) *goqu.SelectDataset {
+	userTable, groupTable, col := assignTables(target)
+	usd := m.RdDB.From(userTable).Select(goqu.C("user_id")).Where(goqu.C(col).Eq(targetID))
+	gsd := m.RdDB.Select(goqu.I("t1.user_id")).
+		From(
+			goqu.T(schema.TableUserGroup).As("t1"),
+			goqu.T(groupTable).As("t2")).
+		Where(
+			goqu.I("t1.group_id").Eq(goqu.I("t2.group_id")),
+			goqu.I("t2."+col).Eq(targetID))
+	if len(userIDs) > 0 {
+		usd = usd.Where(goqu.C("user_id").In(userIDs))
+		gsd = gsd.Where(goqu.I("t1.user_id").In(userIDs))
+	}
+	return usd.Union(gsd)
+}
+
+// estimateAssign 预估将环境标签或配置项指派给用户和群组时影响的用户数，只读取只读库
+func (m *Model) estimateAssign(ctx context.Context, target string, targetID int64, users []string, groups []*tpl.GroupKindUID) (*tpl.EstimateInfo, error) {
+	res := &tpl.EstimateInfo{}
+	subs := make([]*goqu.SelectDataset, 0)
+	if len(users) > 0 {
+		sd := m.RdDB.From(schema.TableUser).Select(goqu.C("id").As("user_id")).
+			Where(goqu.C("uid").In(tpl.StrSliceToInterface(users)...))
+		count, err := sd.CountContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res.Total += count
+		subs = append(subs, sd)
+	}
+
+	if len(groups) > 0 {
+		groupsMap := map[string][]string{}
+		for _, group := range groups {
+			groupsMap[group.Kind] = append(groupsMap[group.Kind], group.UID)
+		}
+		groupIDs := make([]int64, 0, len(groups))
+		for k, v := range groupsMap {
+			ids := make([]int64, 0, len(v))
+			sd := m.RdDB.From(schema.TableGroup).Select(goqu.C("id")).
+				Where(goqu.C("uid").In(tpl.StrSliceToInterface(v)...), goqu.C("kind").Eq(k))
+			if err := sd.Executor().ScanValsContext(ctx, &ids); err != nil {
+				return nil, err
+			}
+			groupIDs = append(groupIDs, ids...)
+		}
+		if len(groupIDs) > 0 {
+			sd := m.RdDB.From(schema.TableUserGroup).Select(goqu.C("user_id")).
+				Where(goqu.C("group_id").In(groupIDs))
+			count, err := sd.CountContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			res.Total += count
+			subs = append(subs, sd)
+		}
+	}
+
+	if len(subs) == 0 {
+		return res, nil
+	}
+	// UNION 对直接指派的用户和群组成员去重
+	sd := subs[0]
+	for _, sub := range subs[1:] {
+		sd = sd.Union(sub)
+	}
+
+	var err error
+	if res.Affected, err = m.RdDB.From(sd.As("t")).CountContext(ctx); err != nil {
+		return nil, err
+	}
+	if res.Overlap, err = m.RdDB.From(sd.As("t")).
+		Where(goqu.I("t.user_id").In(m.assignedUsers(target, targetID, nil))).
+		CountContext(ctx); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// estimateRule 预估发布规则创建后命中的用户数，只读取只读库。
+// 用户总数超过 estimateSampleSize 时抽样计算再按比例推算。新规则的分桶盐值随机生成，
+// 预估结果与规则实际命中的用户数接近，但命中的具体用户并不相同。
+func (m *Model) estimateRule(ctx context.Context, target string, rule ruleEntry) (*tpl.EstimateInfo, error) {
+	res := &tpl.EstimateInfo{}
+	total, err := m.RdDB.From(schema.TableUser).CountContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res.Total = total
+	if total == 0 {
+		return res, nil
+	}
+
+	step := int64(1)
+	if total > estimateSampleSize {
+		step = (total + estimateSampleSize - 1) / estimateSampleSize
+		res.Estimated = true
+	}
+
+	lb, err := m.findLayerBuckets(ctx, target, []int64{rule.targetID})
+	if err != nil {
+		return nil, err
+	}
+	if rule.createdAt.IsZero() {
+		rule.createdAt = time.Now().UTC()
+	}
+	rv := schema.ToPercentRule(rule.kind, rule.rule).Rule
+
+	var matched, overlap int64
+	cursor := int64(0)
+	for {
+		users := make([]schema.User, 0)
+		sd := m.RdDB.From(schema.TableUser).
+			Select(goqu.C("id"), goqu.C("uid"), goqu.C("created_at")).
+			Where(goqu.C("id").Gt(cursor))
+		if step > 1 {
+			sd = sd.Where(goqu.L("`id` % ?", step).Eq(0))
+		}
+		sd = sd.Order(goqu.C("id").Asc()).Limit(1000)
+		if err := sd.Executor().ScanStructsContext(ctx, &users); err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			break
+		}
+		cursor = users[len(users)-1].ID
+		res.Sampled += int64(len(users))
+
+		var attrs map[int64]map[string]string
+		if ruleNeedsAttrs(rule.kind, rv) {
+			if attrs, err = m.findUsersAttributes(ctx, users); err != nil {
+				return nil, err
+			}
+		}
+
+		ids := make([]int64, 0)
+		for _, user := range users {
+			subject := newUserSubject(user.ID)
+			subject.attrs = attrs[user.ID]
+			if _, ok := matchRule(lb, subject, rule, rv); ok {
+				ids = append(ids, user.ID)
+			}
+		}
+		if len(ids) > 0 {
+			matched += int64(len(ids))
+			count, err := m.RdDB.From(m.assignedUsers(target, rule.targetID, ids).As("t")).CountContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			overlap += count
+		}
+	}
+
+	res.Affected, res.Overlap = matched, overlap
+	if res.Estimated && res.Sampled > 0 {
+		res.Affected = matched * total / res.Sampled
+		res.Overlap = overlap * total / res.Sampled
+	}
+	return res, nil
+}
+
+// EstimateAssign 预估将环境标签指派给用户和群组时影响的用户数
+func (m *Label) EstimateAssign(ctx context.Context, labelID int64, users []string, groups []*tpl.GroupKindUID) (*tpl.EstimateInfo, error) {
+	return m.estimateAssign(ctx, schema.TableLabel, labelID, users, groups)
+}
+
+// EstimateAssign 预估将配置项指派给用户和群组时影响的用户数
+func (m *Setting) EstimateAssign(ctx context.Context, settingID int64, users []string, groups []*tpl.GroupKindUID) (*tpl.EstimateInfo, error) {
+	return m.estimateAssign(ctx, schema.TableSetting, settingID, users, groups)
+}
+
+// Estimate 预估环境标签发布规则命中的用户数，规则不需要已创建
+func (m *LabelRule) Estimate(ctx context.Context, labelRule *schema.LabelRule) (*tpl.EstimateInfo, error) {
+	return m.estimateRule(ctx, schema.TableLabel, labelRuleEntries([]schema.LabelRule{*labelRule})[0])
+}
+
+// Estimate 预估配置项发布规则命中的用户数，规则不需要已创建
+func (m *SettingRule) Estimate(ctx context.Context, settingRule *schema.SettingRule) (*tpl.EstimateInfo, error) {
+	return m.estimateRule(ctx, schema.TableSetting, settingRuleEntries([]schema.SettingRule{*settingRule})[0])
+}
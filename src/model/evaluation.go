@@ -0,0 +1,500 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// evaluationPageSize 评估时读取的指派记录数上限，与 settings:unionAll 单页上限一致
+const evaluationPageSize = 1000
+
+// evaluationRow 评估时读取的环境标签或配置项记录
+type evaluationRow struct {
+	ID         int64     `db:"id"`
+	Name       string    `db:"name"`
+	Release    int64     `db:"rls"`
+	AssignedAt time.Time `db:"assigned_at"`
+	Channels   string    `db:"channels"`
+	Clients    string    `db:"clients"`
+	MinVersion string    `db:"min_version"`
+	MaxVersion string    `db:"max_version"`
+	RuleID     int64     `db:"rule_id"`
+	Bucket     int       `db:"bucket"`
+	GroupUID   string    `db:"group_uid"`
+	GroupKind  string    `db:"group_kind"`
+}
+
+// match 判断记录是否适用于指定的 channel、client 和客户端版本，与 labels:cache 一致，version 为空时不按版本过滤
+func (r evaluationRow) match(channel, client, version string) bool {
+	if r.Channels != "" && !tpl.StringSliceHas(tpl.StringToSlice(r.Channels), channel) {
+		return false
+	}
+	if r.Clients != "" && !tpl.StringSliceHas(tpl.StringToSlice(r.Clients), client) {
+		return false
+	}
+	if version != "" && !schema.VersionInRange(version, r.MinVersion, r.MaxVersion) {
+		return false
+	}
+	return true
+}
+
+// reason 返回指派记录的来源，kinds 为规则 ID 到规则类型的映射，用于由发布规则写入的记录
+func (r evaluationRow) reason(kinds map[int64]string) tpl.EvaluationReason {
+	assignedAt := r.AssignedAt
+	reason := tpl.EvaluationReason{Source: tpl.EvaluationSourceUser, AssignedAt: &assignedAt}
+	if r.GroupUID != "" {
+		reason.Source = tpl.EvaluationSourceGroup
+		reason.Group = &tpl.EvaluationGroup{UID: r.GroupUID, Kind: r.GroupKind}
+	}
+	if kind, ok := kinds[r.RuleID]; ok {
+		reason.Rule = &tpl.EvaluationRule{
+			HID:    service.IDToHID(r.RuleID, "label_rule"),
+			Kind:   kind,
+			Bucket: r.Bucket,
+		}
+	}
+	return reason
+}
+
+// sortEvaluationRows 合并用户与群组的环境标签记录，按指派时间倒序并去重，与 labels:cache 的取值逻辑一致
+func sortEvaluationRows(rows []evaluationRow) []evaluationRow {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].AssignedAt.After(rows[j].AssignedAt)
+	})
+	res := make([]evaluationRow, 0, len(rows))
+	set := make(map[int64]struct{})
+	for _, row := range rows {
+		if _, ok := set[row.ID]; !ok {
+			set[row.ID] = struct{}{}
+			res = append(res, row)
+		}
+	}
+	return res
+}
+
+// EvaluateUser 只读地计算用户在产品线下的环境标签和配置项及其来源，不写入 user_label、user_setting 指派记录。
+// userID 为 0 时，uid 作为匿名用户计算发布规则。
+func (ms *Models) EvaluateUser(ctx context.Context, productID, userID int64, uid, channel, client, version string) ([]tpl.EvaluatedLabel, []tpl.EvaluatedSetting, error) {
+	subject := newAnonymousSubject(uid)
+	if userID > 0 {
+		subject = newUserSubject(userID)
+	}
+
+	now := time.Now().UTC()
+	labels, err := ms.evaluateLabels(ctx, productID, subject, channel, client, version, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings, err := ms.evaluateSettings(ctx, productID, subject, channel, client, version, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	return labels, settings, nil
+}
+
+func (ms *Models) evaluateLabels(ctx context.Context, productID int64, subject *ruleSubject, channel, client, version string, now time.Time) ([]tpl.EvaluatedLabel, error) {
+	db := ms.Model.RdDB
+	rows := make([]evaluationRow, 0)
+	if subject.userID > 0 {
+		sd := db.Select(
+			goqu.I("t1.rls"),
+			goqu.I("t1.created_at").As("assigned_at"),
+			goqu.I("t1.rule_id"),
+			goqu.I("t1.bucket"),
+			goqu.I("t2.id"),
+			goqu.I("t2.name"),
+			goqu.I("t2.channels"),
+			goqu.I("t2.clients"),
+			goqu.I("t2.min_version"),
+			goqu.I("t2.max_version")).
+			From(
+				goqu.T(schema.TableUserLabel).As("t1"),
+				goqu.T(schema.TableLabel).As("t2")).
+			Where(
+				goqu.I("t1.user_id").Eq(subject.userID),
+				goqu.I("t1.label_id").Eq(goqu.I("t2.id")),
+				goqu.I("t2.product_id").Eq(productID)).
+			Order(goqu.I("t1.id").Desc()).Limit(200)
+		if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+			return nil, err
+		}
+
+		groupRows := make([]evaluationRow, 0)
+		sd = db.Select(
+			goqu.I("t2.rls"),
+			goqu.I("t2.created_at").As("assigned_at"),
+			goqu.I("t2.rule_id"),
+			goqu.I("t2.bucket"),
+			goqu.I("t3.id"),
+			goqu.I("t3.name"),
+			goqu.I("t3.channels"),
+			goqu.I("t3.clients"),
+			goqu.I("t3.min_version"),
+			goqu.I("t3.max_version"),
+			goqu.I("t4.uid").As("group_uid"),
+			goqu.I("t4.kind").As("group_kind")).
+			From(
+				goqu.T(schema.TableUserGroup).As("t1"),
+				goqu.T(schema.TableGroupLabel).As("t2"),
+				goqu.T(schema.TableLabel).As("t3"),
+				goqu.T(schema.TableGroup).As("t4")).
+			Where(
+				goqu.I("t1.user_id").Eq(subject.userID),
+				goqu.I("t1.group_id").Eq(goqu.I("t2.group_id")),
+				goqu.I("t2.label_id").Eq(goqu.I("t3.id")),
+				goqu.I("t3.product_id").Eq(productID),
+				goqu.I("t1.group_id").Eq(goqu.I("t4.id"))).
+			Order(goqu.I("t2.id").Desc()).Limit(200)
+		if err := sd.Executor().ScanStructsContext(ctx, &groupRows); err != nil {
+			return nil, err
+		}
+		rows = sortEvaluationRows(append(rows, groupRows...))
+	}
+
+	// 与 ApplyLabelRulesAndRefreshUserLabels 一致：没有标签时计算 userPercent、userAttribute 规则，
+	// 否则计算最新标签的子标签规则
+	var rules []schema.LabelRule
+	var err error
+	if len(rows) == 0 {
+		rules, err = ms.LabelRule.findRules(ctx,
+			goqu.C("kind").In(schema.RuleUserPercent, schema.RuleUserAttribute),
+			goqu.C("product_id").Eq(productID))
+	} else if subject.userID > 0 {
+		var child *schema.Label
+		if child, err = ms.findChildLabel(ctx, productID, rows[0].Name); err == nil && child != nil {
+			rules, err = ms.LabelRule.findRules(ctx,
+				goqu.C("kind").Eq(schema.RuleChildLabelUserPercent),
+				goqu.C("label_id").Eq(child.ID),
+				goqu.C("product_id").Eq(productID))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ruleEntry, 0, len(rules))
+	assigned := make(map[int64]struct{}, len(rows))
+	for _, row := range rows {
+		assigned[row.ID] = struct{}{}
+	}
+	for _, entry := range labelRuleEntries(rules) {
+		if _, ok := assigned[entry.targetID]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+	matches, err := ms.Model.matchRules(ctx, schema.TableLabel, subject, entries, now)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds, err := ms.findLabelRuleKinds(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]tpl.EvaluatedLabel, 0, len(rows)+len(matches))
+	for _, row := range rows {
+		if row.match(channel, client, version) {
+			res = append(res, tpl.EvaluatedLabel{
+				HID:     service.IDToHID(row.ID, "label"),
+				Label:   row.Name,
+				Release: row.Release,
+				Reason:  row.reason(kinds),
+			})
+		}
+	}
+
+	if len(matches) > 0 {
+		labelIDs := make([]int64, 0, len(matches))
+		for _, match := range matches {
+			labelIDs = append(labelIDs, match.targetID)
+		}
+		labels := make([]evaluationRow, 0)
+		sd := db.Select(goqu.C("id"), goqu.C("name"), goqu.C("channels"), goqu.C("clients"),
+			goqu.C("min_version"), goqu.C("max_version")).
+			From(schema.TableLabel).Where(goqu.C("id").In(labelIDs)).Limit(uint(len(labelIDs)))
+		if err := sd.Executor().ScanStructsContext(ctx, &labels); err != nil {
+			return nil, err
+		}
+		mp := make(map[int64]evaluationRow, len(labels))
+		for _, label := range labels {
+			mp[label.ID] = label
+		}
+
+		for _, match := range matches {
+			label, ok := mp[match.targetID]
+			if _, exists := assigned[match.targetID]; !ok || exists || !label.match(channel, client, version) {
+				continue
+			}
+			assigned[match.targetID] = struct{}{}
+			res = append(res, tpl.EvaluatedLabel{
+				HID:     service.IDToHID(label.ID, "label"),
+				Label:   label.Name,
+				Release: match.release,
+				Reason: tpl.EvaluationReason{
+					Source: tpl.EvaluationSourceRule,
+					Rule: &tpl.EvaluationRule{
+						HID:    service.IDToHID(match.ruleID, "label_rule"),
+						Kind:   match.kind,
+						Bucket: match.bucket,
+					},
+				},
+			})
+		}
+	}
+	return res, nil
+}
+
+// findLabelRuleKinds 返回由发布规则写入的环境标签记录对应的规则类型，规则已删除时不包含
+func (ms *Models) findLabelRuleKinds(ctx context.Context, rows []evaluationRow) (map[int64]string, error) {
+	ruleIDs := make([]int64, 0)
+	for _, row := range rows {
+		if row.RuleID > 0 {
+			ruleIDs = append(ruleIDs, row.RuleID)
+		}
+	}
+	kinds := make(map[int64]string, len(ruleIDs))
+	if len(ruleIDs) == 0 {
+		return kinds, nil
+	}
+
+	rules := make([]struct {
+		ID   int64  `db:"id"`
+		Kind string `db:"kind"`
+	}, 0)
+	sd := ms.Model.RdDB.From(schema.TableLabelRule).Select(goqu.C("id"), goqu.C("kind")).
+		Where(goqu.C("id").In(ruleIDs)).Limit(uint(len(ruleIDs)))
+	if err := sd.Executor().ScanStructsContext(ctx, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		kinds[rule.ID] = rule.Kind
+	}
+	return kinds, nil
+}
+
+// evaluateSettings 与 settings:unionAll 使用相同的取值过程：指派记录按冲突优先策略取值，应用覆盖值，
+// 追加默认值，按前置条件过滤，再填充类型值与变体内容。命中而尚未写入的发布规则视为用户最新的指派记录。
+func (ms *Models) evaluateSettings(ctx context.Context, productID int64, subject *ruleSubject, channel, client, version string, now time.Time) ([]tpl.EvaluatedSetting, error) {
+	rules, err := ms.SettingRule.findRules(ctx, goqu.C("product_id").Eq(productID))
+	if err != nil {
+		return nil, err
+	}
+	kinds := make(map[int64]string, len(rules))
+	applicable := make([]schema.SettingRule, 0, len(rules))
+	for _, rule := range rules {
+		kinds[rule.ID] = rule.Kind
+		switch rule.Kind {
+		case schema.RuleUserPercent, schema.RuleUserAttribute, schema.RuleUserVariant:
+			applicable = append(applicable, rule)
+		}
+	}
+	matches, err := ms.Model.matchRules(ctx, schema.TableSetting, subject, settingRuleEntries(applicable), now)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []tpl.MySetting
+	reasons := make(map[int64]tpl.EvaluationReason)
+	if subject.userID > 0 {
+		settings, err = ms.evaluateUserSettings(ctx, productID, subject.userID, applicable, matches, kinds, reasons, channel, client, version)
+	} else {
+		settings, err = ms.evaluateAnonymousSettings(ctx, productID, matches, reasons, channel, client, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tpl.SetTypedValues(settings)
+	if err = ms.SettingVariant.FillPayloads(ctx, settings); err != nil {
+		return nil, err
+	}
+	res := make([]tpl.EvaluatedSetting, 0, len(settings))
+	for _, s := range settings {
+		reason, ok := reasons[s.ID]
+		if !ok {
+			reason = tpl.EvaluationReason{Source: tpl.EvaluationSourceDefault}
+		}
+		res = append(res, tpl.EvaluatedSetting{
+			HID:         s.HID,
+			Module:      s.Module,
+			Setting:     s.Name,
+			Value:       s.Value,
+			ValueType:   s.ValueType,
+			TypedValue:  s.TypedValue,
+			Payload:     s.Payload,
+			PayloadHash: s.PayloadHash,
+			Release:     s.Release,
+			Conflicts:   s.Conflicts,
+			Reason:      reason,
+		})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Module != res[j].Module {
+			return res[i].Module < res[j].Module
+		}
+		return res[i].Setting < res[j].Setting
+	})
+	return res, nil
+}
+
+// evaluateUserSettings 计算用户的配置项，与 ListSettingsUnionAll 对已知用户的处理一致
+func (ms *Models) evaluateUserSettings(ctx context.Context, productID, userID int64, rules []schema.SettingRule, matches []ruleMatch,
+	kinds map[int64]string, reasons map[int64]tpl.EvaluationReason, channel, client, version string) ([]tpl.MySetting, error) {
+	groupIDs, err := ms.Group.FindIDsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与 ApplyRules 一致，规则只写入用户尚无指派记录的配置项，同一配置项只写入靠前的规则
+	pending := make([]ruleMatch, 0, len(matches))
+	if len(matches) > 0 {
+		targetIDs := make([]int64, 0, len(matches))
+		for _, match := range matches {
+			targetIDs = append(targetIDs, match.targetID)
+		}
+		assignedIDs := make([]int64, 0)
+		sd := ms.Model.RdDB.From(schema.TableUserSetting).Select(goqu.C("setting_id")).
+			Where(goqu.C("user_id").Eq(userID), goqu.C("setting_id").In(targetIDs))
+		if err := sd.Executor().ScanValsContext(ctx, &assignedIDs); err != nil {
+			return nil, err
+		}
+		assigned := make(map[int64]struct{}, len(assignedIDs)+len(matches))
+		for _, id := range assignedIDs {
+			assigned[id] = struct{}{}
+		}
+		for _, match := range matches {
+			if _, ok := assigned[match.targetID]; !ok {
+				assigned[match.targetID] = struct{}{}
+				pending = append(pending, match)
+			}
+		}
+	}
+	values := make(map[int64]string, len(rules))
+	for _, rule := range rules {
+		values[rule.ID] = rule.Value
+	}
+	pendingValues := make(map[int64]string, len(pending))
+	for _, match := range pending {
+		if match.kind == schema.RuleUserVariant {
+			pendingValues[match.targetID] = match.value
+		} else {
+			pendingValues[match.targetID] = values[match.ruleID]
+		}
+	}
+
+	ruleSettings, hits, err := ms.Model.findRuleSettings(ctx, pending, channel, client, version)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := ms.User.FindSettingsUnionAll(ctx, groupIDs, userID, productID, 0, 0, tpl.Pagination{PageSize: evaluationPageSize}, channel, client, version)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(ruleSettings)+len(stored))
+	for _, s := range ruleSettings {
+		ids = append(ids, s.ID)
+	}
+	for _, s := range stored {
+		ids = append(ids, s.ID)
+	}
+	sources := make(map[int64][]settingSource, len(ids))
+	if len(ids) > 0 {
+		rows, err := ms.Model.findSettingSources(ctx, productID, []int64{userID}, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			sources[row.SettingID] = append(sources[row.SettingID], row)
+		}
+	}
+
+	settings := make([]tpl.MySetting, 0, len(ids))
+	for i, s := range ruleSettings {
+		// 规则写入的记录为用户最新的指派记录，优先于已有的群组指派记录
+		for _, r := range sources[s.ID] {
+			s.Conflicts = append(s.Conflicts, r.conflict())
+		}
+		reasons[s.ID] = ruleReason(hits[i])
+		settings = append(settings, s)
+	}
+	for _, s := range stored {
+		if _, ok := pendingValues[s.ID]; ok {
+			continue
+		}
+		if rs := sources[s.ID]; len(rs) > 0 {
+			reasons[s.ID] = rs[0].reason(kinds)
+		}
+		settings = append(settings, s)
+	}
+
+	defaults, err := ms.Setting.FindDefaults(ctx, productID, 0, 0, userID, groupIDs, "", channel, client, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range defaults {
+		if _, ok := pendingValues[s.ID]; !ok {
+			settings = append(settings, s)
+		}
+	}
+	return ms.Model.filterByPrerequisites(ctx, productID, userID, channel, client, settings, pendingValues)
+}
+
+// evaluateAnonymousSettings 计算匿名用户的配置项，与 ListSettingsUnionAll 对匿名用户的处理一致
+func (ms *Models) evaluateAnonymousSettings(ctx context.Context, productID int64, matches []ruleMatch,
+	reasons map[int64]tpl.EvaluationReason, channel, client, version string) ([]tpl.MySetting, error) {
+	settings, hits, err := ms.Model.findRuleSettings(ctx, matches, channel, client, version)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range settings {
+		reasons[s.ID] = ruleReason(hits[i])
+	}
+
+	defaults, err := ms.Setting.FindDefaults(ctx, productID, 0, 0, 0, nil, "", channel, client, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range defaults {
+		if _, ok := reasons[s.ID]; !ok {
+			settings = append(settings, s)
+		}
+	}
+	return ms.Setting.FilterAnonymousByPrerequisites(ctx, productID, settings)
+}
+
+// ruleReason 返回命中而尚未写入的发布规则的来源
+func ruleReason(match ruleMatch) tpl.EvaluationReason {
+	return tpl.EvaluationReason{
+		Source: tpl.EvaluationSourceRule,
+		Rule: &tpl.EvaluationRule{
+			HID:    service.IDToHID(match.ruleID, "setting_rule"),
+			Kind:   match.kind,
+			Bucket: match.bucket,
+		},
+	}
+}
+
+// reason 返回指派记录的来源，kinds 为规则 ID 到规则类型的映射，用于由发布规则写入的记录
+func (r settingSource) reason(kinds map[int64]string) tpl.EvaluationReason {
+	assignedAt := r.AssignedAt
+	reason := tpl.EvaluationReason{Source: tpl.EvaluationSourceUser, AssignedAt: &assignedAt}
+	if r.GroupUID != "" {
+		reason.Source = tpl.EvaluationSourceGroup
+		reason.Group = &tpl.EvaluationGroup{UID: r.GroupUID, Kind: r.GroupKind}
+	}
+	if kind, ok := kinds[r.RuleID]; ok {
+		reason.Rule = &tpl.EvaluationRule{
+			HID:    service.IDToHID(r.RuleID, "setting_rule"),
+			Kind:   kind,
+			Bucket: r.Bucket,
+		}
+	}
+	return reason
+}
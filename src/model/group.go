@@ -170,6 +170,7 @@ func (m *Group) FindSettings(ctx context.Context, groupID, productID, moduleID,
 		goqu.I("t2.id"),
 		goqu.I("t2.name"),
 		goqu.I("t2.description"),
+		goqu.I("t2.value_type"),
 		goqu.I("t3.name").As("module"),
 		goqu.I("t4.name").As("product")).
 		From(
@@ -298,6 +299,7 @@ func (m *Group) Delete(ctx context.Context, groupID int64) error {
 		var rowsAffected int64
 		rowsAffected, err = m.deleteByID(ctx, schema.TableGroup, groupID)
 		if rowsAffected > 0 {
+			m.tryEmitChanges(ctx, schema.ChangeKindAll, 0, nil, []int64{groupID})
 			util.Go(5*time.Second, func(gctx context.Context) {
 				m.tryIncreaseStatisticStatus(gctx, schema.GroupsTotalSize, -1)
 			})
@@ -320,6 +322,7 @@ func (m *Group) BatchAddMembers(ctx context.Context, group *schema.Group, users
 
 	rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
 	if rowsAffected > 0 {
+		m.tryEmitChanges(ctx, schema.ChangeKindAll, 0, nil, []int64{group.ID})
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshGroupStatus(gctx, group.ID)
 		})
@@ -397,6 +400,20 @@ func (m *Group) FindIDsByUser(ctx context.Context, userID int64) ([]int64, error
 	return ids, nil
 }
 
+// FindMemberIDs 返回 userIDs 中属于指定群组的用户 ID
+func (m *Group) FindMemberIDs(ctx context.Context, groupID int64, userIDs []int64) ([]int64, error) {
+	ids := make([]int64, 0)
+	if len(userIDs) == 0 {
+		return ids, nil
+	}
+	sd := m.DB.From(schema.TableUserGroup).
+		Where(goqu.C("group_id").Eq(groupID), goqu.C("user_id").In(userIDs))
+	if err := sd.PluckContext(ctx, &ids, "user_id"); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 // RemoveMembers 删除群组的成员
 func (m *Group) RemoveMembers(ctx context.Context, groupID, userID int64, syncLt int64) error {
 
@@ -411,6 +428,7 @@ func (m *Group) RemoveMembers(ctx context.Context, groupID, userID int64, syncLt
 
 	res, err := service.DeResult(sd.Executor().ExecContext(ctx))
 	if res > 0 {
+		m.tryEmitChanges(ctx, schema.ChangeKindAll, 0, nil, []int64{groupID})
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshGroupStatus(gctx, groupID)
 		})
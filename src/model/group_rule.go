@@ -0,0 +1,140 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+	"github.com/teambition/urbs-setting/src/util"
+)
+
+// groupRuleRecord 返回由 groupPercent 规则写入 group_label 或 group_setting 的记录，记录规则 ID 与分桶以便回收
+func groupRuleRecord(target string, groupID int64, rule ruleEntry, bucket int, value string) goqu.Record {
+	if target == schema.TableSetting {
+		return goqu.Record{
+			"group_id":   groupID,
+			"setting_id": rule.targetID,
+			"value":      value,
+			"rls":        rule.release,
+			"rule_id":    rule.id,
+			"bucket":     bucket,
+		}
+	}
+	// rls 沿用规则 ID，与 ruleLabelRecord 一致
+	return goqu.Record{
+		"group_id": groupID,
+		"label_id": rule.targetID,
+		"rls":      rule.id,
+		"rule_id":  rule.id,
+		"bucket":   bucket,
+	}
+}
+
+// applyGroupRule 将 groupPercent 规则应用到 id 大于 cursor 的一批 groupKind 类型群组，为命中的群组写入指派记录，
+// 群组成员通过群组继承。已被指派的群组不会被覆盖；groupIDs 非空时只处理其中的群组。
+// 群组按 uid 分桶，不参与实验层。
+func (m *Model) applyGroupRule(ctx context.Context, target string, rule ruleEntry, value string, groupIDs []int64, cursor int64, batchSize int) (*BackfillBatch, error) {
+	batch := &BackfillBatch{Cursor: cursor}
+	rv := schema.ToPercentRule(rule.kind, rule.rule).Rule
+	if rule.kind != schema.RuleGroupPercent || rv.Value <= 0 {
+		return batch, nil
+	}
+
+	exps := []exp.Expression{goqu.C("kind").Eq(rv.GroupKind), goqu.C("id").Gt(cursor)}
+	if len(groupIDs) > 0 {
+		exps = append(exps, goqu.C("id").In(groupIDs))
+	}
+	groups := make([]schema.Group, 0)
+	sd := m.DB.From(schema.TableGroup).Select(goqu.C("id"), goqu.C("uid")).
+		Where(exps...).
+		Order(goqu.C("id").Asc()).Limit(uint(batchSize))
+	if err := sd.Executor().ScanStructsContext(ctx, &groups); err != nil {
+		return nil, err
+	}
+	batch.Processed = len(groups)
+	if len(groups) == 0 {
+		return batch, nil
+	}
+	batch.Cursor = groups[len(groups)-1].ID
+
+	_, groupTable, _ := assignTables(target)
+	rows := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		bucket := schema.RuleBucket(rule.salt, rule.createdAt, g.ID, g.UID)
+		if schema.RuleHit(rule.salt, bucket, rv.Value) {
+			rows = append(rows, groupRuleRecord(target, g.ID, rule, bucket, value))
+		}
+	}
+	if len(rows) == 0 {
+		return batch, nil
+	}
+
+	rowsAffected, err := service.DeResult(m.DB.Insert(groupTable).Rows(rows...).
+		OnConflict(goqu.DoNothing()).Executor().ExecContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	batch.Applied = int(rowsAffected)
+	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, targetChangeKind(target), rule.targetID, nil, nil)
+		m.tryRefreshTargetStatus(target, rule.targetID)
+	}
+	return batch, nil
+}
+
+// tryRefreshTargetStatus 异步重新统计环境标签或配置项的 Status，群组指派按群组成员数计入
+func (m *Model) tryRefreshTargetStatus(target string, targetID int64) {
+	util.Go(10*time.Second, func(gctx context.Context) {
+		if target == schema.TableSetting {
+			m.tryRefreshSettingStatus(gctx, targetID)
+		} else {
+			m.tryRefreshLabelStatus(gctx, targetID)
+		}
+	})
+}
+
+// ApplyRules 将 kind 类型群组的 groupPercent 发布规则应用到指定的群组，用于新添加的群组
+func (m *Group) ApplyRules(ctx context.Context, kind string, uids []string) error {
+	groupIDs := make([]int64, 0, len(uids))
+	sd := m.DB.From(schema.TableGroup).Select(goqu.C("id")).
+		Where(goqu.C("kind").Eq(kind), goqu.C("uid").In(uids))
+	if err := sd.Executor().ScanValsContext(ctx, &groupIDs); err != nil {
+		return err
+	}
+	if len(groupIDs) == 0 {
+		return nil
+	}
+
+	// groupKind 在规则 JSON 中，取出全部 groupPercent 规则后解码过滤
+	exps := []exp.Expression{goqu.C("kind").Eq(schema.RuleGroupPercent)}
+
+	labelRules := make([]schema.LabelRule, 0)
+	if err := m.DB.From(schema.TableLabelRule).Where(exps...).Executor().ScanStructsContext(ctx, &labelRules); err != nil {
+		return err
+	}
+	for _, rule := range labelRuleEntries(labelRules) {
+		if schema.ToPercentRule(rule.kind, rule.rule).Rule.GroupKind != kind {
+			continue
+		}
+		if _, err := m.applyGroupRule(ctx, schema.TableLabel, rule, "", groupIDs, 0, len(groupIDs)); err != nil {
+			return err
+		}
+	}
+
+	settingRules := make([]schema.SettingRule, 0)
+	if err := m.DB.From(schema.TableSettingRule).Where(exps...).Executor().ScanStructsContext(ctx, &settingRules); err != nil {
+		return err
+	}
+	for i, rule := range settingRuleEntries(settingRules) {
+		if schema.ToPercentRule(rule.kind, rule.rule).Rule.GroupKind != kind {
+			continue
+		}
+		if _, err := m.applyGroupRule(ctx, schema.TableSetting, rule, settingRules[i].Value, groupIDs, 0, len(groupIDs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -14,3 +14,8 @@ type Healthz struct {
 func (m *Healthz) DBStats(ctx context.Context) sql.DBStats {
 	return m.SQL.DBStats()
 }
+
+// EntityCacheStats 返回名称解析缓存的统计数据
+func (m *Healthz) EntityCacheStats(ctx context.Context) CacheStats {
+	return entityCache.stats()
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
@@ -30,9 +31,29 @@ func (m *Label) FindByName(ctx context.Context, productID int64, name, selectStr
 	return label, nil
 }
 
+// findCached 优先从名称解析缓存读取 label，未命中时从主库读取，仅缓存未下线的 label
+func (m *Label) findCached(ctx context.Context, productID int64, name string) (*schema.Label, error) {
+	m.syncEntityCache(ctx)
+	key := fmt.Sprintf("label:%d:%s", productID, name)
+	if val, ok := entityCache.get(key); ok {
+		label := val.(schema.Label)
+		return &label, nil
+	}
+
+	gen := entityCache.generation()
+	label, err := m.FindByName(primaryCtx(ctx), productID, name, "")
+	if err != nil || label == nil {
+		return nil, err
+	}
+	if label.OfflineAt == nil {
+		entityCache.set(gen, key, schema.TableLabel, label.ID, *label)
+	}
+	return label, nil
+}
+
 // Acquire ...
 func (m *Label) Acquire(ctx context.Context, productID int64, labelName string) (*schema.Label, error) {
-	label, err := m.FindByName(ctx, productID, labelName, "")
+	label, err := m.findCached(ctx, productID, labelName)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +68,7 @@ func (m *Label) Acquire(ctx context.Context, productID int64, labelName string)
 
 // AcquireID ...
 func (m *Label) AcquireID(ctx context.Context, productID int64, labelName string) (int64, error) {
-	label, err := m.FindByName(ctx, productID, labelName, "id, offline_at")
+	label, err := m.findCached(ctx, productID, labelName)
 	if err != nil {
 		return 0, err
 	}
@@ -126,15 +147,20 @@ func (m *Label) Update(ctx context.Context, labelID int64, changed map[string]in
 	if _, err := m.updateByID(ctx, schema.TableLabel, labelID, goqu.Record(changed)); err != nil {
 		return nil, err
 	}
+	m.tryBumpEntityCacheVersion(ctx)
 	if err := m.findOneByID(ctx, schema.TableLabel, labelID, label); err != nil {
 		return nil, err
 	}
+	m.tryEmitChanges(ctx, schema.ChangeKindLabel, label.ProductID, nil, nil)
 	return label, nil
 }
 
 // Offline 标记 label 下线，同时真删除用户和群组的 labels
 func (m *Label) Offline(ctx context.Context, labelID int64) error {
-	return m.offlineLabels(ctx, goqu.Ex{"id": labelID, "offline_at": nil})
+	err := m.offlineLabels(ctx, goqu.Ex{"id": labelID, "offline_at": nil})
+	m.tryBumpEntityCacheVersion(ctx)
+	m.tryEmitTargetChanges(ctx, schema.ChangeKindLabel, labelID, nil, nil)
+	return err
 }
 
 // Assign 把标签批量分配给用户或群组，如果用户或群组不存在则忽略
@@ -152,7 +178,8 @@ func (m *Label) Assign(ctx context.Context, labelID int64, users []string, group
 			FromQuery(goqu.From(goqu.T(schema.TableUser).As("t1")).
 				Select(goqu.I("t1.id"), goqu.V(labelID), goqu.V(release)).
 				Where(goqu.I("t1.uid").In(tpl.StrSliceToInterface(users)...))).
-			OnConflict(goqu.DoUpdate("rls", goqu.C("rls").Set(goqu.V(release))))
+			// 直接指派覆盖发布规则写入的记录，之后不再随规则回收
+			OnConflict(goqu.DoUpdate("", goqu.Record{"rls": release, "rule_id": 0}))
 
 		rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
 		if err != nil {
@@ -188,7 +215,8 @@ func (m *Label) Assign(ctx context.Context, labelID int64, users []string, group
 				FromQuery(goqu.From(goqu.T(schema.TableGroup).As("t1")).
 					Select(goqu.I("t1.id"), goqu.V(labelID), goqu.V(release)).
 					Where(goqu.I("t1.uid").In(tpl.StrSliceToInterface(v)...), goqu.I("t1.kind").Eq(k))).
-				OnConflict(goqu.DoUpdate("rls", goqu.C("rls").Set(goqu.V(release))))
+				// 直接指派覆盖 groupPercent 规则写入的记录，之后不再随规则回收
+				OnConflict(goqu.DoUpdate("", goqu.Record{"rls": release, "rule_id": 0}))
 
 			rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
 			if err != nil {
@@ -216,6 +244,7 @@ func (m *Label) Assign(ctx context.Context, labelID int64, users []string, group
 	}
 
 	if totalRowsAffected > 0 {
+		m.tryEmitAssignChanges(ctx, schema.ChangeKindLabel, labelID, users, groups)
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshLabelStatus(gctx, labelID)
 		})
@@ -225,7 +254,12 @@ func (m *Label) Assign(ctx context.Context, labelID int64, users []string, group
 
 // Delete 对标签进行物理删除
 func (m *Label) Delete(ctx context.Context, id int64) error {
-	_, err := m.deleteByID(ctx, schema.TableLabel, id)
+	productID, _ := m.findTargetProductID(ctx, schema.ChangeKindLabel, id)
+	rowsAffected, err := m.deleteByID(ctx, schema.TableLabel, id)
+	m.tryBumpEntityCacheVersion(ctx)
+	if rowsAffected > 0 {
+		m.tryEmitChanges(ctx, schema.ChangeKindLabel, productID, nil, nil)
+	}
 	return err
 }
 
@@ -244,6 +278,7 @@ func (m *Label) Cleanup(ctx context.Context, id int64) error {
 		return err
 	}
 	_, err = m.updateByID(ctx, schema.TableLabel, id, goqu.Record{"status": 0})
+	m.tryEmitTargetChanges(ctx, schema.ChangeKindLabel, id, nil, nil)
 	return err
 }
 
@@ -251,6 +286,7 @@ func (m *Label) Cleanup(ctx context.Context, id int64) error {
 func (m *Label) RemoveUserLabel(ctx context.Context, userID, labelID int64) (int64, error) {
 	rowsAffected, err := m.deleteByCols(ctx, schema.TableUserLabel, goqu.Ex{"user_id": userID, "label_id": labelID})
 	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindLabel, labelID, []int64{userID}, nil)
 		util.Go(5*time.Second, func(gctx context.Context) {
 			m.tryIncreaseLabelsStatus(gctx, []int64{labelID}, -1)
 		})
@@ -262,6 +298,7 @@ func (m *Label) RemoveUserLabel(ctx context.Context, userID, labelID int64) (int
 func (m *Label) RemoveGroupLabel(ctx context.Context, groupID, labelID int64) (int64, error) {
 	rowsAffected, err := m.deleteByCols(ctx, schema.TableGroupLabel, goqu.Ex{"group_id": groupID, "label_id": labelID})
 	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindLabel, labelID, nil, []int64{groupID})
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshLabelStatus(gctx, labelID)
 		})
@@ -284,6 +321,7 @@ func (m *Label) Recall(ctx context.Context, labelID, release int64) error {
 	}
 	totalRowsAffected += rowsAffected
 	if totalRowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindLabel, labelID, nil, nil)
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshLabelStatus(gctx, labelID)
 		})
@@ -2,7 +2,6 @@ package model
 
 import (
 	"context"
-	"hash/crc32"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
@@ -19,14 +18,12 @@ type LabelRule struct {
 }
 
 // ApplyRules ...
-func (m *LabelRule) ApplyRules(ctx context.Context, productID int64, userID int64, excludeLabels []int64, kind string) (int, error) {
-	rules := []schema.LabelRule{}
-	exps := []exp.Expression{goqu.C("kind").Eq(kind)}
+func (m *LabelRule) ApplyRules(ctx context.Context, productID int64, userID int64, excludeLabels []int64, kinds ...string) (int, error) {
+	exps := []exp.Expression{goqu.C("kind").In(kinds)}
 	if productID > 0 {
 		exps = append(exps, goqu.C("product_id").Eq(productID))
 	}
-	sd := m.RdDB.From(schema.TableLabelRule).Where(exps...).Order(goqu.C("updated_at").Desc()).Limit(200)
-	err := sd.Executor().ScanStructsContext(ctx, &rules)
+	rules, err := m.findRules(ctx, exps...)
 	if err != nil {
 		return 0, err
 	}
@@ -40,14 +37,10 @@ func (m *LabelRule) ApplyRules(ctx context.Context, productID int64, userID int6
 
 // ApplyRule ...
 func (m *LabelRule) ApplyRule(ctx context.Context, productID int64, userID int64, labelID int64, kind string) (int, error) {
-	rules := []schema.LabelRule{}
-	exps := []exp.Expression{
+	rules, err := m.findRules(ctx,
 		goqu.C("kind").Eq(kind),
 		goqu.C("label_id").Eq(labelID),
-		goqu.C("product_id").Eq(productID),
-	}
-	sd := m.RdDB.From(schema.TableLabelRule).Where(exps...).Order(goqu.C("updated_at").Desc()).Limit(200)
-	err := sd.Executor().ScanStructsContext(ctx, &rules)
+		goqu.C("product_id").Eq(productID))
 	if err != nil {
 		return 0, err
 	}
@@ -60,28 +53,26 @@ func (m *LabelRule) ApplyRule(ctx context.Context, productID int64, userID int64
 
 // ComputeUserRule ...
 func (m *LabelRule) ComputeUserRule(ctx context.Context, userID int64, excludeLabels []int64, rules []schema.LabelRule) (int, error) {
-	ids := make([]interface{}, 0)
-	labelIDs := make([]int64, 0)
-	for _, rule := range rules {
-		if tpl.Int64SliceHas(excludeLabels, rule.LabelID) {
-			continue
+	entries := make([]ruleEntry, 0, len(rules))
+	for _, entry := range labelRuleEntries(rules) {
+		if !tpl.Int64SliceHas(excludeLabels, entry.targetID) {
+			entries = append(entries, entry)
 		}
+	}
+	matches, err := m.matchRules(ctx, schema.TableLabel, newUserSubject(userID), entries, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
 
-		p := rule.ToPercent()
-
-		if p > 0 && (int((userID+rule.CreatedAt.Unix())%100) <= p) {
-			// 百分比规则无效或者用户不在百分比区间内
-			ids = append(ids, rule.ID)
-			labelIDs = append(labelIDs, rule.LabelID)
-		}
+	rows := make([]interface{}, 0, len(matches))
+	labelIDs := make([]int64, 0, len(matches))
+	for _, match := range matches {
+		rows = append(rows, ruleLabelRecord(userID, match))
+		labelIDs = append(labelIDs, match.targetID)
 	}
 
-	if len(ids) > 0 {
-		sd := m.DB.Insert(schema.TableUserLabel).Cols("user_id", "label_id", "rls").
-			FromQuery(goqu.From(goqu.T(schema.TableLabelRule).As("t1")).
-				Select(goqu.V(userID), goqu.I("t1.label_id"), goqu.I("t1.id")).
-				Where(goqu.I("t1.id").In(ids...))).
-			OnConflict(goqu.DoNothing())
+	if len(rows) > 0 {
+		sd := m.DB.Insert(schema.TableUserLabel).Rows(rows...).OnConflict(goqu.DoNothing())
 		rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
 		if err != nil {
 			return 0, err
@@ -93,75 +84,270 @@ func (m *LabelRule) ComputeUserRule(ctx context.Context, userID int64, excludeLa
 			})
 		}
 	}
-	return len(ids), nil
+	return len(rows), nil
 }
 
 // ApplyRulesToAnonymous ...
-func (m *LabelRule) ApplyRulesToAnonymous(ctx context.Context, anonymousID string, productID int64, kind string) ([]schema.UserCacheLabel, error) {
-	rules := []schema.LabelRule{}
-	sd := m.RdDB.From(schema.TableLabelRule).
-		Where(
-			goqu.C("kind").Eq(kind),
-			goqu.C("product_id").Eq(productID)).
-		Order(goqu.C("updated_at").Desc()).Limit(200)
-	err := sd.Executor().ScanStructsContext(ctx, &rules)
+func (m *LabelRule) ApplyRulesToAnonymous(ctx context.Context, anonymousID string, productID int64, kinds ...string) ([]schema.UserCacheLabel, error) {
+	rules, err := m.findRules(ctx, goqu.C("kind").In(kinds), goqu.C("product_id").Eq(productID))
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := m.matchRules(ctx, schema.TableLabel, newAnonymousSubject(anonymousID), labelRuleEntries(rules), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	labelIDs := make([]int64, 0, len(matches))
+	for _, match := range matches {
+		labelIDs = append(labelIDs, match.targetID)
+	}
+
+	mp, err := m.findCacheLabels(ctx, labelIDs)
 	if err != nil {
 		return nil, err
 	}
+	data := make([]schema.UserCacheLabel, 0)
+	for _, id := range labelIDs {
+		if label, ok := mp[id]; ok {
+			data = append(data, label)
+		}
+	}
+	return data, nil
+}
 
-	anonID := int64(crc32.ChecksumIEEE([]byte(anonymousID)))
+// ApplyRulesToAnonymousUsers 批量计算匿名用户命中的环境标签，规则只读取一次，不写入任何数据
+func (m *LabelRule) ApplyRulesToAnonymousUsers(ctx context.Context, anonymousIDs []string, productID int64, kinds ...string) (map[string][]schema.UserCacheLabel, error) {
+	res := make(map[string][]schema.UserCacheLabel, len(anonymousIDs))
+	if len(anonymousIDs) == 0 {
+		return res, nil
+	}
+	rules, err := m.findRules(ctx, goqu.C("kind").In(kinds), goqu.C("product_id").Eq(productID))
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make([]*ruleSubject, len(anonymousIDs))
+	for i, anonymousID := range anonymousIDs {
+		subjects[i] = newAnonymousSubject(anonymousID)
+	}
+	matches, err := m.matchSubjectsRules(ctx, schema.TableLabel, subjects, labelRuleEntries(rules), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
 	labelIDs := make([]int64, 0)
-	for _, rule := range rules {
-		p := rule.ToPercent()
-		if p > 0 && (int((anonID+rule.CreatedAt.Unix())%100) <= p) {
-			// 百分比规则无效或者用户不在百分比区间内
-			labelIDs = append(labelIDs, rule.LabelID)
+	for _, ms := range matches {
+		for _, match := range ms {
+			labelIDs = append(labelIDs, match.targetID)
 		}
 	}
 
-	data := make([]schema.UserCacheLabel, 0)
-	if len(labelIDs) > 0 {
-		sd := m.RdDB.Select(
-			goqu.I("t1.id"),
-			goqu.I("t1.name"),
-			goqu.I("t1.channels"),
-			goqu.I("t1.clients")).
-			From(goqu.T(schema.TableLabel).As("t1")).
-			Where(goqu.I("t1.id").In(labelIDs))
-
-		scanner, err := sd.Executor().ScannerContext(ctx)
+	mp, err := m.findCacheLabels(ctx, labelIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i, anonymousID := range anonymousIDs {
+		data := make([]schema.UserCacheLabel, 0)
+		for _, match := range matches[i] {
+			if label, ok := mp[match.targetID]; ok {
+				data = append(data, label)
+			}
+		}
+		res[anonymousID] = data
+	}
+	return res, nil
+}
+
+// ApplyRulesToUsers 批量计算用户命中的环境标签发布规则并写入，规则只读取一次。
+// exclude 为各用户已有的环境标签，返回新指派了环境标签的用户 ID。users 须包含 id、uid 和 created_at
+func (m *LabelRule) ApplyRulesToUsers(ctx context.Context, productID int64, users []schema.User, exclude map[int64][]int64, kinds ...string) ([]int64, error) {
+	rules, err := m.findRules(ctx, goqu.C("kind").In(kinds), goqu.C("product_id").Eq(productID))
+	if err != nil {
+		return nil, err
+	}
+	return m.computeUsersRules(ctx, users, exclude, rules)
+}
+
+// ApplyRuleToUsers 批量计算用户命中指定环境标签的发布规则并写入，返回新指派了环境标签的用户 ID
+func (m *LabelRule) ApplyRuleToUsers(ctx context.Context, productID int64, users []schema.User, labelID int64, kind string) ([]int64, error) {
+	rules, err := m.findRules(ctx,
+		goqu.C("kind").Eq(kind),
+		goqu.C("label_id").Eq(labelID),
+		goqu.C("product_id").Eq(productID))
+	if err != nil {
+		return nil, err
+	}
+	return m.computeUsersRules(ctx, users, nil, rules)
+}
+
+func (m *LabelRule) computeUsersRules(ctx context.Context, users []schema.User, exclude map[int64][]int64, rules []schema.LabelRule) ([]int64, error) {
+	userIDs := make([]int64, 0)
+	if len(users) == 0 || len(rules) == 0 {
+		return userIDs, nil
+	}
+
+	entries := labelRuleEntries(rules)
+	subjects := make([]*ruleSubject, len(users))
+	for i, user := range users {
+		subjects[i] = newUserSubject(user.ID)
+	}
+	if rulesNeedAttrs(entries) {
+		attrs, err := m.findUsersAttributes(ctx, users)
 		if err != nil {
 			return nil, err
 		}
+		for i, user := range users {
+			subjects[i].attrs = attrs[user.ID]
+		}
+	}
+	matches, err := m.matchSubjectsRules(ctx, schema.TableLabel, subjects, entries, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
 
-		mp := make(map[int64]schema.UserCacheLabel)
-		for scanner.Next() {
-			myLabelInfo := schema.MyLabelInfo{}
-			if err := scanner.ScanStruct(&myLabelInfo); err != nil {
-				scanner.Close()
-				return nil, err
-			}
+	existing, err := m.findUsersLabelPairs(ctx, matches, users)
+	if err != nil {
+		return nil, err
+	}
 
-			mp[myLabelInfo.ID] = schema.UserCacheLabel{
-				Label:    myLabelInfo.Name,
-				Clients:  tpl.StringToSlice(myLabelInfo.Clients),
-				Channels: tpl.StringToSlice(myLabelInfo.Channels),
+	// 已有的记录不会被写入，不计入命中数，这些用户也不需要重新刷新
+	rows := make([]interface{}, 0)
+	counts := make(map[int64]int)
+	for i, user := range users {
+		hit := false
+		for _, match := range matches[i] {
+			if tpl.Int64SliceHas(exclude[user.ID], match.targetID) || existing[user.ID][match.targetID] {
+				continue
 			}
+			rows = append(rows, ruleLabelRecord(user.ID, match))
+			counts[match.targetID]++
+			hit = true
 		}
+		if hit {
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+	if len(rows) == 0 {
+		return userIDs, nil
+	}
 
-		scanner.Close()
-		if err := scanner.Err(); err != nil {
-			return nil, err
+	sd := m.DB.Insert(schema.TableUserLabel).Rows(rows...).OnConflict(goqu.DoNothing())
+	rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected > 0 {
+		if int(rowsAffected) < len(rows) {
+			// 并发请求已写入了部分记录，无法区分各环境标签实际写入的数量，只有一个环境标签时按写入数计数
+			if len(counts) > 1 {
+				return userIDs, nil
+			}
+			for labelID := range counts {
+				counts[labelID] = int(rowsAffected)
+			}
 		}
+		util.Go(5*time.Second, func(gctx context.Context) {
+			for labelID, count := range counts {
+				m.tryIncreaseLabelsStatus(gctx, []int64{labelID}, count)
+			}
+		})
+	}
+	return userIDs, nil
+}
 
-		for _, id := range labelIDs {
-			if label, ok := mp[id]; ok {
-				data = append(data, label)
+// findUsersLabelPairs 从主库读取用户已有的、命中规则的环境标签，返回 user_id -> label_id 集合
+func (m *LabelRule) findUsersLabelPairs(ctx context.Context, matches [][]ruleMatch, users []schema.User) (map[int64]map[int64]bool, error) {
+	res := make(map[int64]map[int64]bool)
+	userIDs := make([]int64, 0)
+	labelIDs := make([]int64, 0)
+	for i, user := range users {
+		if len(matches[i]) == 0 {
+			continue
+		}
+		userIDs = append(userIDs, user.ID)
+		for _, match := range matches[i] {
+			if !tpl.Int64SliceHas(labelIDs, match.targetID) {
+				labelIDs = append(labelIDs, match.targetID)
 			}
 		}
 	}
+	if len(userIDs) == 0 {
+		return res, nil
+	}
 
-	return data, nil
+	pairs := []schema.UserLabel{}
+	sd := m.DB.Select(goqu.C("user_id"), goqu.C("label_id")).
+		From(schema.TableUserLabel).
+		Where(goqu.C("user_id").In(userIDs), goqu.C("label_id").In(labelIDs))
+	if err := sd.Executor().ScanStructsContext(ctx, &pairs); err != nil {
+		return nil, err
+	}
+	for _, p := range pairs {
+		if res[p.UserID] == nil {
+			res[p.UserID] = make(map[int64]bool)
+		}
+		res[p.UserID][p.LabelID] = true
+	}
+	return res, nil
+}
+
+// toCacheLabel 转换为缓存在 user 上的轻量级环境标签
+func toCacheLabel(info schema.MyLabelInfo) schema.UserCacheLabel {
+	return schema.UserCacheLabel{
+		Label:      info.Name,
+		Clients:    tpl.StringToSlice(info.Clients),
+		Channels:   tpl.StringToSlice(info.Channels),
+		MinVersion: info.MinVersion,
+		MaxVersion: info.MaxVersion,
+	}
+}
+
+// findCacheLabels 读取指定环境标签的轻量级数据
+func (m *LabelRule) findCacheLabels(ctx context.Context, labelIDs []int64) (map[int64]schema.UserCacheLabel, error) {
+	mp := make(map[int64]schema.UserCacheLabel)
+	if len(labelIDs) == 0 {
+		return mp, nil
+	}
+
+	sd := m.RdDB.Select(
+		goqu.I("t1.id"),
+		goqu.I("t1.name"),
+		goqu.I("t1.channels"),
+		goqu.I("t1.clients"),
+		goqu.I("t1.min_version"),
+		goqu.I("t1.max_version")).
+		From(goqu.T(schema.TableLabel).As("t1")).
+		Where(goqu.I("t1.id").In(labelIDs))
+
+	scanner, err := sd.Executor().ScannerContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for scanner.Next() {
+		myLabelInfo := schema.MyLabelInfo{}
+		if err := scanner.ScanStruct(&myLabelInfo); err != nil {
+			scanner.Close()
+			return nil, err
+		}
+		mp[myLabelInfo.ID] = toCacheLabel(myLabelInfo)
+	}
+
+	scanner.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mp, nil
+}
+
+// findRules 从只读库中读取符合条件的规则，按更新时间倒序
+func (m *LabelRule) findRules(ctx context.Context, exps ...exp.Expression) ([]schema.LabelRule, error) {
+	rules := []schema.LabelRule{}
+	sd := m.RdDB.From(schema.TableLabelRule).Where(exps...).Order(goqu.C("updated_at").Desc()).Limit(200)
+	if err := sd.Executor().ScanStructsContext(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
 }
 
 // Acquire ...
@@ -173,6 +359,11 @@ func (m *LabelRule) Acquire(ctx context.Context, labelRuleID int64) (*schema.Lab
 	return labelRule, nil
 }
 
+// FindByLabel 返回环境标签的全部发布规则
+func (m *LabelRule) FindByLabel(ctx context.Context, labelID int64) ([]schema.LabelRule, error) {
+	return m.findRules(ctx, goqu.C("label_id").Eq(labelID))
+}
+
 // Find ...
 func (m *LabelRule) Find(ctx context.Context, productID, labelID int64) ([]schema.LabelRule, error) {
 	labelRules := make([]schema.LabelRule, 0)
@@ -189,7 +380,10 @@ func (m *LabelRule) Find(ctx context.Context, productID, labelID int64) ([]schem
 
 // Create ...
 func (m *LabelRule) Create(ctx context.Context, labelRule *schema.LabelRule) error {
-	_, err := m.createOne(ctx, schema.TableLabelRule, labelRule)
+	rowsAffected, err := m.createOne(ctx, schema.TableLabelRule, labelRule)
+	if rowsAffected > 0 {
+		m.tryEmitChanges(ctx, schema.ChangeKindLabel, labelRule.ProductID, nil, nil)
+	}
 	return err
 }
 
@@ -202,10 +396,17 @@ func (m *LabelRule) Update(ctx context.Context, labelRuleID int64, changed map[s
 	if err := m.findOneByID(ctx, schema.TableLabelRule, labelRuleID, labelRule); err != nil {
 		return nil, err
 	}
+	m.tryEmitChanges(ctx, schema.ChangeKindLabel, labelRule.ProductID, nil, nil)
 	return labelRule, nil
 }
 
 // Delete ...
 func (m *LabelRule) Delete(ctx context.Context, id int64) (int64, error) {
-	return m.deleteByID(ctx, schema.TableLabelRule, id)
+	labelRule := &schema.LabelRule{}
+	findErr := m.findOneByID(ctx, schema.TableLabelRule, id, labelRule)
+	rowsAffected, err := m.deleteByID(ctx, schema.TableLabelRule, id)
+	if rowsAffected > 0 && findErr == nil {
+		m.tryEmitChanges(ctx, schema.ChangeKindLabel, labelRule.ProductID, nil, nil)
+	}
+	return rowsAffected, err
 }
@@ -0,0 +1,290 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// Layer ...
+type Layer struct {
+	*Model
+}
+
+// FindByName 根据 productID 和 name 返回 layer 数据
+func (m *Layer) FindByName(ctx context.Context, productID int64, name string) (*schema.Layer, error) {
+	layer := &schema.Layer{}
+	ok, err := m.findOneByCols(ctx, schema.TableLayer, goqu.Ex{"product_id": productID, "name": name}, "", layer)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	return layer, nil
+}
+
+// Acquire ...
+func (m *Layer) Acquire(ctx context.Context, productID int64, layerName string) (*schema.Layer, error) {
+	layer, err := m.FindByName(ctx, productID, layerName)
+	if err != nil {
+		return nil, err
+	}
+	if layer == nil {
+		return nil, gear.ErrNotFound.WithMsgf("layer %s not found", layerName)
+	}
+	return layer, nil
+}
+
+// Find 根据条件查找 layers
+func (m *Layer) Find(ctx context.Context, productID int64, pg tpl.Pagination) ([]schema.Layer, int, error) {
+	layers := make([]schema.Layer, 0)
+	cursor := pg.TokenToID()
+	sdc := m.RdDB.Select().
+		From(goqu.T(schema.TableLayer)).
+		Where(goqu.C("product_id").Eq(productID))
+
+	sd := m.RdDB.Select().
+		From(goqu.T(schema.TableLayer)).
+		Where(
+			goqu.C("id").Lte(cursor),
+			goqu.C("product_id").Eq(productID))
+
+	if pg.Q != "" {
+		sdc = sdc.Where(goqu.C("name").ILike(pg.Q))
+		sd = sd.Where(goqu.C("name").ILike(pg.Q))
+	}
+
+	sd = sd.Order(goqu.C("id").Desc()).Limit(uint(pg.PageSize + 1))
+
+	total, err := sdc.CountContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err = sd.Executor().ScanStructsContext(ctx, &layers); err != nil {
+		return nil, 0, err
+	}
+
+	return layers, int(total), nil
+}
+
+// Create ...
+func (m *Layer) Create(ctx context.Context, layer *schema.Layer) error {
+	_, err := m.createOne(ctx, schema.TableLayer, layer)
+	return err
+}
+
+// Update 更新指定实验层
+func (m *Layer) Update(ctx context.Context, layerID int64, changed map[string]interface{}) (*schema.Layer, error) {
+	layer := &schema.Layer{}
+	if _, err := m.updateByID(ctx, schema.TableLayer, layerID, goqu.Record(changed)); err != nil {
+		return nil, err
+	}
+	if err := m.findOneByID(ctx, schema.TableLayer, layerID, layer); err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+// Delete 删除实验层及其全部实验，实验对象恢复为独立分桶
+func (m *Layer) Delete(ctx context.Context, layerID int64) (int64, error) {
+	if _, err := m.deleteByCols(ctx, schema.TableLayerExperiment, goqu.Ex{"layer_id": layerID}); err != nil {
+		return 0, err
+	}
+	return m.deleteByID(ctx, schema.TableLayer, layerID)
+}
+
+// FindExperiments 返回实验层的全部实验，按分桶区间排序
+func (m *Layer) FindExperiments(ctx context.Context, layerID int64) ([]schema.LayerExperiment, error) {
+	experiments := make([]schema.LayerExperiment, 0)
+	sd := m.DB.From(schema.TableLayerExperiment).
+		Where(goqu.C("layer_id").Eq(layerID)).
+		Order(goqu.C("bucket_start").Asc()).Limit(1000)
+	if err := sd.Executor().ScanStructsContext(ctx, &experiments); err != nil {
+		return nil, err
+	}
+	return experiments, nil
+}
+
+// FindExperimentInfos 返回实验层的全部实验及实验对象的名称，按分桶区间排序
+func (m *Layer) FindExperimentInfos(ctx context.Context, layerID int64) ([]tpl.LayerExperimentInfo, error) {
+	data := make([]tpl.LayerExperimentInfo, 0)
+	sd := m.RdDB.Select(
+		goqu.I("t1.id"),
+		goqu.I("t1.created_at"),
+		goqu.I("t1.bucket_start"),
+		goqu.I("t1.bucket_end"),
+		goqu.I("t2.name").As("label")).
+		From(
+			goqu.T(schema.TableLayerExperiment).As("t1"),
+			goqu.T(schema.TableLabel).As("t2")).
+		Where(
+			goqu.I("t1.layer_id").Eq(layerID),
+			goqu.I("t1.target").Eq(schema.TableLabel),
+			goqu.I("t1.target_id").Eq(goqu.I("t2.id"))).
+		Limit(1000)
+	labels := make([]tpl.LayerExperimentInfo, 0)
+	if err := sd.Executor().ScanStructsContext(ctx, &labels); err != nil {
+		return nil, err
+	}
+
+	sd = m.RdDB.Select(
+		goqu.I("t1.id"),
+		goqu.I("t1.created_at"),
+		goqu.I("t1.bucket_start"),
+		goqu.I("t1.bucket_end"),
+		goqu.I("t2.name").As("setting"),
+		goqu.I("t3.name").As("module")).
+		From(
+			goqu.T(schema.TableLayerExperiment).As("t1"),
+			goqu.T(schema.TableSetting).As("t2"),
+			goqu.T(schema.TableModule).As("t3")).
+		Where(
+			goqu.I("t1.layer_id").Eq(layerID),
+			goqu.I("t1.target").Eq(schema.TableSetting),
+			goqu.I("t1.target_id").Eq(goqu.I("t2.id")),
+			goqu.I("t2.module_id").Eq(goqu.I("t3.id"))).
+		Limit(1000)
+	settings := make([]tpl.LayerExperimentInfo, 0)
+	if err := sd.Executor().ScanStructsContext(ctx, &settings); err != nil {
+		return nil, err
+	}
+
+	data = append(data, labels...)
+	data = append(data, settings...)
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].BucketStart < data[j].BucketStart
+	})
+	for i := range data {
+		data[i].Fill()
+	}
+	return data, nil
+}
+
+// AcquireExperiment ...
+func (m *Layer) AcquireExperiment(ctx context.Context, experimentID int64) (*schema.LayerExperiment, error) {
+	experiment := &schema.LayerExperiment{}
+	if err := m.findOneByID(ctx, schema.TableLayerExperiment, experimentID, experiment); err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+// AddExperiment 将环境标签或配置项加入实验层，并为其分配 size 个连续的空闲分桶
+func (m *Layer) AddExperiment(ctx context.Context, layerID int64, target string, targetID int64, size int) (*schema.LayerExperiment, error) {
+	experiment := &schema.LayerExperiment{}
+	ok, err := m.findOneByCols(ctx, schema.TableLayerExperiment, goqu.Ex{"target": target, "target_id": targetID}, "", experiment)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, gear.ErrConflict.WithMsgf("%s %d already in layer %d", target, targetID, experiment.LayerID)
+	}
+
+	key := fmt.Sprintf("Layer:%d", layerID)
+	if err := m.lock(ctx, key, time.Minute); err != nil {
+		return nil, gear.ErrConflict.From(err)
+	}
+	defer m.unlock(ctx, key)
+
+	experiments, err := m.FindExperiments(ctx, layerID)
+	if err != nil {
+		return nil, err
+	}
+
+	start := -1
+	end := 0 // 上一个实验的分桶区间终点
+	for _, e := range experiments {
+		if e.BucketStart-end >= size {
+			start = end
+			break
+		}
+		end = e.BucketEnd
+	}
+	if start < 0 && schema.RuleBuckets-end >= size {
+		start = end
+	}
+	if start < 0 {
+		return nil, gear.ErrConflict.WithMsgf("no enough free buckets in layer %d for %d buckets", layerID, size)
+	}
+
+	experiment = &schema.LayerExperiment{
+		LayerID:     layerID,
+		Target:      target,
+		TargetID:    targetID,
+		BucketStart: start,
+		BucketEnd:   start + size,
+	}
+	if _, err = m.createOne(ctx, schema.TableLayerExperiment, experiment); err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+// RemoveExperiment 将实验移出实验层，其分桶区间被释放
+func (m *Layer) RemoveExperiment(ctx context.Context, experimentID int64) (int64, error) {
+	return m.deleteByID(ctx, schema.TableLayerExperiment, experimentID)
+}
+
+// layerBuckets 加入了实验层的实验对象的分桶信息
+type layerBuckets struct {
+	experiments map[int64]schema.LayerExperiment // key 为 target_id
+	salts       map[int64]string                 // key 为 layer_id
+}
+
+// bucket 返回用户在 targetID 所属实验层中的相对分桶及实验层盐值，ok 为 false 表示实验对象未加入实验层。
+// 用户不在该实验的分桶区间内时 bucket 为 -1。
+func (lb *layerBuckets) bucket(targetID int64, key string) (bucket int, salt string, ok bool) {
+	e, ok := lb.experiments[targetID]
+	if !ok {
+		return 0, "", false
+	}
+	salt = lb.salts[e.LayerID]
+	return e.Bucket(salt, key), salt, true
+}
+
+// findLayerBuckets 返回指定实验对象所加入实验层的分桶信息
+func (m *Model) findLayerBuckets(ctx context.Context, target string, targetIDs []int64) (*layerBuckets, error) {
+	lb := &layerBuckets{
+		experiments: make(map[int64]schema.LayerExperiment),
+		salts:       make(map[int64]string),
+	}
+	if len(targetIDs) == 0 {
+		return lb, nil
+	}
+
+	experiments := make([]schema.LayerExperiment, 0)
+	sd := m.RdDB.From(schema.TableLayerExperiment).
+		Where(goqu.C("target").Eq(target), goqu.C("target_id").In(targetIDs)).
+		Limit(uint(len(targetIDs)))
+	if err := sd.Executor().ScanStructsContext(ctx, &experiments); err != nil {
+		return nil, err
+	}
+	if len(experiments) == 0 {
+		return lb, nil
+	}
+
+	layerIDs := make([]int64, 0, len(experiments))
+	for _, e := range experiments {
+		lb.experiments[e.TargetID] = e
+		layerIDs = append(layerIDs, e.LayerID)
+	}
+
+	layers := make([]schema.Layer, 0)
+	sd = m.RdDB.From(schema.TableLayer).Select(goqu.C("id"), goqu.C("salt")).
+		Where(goqu.C("id").In(layerIDs)).Limit(uint(len(layerIDs)))
+	if err := sd.Executor().ScanStructsContext(ctx, &layers); err != nil {
+		return nil, err
+	}
+	for _, l := range layers {
+		lb.salts[l.ID] = l.Salt
+	}
+	return lb, nil
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
@@ -30,9 +31,29 @@ func (m *Module) FindByName(ctx context.Context, productID int64, name, selectSt
 	return module, nil
 }
 
+// findCached 优先从名称解析缓存读取 module，未命中时从主库读取，仅缓存未下线的 module
+func (m *Module) findCached(ctx context.Context, productID int64, name string) (*schema.Module, error) {
+	m.syncEntityCache(ctx)
+	key := fmt.Sprintf("module:%d:%s", productID, name)
+	if val, ok := entityCache.get(key); ok {
+		module := val.(schema.Module)
+		return &module, nil
+	}
+
+	gen := entityCache.generation()
+	module, err := m.FindByName(primaryCtx(ctx), productID, name, "")
+	if err != nil || module == nil {
+		return nil, err
+	}
+	if module.OfflineAt == nil {
+		entityCache.set(gen, key, schema.TableModule, module.ID, *module)
+	}
+	return module, nil
+}
+
 // Acquire ...
 func (m *Module) Acquire(ctx context.Context, productID int64, moduleName string) (*schema.Module, error) {
-	module, err := m.FindByName(ctx, productID, moduleName, "")
+	module, err := m.findCached(ctx, productID, moduleName)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +68,7 @@ func (m *Module) Acquire(ctx context.Context, productID int64, moduleName string
 
 // AcquireID ...
 func (m *Module) AcquireID(ctx context.Context, productID int64, moduleName string) (int64, error) {
-	module, err := m.FindByName(ctx, productID, moduleName, "id, offline_at")
+	module, err := m.findCached(ctx, productID, moduleName)
 	if err != nil {
 		return 0, err
 	}
@@ -113,6 +134,7 @@ func (m *Module) Update(ctx context.Context, moduleID int64, changed map[string]
 	if _, err := m.updateByID(ctx, schema.TableModule, moduleID, goqu.Record(changed)); err != nil {
 		return nil, err
 	}
+	m.tryBumpEntityCacheVersion(ctx)
 	if err := m.findOneByID(ctx, schema.TableModule, moduleID, module); err != nil {
 		return nil, err
 	}
@@ -121,5 +143,11 @@ func (m *Module) Update(ctx context.Context, moduleID int64, changed map[string]
 
 // Offline 标记模块下线
 func (m *Module) Offline(ctx context.Context, moduleID int64) error {
-	return m.offlineModules(ctx, goqu.Ex{"id": moduleID, "offline_at": nil})
+	err := m.offlineModules(ctx, goqu.Ex{"id": moduleID, "offline_at": nil})
+	m.tryBumpEntityCacheVersion(ctx)
+	module := &schema.Module{}
+	if err == nil && m.findOneByID(ctx, schema.TableModule, moduleID, module) == nil {
+		m.tryEmitChanges(ctx, schema.ChangeKindSetting, module.ProductID, nil, nil)
+	}
+	return err
 }
@@ -30,9 +30,29 @@ func (m *Product) FindByName(ctx context.Context, name, selectStr string) (*sche
 	return product, nil
 }
 
+// findCached 优先从名称解析缓存读取 product，未命中时从主库读取，仅缓存未下线且未删除的 product
+func (m *Product) findCached(ctx context.Context, name string) (*schema.Product, error) {
+	m.syncEntityCache(ctx)
+	key := "product:" + name
+	if val, ok := entityCache.get(key); ok {
+		product := val.(schema.Product)
+		return &product, nil
+	}
+
+	gen := entityCache.generation()
+	product, err := m.FindByName(primaryCtx(ctx), name, "")
+	if err != nil || product == nil {
+		return nil, err
+	}
+	if product.OfflineAt == nil && product.DeletedAt == nil {
+		entityCache.set(gen, key, schema.TableProduct, product.ID, *product)
+	}
+	return product, nil
+}
+
 // Acquire ...
 func (m *Product) Acquire(ctx context.Context, productName string) (*schema.Product, error) {
-	product, err := m.FindByName(ctx, productName, "")
+	product, err := m.findCached(ctx, productName)
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +70,7 @@ func (m *Product) Acquire(ctx context.Context, productName string) (*schema.Prod
 
 // AcquireID ...
 func (m *Product) AcquireID(ctx context.Context, productName string) (int64, error) {
-	product, err := m.FindByName(ctx, productName, "id, offline_at, deleted_at")
+	product, err := m.findCached(ctx, productName)
 	if err != nil {
 		return 0, err
 	}
@@ -118,6 +138,7 @@ func (m *Product) Update(ctx context.Context, productID int64, changed map[strin
 	if _, err := m.updateByID(ctx, schema.TableProduct, productID, goqu.Record(changed)); err != nil {
 		return nil, err
 	}
+	m.tryBumpEntityCacheVersion(ctx)
 	if err := m.findOneByID(ctx, schema.TableProduct, productID, product); err != nil {
 		return nil, err
 	}
@@ -147,6 +168,8 @@ func (m *Product) Offline(ctx context.Context, productID int64) error {
 				m.tryRefreshSettingsTotalSize(gctx)
 			})
 		}
+		m.tryBumpEntityCacheVersion(ctx)
+		m.tryEmitChanges(ctx, schema.ChangeKindAll, productID, nil, nil)
 	}
 	return err
 }
@@ -155,6 +178,7 @@ func (m *Product) Offline(ctx context.Context, productID int64) error {
 func (m *Product) Delete(ctx context.Context, productID int64) error {
 	now := time.Now().UTC()
 	_, err := m.updateByID(ctx, schema.TableProduct, productID, goqu.Record{"deleted_at": &now})
+	m.tryBumpEntityCacheVersion(ctx)
 	return err
 }
 
@@ -0,0 +1,280 @@
+package model
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/urbs-setting/src/schema"
+)
+
+type ruleCtxKey string
+
+const (
+	bucketKeyCtx  ruleCtxKey = "bucketKey"
+	bucketKeysCtx ruleCtxKey = "bucketKeys"
+)
+
+// WithBucketKey 返回携带请求方分桶 key 的 context，用于 bucketBy 为 "key" 的发布规则，key 为空时返回原 context
+func WithBucketKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, bucketKeyCtx, key)
+}
+
+// WithBucketKeys 返回携带批量请求中各用户分桶 key 的 context，用于 bucketBy 为 "key" 的发布规则。
+// userKeys 为用户 ID 到分桶 key 的映射，anonymousKeys 为匿名用户 uid 到分桶 key 的映射，都为空时返回原 context
+func WithBucketKeys(ctx context.Context, userKeys map[int64]string, anonymousKeys map[string]string) context.Context {
+	if len(userKeys) == 0 && len(anonymousKeys) == 0 {
+		return ctx
+	}
+	// 与 ruleSubject.key 一致，用户为用户 ID，匿名用户为 uid
+	keys := make(map[string]string, len(userKeys)+len(anonymousKeys))
+	for id, key := range userKeys {
+		keys[strconv.FormatInt(id, 10)] = key
+	}
+	for uid, key := range anonymousKeys {
+		keys[uid] = key
+	}
+	return context.WithValue(ctx, bucketKeysCtx, keys)
+}
+
+// requestBucketKey 返回请求方为对象提供的分桶 key，批量请求中对象单独的 key 优先
+func requestBucketKey(ctx context.Context, subject *ruleSubject) string {
+	if keys, ok := ctx.Value(bucketKeysCtx).(map[string]string); ok {
+		if key := keys[subject.key]; key != "" {
+			return key
+		}
+	}
+	key, _ := ctx.Value(bucketKeyCtx).(string)
+	return key
+}
+
+// ruleSubject 规则计算的对象，为用户或匿名用户
+type ruleSubject struct {
+	userID     int64             // 用户 ID，匿名用户为 0
+	id         int64             // legacy 分桶使用的 ID
+	key        string            // hash 分桶使用的 key
+	attrs      map[string]string // userAttribute 规则匹配或按 uid、用户属性分桶时使用的用户属性，按需读取
+	requestKey string            // 请求方提供的分桶 key，可能为空
+}
+
+// bucketKey 返回对象在规则中用于分桶的 id 与 key，规则未指定 bucketBy 时使用对象默认的 id 与 key；
+// 须先读取 subject.attrs。分桶 key 缺失时返回 false，对象不命中该规则。
+func (s *ruleSubject) bucketKey(rv schema.RuleValue) (int64, string, bool) {
+	var key string
+	switch {
+	case rv.BucketBy == "":
+		return s.id, s.key, true
+	case rv.BucketBy == schema.RuleBucketByKey && s.requestKey != "":
+		key = s.requestKey
+	case rv.BucketBy == schema.RuleBucketByKey, rv.BucketBy == schema.RuleBucketByUID:
+		key = s.attrs[schema.AttrUID]
+	default:
+		key = s.attrs[schema.RuleBucketByAttr(rv.BucketBy)]
+	}
+	if key == "" {
+		return 0, "", false
+	}
+	return schema.AnonymousRuleID(key), key, true
+}
+
+// ruleNeedsAttrs 规则计算是否需要读取用户属性
+func ruleNeedsAttrs(kind string, rv schema.RuleValue) bool {
+	return kind == schema.RuleUserAttribute || rv.BucketBy != ""
+}
+
+// newUserSubject 返回用户的规则计算对象
+func newUserSubject(userID int64) *ruleSubject {
+	return &ruleSubject{userID: userID, id: userID, key: strconv.FormatInt(userID, 10)}
+}
+
+// newAnonymousSubject 返回匿名用户的规则计算对象，匿名用户仅有 uid 属性
+func newAnonymousSubject(anonymousID string) *ruleSubject {
+	return &ruleSubject{
+		id:    schema.AnonymousRuleID(anonymousID),
+		key:   anonymousID,
+		attrs: map[string]string{schema.AttrUID: anonymousID},
+	}
+}
+
+// ruleEntry label_rule 与 setting_rule 参与计算的公共字段
+type ruleEntry struct {
+	id        int64
+	createdAt time.Time
+	targetID  int64
+	kind      string
+	rule      string
+	release   int64
+	startAt   *time.Time
+	endAt     *time.Time
+	salt      string
+}
+
+func labelRuleEntries(rules []schema.LabelRule) []ruleEntry {
+	entries := make([]ruleEntry, 0, len(rules))
+	for _, r := range rules {
+		entries = append(entries, ruleEntry{r.ID, r.CreatedAt, r.LabelID, r.Kind, r.Rule, r.Release, r.StartAt, r.EndAt, r.Salt})
+	}
+	return entries
+}
+
+func settingRuleEntries(rules []schema.SettingRule) []ruleEntry {
+	entries := make([]ruleEntry, 0, len(rules))
+	for _, r := range rules {
+		entries = append(entries, ruleEntry{r.ID, r.CreatedAt, r.SettingID, r.Kind, r.Rule, r.Release, r.StartAt, r.EndAt, r.Salt})
+	}
+	return entries
+}
+
+// ruleMatch 对象命中的规则
+type ruleMatch struct {
+	ruleID   int64
+	targetID int64
+	kind     string
+	release  int64
+	bucket   int    // 对象在规则中的分桶，加入实验层时为实验层中的相对分桶
+	value    string // 多变量规则命中的配置值
+}
+
+// matchRules 按规则顺序计算对象命中的规则，不写入任何数据。
+// target 为 schema.TableLabel 或 schema.TableSetting，用于读取实验层分桶。
+func (m *Model) matchRules(ctx context.Context, target string, subject *ruleSubject, rules []ruleEntry, now time.Time) ([]ruleMatch, error) {
+	res := make([]ruleMatch, 0)
+	if len(rules) == 0 {
+		return res, nil
+	}
+
+	targetIDs := make([]int64, 0, len(rules))
+	for _, rule := range rules {
+		targetIDs = append(targetIDs, rule.targetID)
+	}
+	lb, err := m.findLayerBuckets(ctx, target, targetIDs)
+	if err != nil {
+		return nil, err
+	}
+	if subject.requestKey == "" {
+		subject.requestKey = requestBucketKey(ctx, subject)
+	}
+
+	for _, rule := range rules {
+		if schema.RuleScheduleState(rule.startAt, rule.endAt, now) != schema.RuleStateActive {
+			continue // 规则不在生效时间窗口内
+		}
+
+		rv := schema.ToPercentRule(rule.kind, rule.rule).Rule
+		if ruleNeedsAttrs(rule.kind, rv) && subject.attrs == nil {
+			if subject.attrs, err = m.findUserAttributes(ctx, subject.userID); err != nil {
+				return nil, err
+			}
+		}
+
+		if match, ok := matchRule(lb, subject, rule, rv); ok {
+			res = append(res, match)
+		}
+	}
+	return res, nil
+}
+
+// matchSubjectsRules 批量计算多个对象命中的规则，实验层分桶只读取一次，不写入任何数据，
+// 返回结果与 subjects 一一对应。对于 ruleNeedsAttrs 的规则，须先读取各 subject.attrs。
+func (m *Model) matchSubjectsRules(ctx context.Context, target string, subjects []*ruleSubject, rules []ruleEntry, now time.Time) ([][]ruleMatch, error) {
+	res := make([][]ruleMatch, len(subjects))
+	active := make([]ruleEntry, 0, len(rules))
+	values := make([]schema.RuleValue, 0, len(rules))
+	targetIDs := make([]int64, 0, len(rules))
+	for _, rule := range rules {
+		if schema.RuleScheduleState(rule.startAt, rule.endAt, now) == schema.RuleStateActive {
+			active = append(active, rule)
+			values = append(values, schema.ToPercentRule(rule.kind, rule.rule).Rule)
+			targetIDs = append(targetIDs, rule.targetID)
+		}
+	}
+	if len(active) == 0 {
+		return res, nil
+	}
+
+	lb, err := m.findLayerBuckets(ctx, target, targetIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i, subject := range subjects {
+		if subject.requestKey == "" {
+			subject.requestKey = requestBucketKey(ctx, subject)
+		}
+		for j, rule := range active {
+			if match, ok := matchRule(lb, subject, rule, values[j]); ok {
+				res[i] = append(res[i], match)
+			}
+		}
+	}
+	return res, nil
+}
+
+// rulesNeedAttrs 规则计算是否需要读取用户属性
+func rulesNeedAttrs(rules []ruleEntry) bool {
+	for _, rule := range rules {
+		if ruleNeedsAttrs(rule.kind, schema.ToPercentRule(rule.kind, rule.rule).Rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRule 计算对象是否命中规则，不检查规则的生效时间窗口；
+// 对于 ruleNeedsAttrs 的规则，须先读取 subject.attrs。
+func matchRule(lb *layerBuckets, subject *ruleSubject, rule ruleEntry, rv schema.RuleValue) (ruleMatch, bool) {
+	id, key, ok := subject.bucketKey(rv)
+	if !ok {
+		return ruleMatch{}, false // 缺少规则指定的分桶 key
+	}
+	bucket, salt := schema.RuleBucket(rule.salt, rule.createdAt, id, key), rule.salt
+	if b, s, ok := lb.bucket(rule.targetID, key); ok {
+		bucket, salt = b, s // 加入实验层时使用实验层的分桶
+	}
+	if bucket < 0 {
+		return ruleMatch{}, false // 用户不在该实验的实验层分桶区间内
+	}
+	if rule.kind == schema.RuleUserAttribute && !rv.Match(subject.attrs) {
+		return ruleMatch{}, false
+	}
+
+	match := ruleMatch{ruleID: rule.id, targetID: rule.targetID, kind: rule.kind, release: rule.release, bucket: bucket}
+	if rule.kind == schema.RuleUserVariant {
+		// 多变量规则，各配置值占据互不重叠的区间
+		match.value = rv.Variant(bucket)
+		return match, match.value != ""
+	}
+	// 百分比规则无效或者用户不在百分比区间内时不命中
+	return match, schema.RuleHit(salt, bucket, rv.Value)
+}
+
+// ruleLabelRecord 返回由发布规则写入 user_label 的记录，记录规则 ID 与分桶以便回收
+func ruleLabelRecord(userID int64, match ruleMatch) goqu.Record {
+	// rls 沿用规则 ID
+	return goqu.Record{
+		"user_id":  userID,
+		"label_id": match.targetID,
+		"rls":      match.ruleID,
+		"rule_id":  match.ruleID,
+		"bucket":   match.bucket,
+	}
+}
+
+// ruleSettingRecord 返回由发布规则写入 user_setting 的记录，记录规则 ID 与分桶以便回收；
+// value 为规则的配置值，多变量规则使用命中的 variant 配置值
+func ruleSettingRecord(userID int64, match ruleMatch, value string) goqu.Record {
+	if match.kind == schema.RuleUserVariant {
+		value = match.value
+	}
+	return goqu.Record{
+		"user_id":    userID,
+		"setting_id": match.targetID,
+		"rls":        match.release,
+		"value":      value,
+		"rule_id":    match.ruleID,
+		"bucket":     match.bucket,
+	}
+}
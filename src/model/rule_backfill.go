@@ -0,0 +1,253 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+)
+
+// RuleBackfill ...
+type RuleBackfill struct {
+	*Model
+}
+
+// Acquire ...
+func (m *RuleBackfill) Acquire(ctx context.Context, backfillID int64) (*schema.RuleBackfill, error) {
+	backfill := &schema.RuleBackfill{}
+	if err := m.findOneByID(ctx, schema.TableRuleBackfill, backfillID, backfill); err != nil {
+		return nil, err
+	}
+	return backfill, nil
+}
+
+// AcquireLatest 返回规则最近的回填任务
+func (m *RuleBackfill) AcquireLatest(ctx context.Context, target string, ruleID int64) (*schema.RuleBackfill, error) {
+	backfill := &schema.RuleBackfill{}
+	sd := m.DB.From(schema.TableRuleBackfill).
+		Where(goqu.C("target").Eq(target), goqu.C("rule_id").Eq(ruleID)).
+		Order(goqu.C("id").Desc()).Limit(1)
+	ok, err := sd.Executor().ScanStructContext(ctx, backfill)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, gear.ErrNotFound.WithMsgf("%s %d backfill not found", target, ruleID)
+	}
+	return backfill, nil
+}
+
+// FindDue 返回到期需要执行下一批次的回填任务
+func (m *RuleBackfill) FindDue(ctx context.Context, now time.Time, limit int) ([]schema.RuleBackfill, error) {
+	backfills := make([]schema.RuleBackfill, 0)
+	sd := m.DB.From(schema.TableRuleBackfill).
+		Where(goqu.C("status").Eq(schema.BackfillStatusRunning), goqu.C("next_at").Lte(now)).
+		Order(goqu.C("next_at").Asc()).Limit(uint(limit))
+	if err := sd.Executor().ScanStructsContext(ctx, &backfills); err != nil {
+		return nil, err
+	}
+	return backfills, nil
+}
+
+// CountUsers 返回用户总数，用于计算回填进度
+func (m *RuleBackfill) CountUsers(ctx context.Context) (int64, error) {
+	return m.RdDB.From(schema.TableUser).CountContext(ctx)
+}
+
+// CountGroups 返回 kind 类型的群组总数，用于计算 groupPercent 规则的回填进度
+func (m *RuleBackfill) CountGroups(ctx context.Context, kind string) (int64, error) {
+	return m.RdDB.From(schema.TableGroup).Where(goqu.C("kind").Eq(kind)).CountContext(ctx)
+}
+
+// Create ...
+func (m *RuleBackfill) Create(ctx context.Context, backfill *schema.RuleBackfill) error {
+	_, err := m.createOne(ctx, schema.TableRuleBackfill, backfill)
+	return err
+}
+
+// Update ...
+func (m *RuleBackfill) Update(ctx context.Context, backfillID int64, changed map[string]interface{}) (*schema.RuleBackfill, error) {
+	backfill := &schema.RuleBackfill{}
+	if _, err := m.updateByID(ctx, schema.TableRuleBackfill, backfillID, goqu.Record(changed)); err != nil {
+		return nil, err
+	}
+	if err := m.findOneByID(ctx, schema.TableRuleBackfill, backfillID, backfill); err != nil {
+		return nil, err
+	}
+	return backfill, nil
+}
+
+// Lock 锁定回填任务，避免多实例重复执行
+func (m *RuleBackfill) Lock(ctx context.Context, backfillID int64) error {
+	return m.lock(ctx, backfillLockKey(backfillID), time.Minute)
+}
+
+// Unlock ...
+func (m *RuleBackfill) Unlock(ctx context.Context, backfillID int64) {
+	m.unlock(ctx, backfillLockKey(backfillID))
+}
+
+func backfillLockKey(backfillID int64) string {
+	return fmt.Sprintf("RuleBackfill:%d", backfillID)
+}
+
+// BackfillBatch 回填任务一个批次的执行结果
+type BackfillBatch struct {
+	Cursor    int64 // 本批次处理的最大用户 ID，groupPercent 规则为群组 ID
+	Processed int   // 本批次处理的用户或群组数，小于 batchSize 时表示已遍历完
+	Applied   int   // 本批次新写入指派记录的用户或群组数
+}
+
+// backfillMatch 读取 id 大于 cursor 的一批用户，返回其中命中规则的结果及对应的用户 ID。
+// 回填没有请求方提供的分桶 key，bucketBy 为 "key" 的规则按 uid 分桶。
+func (m *Model) backfillMatch(ctx context.Context, target string, rule ruleEntry, cursor int64, batchSize int) (*BackfillBatch, []ruleMatch, []int64, error) {
+	users := make([]schema.User, 0)
+	sd := m.RdDB.From(schema.TableUser).
+		Select(goqu.C("id"), goqu.C("uid"), goqu.C("created_at")).
+		Where(goqu.C("id").Gt(cursor)).
+		Order(goqu.C("id").Asc()).Limit(uint(batchSize))
+	if err := sd.Executor().ScanStructsContext(ctx, &users); err != nil {
+		return nil, nil, nil, err
+	}
+
+	batch := &BackfillBatch{Cursor: cursor, Processed: len(users)}
+	if len(users) == 0 {
+		return batch, nil, nil, nil
+	}
+	batch.Cursor = users[len(users)-1].ID
+
+	lb, err := m.findLayerBuckets(ctx, target, []int64{rule.targetID})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rv := schema.ToPercentRule(rule.kind, rule.rule).Rule
+	var attrs map[int64]map[string]string
+	if ruleNeedsAttrs(rule.kind, rv) {
+		if attrs, err = m.findUsersAttributes(ctx, users); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	matches := make([]ruleMatch, 0)
+	userIDs := make([]int64, 0)
+	for _, user := range users {
+		subject := newUserSubject(user.ID)
+		subject.attrs = attrs[user.ID]
+		if match, ok := matchRule(lb, subject, rule, rv); ok {
+			matches = append(matches, match)
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+	return batch, matches, userIDs, nil
+}
+
+// Backfill 将环境标签发布规则应用到 id 大于 cursor 的一批用户，不检查规则的生效时间窗口。
+// 与用户请求时的计算一致，已有该产品线环境标签的用户不会被回填。groupPercent 规则遍历的是群组。
+func (m *LabelRule) Backfill(ctx context.Context, labelRule *schema.LabelRule, cursor int64, batchSize int) (*BackfillBatch, error) {
+	rule := labelRuleEntries([]schema.LabelRule{*labelRule})[0]
+	if rule.kind == schema.RuleGroupPercent {
+		return m.applyGroupRule(ctx, schema.TableLabel, rule, "", nil, cursor, batchSize)
+	}
+	batch, matches, userIDs, err := m.backfillMatch(ctx, schema.TableLabel, rule, cursor, batchSize)
+	if err != nil || len(userIDs) == 0 {
+		return batch, err
+	}
+
+	labeled := make([]int64, 0)
+	sd := m.DB.Select(goqu.I("t1.user_id")).Distinct().
+		From(
+			goqu.T(schema.TableUserLabel).As("t1"),
+			goqu.T(schema.TableLabel).As("t2")).
+		Where(
+			goqu.I("t1.user_id").In(userIDs),
+			goqu.I("t1.label_id").Eq(goqu.I("t2.id")),
+			goqu.I("t2.product_id").Eq(labelRule.ProductID))
+	if err := sd.Executor().ScanValsContext(ctx, &labeled); err != nil {
+		return nil, err
+	}
+
+	exclude := make(map[int64]bool, len(labeled))
+	for _, id := range labeled {
+		exclude[id] = true
+	}
+	rows := make([]interface{}, 0, len(userIDs))
+	applied := make([]int64, 0, len(userIDs))
+	for i, id := range userIDs {
+		if !exclude[id] {
+			rows = append(rows, ruleLabelRecord(id, matches[i]))
+			applied = append(applied, id)
+		}
+	}
+	if len(rows) == 0 {
+		return batch, nil
+	}
+
+	rowsAffected, err := service.DeResult(m.DB.Insert(schema.TableUserLabel).Rows(rows...).
+		OnConflict(goqu.DoNothing()).Executor().ExecContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	batch.Applied = int(rowsAffected)
+	if rowsAffected > 0 {
+		// 清空用户的环境标签缓存，下次请求时重新计算
+		if _, err := m.updateByCols(ctx, schema.TableUser, goqu.Ex{"id": applied}, goqu.Record{"labels": ""}); err != nil {
+			return nil, err
+		}
+		m.tryEmitChanges(ctx, schema.ChangeKindLabel, labelRule.ProductID, applied, nil)
+		m.tryIncreaseLabelsStatus(ctx, []int64{labelRule.LabelID}, batch.Applied)
+	}
+	return batch, nil
+}
+
+// Backfill 将配置项发布规则应用到 id 大于 cursor 的一批用户，不检查规则的生效时间窗口。
+// 已有该配置项的用户不会被覆盖。groupPercent 规则遍历的是群组。
+func (m *SettingRule) Backfill(ctx context.Context, settingRule *schema.SettingRule, cursor int64, batchSize int) (*BackfillBatch, error) {
+	rule := settingRuleEntries([]schema.SettingRule{*settingRule})[0]
+	if rule.kind == schema.RuleGroupPercent {
+		return m.applyGroupRule(ctx, schema.TableSetting, rule, settingRule.Value, nil, cursor, batchSize)
+	}
+	batch, matches, userIDs, err := m.backfillMatch(ctx, schema.TableSetting, rule, cursor, batchSize)
+	if err != nil || len(matches) == 0 {
+		return batch, err
+	}
+
+	// 插入时会跳过已有该配置项的用户，先排除掉，变更事件只记录新写入的用户
+	assigned := make([]int64, 0)
+	sd := m.DB.From(schema.TableUserSetting).Select(goqu.C("user_id")).
+		Where(goqu.C("user_id").In(userIDs), goqu.C("setting_id").Eq(settingRule.SettingID))
+	if err := sd.Executor().ScanValsContext(ctx, &assigned); err != nil {
+		return nil, err
+	}
+
+	exclude := make(map[int64]bool, len(assigned))
+	for _, id := range assigned {
+		exclude[id] = true
+	}
+	rows := make([]interface{}, 0, len(matches))
+	applied := make([]int64, 0, len(matches))
+	for i, match := range matches {
+		if !exclude[userIDs[i]] {
+			rows = append(rows, ruleSettingRecord(userIDs[i], match, settingRule.Value))
+			applied = append(applied, userIDs[i])
+		}
+	}
+	if len(rows) == 0 {
+		return batch, nil
+	}
+
+	rowsAffected, err := service.DeResult(m.DB.Insert(schema.TableUserSetting).Rows(rows...).
+		OnConflict(goqu.DoNothing()).Executor().ExecContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	batch.Applied = int(rowsAffected)
+	if rowsAffected > 0 {
+		m.tryEmitChanges(ctx, schema.ChangeKindSetting, settingRule.ProductID, applied, nil)
+		m.tryIncreaseSettingsStatus(ctx, []int64{settingRule.SettingID}, batch.Applied)
+	}
+	return batch, nil
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+)
+
+// RuleRamp ...
+type RuleRamp struct {
+	*Model
+}
+
+// Acquire ...
+func (m *RuleRamp) Acquire(ctx context.Context, rampID int64) (*schema.RuleRamp, error) {
+	ramp := &schema.RuleRamp{}
+	if err := m.findOneByID(ctx, schema.TableRuleRamp, rampID, ramp); err != nil {
+		return nil, err
+	}
+	return ramp, nil
+}
+
+// AcquireLatest 返回规则最近的渐进发布计划
+func (m *RuleRamp) AcquireLatest(ctx context.Context, target string, ruleID int64) (*schema.RuleRamp, error) {
+	ramp := &schema.RuleRamp{}
+	sd := m.DB.From(schema.TableRuleRamp).
+		Where(goqu.C("target").Eq(target), goqu.C("rule_id").Eq(ruleID)).
+		Order(goqu.C("id").Desc()).Limit(1)
+	ok, err := sd.Executor().ScanStructContext(ctx, ramp)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, gear.ErrNotFound.WithMsgf("%s %d ramp not found", target, ruleID)
+	}
+	return ramp, nil
+}
+
+// FindDue 返回到期需要执行的渐进发布计划
+func (m *RuleRamp) FindDue(ctx context.Context, now time.Time, limit int) ([]schema.RuleRamp, error) {
+	ramps := make([]schema.RuleRamp, 0)
+	sd := m.DB.From(schema.TableRuleRamp).
+		Where(goqu.C("status").Eq(schema.RampStatusRunning), goqu.C("next_at").Lte(now)).
+		Order(goqu.C("next_at").Asc()).Limit(uint(limit))
+	if err := sd.Executor().ScanStructsContext(ctx, &ramps); err != nil {
+		return nil, err
+	}
+	return ramps, nil
+}
+
+// FindLogs 返回渐进发布计划的执行历史，按时间正序
+func (m *RuleRamp) FindLogs(ctx context.Context, rampID int64) ([]schema.RuleRampLog, error) {
+	logs := make([]schema.RuleRampLog, 0)
+	sd := m.RdDB.From(schema.TableRuleRampLog).
+		Where(goqu.C("ramp_id").Eq(rampID)).
+		Order(goqu.C("id").Asc()).Limit(1000)
+	if err := sd.Executor().ScanStructsContext(ctx, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// Create ...
+func (m *RuleRamp) Create(ctx context.Context, ramp *schema.RuleRamp) error {
+	_, err := m.createOne(ctx, schema.TableRuleRamp, ramp)
+	return err
+}
+
+// Update ...
+func (m *RuleRamp) Update(ctx context.Context, rampID int64, changed map[string]interface{}) (*schema.RuleRamp, error) {
+	ramp := &schema.RuleRamp{}
+	if _, err := m.updateByID(ctx, schema.TableRuleRamp, rampID, goqu.Record(changed)); err != nil {
+		return nil, err
+	}
+	if err := m.findOneByID(ctx, schema.TableRuleRamp, rampID, ramp); err != nil {
+		return nil, err
+	}
+	return ramp, nil
+}
+
+// AddLog 记录渐进发布计划的执行历史
+func (m *RuleRamp) AddLog(ctx context.Context, log *schema.RuleRampLog) error {
+	_, err := m.createOne(ctx, schema.TableRuleRampLog, log)
+	return err
+}
+
+// Lock 锁定渐进发布计划，避免多实例重复执行
+func (m *RuleRamp) Lock(ctx context.Context, rampID int64) error {
+	return m.lock(ctx, rampLockKey(rampID), time.Minute)
+}
+
+// Unlock ...
+func (m *RuleRamp) Unlock(ctx context.Context, rampID int64) {
+	m.unlock(ctx, rampLockKey(rampID))
+}
+
+func rampLockKey(rampID int64) string {
+	return fmt.Sprintf("RuleRamp:%d", rampID)
+}
@@ -0,0 +1,165 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+)
+
+// revokeBatchSize 回收规则指派时每批删除的记录数
+const revokeBatchSize = 1000
+
+// ruleMissExp 返回规则写入的指派记录中已不再命中规则的记录条件，按记录的分桶判断。
+// salt 为计算分桶时使用的盐值，规则加入实验层时为实验层的盐值。
+// userAttribute 规则的 conditions 与配置规则的配置值无法按分桶判断，改变时调用方需回收全部记录。
+func ruleMissExp(rule ruleEntry, salt string) exp.Expression {
+	rv := schema.ToPercentRule(rule.kind, rule.rule).Rule
+	if rule.kind != schema.RuleUserVariant {
+		return goqu.C("bucket").Gte(schema.RuleMissBucket(salt, rv.Value))
+	}
+
+	// 多变量规则，分桶不在任何区间内或所在区间的配置值已改变
+	exps := make([]exp.Expression, 0, len(rv.Variants)+1)
+	start := 0
+	for _, v := range rv.Variants {
+		end := start + v.Weight*schema.RuleBuckets/100
+		exps = append(exps, goqu.And(
+			goqu.C("bucket").Gte(start),
+			goqu.C("bucket").Lt(end),
+			goqu.C("value").Neq(v.Value)))
+		start = end
+	}
+	exps = append(exps, goqu.C("bucket").Gte(start))
+	return goqu.Or(exps...)
+}
+
+// revokeRule 删除由规则写入且已不再命中规则的用户指派记录，all 为 true 时删除该规则写入的全部记录。
+// groupPercent 规则删除的是群组指派记录。直接指派的记录不受影响。返回删除的记录数。
+func (m *Model) revokeRule(ctx context.Context, target string, rule ruleEntry, all bool) (int64, error) {
+	userTable, groupTable, _ := assignTables(target)
+	table, subjectCol := userTable, "user_id"
+	if rule.kind == schema.RuleGroupPercent {
+		table, subjectCol = groupTable, "group_id"
+	}
+	exps := []exp.Expression{goqu.C("rule_id").Eq(rule.id)}
+	if !all {
+		salt := rule.salt
+		if rule.kind != schema.RuleGroupPercent {
+			// 群组分桶不参与实验层
+			lb, err := m.findLayerBuckets(ctx, target, []int64{rule.targetID})
+			if err != nil {
+				return 0, err
+			}
+			if e, ok := lb.experiments[rule.targetID]; ok {
+				salt = lb.salts[e.LayerID]
+			}
+		}
+		exps = append(exps, ruleMissExp(rule, salt))
+	}
+
+	total := int64(0)
+	for {
+		rows := make([]struct {
+			ID        int64 `db:"id"`
+			SubjectID int64 `db:"subject_id"`
+		}, 0)
+		sd := m.DB.From(table).Select(goqu.C("id"), goqu.C(subjectCol).As("subject_id")).
+			Where(exps...).Limit(revokeBatchSize)
+		if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+			return total, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		ids := make([]int64, 0, len(rows))
+		userIDs := make([]int64, 0, len(rows)) // groupPercent 规则为群组 ID
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+			userIDs = append(userIDs, row.SubjectID)
+		}
+		if target == schema.TableLabel && rule.kind != schema.RuleGroupPercent {
+			// 清空用户的环境标签缓存，下次请求时重新计算
+			if _, err := m.updateByCols(ctx, schema.TableUser, goqu.Ex{"id": userIDs}, goqu.Record{"labels": ""}); err != nil {
+				return total, err
+			}
+		}
+		rowsAffected, err := service.DeResult(m.DB.Delete(table).Where(goqu.C("id").In(ids)).Executor().ExecContext(ctx))
+		if err != nil {
+			return total, err
+		}
+		total += rowsAffected
+		if rule.kind == schema.RuleGroupPercent {
+			m.tryEmitTargetChanges(ctx, targetChangeKind(target), rule.targetID, nil, userIDs)
+		} else {
+			m.tryEmitTargetChanges(ctx, targetChangeKind(target), rule.targetID, userIDs, nil)
+		}
+		if len(rows) < revokeBatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		switch {
+		case rule.kind == schema.RuleGroupPercent:
+			// 群组指派按成员数计入 status，重新统计
+			m.tryRefreshTargetStatus(target, rule.targetID)
+		case target == schema.TableLabel:
+			m.tryIncreaseLabelsStatus(ctx, []int64{rule.targetID}, -int(total))
+		default:
+			m.tryIncreaseSettingsStatus(ctx, []int64{rule.targetID}, -int(total))
+		}
+	}
+	return total, nil
+}
+
+// Revoke 删除由环境标签发布规则写入且已不再命中规则的用户环境标签，all 为 true 时删除该规则写入的全部记录
+func (m *LabelRule) Revoke(ctx context.Context, labelRule *schema.LabelRule, all bool) (int64, error) {
+	return m.revokeRule(ctx, schema.TableLabel, labelRuleEntries([]schema.LabelRule{*labelRule})[0], all)
+}
+
+// Revoke 删除由配置项发布规则写入且已不再命中规则的用户配置项，all 为 true 时删除该规则写入的全部记录
+func (m *SettingRule) Revoke(ctx context.Context, settingRule *schema.SettingRule, all bool) (int64, error) {
+	return m.revokeRule(ctx, schema.TableSetting, settingRuleEntries([]schema.SettingRule{*settingRule})[0], all)
+}
+
+// ruleAssignedExp 返回规则仍有其写入的指派记录的条件，groupPercent 规则查群组指派记录
+func ruleAssignedExp(target, ruleTable string) exp.Expression {
+	userTable, groupTable, _ := assignTables(target)
+	exists := func(table string) exp.LiteralExpression {
+		return goqu.L("EXISTS (SELECT 1 FROM ? WHERE ? = ?)",
+			goqu.T(table), goqu.T(table).Col("rule_id"), goqu.T(ruleTable).Col("id"))
+	}
+	return goqu.Or(
+		goqu.And(goqu.C("kind").Neq(schema.RuleGroupPercent), exists(userTable)),
+		goqu.And(goqu.C("kind").Eq(schema.RuleGroupPercent), exists(groupTable)),
+	)
+}
+
+// FindEnded 返回已过失效时间且仍有其写入的指派记录的环境标签发布规则
+func (m *LabelRule) FindEnded(ctx context.Context, now time.Time, limit int) ([]schema.LabelRule, error) {
+	rules := make([]schema.LabelRule, 0)
+	sd := m.DB.From(schema.TableLabelRule).
+		Where(goqu.C("end_at").Lte(now), ruleAssignedExp(schema.TableLabel, schema.TableLabelRule)).
+		Order(goqu.C("end_at").Asc()).Limit(uint(limit))
+	if err := sd.Executor().ScanStructsContext(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// FindEnded 返回已过失效时间且仍有其写入的指派记录的配置项发布规则
+func (m *SettingRule) FindEnded(ctx context.Context, now time.Time, limit int) ([]schema.SettingRule, error) {
+	rules := make([]schema.SettingRule, 0)
+	sd := m.DB.From(schema.TableSettingRule).
+		Where(goqu.C("end_at").Lte(now), ruleAssignedExp(schema.TableSetting, schema.TableSettingRule)).
+		Order(goqu.C("end_at").Asc()).Limit(uint(limit))
+	if err := sd.Executor().ScanStructsContext(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
@@ -32,9 +33,29 @@ func (m *Setting) FindByName(ctx context.Context, moduleID int64, name, selectSt
 	return setting, nil
 }
 
+// findCached 优先从名称解析缓存读取 setting，未命中时从主库读取，仅缓存未下线的 setting
+func (m *Setting) findCached(ctx context.Context, moduleID int64, name string) (*schema.Setting, error) {
+	m.syncEntityCache(ctx)
+	key := fmt.Sprintf("setting:%d:%s", moduleID, name)
+	if val, ok := entityCache.get(key); ok {
+		setting := val.(schema.Setting)
+		return &setting, nil
+	}
+
+	gen := entityCache.generation()
+	setting, err := m.FindByName(primaryCtx(ctx), moduleID, name, "")
+	if err != nil || setting == nil {
+		return nil, err
+	}
+	if setting.OfflineAt == nil {
+		entityCache.set(gen, key, schema.TableSetting, setting.ID, *setting)
+	}
+	return setting, nil
+}
+
 // Acquire ...
 func (m *Setting) Acquire(ctx context.Context, moduleID int64, settingName string) (*schema.Setting, error) {
-	setting, err := m.FindByName(ctx, moduleID, settingName, "")
+	setting, err := m.findCached(ctx, moduleID, settingName)
 	if err != nil {
 		return nil, err
 	}
@@ -49,7 +70,7 @@ func (m *Setting) Acquire(ctx context.Context, moduleID int64, settingName strin
 
 // AcquireID ...
 func (m *Setting) AcquireID(ctx context.Context, moduleID int64, settingName string) (int64, error) {
-	setting, err := m.FindByName(ctx, moduleID, settingName, "id, offline_at")
+	setting, err := m.findCached(ctx, moduleID, settingName)
 	if err != nil {
 		return 0, err
 	}
@@ -98,6 +119,12 @@ func (m *Setting) Find(ctx context.Context, productID, moduleID int64, pg tpl.Pa
 		goqu.I("t1.vals"),
 		goqu.I("t1.status"),
 		goqu.I("t1.rls"),
+		goqu.I("t1.prereq_id"),
+		goqu.I("t1.prereq_value"),
+		goqu.I("t1.default_value"),
+		goqu.I("t1.default_overrides"),
+		goqu.I("t1.value_type"),
+		goqu.I("t1.value_schema"),
 		goqu.I("t2.name").As("module")).
 		From(
 			goqu.T(schema.TableSetting).As("t1"),
@@ -169,15 +196,20 @@ func (m *Setting) Update(ctx context.Context, settingID int64, changed map[strin
 	if _, err := m.updateByID(ctx, schema.TableSetting, settingID, goqu.Record(changed)); err != nil {
 		return nil, err
 	}
+	m.tryBumpEntityCacheVersion(ctx)
 	if err := m.findOneByID(ctx, schema.TableSetting, settingID, setting); err != nil {
 		return nil, err
 	}
+	m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, settingID, nil, nil)
 	return setting, nil
 }
 
 // Offline 标记配置项下线，同时真删除用户和群组的配置项值
 func (m *Setting) Offline(ctx context.Context, moduleID, settingID int64) error {
-	return m.offlineSettingsInModule(ctx, moduleID, goqu.Ex{"id": settingID, "offline_at": nil})
+	err := m.offlineSettingsInModule(ctx, moduleID, goqu.Ex{"id": settingID, "offline_at": nil})
+	m.tryBumpEntityCacheVersion(ctx)
+	m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, settingID, nil, nil)
+	return err
 }
 
 // Assign 把标签批量分配给用户或群组，如果用户或群组不存在则忽略，如果已经分配，则把原值保存到 last_value 并更新值
@@ -194,10 +226,12 @@ func (m *Setting) Assign(ctx context.Context, settingID int64, value string, use
 			FromQuery(goqu.From(goqu.T(schema.TableUser).As("t1")).
 				Select(goqu.I("t1.id"), goqu.V(settingID), goqu.V(value), goqu.V(release)).
 				Where(goqu.I("t1.uid").In(tpl.StrSliceToInterface(users)...))).
+			// 直接指派覆盖发布规则写入的记录，之后不再随规则回收
 			OnConflict(goqu.DoUpdate("", goqu.Record{
 				"last_value": goqu.T(schema.TableUserSetting).Col("value"),
 				"value":      value,
 				"rls":        release,
+				"rule_id":    0,
 			}))
 
 		rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
@@ -238,6 +272,7 @@ func (m *Setting) Assign(ctx context.Context, settingID int64, value string, use
 					"last_value": goqu.T(schema.TableGroupSetting).Col("value"),
 					"value":      value,
 					"rls":        release,
+					"rule_id":    0,
 				}))
 
 			rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
@@ -265,6 +300,7 @@ func (m *Setting) Assign(ctx context.Context, settingID int64, value string, use
 	}
 
 	if totalRowsAffected > 0 {
+		m.tryEmitAssignChanges(ctx, schema.ChangeKindSetting, settingID, users, groups)
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshSettingStatus(gctx, settingID)
 		})
@@ -274,7 +310,18 @@ func (m *Setting) Assign(ctx context.Context, settingID int64, value string, use
 
 // Delete 对配置项进行物理删除
 func (m *Setting) Delete(ctx context.Context, id int64) error {
-	_, err := m.deleteByID(ctx, schema.TableSetting, id)
+	if _, err := m.deleteByCols(ctx, schema.TableSettingVariant, goqu.Ex{"setting_id": id}); err != nil {
+		return err
+	}
+	if _, err := m.deleteByCols(ctx, schema.TableSettingOverride, goqu.Ex{"setting_id": id}); err != nil {
+		return err
+	}
+	productID, _ := m.findTargetProductID(ctx, schema.ChangeKindSetting, id)
+	rowsAffected, err := m.deleteByID(ctx, schema.TableSetting, id)
+	m.tryBumpEntityCacheVersion(ctx)
+	if rowsAffected > 0 {
+		m.tryEmitChanges(ctx, schema.ChangeKindSetting, productID, nil, nil)
+	}
 	return err
 }
 
@@ -293,6 +340,7 @@ func (m *Setting) Cleanup(ctx context.Context, id int64) error {
 		return err
 	}
 	_, err = m.updateByID(ctx, schema.TableSetting, id, goqu.Record{"status": 0})
+	m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, id, nil, nil)
 	return err
 }
 
@@ -301,6 +349,7 @@ func (m *Setting) RemoveUserSetting(ctx context.Context, userID, settingID int64
 	rowsAffected, err := m.deleteByCols(ctx, schema.TableUserSetting,
 		goqu.Ex{"user_id": userID, "setting_id": settingID})
 	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, settingID, []int64{userID}, nil)
 		util.Go(5*time.Second, func(gctx context.Context) {
 			m.tryIncreaseSettingsStatus(gctx, []int64{settingID}, -1)
 		})
@@ -308,11 +357,14 @@ func (m *Setting) RemoveUserSetting(ctx context.Context, userID, settingID int64
 	return rowsAffected, err
 }
 
-// RollbackUserSetting 回滚用户的 setting
+// RollbackUserSetting 回滚用户的 setting，回滚后的记录视为直接指派
 func (m *Setting) RollbackUserSetting(ctx context.Context, userID, settingID int64) error {
-	_, err := m.updateByCols(ctx, schema.TableUserSetting,
+	rowsAffected, err := m.updateByCols(ctx, schema.TableUserSetting,
 		goqu.Ex{"user_id": userID, "setting_id": settingID},
-		goqu.Record{"value": goqu.T(schema.TableUserSetting).Col("last_value")})
+		goqu.Record{"value": goqu.T(schema.TableUserSetting).Col("last_value"), "rule_id": 0})
+	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, settingID, []int64{userID}, nil)
+	}
 	return err
 }
 
@@ -321,6 +373,7 @@ func (m *Setting) RemoveGroupSetting(ctx context.Context, groupID, settingID int
 	rowsAffected, err := m.deleteByCols(ctx, schema.TableGroupSetting,
 		goqu.Ex{"group_id": groupID, "setting_id": settingID})
 	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, settingID, nil, []int64{groupID})
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshSettingStatus(gctx, settingID)
 		})
@@ -330,9 +383,12 @@ func (m *Setting) RemoveGroupSetting(ctx context.Context, groupID, settingID int
 
 // RollbackGroupSetting 回滚群组的 setting
 func (m *Setting) RollbackGroupSetting(ctx context.Context, groupID, settingID int64) error {
-	_, err := m.updateByCols(ctx, schema.TableGroupSetting,
+	rowsAffected, err := m.updateByCols(ctx, schema.TableGroupSetting,
 		goqu.Ex{"group_id": groupID, "setting_id": settingID},
-		goqu.Record{"value": goqu.T(schema.TableGroupSetting).Col("last_value")})
+		goqu.Record{"value": goqu.T(schema.TableGroupSetting).Col("last_value"), "rule_id": 0})
+	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, settingID, nil, []int64{groupID})
+	}
 	return err
 }
 
@@ -351,6 +407,7 @@ func (m *Setting) Recall(ctx context.Context, settingID, release int64) error {
 	}
 	totalRowsAffected += rowsAffected
 	if totalRowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, settingID, nil, nil)
 		util.Go(10*time.Second, func(gctx context.Context) {
 			m.tryRefreshSettingStatus(gctx, settingID)
 		})
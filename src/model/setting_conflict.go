@@ -0,0 +1,179 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// settingSource 用户或其群组对配置项的一条指派记录
+type settingSource struct {
+	UserID     int64     `db:"user_id"`
+	SettingID  int64     `db:"setting_id"`
+	Value      string    `db:"value"`
+	LastValue  string    `db:"last_value"`
+	Release    int64     `db:"rls"`
+	AssignedAt time.Time `db:"assigned_at"`
+	RuleID     int64     `db:"rule_id"`
+	Bucket     int       `db:"bucket"`
+	GroupUID   string    `db:"group_uid"`
+	GroupKind  string    `db:"group_kind"`
+}
+
+// conflict 返回指派记录落选时的冲突信息
+func (r settingSource) conflict() tpl.SettingConflict {
+	conflict := tpl.SettingConflict{Source: tpl.EvaluationSourceUser, Value: r.Value, AssignedAt: r.AssignedAt}
+	if r.GroupUID != "" {
+		conflict.Source = tpl.EvaluationSourceGroup
+		conflict.Group = &tpl.EvaluationGroup{UID: r.GroupUID, Kind: r.GroupKind}
+	}
+	return conflict
+}
+
+// findSettingSources 返回用户及其群组对指定配置项的全部指派记录，已按产品线的冲突优先策略排序
+func (m *Model) findSettingSources(ctx context.Context, productID int64, userIDs, settingIDs []int64) ([]settingSource, error) {
+	rows := make([]settingSource, 0)
+	sd := m.RdDB.Select(
+		goqu.C("user_id"),
+		goqu.C("setting_id"),
+		goqu.C("value"),
+		goqu.C("last_value"),
+		goqu.C("rls"),
+		goqu.C("updated_at").As("assigned_at"),
+		goqu.C("rule_id"),
+		goqu.C("bucket")).
+		From(schema.TableUserSetting).
+		Where(
+			goqu.C("user_id").In(userIDs),
+			goqu.C("setting_id").In(settingIDs))
+	if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	groupRows := make([]settingSource, 0)
+	sd = m.RdDB.Select(
+		goqu.I("t1.user_id"),
+		goqu.I("t2.setting_id"),
+		goqu.I("t2.value"),
+		goqu.I("t2.last_value"),
+		goqu.I("t2.rls"),
+		goqu.I("t2.updated_at").As("assigned_at"),
+		goqu.I("t2.rule_id"),
+		goqu.I("t2.bucket"),
+		goqu.I("t3.uid").As("group_uid"),
+		goqu.I("t3.kind").As("group_kind")).
+		From(
+			goqu.T(schema.TableUserGroup).As("t1"),
+			goqu.T(schema.TableGroupSetting).As("t2"),
+			goqu.T(schema.TableGroup).As("t3")).
+		Where(
+			goqu.I("t1.user_id").In(userIDs),
+			goqu.I("t1.group_id").Eq(goqu.I("t2.group_id")),
+			goqu.I("t2.setting_id").In(settingIDs),
+			goqu.I("t1.group_id").Eq(goqu.I("t3.id")))
+	if err := sd.Executor().ScanStructsContext(ctx, &groupRows); err != nil {
+		return nil, err
+	}
+
+	rows = append(rows, groupRows...)
+	if len(groupRows) == 0 {
+		return rows, nil // 只有用户的指派记录，不会冲突
+	}
+	product, err := m.findConflictPolicy(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	sortSettingSources(product, rows)
+	return rows, nil
+}
+
+// findLatestAssignedAt 返回用户及其群组对指定配置项最近的指派时间，按指派时间分页时配置项只在该时间所在的页返回
+func (m *Model) findLatestAssignedAt(ctx context.Context, userID int64, groupIDs, settingIDs []int64) (map[int64]time.Time, error) {
+	res := make(map[int64]time.Time, len(settingIDs))
+	if len(settingIDs) == 0 {
+		return res, nil
+	}
+
+	rows := make([]settingSource, 0)
+	sd := m.RdDB.Select(goqu.C("setting_id"), goqu.MAX("updated_at").As("assigned_at")).
+		From(schema.TableUserSetting).
+		Where(goqu.C("user_id").Eq(userID), goqu.C("setting_id").In(settingIDs)).
+		GroupBy(goqu.C("setting_id"))
+	if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+		return nil, err
+	}
+	if len(groupIDs) > 0 {
+		groupRows := make([]settingSource, 0)
+		sd = m.RdDB.Select(goqu.C("setting_id"), goqu.MAX("updated_at").As("assigned_at")).
+			From(schema.TableGroupSetting).
+			Where(goqu.C("group_id").In(groupIDs), goqu.C("setting_id").In(settingIDs)).
+			GroupBy(goqu.C("setting_id"))
+		if err := sd.Executor().ScanStructsContext(ctx, &groupRows); err != nil {
+			return nil, err
+		}
+		rows = append(rows, groupRows...)
+	}
+
+	for _, row := range rows {
+		if t, ok := res[row.SettingID]; !ok || row.AssignedAt.After(t) {
+			res[row.SettingID] = row.AssignedAt
+		}
+	}
+	return res, nil
+}
+
+// findConflictPolicy 返回只包含冲突优先策略的产品线数据
+func (m *Model) findConflictPolicy(ctx context.Context, productID int64) (schema.Product, error) {
+	product := schema.Product{}
+	_, err := m.findOneByCols(ctx, schema.TableProduct, goqu.Ex{"id": productID}, "id, conflict_policy, group_kinds", &product)
+	return product, err
+}
+
+// sortSettingSources 按冲突优先级排序指派记录，同一优先级内最近指派的优先
+func sortSettingSources(product schema.Product, rows []settingSource) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		ri := product.ConflictRank(rows[i].GroupUID != "", rows[i].GroupKind)
+		rj := product.ConflictRank(rows[j].GroupUID != "", rows[j].GroupKind)
+		if ri != rj {
+			return ri < rj
+		}
+		return rows[i].AssignedAt.After(rows[j].AssignedAt)
+	})
+}
+
+// resolveSettingConflicts 按产品线的冲突优先策略确定用户配置项的取值，并列出落选的来源
+func (m *Model) resolveSettingConflicts(ctx context.Context, productID, userID int64, settings []tpl.MySetting) error {
+	if len(settings) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(settings))
+	for _, s := range settings {
+		ids = append(ids, s.ID)
+	}
+	rows, err := m.findSettingSources(ctx, productID, []int64{userID}, ids)
+	if err != nil {
+		return err
+	}
+
+	sources := make(map[int64][]settingSource, len(settings))
+	for _, row := range rows {
+		sources[row.SettingID] = append(sources[row.SettingID], row)
+	}
+	for i := range settings {
+		rs := sources[settings[i].ID]
+		if len(rs) == 0 {
+			continue
+		}
+		settings[i].Value = rs[0].Value
+		settings[i].LastValue = rs[0].LastValue
+		settings[i].Release = rs[0].Release
+		for _, r := range rs[1:] {
+			settings[i].Conflicts = append(settings[i].Conflicts, r.conflict())
+		}
+	}
+	return nil
+}
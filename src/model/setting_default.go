@@ -0,0 +1,121 @@
+package model
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// FindDefaults 返回产品线下有默认值且未指派给用户及其群组的配置项，取值为 channel、client 下的默认值。
+// userID 为 0 时不排除任何配置项。
+func (m *Setting) FindDefaults(ctx context.Context, productID, moduleID, settingID, userID int64, groupIDs []int64, q, channel, client, version string) ([]tpl.MySetting, error) {
+	return m.FindDefaultsAfter(ctx, productID, moduleID, settingID, userID, groupIDs, q, channel, client, version, 0, 0)
+}
+
+// FindDefaultsAfter 同 FindDefaults，按配置项 ID 分页，返回 ID 大于 afterID 的至多 limit 个配置项，limit 为 0 时不限制
+func (m *Setting) FindDefaultsAfter(ctx context.Context, productID, moduleID, settingID, userID int64, groupIDs []int64, q, channel, client, version string, afterID int64, limit int) ([]tpl.MySetting, error) {
+	exps := []exp.Expression{
+		goqu.I("t1.id").Gt(afterID),
+		goqu.I("t1.module_id").Eq(goqu.I("t2.id")),
+		goqu.I("t2.product_id").Eq(productID),
+		goqu.I("t1.offline_at").IsNull(),
+		goqu.I("t2.offline_at").IsNull(),
+		goqu.Or(
+			goqu.I("t1.default_value").Neq(""),
+			goqu.I("t1.default_overrides").Neq("")),
+	}
+	if settingID > 0 {
+		exps = append(exps, goqu.I("t1.id").Eq(settingID))
+	} else if moduleID > 0 {
+		exps = append(exps, goqu.I("t2.id").Eq(moduleID))
+	}
+	if q != "" {
+		exps = append(exps, goqu.I("t1.name").ILike(q))
+	}
+	if userID > 0 {
+		exps = append(exps, goqu.I("t1.id").NotIn(
+			m.RdDB.From(schema.TableUserSetting).Select(goqu.C("setting_id")).Where(goqu.C("user_id").Eq(userID))))
+	}
+	if len(groupIDs) > 0 {
+		exps = append(exps, goqu.I("t1.id").NotIn(
+			m.RdDB.From(schema.TableGroupSetting).Select(goqu.C("setting_id")).Where(goqu.C("group_id").In(groupIDs))))
+	}
+
+	settings := make([]schema.Setting, 0)
+	sd := m.RdDB.Select(
+		goqu.I("t1.id"),
+		goqu.I("t1.name"),
+		goqu.I("t1.description"),
+		goqu.I("t1.channels"),
+		goqu.I("t1.clients"),
+		goqu.I("t1.default_value"),
+		goqu.I("t1.default_overrides"),
+		goqu.I("t1.value_type"),
+		goqu.I("t1.min_version"),
+		goqu.I("t1.max_version"),
+		goqu.I("t2.name").As("module")).
+		From(
+			goqu.T(schema.TableSetting).As("t1"),
+			goqu.T(schema.TableModule).As("t2")).
+		Where(exps...).
+		Order(goqu.I("t1.id").Asc()).Limit(1000)
+	if err := sd.Executor().ScanStructsContext(ctx, &settings); err != nil {
+		return nil, err
+	}
+
+	data := make([]tpl.MySetting, 0, len(settings))
+	for _, setting := range settings {
+		if limit > 0 && len(data) >= limit {
+			break
+		}
+		if setting.Channels != "" && !tpl.StringSliceHas(tpl.StringToSlice(setting.Channels), channel) {
+			continue // channel 不匹配
+		}
+		if setting.Clients != "" && !tpl.StringSliceHas(tpl.StringToSlice(setting.Clients), client) {
+			continue // client 不匹配
+		}
+		if !schema.VersionInRange(version, setting.MinVersion, setting.MaxVersion) {
+			continue // 客户端版本不在适用范围内
+		}
+		value, ok := setting.DefaultFor(channel, client)
+		if !ok {
+			continue
+		}
+		data = append(data, tpl.MySetting{
+			ID:        setting.ID,
+			HID:       service.IDToHID(setting.ID, "setting"),
+			Module:    setting.Module,
+			Name:      setting.Name,
+			Desc:      setting.Desc,
+			Value:     value,
+			Channels:  setting.Channels,
+			Clients:   setting.Clients,
+			Source:    tpl.EvaluationSourceDefault,
+			ValueType: setting.ValueType,
+		})
+	}
+	return data, nil
+}
+
+// findSettingDefaults 返回指定配置项在 channel、client 下的默认值，没有默认值的配置项不返回
+func (m *Model) findSettingDefaults(ctx context.Context, settingIDs []int64, channel, client string) (map[int64]string, error) {
+	settings := make([]schema.Setting, 0)
+	sd := m.RdDB.From(schema.TableSetting).
+		Select(goqu.C("id"), goqu.C("default_value"), goqu.C("default_overrides")).
+		Where(goqu.C("id").In(settingIDs), goqu.C("offline_at").IsNull())
+	if err := sd.Executor().ScanStructsContext(ctx, &settings); err != nil {
+		return nil, err
+	}
+
+	res := make(map[int64]string, len(settings))
+	for _, setting := range settings {
+		if value, ok := setting.DefaultFor(channel, client); ok {
+			res[setting.ID] = value
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// SettingOverride ...
+type SettingOverride struct {
+	*Model
+}
+
+// Acquire ...
+func (m *SettingOverride) Acquire(ctx context.Context, overrideID int64) (*schema.SettingOverride, error) {
+	override := &schema.SettingOverride{}
+	if err := m.findOneByID(ctx, schema.TableSettingOverride, overrideID, override); err != nil {
+		return nil, err
+	}
+	return override, nil
+}
+
+// Find 返回配置项的全部覆盖值
+func (m *SettingOverride) Find(ctx context.Context, settingID int64) ([]schema.SettingOverride, error) {
+	overrides := make([]schema.SettingOverride, 0)
+	sd := m.RdDB.From(schema.TableSettingOverride).
+		Where(goqu.C("setting_id").Eq(settingID)).
+		Order(goqu.C("id").Asc()).Limit(1000)
+	if err := sd.Executor().ScanStructsContext(ctx, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// Create ...
+func (m *SettingOverride) Create(ctx context.Context, override *schema.SettingOverride) error {
+	rowsAffected, err := m.createOne(ctx, schema.TableSettingOverride, override)
+	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, override.SettingID, nil, nil)
+	}
+	return err
+}
+
+// Update ...
+func (m *SettingOverride) Update(ctx context.Context, overrideID int64, changed map[string]interface{}) (*schema.SettingOverride, error) {
+	override := &schema.SettingOverride{}
+	if _, err := m.updateByID(ctx, schema.TableSettingOverride, overrideID, goqu.Record(changed)); err != nil {
+		return nil, err
+	}
+	if err := m.findOneByID(ctx, schema.TableSettingOverride, overrideID, override); err != nil {
+		return nil, err
+	}
+	m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, override.SettingID, nil, nil)
+	return override, nil
+}
+
+// Delete ...
+func (m *SettingOverride) Delete(ctx context.Context, overrideID int64) (int64, error) {
+	override := &schema.SettingOverride{}
+	findErr := m.findOneByID(ctx, schema.TableSettingOverride, overrideID, override)
+	rowsAffected, err := m.deleteByID(ctx, schema.TableSettingOverride, overrideID)
+	if rowsAffected > 0 && findErr == nil {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, override.SettingID, nil, nil)
+	}
+	return rowsAffected, err
+}
+
+// applySettingOverrides 将 channel、client 下的覆盖值应用到已确定取值的配置项，
+// 同时匹配 channel 和 client 的覆盖优先，其次是只匹配 client、只匹配 channel 的覆盖
+func (m *Model) applySettingOverrides(ctx context.Context, settings []tpl.MySetting, channel, client string) error {
+	if len(settings) == 0 || (channel == "" && client == "") {
+		return nil
+	}
+	ids := make([]int64, 0, len(settings))
+	for _, s := range settings {
+		ids = append(ids, s.ID)
+	}
+
+	overrides := make([]schema.SettingOverride, 0)
+	sd := m.RdDB.From(schema.TableSettingOverride).
+		Where(
+			goqu.C("setting_id").In(ids),
+			goqu.C("channel").In("", channel),
+			goqu.C("client").In("", client))
+	if err := sd.Executor().ScanStructsContext(ctx, &overrides); err != nil {
+		return err
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	values := make(map[int64]string, len(overrides))
+	scores := make(map[int64]int, len(overrides))
+	for _, o := range overrides {
+		if sc := schema.OverrideScore(o.Channel, o.Client, channel, client); sc > scores[o.SettingID] {
+			values[o.SettingID], scores[o.SettingID] = o.Value, sc
+		}
+	}
+	for i := range settings {
+		if value, ok := values[settings[i].ID]; ok {
+			settings[i].Value = value
+		}
+	}
+	return nil
+}
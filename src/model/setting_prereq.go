@@ -0,0 +1,311 @@
+package model
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// maxPrereqDepth 前置条件链的最大长度
+const maxPrereqDepth = 8
+
+// settingPrereq 配置项的前置条件
+type settingPrereq struct {
+	ID          int64  `db:"id"`
+	PrereqID    int64  `db:"prereq_id"`
+	PrereqValue string `db:"prereq_value"`
+}
+
+// settingPrereqs 产品线下设置了前置条件的配置项，按配置项 ID 索引
+type settingPrereqs map[int64]settingPrereq
+
+// settingIDs 返回被依赖的配置项 ID
+func (ps settingPrereqs) settingIDs() []int64 {
+	set := make(map[int64]struct{}, len(ps))
+	ids := make([]int64, 0, len(ps))
+	for _, p := range ps {
+		if _, ok := set[p.PrereqID]; !ok {
+			set[p.PrereqID] = struct{}{}
+			ids = append(ids, p.PrereqID)
+		}
+	}
+	return ids
+}
+
+// met 判断配置项的前置条件链是否都满足，values 为用户或群组对配置项的取值
+func (ps settingPrereqs) met(settingID int64, values map[int64]string) bool {
+	for i := 0; i <= maxPrereqDepth; i++ {
+		p, ok := ps[settingID]
+		if !ok {
+			return true
+		}
+		if v, ok := values[p.PrereqID]; !ok || v != p.PrereqValue {
+			return false
+		}
+		settingID = p.PrereqID
+	}
+	return false
+}
+
+// filter 过滤掉前置条件不满足的配置项
+func (ps settingPrereqs) filter(settings []tpl.MySetting, values map[int64]string) []tpl.MySetting {
+	res := make([]tpl.MySetting, 0, len(settings))
+	for _, s := range settings {
+		if ps.met(s.ID, values) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// withDefaults 返回以默认值补全的取值，values 中的指派值优先
+func withDefaults(values, defaults map[int64]string) map[int64]string {
+	res := make(map[int64]string, len(values)+len(defaults))
+	for id, v := range defaults {
+		res[id] = v
+	}
+	for id, v := range values {
+		res[id] = v
+	}
+	return res
+}
+
+// findSettingPrereqs 返回产品线下设置了前置条件的配置项
+func (m *Model) findSettingPrereqs(ctx context.Context, productID int64) (settingPrereqs, error) {
+	rows := make([]settingPrereq, 0)
+	sd := m.RdDB.Select(
+		goqu.I("t1.id"),
+		goqu.I("t1.prereq_id"),
+		goqu.I("t1.prereq_value")).
+		From(
+			goqu.T(schema.TableSetting).As("t1"),
+			goqu.T(schema.TableModule).As("t2")).
+		Where(
+			goqu.I("t1.module_id").Eq(goqu.I("t2.id")),
+			goqu.I("t2.product_id").Eq(productID),
+			goqu.I("t1.prereq_id").Gt(0))
+	if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	ps := make(settingPrereqs, len(rows))
+	for _, row := range rows {
+		ps[row.ID] = row
+	}
+	return ps, nil
+}
+
+// findUsersSettingValues 返回用户对指定配置项的取值，按用户 ID、配置项 ID 索引。
+// 与 settings:unionAll 一致，用户与其群组的指派记录按产品线的冲突优先策略确定取值。
+func (m *Model) findUsersSettingValues(ctx context.Context, productID int64, userIDs, settingIDs []int64) (map[int64]map[int64]string, error) {
+	rows, err := m.findSettingSources(ctx, productID, userIDs, settingIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int64]map[int64]string, len(userIDs))
+	for _, row := range rows {
+		if res[row.UserID] == nil {
+			res[row.UserID] = make(map[int64]string)
+		}
+		if _, ok := res[row.UserID][row.SettingID]; !ok {
+			res[row.UserID][row.SettingID] = row.Value
+		}
+	}
+	return res, nil
+}
+
+// FilterByPrerequisites 过滤掉用户前置条件不满足的配置项，前置条件配置项未被指派时按 channel、client 下的默认值判断
+func (m *Setting) FilterByPrerequisites(ctx context.Context, productID, userID int64, channel, client string, settings []tpl.MySetting) ([]tpl.MySetting, error) {
+	return m.filterByPrerequisites(ctx, productID, userID, channel, client, settings, nil)
+}
+
+// filterByPrerequisites 同 FilterByPrerequisites，pending 为尚未写入的规则取值，优先于已有的指派记录
+func (m *Model) filterByPrerequisites(ctx context.Context, productID, userID int64, channel, client string, settings []tpl.MySetting, pending map[int64]string) ([]tpl.MySetting, error) {
+	ps, err := m.findSettingPrereqs(ctx, productID)
+	if err != nil || len(ps) == 0 || len(settings) == 0 {
+		return settings, err
+	}
+
+	ids := ps.settingIDs()
+	values, err := m.findUsersSettingValues(ctx, productID, []int64{userID}, ids)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := m.findSettingDefaults(ctx, ids, channel, client)
+	if err != nil {
+		return nil, err
+	}
+	return ps.filter(settings, withDefaults(withDefaults(pending, values[userID]), defaults)), nil
+}
+
+// FilterAnonymousByPrerequisites 过滤掉匿名用户前置条件不满足的配置项。
+// 匿名用户没有指派记录，前置条件按 settings 中命中规则的值或默认值判断。
+func (m *Setting) FilterAnonymousByPrerequisites(ctx context.Context, productID int64, settings []tpl.MySetting) ([]tpl.MySetting, error) {
+	ps, err := m.findSettingPrereqs(ctx, productID)
+	if err != nil || len(ps) == 0 || len(settings) == 0 {
+		return settings, err
+	}
+
+	values := make(map[int64]string, len(settings))
+	for _, s := range settings {
+		if _, ok := values[s.ID]; !ok {
+			values[s.ID] = s.Value
+		}
+	}
+	return ps.filter(settings, values), nil
+}
+
+// CheckPrerequisite 检查配置项以 prereqID 为前置条件时是否构成循环依赖或依赖链过长，settingID 为 0 表示新建配置项。
+// 配置项已被其它配置项依赖时，依赖链长度包括依赖它的配置项。
+func (m *Setting) CheckPrerequisite(ctx context.Context, settingID, prereqID int64) error {
+	depth := 0
+	if settingID > 0 {
+		ids := []int64{settingID}
+		for {
+			dependents := make([]int64, 0)
+			sd := m.DB.From(schema.TableSetting).Select(goqu.C("id")).Where(goqu.C("prereq_id").In(ids))
+			if err := sd.Executor().ScanValsContext(ctx, &dependents); err != nil {
+				return err
+			}
+			if len(dependents) == 0 {
+				break
+			}
+			if tpl.Int64SliceHas(dependents, prereqID) {
+				return gear.ErrBadRequest.WithMsg("cyclic prerequisite")
+			}
+			if depth++; depth >= maxPrereqDepth {
+				return gear.ErrBadRequest.WithMsgf("prerequisite chain too long (<= %d)", maxPrereqDepth)
+			}
+			ids = dependents
+		}
+	}
+
+	id := prereqID
+	for i := depth; i < maxPrereqDepth; i++ {
+		if id == settingID {
+			return gear.ErrBadRequest.WithMsg("cyclic prerequisite")
+		}
+		setting, err := m.AcquireByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if setting.PrereqID == 0 {
+			return nil
+		}
+		id = setting.PrereqID
+	}
+	return gear.ErrBadRequest.WithMsgf("prerequisite chain too long (<= %d)", maxPrereqDepth)
+}
+
+// FilterUsersByPrerequisites 将用户分为前置条件满足与不满足两组，不存在的用户按不满足处理
+func (m *Setting) FilterUsersByPrerequisites(ctx context.Context, productID int64, setting *schema.Setting, uids []string) ([]string, []string, error) {
+	if setting.PrereqID == 0 || len(uids) == 0 {
+		return uids, nil, nil
+	}
+	ps, err := m.findSettingPrereqs(ctx, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]schema.User, 0)
+	sd := m.RdDB.From(schema.TableUser).Select(goqu.C("id"), goqu.C("uid")).
+		Where(goqu.C("uid").In(tpl.StrSliceToInterface(uids)...))
+	if err := sd.Executor().ScanStructsContext(ctx, &users); err != nil {
+		return nil, nil, err
+	}
+	userIDs := make([]int64, 0, len(users))
+	ids := make(map[string]int64, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+		ids[user.UID] = user.ID
+	}
+
+	values := map[int64]map[int64]string{}
+	if len(userIDs) > 0 {
+		if values, err = m.findUsersSettingValues(ctx, productID, userIDs, ps.settingIDs()); err != nil {
+			return nil, nil, err
+		}
+	}
+	// 指派时没有 channel、client，按基础默认值判断
+	defaults, err := m.findSettingDefaults(ctx, ps.settingIDs(), "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	met := make([]string, 0, len(uids))
+	skipped := make([]string, 0)
+	for _, uid := range uids {
+		if id, ok := ids[uid]; ok && ps.met(setting.ID, withDefaults(values[id], defaults)) {
+			met = append(met, uid)
+		} else {
+			skipped = append(skipped, uid)
+		}
+	}
+	return met, skipped, nil
+}
+
+// FilterGroupsByPrerequisites 将群组分为前置条件满足与不满足两组，群组只按其自身的配置项取值判断，不存在的群组按不满足处理
+func (m *Setting) FilterGroupsByPrerequisites(ctx context.Context, productID int64, setting *schema.Setting, groups []*tpl.GroupKindUID) ([]*tpl.GroupKindUID, []string, error) {
+	if setting.PrereqID == 0 || len(groups) == 0 {
+		return groups, nil, nil
+	}
+	ps, err := m.findSettingPrereqs(ctx, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := make([]struct {
+		Kind      string `db:"kind"`
+		UID       string `db:"uid"`
+		SettingID int64  `db:"setting_id"`
+		Value     string `db:"value"`
+	}, 0)
+	uids := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		uids = append(uids, g.UID)
+	}
+	sd := m.RdDB.Select(
+		goqu.I("t1.kind"),
+		goqu.I("t1.uid"),
+		goqu.I("t2.setting_id"),
+		goqu.I("t2.value")).
+		From(
+			goqu.T(schema.TableGroup).As("t1"),
+			goqu.T(schema.TableGroupSetting).As("t2")).
+		Where(
+			goqu.I("t1.uid").In(uids...),
+			goqu.I("t1.id").Eq(goqu.I("t2.group_id")),
+			goqu.I("t2.setting_id").In(ps.settingIDs()))
+	if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]map[int64]string)
+	for _, row := range rows {
+		key := row.Kind + ":" + row.UID
+		if values[key] == nil {
+			values[key] = make(map[int64]string)
+		}
+		values[key][row.SettingID] = row.Value
+	}
+
+	defaults, err := m.findSettingDefaults(ctx, ps.settingIDs(), "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	met := make([]*tpl.GroupKindUID, 0, len(groups))
+	skipped := make([]string, 0)
+	for _, g := range groups {
+		if ps.met(setting.ID, withDefaults(values[g.Kind+":"+g.UID], defaults)) {
+			met = append(met, g)
+		} else {
+			skipped = append(skipped, g.UID)
+		}
+	}
+	return met, skipped, nil
+}
@@ -2,7 +2,7 @@ package model
 
 import (
 	"context"
-	"hash/crc32"
+	"time"
 
 	"github.com/doug-martin/goqu/v9"
 	"github.com/doug-martin/goqu/v9/exp"
@@ -17,35 +17,34 @@ type SettingRule struct {
 }
 
 // ApplyRules ...
-func (m *SettingRule) ApplyRules(ctx context.Context, productID, userID int64, kind string) error {
-	rules := []schema.SettingRule{}
-	exps := []exp.Expression{goqu.C("kind").Eq(kind)}
+func (m *SettingRule) ApplyRules(ctx context.Context, productID, userID int64, kinds ...string) error {
+	exps := []exp.Expression{goqu.C("kind").In(kinds)}
 	if productID > 0 {
 		exps = append(exps, goqu.C("product_id").Eq(productID))
 	}
-	sd := m.RdDB.From(schema.TableSettingRule).
-		Where(exps...).
-		Order(goqu.C("updated_at").Desc()).Limit(1000)
-	err := sd.Executor().ScanStructsContext(ctx, &rules)
+	rules, err := m.findRules(ctx, exps...)
+	if err != nil {
+		return err
+	}
+
+	matches, err := m.matchRules(ctx, schema.TableSetting, newUserSubject(userID), settingRuleEntries(rules), time.Now().UTC())
 	if err != nil {
 		return err
 	}
 
-	ids := make([]interface{}, 0)
+	values := make(map[int64]string, len(rules))
 	for _, rule := range rules {
-		p := rule.ToPercent()
-		if p > 0 && (int((userID+rule.CreatedAt.Unix())%100) <= p) {
-			// 百分比规则无效或者用户不在百分比区间内
-			ids = append(ids, rule.ID)
-		}
+		values[rule.ID] = rule.Value
+	}
+	ids := make([]interface{}, 0, len(matches))
+	rows := make([]interface{}, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.ruleID)
+		rows = append(rows, ruleSettingRecord(userID, match, values[match.ruleID]))
 	}
 
 	if len(ids) > 0 {
-		sd := m.DB.Insert(schema.TableUserSetting).Cols("user_id", "setting_id", "rls", "value").
-			FromQuery(goqu.From(goqu.T(schema.TableSettingRule).As("t1")).
-				Select(goqu.V(userID), goqu.I("t1.setting_id"), goqu.I("t1.rls"), goqu.I("t1.value")).
-				Where(goqu.I("t1.id").In(ids...))).
-			OnConflict(goqu.DoNothing())
+		sd := m.DB.Insert(schema.TableUserSetting).Rows(rows...).OnConflict(goqu.DoNothing())
 		rowsAffected, err := service.DeResult(sd.Executor().ExecContext(ctx))
 		if err != nil {
 			return err
@@ -53,15 +52,8 @@ func (m *SettingRule) ApplyRules(ctx context.Context, productID, userID int64, k
 
 		if rowsAffected > 0 {
 			settingIDs := make([]int64, 0)
-			sd := m.DB.Select(goqu.I("t1.setting_id")).
-				From(
-					goqu.T(schema.TableSettingRule).As("t1"),
-					goqu.T(schema.TableUserSetting).As("t2")).
-				Where(
-					goqu.I("t1.id").In(ids...),
-					goqu.I("t1.setting_id").Eq(goqu.I("t2.setting_id")),
-					goqu.I("t1.rls").Eq(goqu.I("t2.rls")),
-					goqu.I("t2.user_id").Eq(userID)).
+			sd := m.DB.From(schema.TableUserSetting).Select(goqu.C("setting_id")).
+				Where(goqu.C("user_id").Eq(userID), goqu.C("rule_id").In(ids...)).
 				Limit(1000)
 			if err := sd.Executor().ScanValsContext(ctx, &settingIDs); err != nil {
 				return err
@@ -76,81 +68,115 @@ func (m *SettingRule) ApplyRules(ctx context.Context, productID, userID int64, k
 }
 
 // ApplyRulesToAnonymous ...
-func (m *SettingRule) ApplyRulesToAnonymous(ctx context.Context, anonymousID string, productID int64, channel, client string, kind string) ([]tpl.MySetting, error) {
-	rules := []schema.SettingRule{}
-	sd := m.RdDB.From(schema.TableSettingRule).
-		Where(goqu.C("product_id").Eq(productID), goqu.C("kind").Eq(kind)).
-		Order(goqu.C("updated_at").Desc()).Limit(1000)
-	err := sd.Executor().ScanStructsContext(ctx, &rules)
+func (m *SettingRule) ApplyRulesToAnonymous(ctx context.Context, anonymousID string, productID int64, channel, client, version string, kinds ...string) ([]tpl.MySetting, error) {
+	rules, err := m.findRules(ctx, goqu.C("product_id").Eq(productID), goqu.C("kind").In(kinds))
 	if err != nil {
 		return nil, err
 	}
 
-	anonID := int64(crc32.ChecksumIEEE([]byte(anonymousID)))
-	ids := make([]interface{}, 0)
-	for _, rule := range rules {
-		p := rule.ToPercent()
-		if p > 0 && (int((anonID+rule.CreatedAt.Unix())%100) <= p) {
-			// 百分比规则无效或者用户不在百分比区间内
-			ids = append(ids, rule.ID)
-		}
+	matches, err := m.matchRules(ctx, schema.TableSetting, newAnonymousSubject(anonymousID), settingRuleEntries(rules), time.Now().UTC())
+	if err != nil {
+		return nil, err
 	}
+	data, _, err := m.findRuleSettings(ctx, matches, channel, client, version)
+	return data, err
+}
 
+// findRuleSettings 返回命中规则的配置项，取值为规则的配置值，多变量规则使用命中的 variant 配置值，并应用 channel、client 下的覆盖值。
+// 同一配置项命中多条规则时只取 matches 中靠前的规则，channel、client 或客户端版本不匹配的配置项不返回。
+// 返回的规则与配置项一一对应。
+func (m *Model) findRuleSettings(ctx context.Context, matches []ruleMatch, channel, client, version string) ([]tpl.MySetting, []ruleMatch, error) {
 	data := make([]tpl.MySetting, 0)
-	if len(ids) > 0 {
-		sd := m.RdDB.Select(
-			goqu.I("t1.rls"),
-			goqu.I("t1.updated_at").As("assigned_at"),
-			goqu.I("t1.value"),
-			goqu.I("t2.id"),
-			goqu.I("t2.name"),
-			goqu.I("t2.description"),
-			goqu.I("t2.channels"),
-			goqu.I("t2.clients"),
-			goqu.I("t3.name").As("module")).
-			From(
-				goqu.T(schema.TableSettingRule).As("t1"),
-				goqu.T(schema.TableSetting).As("t2"),
-				goqu.T(schema.TableModule).As("t3")).
-			Where(
-				goqu.I("t1.id").In(ids),
-				goqu.I("t1.setting_id").Eq(goqu.I("t2.id")),
-				goqu.I("t2.module_id").Eq(goqu.I("t3.id"))).
-			Order(goqu.I("t1.updated_at").Desc())
-
-		scanner, err := sd.Executor().ScannerContext(ctx)
-		if err != nil {
-			return nil, err
-		}
+	hits := make([]ruleMatch, 0)
+	if len(matches) == 0 {
+		return data, hits, nil
+	}
 
-		for scanner.Next() {
-			mySetting := tpl.MySetting{}
-			if err := scanner.ScanStruct(&mySetting); err != nil {
-				scanner.Close()
-				return nil, err
-			}
+	ids := make([]int64, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.ruleID)
+	}
+	rows := make([]struct {
+		tpl.MySetting
+		RuleID int64 `db:"rule_id"`
+	}, 0, len(ids))
+	sd := m.RdDB.Select(
+		goqu.I("t1.id").As("rule_id"),
+		goqu.I("t1.rls"),
+		goqu.I("t1.updated_at").As("assigned_at"),
+		goqu.I("t1.value"),
+		goqu.I("t2.id"),
+		goqu.I("t2.name"),
+		goqu.I("t2.description"),
+		goqu.I("t2.channels"),
+		goqu.I("t2.clients"),
+		goqu.I("t2.value_type"),
+		goqu.I("t2.min_version"),
+		goqu.I("t2.max_version"),
+		goqu.I("t3.name").As("module")).
+		From(
+			goqu.T(schema.TableSettingRule).As("t1"),
+			goqu.T(schema.TableSetting).As("t2"),
+			goqu.T(schema.TableModule).As("t3")).
+		Where(
+			goqu.I("t1.id").In(ids),
+			goqu.I("t1.setting_id").Eq(goqu.I("t2.id")),
+			goqu.I("t2.module_id").Eq(goqu.I("t3.id")))
+	if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+		return nil, nil, err
+	}
+	settings := make(map[int64]tpl.MySetting, len(rows))
+	for _, row := range rows {
+		settings[row.RuleID] = row.MySetting
+	}
+
+	set := make(map[int64]struct{}, len(matches))
+	for _, match := range matches {
+		mySetting, ok := settings[match.ruleID]
+		if !ok {
+			continue
+		}
+		if _, ok := set[mySetting.ID]; ok {
+			continue // 去重
+		}
+		set[mySetting.ID] = struct{}{}
 
-			if mySetting.Channels != "" {
-				if !tpl.StringSliceHas(tpl.StringToSlice(mySetting.Channels), channel) {
-					continue // channel 不匹配
-				}
+		if match.kind == schema.RuleUserVariant {
+			mySetting.Value = match.value
+		}
+		if mySetting.Channels != "" {
+			if !tpl.StringSliceHas(tpl.StringToSlice(mySetting.Channels), channel) {
+				continue // channel 不匹配
 			}
-			if mySetting.Clients != "" {
-				if !tpl.StringSliceHas(tpl.StringToSlice(mySetting.Clients), client) {
-					continue // client 不匹配
-				}
+		}
+		if mySetting.Clients != "" {
+			if !tpl.StringSliceHas(tpl.StringToSlice(mySetting.Clients), client) {
+				continue // client 不匹配
 			}
-
-			mySetting.HID = service.IDToHID(mySetting.ID, "setting")
-			data = append(data, mySetting)
 		}
-
-		scanner.Close()
-		if err := scanner.Err(); err != nil {
-			return nil, err
+		if !schema.VersionInRange(version, mySetting.MinVersion, mySetting.MaxVersion) {
+			continue // 客户端版本不在适用范围内
 		}
+
+		mySetting.HID = service.IDToHID(mySetting.ID, "setting")
+		data = append(data, mySetting)
+		hits = append(hits, match)
+	}
+
+	if err := m.applySettingOverrides(ctx, data, channel, client); err != nil {
+		return nil, nil, err
 	}
-	return data, nil
+	return data, hits, nil
+}
+
+// findRules 从只读库中读取符合条件的规则，按更新时间倒序
+func (m *SettingRule) findRules(ctx context.Context, exps ...exp.Expression) ([]schema.SettingRule, error) {
+	rules := []schema.SettingRule{}
+	sd := m.RdDB.From(schema.TableSettingRule).Where(exps...).Order(goqu.C("updated_at").Desc()).Limit(1000)
+	if err := sd.Executor().ScanStructsContext(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
 }
 
 // Acquire ...
@@ -162,6 +188,11 @@ func (m *SettingRule) Acquire(ctx context.Context, settingRuleID int64) (*schema
 	return settingRule, nil
 }
 
+// FindBySetting 返回配置项的全部发布规则
+func (m *SettingRule) FindBySetting(ctx context.Context, settingID int64) ([]schema.SettingRule, error) {
+	return m.findRules(ctx, goqu.C("setting_id").Eq(settingID))
+}
+
 // Find ...
 func (m *SettingRule) Find(ctx context.Context, productID, settingID int64) ([]schema.SettingRule, error) {
 	settingRules := make([]schema.SettingRule, 0)
@@ -178,7 +209,10 @@ func (m *SettingRule) Find(ctx context.Context, productID, settingID int64) ([]s
 
 // Create ...
 func (m *SettingRule) Create(ctx context.Context, settingRule *schema.SettingRule) error {
-	_, err := m.createOne(ctx, schema.TableSettingRule, settingRule)
+	rowsAffected, err := m.createOne(ctx, schema.TableSettingRule, settingRule)
+	if rowsAffected > 0 {
+		m.tryEmitChanges(ctx, schema.ChangeKindSetting, settingRule.ProductID, nil, nil)
+	}
 	return err
 }
 
@@ -191,10 +225,17 @@ func (m *SettingRule) Update(ctx context.Context, settingRuleID int64, changed m
 	if err := m.findOneByID(ctx, schema.TableSettingRule, settingRuleID, settingRule); err != nil {
 		return nil, err
 	}
+	m.tryEmitChanges(ctx, schema.ChangeKindSetting, settingRule.ProductID, nil, nil)
 	return settingRule, nil
 }
 
 // Delete ...
 func (m *SettingRule) Delete(ctx context.Context, id int64) (int64, error) {
-	return m.deleteByID(ctx, schema.TableSettingRule, id)
+	settingRule := &schema.SettingRule{}
+	findErr := m.findOneByID(ctx, schema.TableSettingRule, id, settingRule)
+	rowsAffected, err := m.deleteByID(ctx, schema.TableSettingRule, id)
+	if rowsAffected > 0 && findErr == nil {
+		m.tryEmitChanges(ctx, schema.ChangeKindSetting, settingRule.ProductID, nil, nil)
+	}
+	return rowsAffected, err
 }
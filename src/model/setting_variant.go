@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// SettingVariant ...
+type SettingVariant struct {
+	*Model
+}
+
+// Acquire 根据配置项 ID 和变体名称返回变体数据
+func (m *SettingVariant) Acquire(ctx context.Context, settingID int64, name string) (*schema.SettingVariant, error) {
+	variant := &schema.SettingVariant{}
+	ok, err := m.findOneByCols(ctx, schema.TableSettingVariant, goqu.Ex{"setting_id": settingID, "name": name}, "", variant)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, gear.ErrNotFound.WithMsgf("setting variant %s not found", name)
+	}
+	return variant, nil
+}
+
+// Find 返回配置项的全部变体，不包含变体内容
+func (m *SettingVariant) Find(ctx context.Context, settingID int64) ([]schema.SettingVariant, error) {
+	variants := make([]schema.SettingVariant, 0)
+	sd := m.RdDB.Select(
+		goqu.C("id"),
+		goqu.C("created_at"),
+		goqu.C("updated_at"),
+		goqu.C("setting_id"),
+		goqu.C("name"),
+		goqu.C("size"),
+		goqu.C("hash")).
+		From(schema.TableSettingVariant).
+		Where(goqu.C("setting_id").Eq(settingID)).
+		Order(goqu.C("id").Asc()).Limit(1000)
+	if err := sd.Executor().ScanStructsContext(ctx, &variants); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+// Count 返回配置项的变体数
+func (m *SettingVariant) Count(ctx context.Context, settingID int64) (int, error) {
+	count, err := m.DB.From(schema.TableSettingVariant).Where(goqu.C("setting_id").Eq(settingID)).CountContext(ctx)
+	return int(count), err
+}
+
+// Create ...
+func (m *SettingVariant) Create(ctx context.Context, variant *schema.SettingVariant) error {
+	variant.Size = len(variant.Payload)
+	variant.Hash = schema.PayloadHash(variant.Payload)
+	rowsAffected, err := m.createOne(ctx, schema.TableSettingVariant, variant)
+	if rowsAffected > 0 {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, variant.SettingID, nil, nil)
+	}
+	return err
+}
+
+// UpdatePayload 更新变体内容
+func (m *SettingVariant) UpdatePayload(ctx context.Context, variantID int64, payload string) (*schema.SettingVariant, error) {
+	variant := &schema.SettingVariant{}
+	if _, err := m.updateByID(ctx, schema.TableSettingVariant, variantID, goqu.Record{
+		"payload": payload,
+		"size":    len(payload),
+		"hash":    schema.PayloadHash(payload),
+	}); err != nil {
+		return nil, err
+	}
+	if err := m.findOneByID(ctx, schema.TableSettingVariant, variantID, variant); err != nil {
+		return nil, err
+	}
+	m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, variant.SettingID, nil, nil)
+	return variant, nil
+}
+
+// Delete ...
+func (m *SettingVariant) Delete(ctx context.Context, variantID int64) (int64, error) {
+	variant := &schema.SettingVariant{}
+	findErr := m.findOneByID(ctx, schema.TableSettingVariant, variantID, variant)
+	rowsAffected, err := m.deleteByID(ctx, schema.TableSettingVariant, variantID)
+	if rowsAffected > 0 && findErr == nil {
+		m.tryEmitTargetChanges(ctx, schema.ChangeKindSetting, variant.SettingID, nil, nil)
+	}
+	return rowsAffected, err
+}
+
+// FillPayloads 为取值为变体名称的配置项填充变体内容及摘要
+func (m *SettingVariant) FillPayloads(ctx context.Context, settings []tpl.MySetting) error {
+	if len(settings) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(settings))
+	names := make([]interface{}, 0, len(settings))
+	for _, s := range settings {
+		ids = append(ids, s.ID)
+		names = append(names, s.Value)
+	}
+
+	variants := make([]schema.SettingVariant, 0)
+	sd := m.RdDB.Select(
+		goqu.C("setting_id"),
+		goqu.C("name"),
+		goqu.C("payload"),
+		goqu.C("hash")).
+		From(schema.TableSettingVariant).
+		Where(goqu.C("setting_id").In(ids), goqu.C("name").In(names...))
+	if err := sd.Executor().ScanStructsContext(ctx, &variants); err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+
+	type variantKey struct {
+		settingID int64
+		name      string
+	}
+	index := make(map[variantKey]*schema.SettingVariant, len(variants))
+	for i := range variants {
+		index[variantKey{variants[i].SettingID, variants[i].Name}] = &variants[i]
+	}
+	for i := range settings {
+		if v, ok := index[variantKey{settings[i].ID, settings[i].Value}]; ok {
+			payload := v.Payload
+			settings[i].Payload = &payload
+			settings[i].PayloadHash = v.Hash
+		}
+	}
+	return nil
+}
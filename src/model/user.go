@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"database/sql"
+	"sort"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
@@ -33,6 +34,23 @@ func (m *User) FindByUID(ctx context.Context, uid string, selectStr string) (*sc
 	return user, nil
 }
 
+// FindByUIDs 根据 uids 返回存在的 users 数据
+func (m *User) FindByUIDs(ctx context.Context, uids []string) ([]schema.User, error) {
+	users := make([]schema.User, 0, len(uids))
+	if len(uids) == 0 {
+		return users, nil
+	}
+	db := m.DB
+	if ctx.Value(ReadDB) != nil {
+		db = m.RdDB
+	}
+	sd := db.From(schema.TableUser).Where(goqu.C("uid").In(tpl.StrSliceToInterface(uids)...))
+	if err := sd.Executor().ScanStructsContext(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // Acquire ...
 func (m *User) Acquire(ctx context.Context, uid string) (*schema.User, error) {
 	user, err := m.FindByUID(ctx, uid, "")
@@ -112,115 +130,309 @@ func (m *User) RefreshLabels(ctx context.Context, id int64, now int64, force boo
 			return nil
 		}
 
-		data := user.GetCacheMap()
-		for _, v := range data { // 清空旧标签缓存
-			v.Labels = make([]schema.UserCacheLabel, 0)
-			if product == "" {
-				v.ActiveAt = now //强制刷新缓存，更新所有活跃时间
-			}
+		data := resetCacheMap(user, product, now)
+		infos, err := findUserLabels(ctx, tx, id)
+		if err != nil {
+			return err
 		}
-		if product != "" {
-			// 放在外面赋值，是为了新产品的第一次更新。
-			data[product] = &schema.UserCache{
-				Labels:   make([]schema.UserCacheLabel, 0),
-				ActiveAt: now, // 只更新该产品缓存的刷新时间
-			}
+		for _, info := range infos {
+			labelIDs = append(labelIDs, info.ID)
+			appendCacheLabel(data, info)
 		}
 
-		sd = tx.Select(
-			goqu.I("t1.created_at"),
-			goqu.I("t2.id"),
-			goqu.I("t2.name"),
-			goqu.I("t2.channels"),
-			goqu.I("t2.clients"),
-			goqu.I("t3.name").As("product")).
-			From(
-				goqu.T(schema.TableUserLabel).As("t1"),
-				goqu.T(schema.TableLabel).As("t2"),
-				goqu.T(schema.TableProduct).As("t3")).
-			Where(
-				goqu.I("t1.user_id").Eq(id),
-				goqu.I("t1.label_id").Eq(goqu.I("t2.id")),
-				goqu.I("t2.product_id").Eq(goqu.I("t3.id"))).
-			Order(goqu.I("t1.id").Desc()).Limit(200)
-
-		sd = sd.UnionAll(tx.Select(
-			goqu.I("t2.created_at"),
-			goqu.I("t3.id"),
-			goqu.I("t3.name"),
-			goqu.I("t3.channels"),
-			goqu.I("t3.clients"),
-			goqu.I("t4.name").As("product")).
-			From(
-				goqu.T(schema.TableUserGroup).As("t1"),
-				goqu.T(schema.TableGroupLabel).As("t2"),
-				goqu.T(schema.TableLabel).As("t3"),
-				goqu.T(schema.TableProduct).As("t4")).
-			Where(
-				goqu.I("t1.user_id").Eq(id),
-				goqu.I("t1.group_id").Eq(goqu.I("t2.group_id")),
-				goqu.I("t2.label_id").Eq(goqu.I("t3.id")),
-				goqu.I("t3.product_id").Eq(goqu.I("t4.id"))).
-			Order(goqu.I("t2.id").Desc()).Limit(200)).
-			Order(goqu.C("created_at").Desc())
+		refreshed = true
+		user.ActiveAt = time.Now().UTC().Unix()
+		_ = user.PutCacheMap(data)
+		_, err = service.DeResult(tx.Update(schema.TableUser).
+			Where(goqu.C("id").Eq(id)).
+			Set(goqu.Record{"labels": user.Labels, "active_at": user.ActiveAt}).
+			Executor().ExecContext(ctx))
+		return err
+	})
 
-		scanner, err := sd.Executor().ScannerContext(ctx)
-		if err != nil {
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return user, labelIDs, refreshed, nil
+}
+
+// RefreshUsersLabels 在一个事务中批量更新多个 user 上指定产品的 labels 缓存，包括通过 group 关系获得的 labels。
+// 返回 ids 中存在的 users，以及被刷新的 user 的全部 label ID；非 force 时跳过已被其它请求更新的 user。
+func (m *User) RefreshUsersLabels(ctx context.Context, ids []int64, now int64, force bool, product string) ([]schema.User, map[int64][]int64, error) {
+	users := make([]schema.User, 0, len(ids))
+	labelIDs := make(map[int64][]int64)
+	if len(ids) == 0 {
+		return users, labelIDs, nil
+	}
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Wrap(func() error {
+		// 按 id 顺序加锁，避免并发批量刷新时死锁
+		sd := tx.From(schema.TableUser).Where(goqu.C("id").In(ids)).
+			ForUpdate(exp.Wait).Order(goqu.C("id").Asc())
+		if err := sd.Executor().ScanStructsContext(ctx, &users); err != nil {
 			return err
 		}
 
-		set := make(map[int64]struct{})
-		for scanner.Next() {
-			myLabelInfo := schema.MyLabelInfo{}
-			if err := scanner.ScanStruct(&myLabelInfo); err != nil {
-				scanner.Close()
-				return err
+		refreshIDs := make([]int64, 0, len(users))
+		dataMap := make(map[int64]schema.UserCacheLabelMap, len(users))
+		for i := range users {
+			if !force && !conf.Config.IsCacheLabelExpired(now-5, users[i].GetCache(product).ActiveAt) {
+				continue // 已被其它请求更新
 			}
-			if _, ok := set[myLabelInfo.ID]; ok {
-				continue // 去重
+			refreshIDs = append(refreshIDs, users[i].ID)
+			dataMap[users[i].ID] = resetCacheMap(&users[i], product, now)
+			labelIDs[users[i].ID] = make([]int64, 0)
+		}
+		if len(refreshIDs) == 0 {
+			return nil
+		}
+
+		usersInfos, err := findUsersLabels(ctx, tx, refreshIDs)
+		if err != nil {
+			return err
+		}
+		for _, userID := range refreshIDs {
+			for _, info := range usersInfos[userID] {
+				labelIDs[userID] = append(labelIDs[userID], info.ID)
+				appendCacheLabel(dataMap[userID], info)
 			}
-			set[myLabelInfo.ID] = struct{}{}
+		}
 
-			labelIDs = append(labelIDs, myLabelInfo.ID)
-			uclm, ok := data[myLabelInfo.Product]
+		activeAt := time.Now().UTC().Unix()
+		for i := range users {
+			data, ok := dataMap[users[i].ID]
 			if !ok {
-				uclm = &schema.UserCache{
-					Labels: make([]schema.UserCacheLabel, 0),
-				}
-				data[myLabelInfo.Product] = uclm
+				continue
+			}
+			users[i].ActiveAt = activeAt
+			_ = users[i].PutCacheMap(data)
+			_, err = service.DeResult(tx.Update(schema.TableUser).
+				Where(goqu.C("id").Eq(users[i].ID)).
+				Set(goqu.Record{"labels": users[i].Labels, "active_at": users[i].ActiveAt}).
+				Executor().ExecContext(ctx))
+			if err != nil {
+				return err
 			}
-			data[myLabelInfo.Product].Labels = append(uclm.Labels, schema.UserCacheLabel{
-				Label:    myLabelInfo.Name,
-				Clients:  tpl.StringToSlice(myLabelInfo.Clients),
-				Channels: tpl.StringToSlice(myLabelInfo.Channels),
-			})
 		}
+		return nil
+	})
 
-		if err := scanner.Close(); err != nil {
-			return err
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, labelIDs, nil
+}
+
+// findUserLabels 在事务中查询 user 直接指派及通过 group 获得的 labels（各取最近的 200 个），按指派时间倒序去重
+func findUserLabels(ctx context.Context, tx *goqu.TxDatabase, userID int64) ([]schema.MyLabelInfo, error) {
+	sd := tx.Select(
+		goqu.I("t1.created_at"),
+		goqu.I("t2.id"),
+		goqu.I("t2.name"),
+		goqu.I("t2.channels"),
+		goqu.I("t2.clients"),
+		goqu.I("t2.min_version"),
+		goqu.I("t2.max_version"),
+		goqu.I("t3.name").As("product")).
+		From(
+			goqu.T(schema.TableUserLabel).As("t1"),
+			goqu.T(schema.TableLabel).As("t2"),
+			goqu.T(schema.TableProduct).As("t3")).
+		Where(
+			goqu.I("t1.user_id").Eq(userID),
+			goqu.I("t1.label_id").Eq(goqu.I("t2.id")),
+			goqu.I("t2.product_id").Eq(goqu.I("t3.id"))).
+		Order(goqu.I("t1.id").Desc()).Limit(200)
+
+	sd = sd.UnionAll(tx.Select(
+		goqu.I("t2.created_at"),
+		goqu.I("t3.id"),
+		goqu.I("t3.name"),
+		goqu.I("t3.channels"),
+		goqu.I("t3.clients"),
+		goqu.I("t3.min_version"),
+		goqu.I("t3.max_version"),
+		goqu.I("t4.name").As("product")).
+		From(
+			goqu.T(schema.TableUserGroup).As("t1"),
+			goqu.T(schema.TableGroupLabel).As("t2"),
+			goqu.T(schema.TableLabel).As("t3"),
+			goqu.T(schema.TableProduct).As("t4")).
+		Where(
+			goqu.I("t1.user_id").Eq(userID),
+			goqu.I("t1.group_id").Eq(goqu.I("t2.group_id")),
+			goqu.I("t2.label_id").Eq(goqu.I("t3.id")),
+			goqu.I("t3.product_id").Eq(goqu.I("t4.id"))).
+		Order(goqu.I("t2.id").Desc()).Limit(200)).
+		Order(goqu.C("created_at").Desc())
+
+	scanner, err := sd.Executor().ScannerContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]schema.MyLabelInfo, 0)
+	set := make(map[int64]struct{})
+	for scanner.Next() {
+		myLabelInfo := schema.MyLabelInfo{}
+		if err := scanner.ScanStruct(&myLabelInfo); err != nil {
+			scanner.Close()
+			return nil, err
 		}
-		if err := scanner.Err(); err != nil {
-			return err
+		if _, ok := set[myLabelInfo.ID]; ok {
+			continue // 去重
 		}
+		set[myLabelInfo.ID] = struct{}{}
 
-		refreshed = true
-		user.ActiveAt = time.Now().UTC().Unix()
-		_ = user.PutCacheMap(data)
-		_, err = service.DeResult(tx.Update(schema.TableUser).
-			Where(goqu.C("id").Eq(id)).
-			Set(goqu.Record{"labels": user.Labels, "active_at": user.ActiveAt}).
-			Executor().ExecContext(ctx))
-		return err
+		infos = append(infos, myLabelInfo)
+	}
+
+	if err := scanner.Close(); err != nil {
+		return nil, err
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// userLabelInfo 批量查询用户 labels 时的一条指派记录
+type userLabelInfo struct {
+	schema.MyLabelInfo
+	UserID  int64 `db:"user_id"`
+	RowID   int64 `db:"row_id"`
+	ByGroup int   `db:"by_group"`
+}
+
+// findUsersLabels 批量版本的 findUserLabels，一次查询全部用户的 labels，
+// 每个用户的直接指派与群组指派仍各取最近的 200 个，避免标签多的用户挤占其它用户
+func findUsersLabels(ctx context.Context, tx *goqu.TxDatabase, userIDs []int64) (map[int64][]schema.MyLabelInfo, error) {
+	sd := tx.Select(
+		goqu.I("t1.user_id"),
+		goqu.I("t1.id").As("row_id"),
+		goqu.L("0").As("by_group"),
+		goqu.I("t1.created_at"),
+		goqu.I("t2.id"),
+		goqu.I("t2.name"),
+		goqu.I("t2.channels"),
+		goqu.I("t2.clients"),
+		goqu.I("t2.min_version"),
+		goqu.I("t2.max_version"),
+		goqu.I("t3.name").As("product")).
+		From(
+			goqu.T(schema.TableUserLabel).As("t1"),
+			goqu.T(schema.TableLabel).As("t2"),
+			goqu.T(schema.TableProduct).As("t3")).
+		Where(
+			goqu.I("t1.user_id").In(userIDs),
+			goqu.I("t1.label_id").Eq(goqu.I("t2.id")),
+			goqu.I("t2.product_id").Eq(goqu.I("t3.id")))
+
+	sd = sd.UnionAll(tx.Select(
+		goqu.I("t1.user_id"),
+		goqu.I("t2.id").As("row_id"),
+		goqu.L("1").As("by_group"),
+		goqu.I("t2.created_at"),
+		goqu.I("t3.id"),
+		goqu.I("t3.name"),
+		goqu.I("t3.channels"),
+		goqu.I("t3.clients"),
+		goqu.I("t3.min_version"),
+		goqu.I("t3.max_version"),
+		goqu.I("t4.name").As("product")).
+		From(
+			goqu.T(schema.TableUserGroup).As("t1"),
+			goqu.T(schema.TableGroupLabel).As("t2"),
+			goqu.T(schema.TableLabel).As("t3"),
+			goqu.T(schema.TableProduct).As("t4")).
+		Where(
+			goqu.I("t1.user_id").In(userIDs),
+			goqu.I("t1.group_id").Eq(goqu.I("t2.group_id")),
+			goqu.I("t2.label_id").Eq(goqu.I("t3.id")),
+			goqu.I("t3.product_id").Eq(goqu.I("t4.id"))))
+
+	rows := make([]userLabelInfo, 0)
+	if err := sd.Executor().ScanStructsContext(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	// 与 findUserLabels 一致：直接指派与群组指派各按记录 ID 倒序取 200 个，再按指派时间倒序去重
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].UserID != rows[j].UserID {
+			return rows[i].UserID < rows[j].UserID
+		}
+		if rows[i].ByGroup != rows[j].ByGroup {
+			return rows[i].ByGroup < rows[j].ByGroup
+		}
+		return rows[i].RowID > rows[j].RowID
 	})
+	grouped := make(map[int64][]userLabelInfo, len(userIDs))
+	counts := make(map[[2]int64]int)
+	for _, row := range rows {
+		k := [2]int64{row.UserID, int64(row.ByGroup)}
+		if counts[k] >= 200 {
+			continue
+		}
+		counts[k]++
+		grouped[row.UserID] = append(grouped[row.UserID], row)
+	}
 
-	if err != nil {
-		return nil, nil, false, err
+	res := make(map[int64][]schema.MyLabelInfo, len(grouped))
+	for userID, items := range grouped {
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].CreatedAt.After(items[j].CreatedAt)
+		})
+		infos := make([]schema.MyLabelInfo, 0, len(items))
+		set := make(map[int64]struct{}, len(items))
+		for _, item := range items {
+			if _, ok := set[item.ID]; ok {
+				continue // 去重
+			}
+			set[item.ID] = struct{}{}
+			infos = append(infos, item.MyLabelInfo)
+		}
+		res[userID] = infos
 	}
-	return user, labelIDs, refreshed, nil
+	return res, nil
+}
+
+// resetCacheMap 清空 user 上的旧标签缓存，返回待填充的缓存数据
+func resetCacheMap(user *schema.User, product string, now int64) schema.UserCacheLabelMap {
+	data := user.GetCacheMap()
+	for _, v := range data { // 清空旧标签缓存
+		v.Labels = make([]schema.UserCacheLabel, 0)
+		if product == "" {
+			v.ActiveAt = now //强制刷新缓存，更新所有活跃时间
+		}
+	}
+	if product != "" {
+		// 放在外面赋值，是为了新产品的第一次更新。
+		data[product] = &schema.UserCache{
+			Labels:   make([]schema.UserCacheLabel, 0),
+			ActiveAt: now, // 只更新该产品缓存的刷新时间
+		}
+	}
+	return data
+}
+
+// appendCacheLabel 将 label 追加到所属产品的标签缓存中
+func appendCacheLabel(data schema.UserCacheLabelMap, info schema.MyLabelInfo) {
+	uclm, ok := data[info.Product]
+	if !ok {
+		uclm = &schema.UserCache{
+			Labels: make([]schema.UserCacheLabel, 0),
+		}
+		data[info.Product] = uclm
+	}
+	uclm.Labels = append(uclm.Labels, toCacheLabel(info))
 }
 
 // FindSettingsUnionAll 根据用户 ID, updateGt, productName 返回其 settings 数据。
-func (m *User) FindSettingsUnionAll(ctx context.Context, groupIDs []int64, userID, productID, moduleID, settingID int64, pg tpl.Pagination, channel, client string) ([]tpl.MySetting, error) {
+func (m *User) FindSettingsUnionAll(ctx context.Context, groupIDs []int64, userID, productID, moduleID, settingID int64, pg tpl.Pagination, channel, client, version string) ([]tpl.MySetting, error) {
 	data := []tpl.MySetting{}
 	cursor := pg.TokenToTimestamp(time.Now().Add(time.Minute * 10))
 	set := make(map[int64]struct{})
@@ -236,6 +448,9 @@ func (m *User) FindSettingsUnionAll(ctx context.Context, groupIDs []int64, userI
 		goqu.I("t2.description"),
 		goqu.I("t2.channels"),
 		goqu.I("t2.clients"),
+		goqu.I("t2.value_type"),
+		goqu.I("t2.min_version"),
+		goqu.I("t2.max_version"),
 		goqu.I("t3.name").As("module"))
 
 	for i := 0; i < 7; i++ { // 分页补偿最多 7 次
@@ -307,6 +522,7 @@ func (m *User) FindSettingsUnionAll(ctx context.Context, groupIDs []int64, userI
 		}
 
 		count := 0
+		batch := []tpl.MySetting{}
 		var nextCursor time.Time
 		for scanner.Next() {
 			count++
@@ -332,9 +548,12 @@ func (m *User) FindSettingsUnionAll(ctx context.Context, groupIDs []int64, userI
 					continue // client 不匹配
 				}
 			}
+			if !schema.VersionInRange(version, mySetting.MinVersion, mySetting.MaxVersion) {
+				continue // 客户端版本不在适用范围内
+			}
 
 			mySetting.HID = service.IDToHID(mySetting.ID, "setting")
-			data = append(data, mySetting)
+			batch = append(batch, mySetting)
 		}
 
 		scanner.Close()
@@ -342,6 +561,26 @@ func (m *User) FindSettingsUnionAll(ctx context.Context, groupIDs []int64, userI
 			return nil, err
 		}
 
+		if len(groupIDs) > 0 {
+			// 用户与群组对同一配置项都有指派时，配置项只在最近的指派所在的页返回，
+			// 较早的指派记录可能在之后的页中读到，这时配置项已在之前的页返回过
+			ids := make([]int64, 0, len(batch))
+			for _, s := range batch {
+				ids = append(ids, s.ID)
+			}
+			latest, err := m.findLatestAssignedAt(ctx, userID, groupIDs, ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range batch {
+				if !s.AssignedAt.Before(latest[s.ID]) {
+					data = append(data, s)
+				}
+			}
+		} else {
+			data = append(data, batch...)
+		}
+
 		if count < size {
 			break // no data to select
 		}
@@ -352,6 +591,15 @@ func (m *User) FindSettingsUnionAll(ctx context.Context, groupIDs []int64, userI
 		cursor = nextCursor.Unix()*1000 + int64(nextCursor.Nanosecond()/1000000) - 1
 	}
 
+	if len(groupIDs) > 0 {
+		// 分页仍按最近的指派时间，取值按产品线的冲突优先策略确定
+		if err := m.resolveSettingConflicts(ctx, productID, userID, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.applySettingOverrides(ctx, data, channel, client); err != nil {
+		return nil, err
+	}
 	return data, nil
 }
 
@@ -439,6 +687,7 @@ func (m *User) FindSettings(ctx context.Context, userID, productID, moduleID, se
 		goqu.I("t2.id"),
 		goqu.I("t2.name"),
 		goqu.I("t2.description"),
+		goqu.I("t2.value_type"),
 		goqu.I("t3.name").As("module"),
 		goqu.I("t4.name").As("product")).
 		From(
@@ -540,3 +789,126 @@ func (m *User) BatchAdd(ctx context.Context, uids []string) error {
 	}
 	return err
 }
+
+// FindByAttribute 根据属性查找 users，value 为空时返回拥有该属性的 users
+func (m *User) FindByAttribute(ctx context.Context, attr, value string, pg tpl.Pagination) ([]schema.User, int, error) {
+	users := make([]schema.User, 0)
+	cursor := pg.TokenToID()
+	exps := []exp.Expression{
+		goqu.I("t1.id").Eq(goqu.I("t2.user_id")),
+		goqu.I("t2.name").Eq(attr),
+	}
+	if value != "" {
+		exps = append(exps, goqu.I("t2.value").Eq(value))
+	}
+
+	sdc := m.RdDB.From(
+		goqu.T(schema.TableUser).As("t1"),
+		goqu.T(schema.TableUserAttribute).As("t2")).
+		Where(exps...)
+	total, err := sdc.CountContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sd := m.RdDB.Select(
+		goqu.I("t1.id"),
+		goqu.I("t1.created_at"),
+		goqu.I("t1.uid"),
+		goqu.I("t1.active_at"),
+		goqu.I("t1.labels")).
+		From(
+			goqu.T(schema.TableUser).As("t1"),
+			goqu.T(schema.TableUserAttribute).As("t2")).
+		Where(append(exps, goqu.I("t1.id").Lte(cursor))...).
+		Order(goqu.I("t1.id").Desc()).Limit(uint(pg.PageSize + 1))
+	err = sd.Executor().ScanStructsContext(ctx, &users)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, int(total), nil
+}
+
+// FindAttributes 返回用户的全部属性，按属性名排序
+func (m *User) FindAttributes(ctx context.Context, userID int64) ([]schema.UserAttribute, error) {
+	attrs := make([]schema.UserAttribute, 0)
+	sd := m.RdDB.From(schema.TableUserAttribute).
+		Where(goqu.C("user_id").Eq(userID)).
+		Order(goqu.C("name").Asc()).Limit(1000)
+	if err := sd.Executor().ScanStructsContext(ctx, &attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// BatchUpsertAttributes 批量添加或更新用户属性，用户不存在时会被创建，属性值为空时删除该属性
+func (m *User) BatchUpsertAttributes(ctx context.Context, users []tpl.UserAttributesBody) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	uids := make([]string, len(users))
+	for i, u := range users {
+		uids[i] = u.UID
+	}
+	if err := m.BatchAdd(ctx, uids); err != nil {
+		return err
+	}
+
+	dbUsers := make([]schema.User, 0)
+	sd := m.DB.From(schema.TableUser).Select(goqu.C("id"), goqu.C("uid")).
+		Where(goqu.C("uid").In(tpl.StrSliceToInterface(uids)...))
+	if err := sd.Executor().ScanStructsContext(ctx, &dbUsers); err != nil {
+		return err
+	}
+	ids := make(map[string]int64, len(dbUsers))
+	for _, u := range dbUsers {
+		ids[u.UID] = u.ID
+	}
+
+	vals := make([][]interface{}, 0)
+	removed := make([]exp.Expression, 0)
+	for _, u := range users {
+		userID, ok := ids[u.UID]
+		if !ok {
+			continue
+		}
+
+		names := make([]string, 0)
+		for name, value := range u.Attributes {
+			if value == "" {
+				names = append(names, name)
+			} else {
+				vals = append(vals, goqu.Vals{userID, name, value})
+			}
+		}
+		if len(names) > 0 {
+			removed = append(removed, goqu.Ex{"user_id": userID, "name": names})
+		}
+	}
+	if len(vals) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	// 删除与更新在同一事务中，避免只生效一部分
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	return tx.Wrap(func() error {
+		if len(removed) > 0 {
+			sd := tx.Delete(schema.TableUserAttribute).Where(goqu.Or(removed...))
+			if _, err := service.DeResult(sd.Executor().ExecContext(ctx)); err != nil {
+				return err
+			}
+		}
+		if len(vals) > 0 {
+			sd := tx.Insert(schema.TableUserAttribute).Cols("user_id", "name", "value").Vals(vals...).
+				OnConflict(goqu.DoUpdate("", goqu.Record{"value": goqu.L("VALUES(`value`)")}))
+			if _, err := service.DeResult(sd.Executor().ExecContext(ctx)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/teambition/gear"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"github.com/teambition/urbs-setting/src/bll"
+	"github.com/teambition/urbs-setting/src/conf"
+	"github.com/teambition/urbs-setting/src/logging"
+	"github.com/teambition/urbs-setting/src/middleware"
+	"github.com/teambition/urbs-setting/src/rpc/pb"
+	"github.com/teambition/urbs-setting/src/util"
+)
+
+// NewServer 创建 gRPC 服务，注册 Urbs 读取服务、健康检查服务和反射服务，
+// 配置了 cert_file 和 key_file 时启用 TLS
+func NewServer() *grpc.Server {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(unaryInterceptor)}
+	if conf.Config.CertFile != "" && conf.Config.KeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(conf.Config.CertFile, conf.Config.KeyFile)
+		if err != nil {
+			logging.Panicf("Load gRPC TLS credentials failed: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	srv := grpc.NewServer(opts...)
+	err := util.DigInvoke(func(blls *bll.Blls) error {
+		pb.RegisterUrbsServer(srv, &urbsServer{blls: blls})
+		return nil
+	})
+	if err != nil {
+		logging.Panicf("DigInvoke error: %v", err)
+	}
+
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("urbs.setting.v1.Urbs", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	return srv
+}
+
+// ListenWithContext 在 addr 上启动 gRPC 服务，ctx 结束时优雅关闭
+func ListenWithContext(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := NewServer()
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	return srv.Serve(lis)
+}
+
+// unaryInterceptor 按与 HTTP API 相同的规则验证请求者身份，健康检查除外，并把错误转换为 gRPC 状态码
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !strings.HasPrefix(info.FullMethod, "/grpc.health.") {
+		md, _ := metadata.FromIncomingContext(ctx)
+		h := http.Header{}
+		for _, v := range md.Get("authorization") {
+			h.Add("Authorization", v)
+		}
+		if _, err := middleware.VerifyHeader(h); err != nil {
+			return nil, toStatusError(err)
+		}
+	}
+
+	res, err := handler(ctx, req)
+	if err != nil {
+		logging.Debugf("gRPC %s error: %v", info.FullMethod, err)
+		return nil, toStatusError(err)
+	}
+	return res, nil
+}
+
+// toStatusError 把 gear.HTTPError 转换为对应状态码的 gRPC 错误
+func toStatusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	herr := gear.ParseError(err)
+	code := codes.Internal
+	switch herr.Status() {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, herr.Error())
+}
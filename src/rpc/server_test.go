@@ -0,0 +1,173 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DavidCai1993/request"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/teambition/urbs-setting/src/api"
+	"github.com/teambition/urbs-setting/src/rpc/pb"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+type TestTools struct {
+	Host string
+	Conn *grpc.ClientConn
+}
+
+func SetUpTestTools() (tt *TestTools, cleanup func()) {
+	tt = &TestTools{}
+	srv := api.NewApp().Start()
+	tt.Host = "http://" + srv.Addr().String()
+
+	lis := bufconn.Listen(1024 * 1024)
+	gs := NewServer()
+	go gs.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+	if err != nil {
+		panic(err)
+	}
+	tt.Conn = conn
+
+	return tt, func() {
+		conn.Close()
+		gs.Stop()
+		srv.Close()
+	}
+}
+
+func send(req *request.Client, path string, body interface{}) error {
+	res, err := req.Set("Content-Type", "application/json").Send(body).End()
+	if err != nil {
+		return err
+	}
+	res.Content() // close http client
+	if res.StatusCode != 200 {
+		return fmt.Errorf("%s: %d", path, res.StatusCode)
+	}
+	return nil
+}
+
+func post(tt *TestTools, path string, body interface{}) error {
+	return send(request.Post(tt.Host+path), path, body)
+}
+
+func put(tt *TestTools, path string, body interface{}) error {
+	return send(request.Put(tt.Host+path), path, body)
+}
+
+func TestUrbsServer(t *testing.T) {
+	tt, cleanup := SetUpTestTools()
+	defer cleanup()
+
+	client := pb.NewUrbsClient(tt.Conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	product, module, setting, label := tpl.RandName(), tpl.RandName(), tpl.RandName(), tpl.RandLabel()
+	uids := []string{tpl.RandUID(), tpl.RandUID()}
+	assert.Nil(t, post(tt, "/v1/users:batch", tpl.UsersBody{Users: uids}))
+	assert.Nil(t, post(tt, "/v1/products", tpl.NameDescBody{Name: product, Desc: product}))
+	assert.Nil(t, post(tt, fmt.Sprintf("/v1/products/%s/labels", product), tpl.LabelBody{Name: label, Desc: label}))
+	assert.Nil(t, post(tt, fmt.Sprintf("/v1/products/%s/modules", product), tpl.NameDescBody{Name: module, Desc: module}))
+	assert.Nil(t, post(tt, fmt.Sprintf("/v1/products/%s/modules/%s/settings", product, module), tpl.NameDescBody{Name: setting, Desc: setting}))
+	values := []string{"a", "b"}
+	assert.Nil(t, put(tt, fmt.Sprintf("/v1/products/%s/modules/%s/settings/%s", product, module, setting), tpl.SettingUpdateBody{Values: &values}))
+	assert.Nil(t, post(tt, fmt.Sprintf("/v1/products/%s/labels/%s:assign", product, label), tpl.UsersGroupsBody{Users: uids[:1]}))
+	assert.Nil(t, post(tt, fmt.Sprintf("/v1/products/%s/modules/%s/settings/%s:assign", product, module, setting), tpl.UsersGroupsBody{Users: uids, Value: "a"}))
+
+	t.Run("health service should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := healthpb.NewHealthClient(tt.Conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "urbs.setting.v1.Urbs"})
+		assert.Nil(err)
+		assert.Equal(healthpb.HealthCheckResponse_SERVING, res.Status)
+	})
+
+	t.Run("GetLabels should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := client.GetLabels(ctx, &pb.GetLabelsRequest{Uid: uids[0], Product: product})
+		assert.Nil(err)
+		assert.True(res.Timestamp > 0)
+		assert.Equal(1, len(res.Labels))
+		assert.Equal(label, res.Labels[0].Label)
+
+		res, err = client.GetLabels(ctx, &pb.GetLabelsRequest{Uid: uids[1], Product: product})
+		assert.Nil(err)
+		assert.Equal(0, len(res.Labels))
+	})
+
+	t.Run("GetLabels should return InvalidArgument", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := client.GetLabels(ctx, &pb.GetLabelsRequest{Uid: uids[0]})
+		assert.Equal(codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("BatchGetLabels should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := client.BatchGetLabels(ctx, &pb.BatchGetLabelsRequest{Uids: []string{uids[1], uids[0], uids[1]}, Product: product})
+		assert.Nil(err)
+		assert.Equal(2, len(res.Users))
+		assert.Equal(uids[1], res.Users[0].Uid)
+		assert.Equal(0, len(res.Users[0].Labels))
+		assert.Equal(uids[0], res.Users[1].Uid)
+		assert.Equal(label, res.Users[1].Labels[0].Label)
+	})
+
+	t.Run("BatchGetLabels should return InvalidArgument for invalid key", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := client.BatchGetLabels(ctx, &pb.BatchGetLabelsRequest{Uids: uids, Product: product, Keys: map[string]string{uids[0]: "k"}})
+		assert.Equal(codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("GetSettings should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := client.GetSettings(ctx, &pb.GetSettingsRequest{Uid: uids[0], Product: product})
+		assert.Nil(err)
+		assert.Equal("", res.NextPageToken)
+		assert.Equal(1, len(res.Settings))
+		assert.Equal(setting, res.Settings[0].Name)
+		assert.Equal(module, res.Settings[0].Module)
+		assert.Equal("a", res.Settings[0].Value)
+		assert.NotNil(res.Settings[0].AssignedAt)
+	})
+
+	t.Run("GetSettings should return NotFound for invalid product", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := client.GetSettings(ctx, &pb.GetSettingsRequest{Uid: uids[0], Product: tpl.RandName()})
+		assert.Equal(codes.NotFound, status.Code(err))
+	})
+
+	t.Run("BatchGetSettings should work", func(t *testing.T) {
+		assert := assert.New(t)
+
+		res, err := client.BatchGetSettings(ctx, &pb.BatchGetSettingsRequest{Uids: uids, Product: product, Module: module})
+		assert.Nil(err)
+		assert.Equal(2, len(res.Users))
+		for i, u := range res.Users {
+			assert.Equal(uids[i], u.Uid)
+			assert.Equal(1, len(u.Settings))
+			assert.Equal("a", u.Settings[0].Value)
+		}
+	})
+}
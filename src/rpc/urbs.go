@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/teambition/gear"
+
+	"github.com/teambition/urbs-setting/src/bll"
+	"github.com/teambition/urbs-setting/src/rpc/pb"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/tpl"
+)
+
+// urbsServer 实现 pb.UrbsServer，复用 bll 层的读取逻辑
+type urbsServer struct {
+	pb.UnimplementedUrbsServer
+	blls *bll.Blls
+}
+
+// GetLabels 与 GET /users/:uid/labels:cache 一致
+func (s *urbsServer) GetLabels(ctx context.Context, req *pb.GetLabelsRequest) (*pb.GetLabelsResponse, error) {
+	q := tpl.UIDProductURL{UID: req.Uid, Product: req.Product, Key: req.Key, Version: req.Version}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	res := s.blls.User.ListCachedLabels(ctx, q.UID, q.Product, q.Key, q.Version)
+	return &pb.GetLabelsResponse{Timestamp: res.Timestamp, Labels: toPBLabels(res.Result)}, nil
+}
+
+// BatchGetLabels 与 POST /users/labels:cache 一致
+func (s *urbsServer) BatchGetLabels(ctx context.Context, req *pb.BatchGetLabelsRequest) (*pb.BatchGetLabelsResponse, error) {
+	body := tpl.UsersCacheLabelsBody{Product: req.Product, Users: req.Uids, Version: req.Version, Keys: req.Keys}
+	if err := body.Validate(); err != nil {
+		return nil, err
+	}
+
+	res := s.blls.User.BatchListCachedLabels(ctx, body.Users, body.Product, body.Keys, body.Version)
+	users := make([]*pb.UserLabels, 0, len(res.Result))
+	for _, u := range res.Result {
+		users = append(users, &pb.UserLabels{Uid: u.UID, Timestamp: u.Timestamp, Labels: toPBLabels(u.Labels)})
+	}
+	return &pb.BatchGetLabelsResponse{Users: users}, nil
+}
+
+// GetSettings 与 GET /v1/users/:uid/settings:unionAll 一致
+func (s *urbsServer) GetSettings(ctx context.Context, req *pb.GetSettingsRequest) (*pb.GetSettingsResponse, error) {
+	q := tpl.MySettingsQueryURL{
+		UID:     req.Uid,
+		Product: req.Product,
+		Module:  req.Module,
+		Setting: req.Setting,
+		Channel: req.Channel,
+		Client:  req.Client,
+		Version: req.Version,
+		Key:     req.Key,
+	}
+	q.PageSize = int(req.PageSize)
+	q.PageToken = req.PageToken
+	if err := validateSettingsQuery(&q); err != nil {
+		return nil, err
+	}
+
+	res, err := s.blls.User.ListSettingsUnionAll(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetSettingsResponse{Settings: toPBSettings(res.Result), NextPageToken: res.NextPageToken}, nil
+}
+
+// BatchGetSettings 批量读取多个用户在产品下的全部配置项
+func (s *urbsServer) BatchGetSettings(ctx context.Context, req *pb.BatchGetSettingsRequest) (*pb.BatchGetSettingsResponse, error) {
+	body := tpl.UsersCacheLabelsBody{Product: req.Product, Users: req.Uids, Version: req.Version, Keys: req.Keys}
+	if err := body.Validate(); err != nil {
+		return nil, err
+	}
+	q := tpl.MySettingsQueryURL{
+		UID:     body.Users[0],
+		Product: req.Product,
+		Module:  req.Module,
+		Channel: req.Channel,
+		Client:  req.Client,
+		Version: req.Version,
+	}
+	if err := validateSettingsQuery(&q); err != nil {
+		return nil, err
+	}
+
+	res, err := s.blls.User.BatchListSettingsUnionAll(ctx, body.Users, body.Keys, q)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*pb.UserSettings, 0, len(res))
+	for _, u := range res {
+		users = append(users, &pb.UserSettings{Uid: u.UID, Settings: toPBSettings(u.Result)})
+	}
+	return &pb.BatchGetSettingsResponse{Users: users}, nil
+}
+
+func validateSettingsQuery(q *tpl.MySettingsQueryURL) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	if q.Product == "" {
+		return gear.ErrBadRequest.WithMsgf("product required")
+	}
+	return nil
+}
+
+func toPBLabels(labels []schema.UserCacheLabel) []*pb.Label {
+	res := make([]*pb.Label, 0, len(labels))
+	for _, l := range labels {
+		res = append(res, &pb.Label{
+			Label:      l.Label,
+			Clients:    l.Clients,
+			Channels:   l.Channels,
+			MinVersion: l.MinVersion,
+			MaxVersion: l.MaxVersion,
+		})
+	}
+	return res
+}
+
+func toPBSettings(settings []tpl.MySetting) []*pb.Setting {
+	res := make([]*pb.Setting, 0, len(settings))
+	for _, s := range settings {
+		setting := &pb.Setting{
+			Hid:         s.HID,
+			Product:     s.Product,
+			Module:      s.Module,
+			Name:        s.Name,
+			Desc:        s.Desc,
+			Value:       s.Value,
+			LastValue:   s.LastValue,
+			Release:     s.Release,
+			AssignedAt:  toPBTime(s.AssignedAt),
+			Source:      s.Source,
+			ValueType:   s.ValueType,
+			TypedValue:  string(s.TypedValue),
+			PayloadHash: s.PayloadHash,
+			Conflicts:   make([]*pb.SettingConflict, 0, len(s.Conflicts)),
+		}
+		if s.Payload != nil {
+			setting.Payload = *s.Payload
+		}
+		for _, c := range s.Conflicts {
+			conflict := &pb.SettingConflict{Source: c.Source, Value: c.Value, AssignedAt: toPBTime(c.AssignedAt)}
+			if c.Group != nil {
+				conflict.GroupUid = c.Group.UID
+				conflict.GroupKind = c.Group.Kind
+			}
+			setting.Conflicts = append(setting.Conflicts, conflict)
+		}
+		res = append(res, setting)
+	}
+	return res
+}
+
+func toPBTime(t time.Time) *timestamp.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return &timestamp.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
@@ -0,0 +1,37 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import "time"
+
+// TableChangeEvent is a table name in db.
+const TableChangeEvent = "change_event"
+
+const (
+	// ChangeKindLabel 环境标签变更
+	ChangeKindLabel = "label"
+	// ChangeKindSetting 配置项变更
+	ChangeKindSetting = "setting"
+	// ChangeKindAll 环境标签和配置项都可能变更，如群组成员变更
+	ChangeKindAll = "all"
+)
+
+// ChangeEvent 详见 ./sql/schema.sql table `change_event`
+// 用户环境标签或配置项的变更事件，用于推送变更通知，只保留一段时间
+type ChangeEvent struct {
+	ID        int64     `db:"id" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" goqu:"skipinsert"`
+	ProductID int64     `db:"product_id"` // 0 表示影响所有产品
+	UserID    int64     `db:"user_id"`    // 非 0 时只影响该用户
+	GroupID   int64     `db:"group_id"`   // 非 0 时只影响该群组的成员，ProductID 为 0 时表示群组成员变更
+	Kind      string    `db:"kind"`       // varchar(15) label、setting 或 all
+}
+
+// TableName retuns table name
+func (ChangeEvent) TableName() string {
+	return "change_event"
+}
+
+// HasKind 判断事件是否涉及指定类型的变更
+func (e ChangeEvent) HasKind(kind string) bool {
+	return e.Kind == kind || e.Kind == ChangeKindAll
+}
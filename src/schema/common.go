@@ -1,8 +1,18 @@
 package schema
 
 import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/teambition/urbs-setting/src/util"
 )
@@ -14,19 +24,202 @@ const (
 	RuleNewUserPercent = "newUserPercent"
 	// RuleChildLabelUserPercent parent-child relationship label
 	RuleChildLabelUserPercent = "childLabelUserPercent"
+	// RuleUserAttribute 按用户属性匹配后再按百分比发布
+	RuleUserAttribute = "userAttribute"
+	// RuleUserVariant 按权重将用户分配到配置项的多个可选值，仅用于配置项
+	RuleUserVariant = "userVariant"
+	// RuleGroupPercent 按群组分桶，将环境标签或配置项指派给命中的指定类型群组，群组成员通过群组继承
+	RuleGroupPercent = "groupPercent"
 )
 
 var (
 	// RuleKinds ...
-	RuleKinds = []string{RuleUserPercent, RuleNewUserPercent, RuleChildLabelUserPercent}
+	RuleKinds = []string{RuleUserPercent, RuleNewUserPercent, RuleChildLabelUserPercent, RuleUserAttribute, RuleUserVariant, RuleGroupPercent}
 )
 
+const (
+	// RuleOpEq ...
+	RuleOpEq = "eq"
+	// RuleOpNe ...
+	RuleOpNe = "ne"
+	// RuleOpIn ...
+	RuleOpIn = "in"
+	// RuleOpNotIn ...
+	RuleOpNotIn = "nin"
+	// RuleOpGt ...
+	RuleOpGt = "gt"
+	// RuleOpGte ...
+	RuleOpGte = "gte"
+	// RuleOpLt ...
+	RuleOpLt = "lt"
+	// RuleOpLte ...
+	RuleOpLte = "lte"
+)
+
+const (
+	// AttrUID 内置用户属性，用户 uid
+	AttrUID = "uid"
+	// AttrCreatedAt 内置用户属性，用户创建时间，RFC3339 格式
+	AttrCreatedAt = "createdAt"
+)
+
+var (
+	// RuleOps ...
+	RuleOps = []string{RuleOpEq, RuleOpNe, RuleOpIn, RuleOpNotIn, RuleOpGt, RuleOpGte, RuleOpLt, RuleOpLte}
+
+	validAttrReg = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]{0,62}$`)
+)
+
+// ValidAttrName 判断用户属性名是否合法，tpl 也使用此规则
+func ValidAttrName(name string) bool {
+	return validAttrReg.MatchString(name)
+}
+
+// RuleCondition 用户属性匹配条件，如 {"attr": "plan", "op": "eq", "values": ["enterprise"]}
+type RuleCondition struct {
+	Attr   string   `json:"attr"`
+	Op     string   `json:"op"`
+	Values []string `json:"values"`
+}
+
+// Validate ...
+func (c *RuleCondition) Validate() error {
+	if !validAttrReg.MatchString(c.Attr) {
+		return fmt.Errorf("invalid condition attr: %s", c.Attr)
+	}
+	if !util.StringSliceHas(RuleOps, c.Op) {
+		return fmt.Errorf("invalid condition op: %s", c.Op)
+	}
+	switch c.Op {
+	case RuleOpIn, RuleOpNotIn:
+		if len(c.Values) == 0 {
+			return fmt.Errorf("condition values required for op %s", c.Op)
+		}
+	default:
+		if len(c.Values) != 1 {
+			return fmt.Errorf("condition op %s requires exactly one value", c.Op)
+		}
+	}
+	return nil
+}
+
+// Match 判断用户属性是否满足条件，属性不存在时不匹配
+func (c *RuleCondition) Match(attrs map[string]string) bool {
+	val, ok := attrs[c.Attr]
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case RuleOpEq:
+		return val == c.Values[0]
+	case RuleOpNe:
+		return val != c.Values[0]
+	case RuleOpIn:
+		return util.StringSliceHas(c.Values, val)
+	case RuleOpNotIn:
+		return !util.StringSliceHas(c.Values, val)
+	case RuleOpGt:
+		return compareAttr(val, c.Values[0]) > 0
+	case RuleOpGte:
+		return compareAttr(val, c.Values[0]) >= 0
+	case RuleOpLt:
+		return compareAttr(val, c.Values[0]) < 0
+	case RuleOpLte:
+		return compareAttr(val, c.Values[0]) <= 0
+	}
+	return false
+}
+
+// compareAttr 依次尝试按时间、数值、字符串比较
+func compareAttr(a, b string) int {
+	if ta, ok := parseAttrTime(a); ok {
+		if tb, ok := parseAttrTime(b); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+	if fa, err := strconv.ParseFloat(a, 64); err == nil {
+		if fb, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func parseAttrTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// RuleVariant 多变量规则中的一个配置值及其权重（百分比）
+type RuleVariant struct {
+	Value  string `json:"value"`
+	Weight int    `json:"weight"`
+}
+
+// RuleValue 规则值，percent 类规则仅有 value，userAttribute 类规则还有 conditions，
+// userVariant 类规则还有 variants，其 value 为各 variant 权重之和，groupPercent 类规则还有 groupKind。
+// 用户类规则可以用 bucketBy 指定分桶 key，为空时按用户内部 ID 分桶
+type RuleValue struct {
+	Value      float64         `json:"value"`
+	Conditions []RuleCondition `json:"conditions,omitempty"`
+	Variants   []RuleVariant   `json:"variants,omitempty"`
+	GroupKind  string          `json:"groupKind,omitempty"`
+	BucketBy   string          `json:"bucketBy,omitempty"`
+}
+
+// VariantsWeight 返回全部 variant 的权重之和
+func (r RuleValue) VariantsWeight() int {
+	sum := 0
+	for _, v := range r.Variants {
+		sum += v.Weight
+	}
+	return sum
+}
+
+// Variant 返回 bucket（见 RuleBucket）所落入的 variant 配置值，各 variant 按顺序占据互不重叠的连续区间，
+// 未落入任何区间时返回空字符串
+func (r RuleValue) Variant(bucket int) string {
+	end := 0
+	for _, v := range r.Variants {
+		end += v.Weight * RuleBuckets / 100
+		if bucket < end {
+			return v.Value
+		}
+	}
+	return ""
+}
+
+// Match 判断用户属性是否满足全部条件
+func (r RuleValue) Match(attrs map[string]string) bool {
+	for _, c := range r.Conditions {
+		if !c.Match(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
 // PercentRule ...
 type PercentRule struct {
-	Kind string `json:"kind"`
-	Rule struct {
-		Value int `json:"value"`
-	} `json:"rule"`
+	Kind string    `json:"kind"`
+	Rule RuleValue `json:"rule"`
 }
 
 // Validate ...
@@ -34,12 +227,82 @@ func (r *PercentRule) Validate() error {
 	if r.Kind == "" || !util.StringSliceHas(RuleKinds, r.Kind) {
 		return fmt.Errorf("invalid kind: %s", r.Kind)
 	}
-	if r.Rule.Value < 0 || r.Rule.Value > 100 {
-		return fmt.Errorf("invalid percent rule value: %d", r.Rule.Value)
+	if r.Rule.Value < 0 || r.Rule.Value > 100 || !isPermyriad(r.Rule.Value) {
+		return fmt.Errorf("invalid percent rule value: %v", r.Rule.Value)
+	}
+	if r.Kind == RuleUserAttribute {
+		if len(r.Rule.Conditions) == 0 {
+			return fmt.Errorf("conditions required for kind %s", r.Kind)
+		}
+		if len(r.Rule.Conditions) > 10 {
+			return fmt.Errorf("too many conditions: %d", len(r.Rule.Conditions))
+		}
+		for i := range r.Rule.Conditions {
+			if err := r.Rule.Conditions[i].Validate(); err != nil {
+				return err
+			}
+		}
+	} else if len(r.Rule.Conditions) > 0 {
+		return fmt.Errorf("conditions not supported for kind %s", r.Kind)
+	}
+	if r.Kind == RuleUserVariant {
+		if len(r.Rule.Variants) < 2 || len(r.Rule.Variants) > 10 {
+			return fmt.Errorf("variants should have 2 to 10 items for kind %s", r.Kind)
+		}
+		seen := make(map[string]bool, len(r.Rule.Variants))
+		for _, v := range r.Rule.Variants {
+			if v.Value == "" || seen[v.Value] {
+				return fmt.Errorf("invalid variant value: %q", v.Value)
+			}
+			if v.Weight < 1 || v.Weight > 100 {
+				return fmt.Errorf("invalid variant weight: %d", v.Weight)
+			}
+			seen[v.Value] = true
+		}
+		if sum := r.Rule.VariantsWeight(); sum > 100 || float64(sum) != r.Rule.Value {
+			return fmt.Errorf("invalid variants weight sum: %d", sum)
+		}
+	} else if len(r.Rule.Variants) > 0 {
+		return fmt.Errorf("variants not supported for kind %s", r.Kind)
+	}
+	if r.Kind == RuleGroupPercent {
+		if r.Rule.GroupKind == "" || len(r.Rule.GroupKind) > 63 {
+			return fmt.Errorf("invalid groupKind for kind %s: %q", r.Kind, r.Rule.GroupKind)
+		}
+	} else if r.Rule.GroupKind != "" {
+		return fmt.Errorf("groupKind not supported for kind %s", r.Kind)
+	}
+	if r.Rule.BucketBy != "" {
+		if r.Kind == RuleGroupPercent {
+			return fmt.Errorf("bucketBy not supported for kind %s", r.Kind)
+		}
+		switch r.Rule.BucketBy {
+		case RuleBucketByUID, RuleBucketByKey:
+		default:
+			if !validAttrReg.MatchString(RuleBucketByAttr(r.Rule.BucketBy)) {
+				return fmt.Errorf("invalid bucketBy: %s", r.Rule.BucketBy)
+			}
+		}
 	}
 	return nil
 }
 
+// ValidPercent 判断百分比是否在 [0, 100] 内且最多精确到两位小数
+func ValidPercent(percent float64) bool {
+	return percent >= 0 && percent <= 100 && isPermyriad(percent)
+}
+
+// ValidBucketingPercent 判断百分比是否适用于规则的分桶模式，salt 为空的 legacy 模式按整数百分比分桶，不支持小数
+func ValidBucketingPercent(salt string, percent float64) bool {
+	return salt != "" || percent == math.Trunc(percent)
+}
+
+// isPermyriad 百分比最多精确到两位小数，即 RuleBuckets 中的一个分桶
+func isPermyriad(percent float64) bool {
+	v := percent * RuleBuckets / 100
+	return math.Abs(v-math.Round(v)) < 1e-6
+}
+
 // ToRule ...
 func (r *PercentRule) ToRule() string {
 	if b, err := json.Marshal(r.Rule); err == nil {
@@ -69,3 +332,111 @@ func ToPercentRule(kind, rule string) *PercentRule {
 func ToRuleObject(kind, rule string) interface{} {
 	return ToPercentRule(kind, rule).Rule
 }
+
+const (
+	// RuleStatePending 规则未到生效时间
+	RuleStatePending = "pending"
+	// RuleStateActive 规则生效中
+	RuleStateActive = "active"
+	// RuleStateEnded 规则已过失效时间
+	RuleStateEnded = "ended"
+)
+
+// RuleScheduleState 根据规则的生效时间窗口 [startAt, endAt) 返回其在 now 时的状态
+func RuleScheduleState(startAt, endAt *time.Time, now time.Time) string {
+	if startAt != nil && now.Before(*startAt) {
+		return RuleStatePending
+	}
+	if endAt != nil && !now.Before(*endAt) {
+		return RuleStateEnded
+	}
+	return RuleStateActive
+}
+
+// RuleBuckets 规则分桶总数，百分比规则可精确到 0.01%
+const RuleBuckets = 10000
+
+const (
+	// RuleBucketByUID 按用户 uid 分桶，与数据库内部 ID 无关，在不同环境间保持一致
+	RuleBucketByUID = "uid"
+	// RuleBucketByKey 按请求方提供的 key 分桶，如设备 ID，可使匿名用户与登录用户分桶一致；请求未提供时按 uid 分桶
+	RuleBucketByKey = "key"
+	// RuleBucketByAttrPrefix 按指定用户属性分桶，如 "attr:tenantId"，用户无该属性时不命中规则
+	RuleBucketByAttrPrefix = "attr:"
+)
+
+// RuleBucketByAttr 返回 bucketBy 指定的用户属性名，bucketBy 不是按用户属性分桶时返回空字符串
+func RuleBucketByAttr(bucketBy string) string {
+	if strings.HasPrefix(bucketBy, RuleBucketByAttrPrefix) {
+		return bucketBy[len(RuleBucketByAttrPrefix):]
+	}
+	return ""
+}
+
+const (
+	// RuleBucketingLegacy 兼容旧规则的分桶模式，规则 salt 为空
+	RuleBucketingLegacy = "legacy"
+	// RuleBucketingHash 加盐哈希分桶模式
+	RuleBucketingHash = "hash"
+)
+
+// RuleBucketing 根据规则的 salt 返回其分桶模式
+func RuleBucketing(salt string) string {
+	if salt == "" {
+		return RuleBucketingLegacy
+	}
+	return RuleBucketingHash
+}
+
+// NewRuleSalt 生成新的随机分桶盐值
+func NewRuleSalt() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// RuleBucket 计算用户在规则中的分桶，取值 [0, RuleBuckets)。
+// salt 为规则的分桶盐值，非空时按 sha1(salt:key) 分桶，不同规则之间互不相关；
+// salt 为空时为兼容旧规则的 legacy 模式，按 (id + createdAt) % 100 分桶，以保持旧规则已覆盖的用户不变。
+// 对于匿名用户，id 为 key 的 crc32 值。
+func RuleBucket(salt string, createdAt time.Time, id int64, key string) int {
+	if salt == "" {
+		return int((id+createdAt.Unix())%100) * (RuleBuckets / 100)
+	}
+	sum := sha1.Sum([]byte(salt + ":" + key))
+	return int(binary.BigEndian.Uint64(sum[:8]) % RuleBuckets)
+}
+
+// AnonymousRuleID 返回 legacy 模式下匿名用户用于分桶的 id，也用于规则指定 bucketBy 时按分桶 key 计算的 id
+func AnonymousRuleID(anonymousID string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte(anonymousID)))
+}
+
+// RuleMissBucket 返回百分比规则不命中的最小分桶，与 RuleHit 一致，分桶不小于该值时不命中
+func RuleMissBucket(salt string, percent float64) int {
+	if percent <= 0 {
+		return 0
+	}
+	if salt == "" {
+		miss := (int(math.Floor(percent)) + 1) * (RuleBuckets / 100)
+		if miss > RuleBuckets {
+			return RuleBuckets
+		}
+		return miss
+	}
+	return int(math.Round(percent * RuleBuckets / 100))
+}
+
+// RuleHit 判断分桶是否落入百分比区间。
+// legacy 模式下保持旧的判断逻辑（包含边界，即 bucket/100 <= percent）。
+func RuleHit(salt string, bucket int, percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	if salt == "" {
+		return float64(bucket/(RuleBuckets/100)) <= percent
+	}
+	return bucket < int(math.Round(percent*RuleBuckets/100))
+}
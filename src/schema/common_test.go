@@ -0,0 +1,431 @@
+package schema
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentRule(t *testing.T) {
+	t.Run(`PercentRule.Validate should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := ToPercentRule(RuleUserPercent, `{"value":10}`)
+		assert.Nil(r.Validate())
+		assert.Equal(float64(10), r.Rule.Value)
+		assert.Equal(`{"value":10}`, r.ToRule())
+
+		r = ToPercentRule(RuleUserPercent, `{"value":10,"conditions":[{"attr":"plan","op":"eq","values":["enterprise"]}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+
+		r = ToPercentRule(RuleUserPercent, `{"value":0.01}`)
+		assert.Nil(r.Validate())
+		assert.Equal(0.01, r.Rule.Value)
+		assert.Equal(`{"value":0.01}`, r.ToRule())
+
+		r = ToPercentRule(RuleUserPercent, `{"value":0.015}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+
+		r = ToPercentRule(RuleUserAttribute, `{"value":10}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+
+		r = ToPercentRule(RuleUserAttribute, `{"value":10,"conditions":[{"attr":"plan","op":"eq","values":["enterprise"]}]}`)
+		assert.Nil(r.Validate())
+		assert.Equal(float64(10), r.Rule.Value)
+		assert.Equal(`{"value":10,"conditions":[{"attr":"plan","op":"eq","values":["enterprise"]}]}`, r.ToRule())
+
+		r = ToPercentRule(RuleUserAttribute, `{"value":10,"conditions":[{"attr":"plan","op":"eq","values":["a","b"]}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+
+		r = ToPercentRule(RuleUserAttribute, `{"value":10,"conditions":[{"attr":"plan","op":"like","values":["a"]}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+
+		r = ToPercentRule(RuleUserAttribute, `{"value":10,"conditions":[{"attr":"","op":"in","values":["a"]}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+
+		r = ToPercentRule(RuleUserAttribute, `{"value":10,"conditions":[{"attr":"region","op":"in","values":[]}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+	})
+
+	t.Run(`RuleValue.Match should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := ToPercentRule(RuleUserAttribute, `{"value":100,"conditions":[
+			{"attr":"plan","op":"eq","values":["enterprise"]},
+			{"attr":"region","op":"in","values":["cn","us"]},
+			{"attr":"createdAt","op":"gt","values":["2026-01-01"]}
+		]}`).Rule
+		assert.Equal(float64(100), r.Value)
+
+		assert.True(r.Match(map[string]string{"plan": "enterprise", "region": "cn", "createdAt": "2026-03-01T00:00:00Z"}))
+		assert.False(r.Match(map[string]string{"plan": "free", "region": "cn", "createdAt": "2026-03-01T00:00:00Z"}))
+		assert.False(r.Match(map[string]string{"plan": "enterprise", "region": "eu", "createdAt": "2026-03-01T00:00:00Z"}))
+		assert.False(r.Match(map[string]string{"plan": "enterprise", "region": "us", "createdAt": "2025-12-31T23:59:59Z"}))
+		assert.False(r.Match(map[string]string{"plan": "enterprise", "region": "us"}))
+	})
+
+	t.Run(`RuleValue.Variant should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := ToPercentRule(RuleUserVariant, `{"value":100,"variants":[{"value":"a","weight":33},{"value":"b","weight":33},{"value":"c","weight":34}]}`)
+		assert.Nil(r.Validate())
+		assert.Equal(float64(100), r.Rule.Value)
+		assert.Equal("a", r.Rule.Variant(0))
+		assert.Equal("a", r.Rule.Variant(3299))
+		assert.Equal("b", r.Rule.Variant(3300))
+		assert.Equal("b", r.Rule.Variant(6599))
+		assert.Equal("c", r.Rule.Variant(6600))
+		assert.Equal("c", r.Rule.Variant(9999))
+
+		r = ToPercentRule(RuleUserVariant, `{"value":60,"variants":[{"value":"a","weight":30},{"value":"b","weight":30}]}`)
+		assert.Equal(float64(60), r.Rule.Value)
+		assert.Equal("b", r.Rule.Variant(5999))
+		assert.Equal("", r.Rule.Variant(6000))
+
+		// 权重之和与 value 不一致
+		r = ToPercentRule(RuleUserVariant, `{"value":100,"variants":[{"value":"a","weight":30},{"value":"b","weight":30}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+		r = ToPercentRule(RuleUserVariant, `{"value":100,"variants":[{"value":"a","weight":100}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+		r = ToPercentRule(RuleUserVariant, `{"value":0,"variants":[{"value":"a","weight":0},{"value":"b","weight":0}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+		r = ToPercentRule(RuleUserPercent, `{"value":60,"variants":[{"value":"a","weight":30},{"value":"b","weight":30}]}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+	})
+
+	t.Run(`groupPercent rule should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := ToPercentRule(RuleGroupPercent, `{"value":50,"groupKind":"organization"}`)
+		assert.Nil(r.Validate())
+		assert.Equal(float64(50), r.Rule.Value)
+		assert.Equal("organization", r.Rule.GroupKind)
+		assert.Equal(`{"value":50,"groupKind":"organization"}`, r.ToRule())
+
+		r = ToPercentRule(RuleGroupPercent, `{"value":50}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+		r = ToPercentRule(RuleUserPercent, `{"value":50,"groupKind":"organization"}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+	})
+
+	t.Run(`rule bucketBy should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		for _, bucketBy := range []string{"uid", "key", "attr:tenantId"} {
+			r := ToPercentRule(RuleUserPercent, `{"value":50,"bucketBy":"`+bucketBy+`"}`)
+			assert.Equal(float64(50), r.Rule.Value)
+			assert.Equal(bucketBy, r.Rule.BucketBy)
+		}
+		assert.Equal("tenantId", RuleBucketByAttr("attr:tenantId"))
+		assert.Equal("", RuleBucketByAttr("uid"))
+
+		r := ToPercentRule(RuleUserPercent, `{"value":50,"bucketBy":"id"}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+		r = ToPercentRule(RuleUserPercent, `{"value":50,"bucketBy":"attr:"}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+		r = ToPercentRule(RuleGroupPercent, `{"value":50,"groupKind":"organization","bucketBy":"uid"}`)
+		assert.Equal(float64(-1), r.Rule.Value)
+	})
+
+	t.Run(`RuleCondition.Match should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		attrs := map[string]string{"seats": "20", "plan": "pro"}
+		assert.True((&RuleCondition{Attr: "seats", Op: RuleOpGte, Values: []string{"20"}}).Match(attrs))
+		assert.True((&RuleCondition{Attr: "seats", Op: RuleOpGt, Values: []string{"9"}}).Match(attrs))
+		assert.False((&RuleCondition{Attr: "seats", Op: RuleOpLt, Values: []string{"9"}}).Match(attrs))
+		assert.True((&RuleCondition{Attr: "seats", Op: RuleOpLte, Values: []string{"20.0"}}).Match(attrs))
+		assert.True((&RuleCondition{Attr: "plan", Op: RuleOpNe, Values: []string{"free"}}).Match(attrs))
+		assert.True((&RuleCondition{Attr: "plan", Op: RuleOpNotIn, Values: []string{"free", "basic"}}).Match(attrs))
+		assert.False((&RuleCondition{Attr: "region", Op: RuleOpNotIn, Values: []string{"cn"}}).Match(attrs))
+	})
+}
+
+func TestRuleBucket(t *testing.T) {
+	createdAt := time.Date(2020, 1, 1, 0, 0, 7, 0, time.UTC)
+
+	t.Run(`legacy bucketing should keep the old population`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		for id := int64(1); id < 1000; id++ {
+			old := int((id + createdAt.Unix()) % 100)
+			bucket := RuleBucket("", createdAt, id, "")
+			assert.Equal(old*100, bucket)
+			for _, p := range []int{1, 10, 50, 99} {
+				assert.Equal(p > 0 && old <= p, RuleHit("", bucket, float64(p)))
+			}
+			assert.False(RuleHit("", bucket, 0))
+		}
+		assert.Equal(RuleBucket("", createdAt, AnonymousRuleID("anon-1"), "anon-1"),
+			RuleBucket("", createdAt, AnonymousRuleID("anon-1"), "other"))
+	})
+
+	t.Run(`hash bucketing should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		salt := NewRuleSalt()
+		assert.Equal(16, len(salt))
+		assert.NotEqual(salt, NewRuleSalt())
+		assert.Equal(RuleBucketingHash, RuleBucketing(salt))
+		assert.Equal(RuleBucketingLegacy, RuleBucketing(""))
+
+		hits := 0
+		same := 0
+		for id := 1; id <= 100000; id++ {
+			key := strconv.Itoa(id)
+			bucket := RuleBucket(salt, createdAt, int64(id), key)
+			assert.True(bucket >= 0 && bucket < RuleBuckets)
+			assert.Equal(bucket, RuleBucket(salt, time.Now(), 0, key))
+			if RuleHit(salt, bucket, 10) {
+				hits++
+			}
+			if RuleHit(salt+"x", RuleBucket(salt+"x", createdAt, int64(id), key), 10) && RuleHit(salt, bucket, 10) {
+				same++
+			}
+		}
+		// 10% 左右的用户命中，不同 salt 的规则之间互不相关（约 1% 同时命中）
+		assert.True(hits > 9500 && hits < 10500, hits)
+		assert.True(same > 800 && same < 1200, same)
+
+		assert.True(RuleHit(salt, 0, 0.01))
+		assert.False(RuleHit(salt, 1, 0.01))
+		assert.True(RuleHit(salt, 9999, 100))
+		assert.False(RuleHit(salt, 0, 0))
+	})
+}
+
+func TestRuleMissBucket(t *testing.T) {
+	t.Run(`RuleMissBucket should agree with RuleHit`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		for _, salt := range []string{"", NewRuleSalt()} {
+			for _, p := range []float64{0, 0.01, 1, 9.5, 10, 50, 99, 99.99, 100} {
+				miss := RuleMissBucket(salt, p)
+				for bucket := 0; bucket < RuleBuckets; bucket++ {
+					assert.Equal(bucket < miss, RuleHit(salt, bucket, p), "salt %q, percent %v, bucket %d", salt, p, bucket)
+				}
+			}
+		}
+	})
+
+	t.Run(`ValidBucketingPercent should reject fractional percent for legacy bucketing`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.True(ValidBucketingPercent("", 10))
+		assert.False(ValidBucketingPercent("", 10.5))
+		assert.False(ValidBucketingPercent("", 0.01))
+		assert.True(ValidBucketingPercent(NewRuleSalt(), 10.5))
+	})
+}
+
+func TestRuleScheduleState(t *testing.T) {
+	t.Run(`RuleScheduleState should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		now := time.Now().UTC()
+		past := now.Add(-time.Hour)
+		future := now.Add(time.Hour)
+
+		assert.Equal(RuleStateActive, RuleScheduleState(nil, nil, now))
+		assert.Equal(RuleStateActive, RuleScheduleState(&past, nil, now))
+		assert.Equal(RuleStateActive, RuleScheduleState(&past, &future, now))
+		assert.Equal(RuleStateActive, RuleScheduleState(&now, &future, now))
+		assert.Equal(RuleStatePending, RuleScheduleState(&future, nil, now))
+		assert.Equal(RuleStateEnded, RuleScheduleState(nil, &past, now))
+		assert.Equal(RuleStateEnded, RuleScheduleState(&past, &now, now))
+	})
+}
+
+func TestLayerExperiment(t *testing.T) {
+	t.Run(`LayerExperiment.Bucket should be mutually exclusive`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		salt := NewRuleSalt()
+		e1 := LayerExperiment{BucketStart: 0, BucketEnd: 5000}
+		e2 := LayerExperiment{BucketStart: 5000, BucketEnd: 7500}
+		assert.Equal(float64(50), e1.Percent())
+		assert.Equal(float64(25), e2.Percent())
+
+		in1, in2 := 0, 0
+		for id := 1; id <= 10000; id++ {
+			key := strconv.Itoa(id)
+			b1, b2 := e1.Bucket(salt, key), e2.Bucket(salt, key)
+			assert.False(b1 >= 0 && b2 >= 0)
+			if b1 >= 0 {
+				in1++
+				assert.True(b1 < RuleBuckets)
+			}
+			if b2 >= 0 {
+				in2++
+				assert.True(b2 < RuleBuckets)
+			}
+		}
+		assert.True(in1 > 4700 && in1 < 5300, in1)
+		assert.True(in2 > 2200 && in2 < 2800, in2)
+	})
+}
+
+func TestSettingDefaultFor(t *testing.T) {
+	t.Run(`Setting.DefaultFor should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		s := Setting{}
+		_, ok := s.DefaultFor("stable", "web")
+		assert.False(ok)
+
+		s.DefaultValue = "a"
+		v, ok := s.DefaultFor("stable", "web")
+		assert.True(ok)
+		assert.Equal("a", v)
+
+		s.DefaultOverrides = `[{"channel":"beta","value":"b"},{"client":"ios","value":"c"},{"channel":"beta","client":"ios","value":"d"}]`
+		v, _ = s.DefaultFor("stable", "web")
+		assert.Equal("a", v)
+		v, _ = s.DefaultFor("beta", "web")
+		assert.Equal("b", v)
+		v, _ = s.DefaultFor("stable", "ios")
+		assert.Equal("c", v)
+		v, _ = s.DefaultFor("beta", "ios")
+		assert.Equal("d", v)
+
+		s.DefaultValue = ""
+		_, ok = s.DefaultFor("stable", "web")
+		assert.False(ok)
+		v, ok = s.DefaultFor("beta", "android")
+		assert.True(ok)
+		assert.Equal("b", v)
+	})
+}
+
+func TestSettingValue(t *testing.T) {
+	t.Run(`TypedSettingValue should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.Equal(`"a"`, string(TypedSettingValue("", "a")))
+		assert.Equal(`true`, string(TypedSettingValue(SettingTypeBool, "true")))
+		assert.Equal(`null`, string(TypedSettingValue(SettingTypeBool, "yes")))
+		assert.Equal(`42`, string(TypedSettingValue(SettingTypeInt, "42")))
+		assert.Equal(`null`, string(TypedSettingValue(SettingTypeInt, "4.2")))
+		assert.Equal(`4.2`, string(TypedSettingValue(SettingTypeFloat, "4.2")))
+		assert.Equal(`{"a":[1,2]}`, string(TypedSettingValue(SettingTypeJSON, `{ "a": [1, 2] }`)))
+		assert.Equal(`null`, string(TypedSettingValue(SettingTypeJSON, `{a}`)))
+	})
+
+	t.Run(`ValidateSettingValue should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.Nil(ValidateSettingValue(SettingTypeInt, "", "-1"))
+		assert.NotNil(ValidateSettingValue("x", "", "1"))
+		assert.NotNil(ValidateSettingValue(SettingTypeInt, `{"unknown":1}`, "1"))
+		assert.NotNil(ValidateSettingValue(SettingTypeInt, `{"type":"int"}`, "1"))
+
+		rangeSchema := `{"minimum":1,"maximum":10}`
+		assert.Nil(ValidateSettingValue(SettingTypeInt, rangeSchema, "1"))
+		assert.Nil(ValidateSettingValue(SettingTypeInt, rangeSchema, "10"))
+		assert.NotNil(ValidateSettingValue(SettingTypeInt, rangeSchema, "0"))
+		assert.NotNil(ValidateSettingValue(SettingTypeFloat, rangeSchema, "10.5"))
+
+		enumSchema := `{"enum":["red","green"]}`
+		assert.Nil(ValidateSettingValue(SettingTypeString, enumSchema, "red"))
+		assert.NotNil(ValidateSettingValue(SettingTypeString, enumSchema, "blue"))
+		assert.NotNil(ValidateSettingValue(SettingTypeString, `{"pattern":"^v\\d+$"}`, "x1"))
+
+		objSchema := `{"type":"object","required":["name"],"additionalProperties":false,
+			"properties":{"name":{"type":"string","minLength":1},"tags":{"type":"array","maxItems":2,"items":{"type":"string"}}}}`
+		assert.Nil(ValidateSettingValue(SettingTypeJSON, objSchema, `{"name":"a","tags":["x"]}`))
+		assert.NotNil(ValidateSettingValue(SettingTypeJSON, objSchema, `{"tags":[]}`))
+		assert.NotNil(ValidateSettingValue(SettingTypeJSON, objSchema, `{"name":""}`))
+		assert.NotNil(ValidateSettingValue(SettingTypeJSON, objSchema, `{"name":"a","other":1}`))
+		assert.NotNil(ValidateSettingValue(SettingTypeJSON, objSchema, `{"name":"a","tags":["x","y","z"]}`))
+		assert.NotNil(ValidateSettingValue(SettingTypeJSON, objSchema, `{"name":"a","tags":[1]}`))
+		assert.NotNil(ValidateSettingValue(SettingTypeJSON, objSchema, `[]`))
+	})
+}
+
+func TestVersion(t *testing.T) {
+	t.Run(`ParseSemver should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		v, err := ParseSemver("v1.2.3-beta.1+build.5")
+		assert.Nil(err)
+		assert.Equal(Semver{Major: 1, Minor: 2, Patch: 3, Pre: []string{"beta", "1"}}, v)
+
+		v, err = ParseSemver("2")
+		assert.Nil(err)
+		assert.Equal(Semver{Major: 2}, v)
+
+		for _, s := range []string{"", "v", "1.2.3.4", "1..2", "01.2", "1.x", "-1", "1.2-", "1.2-a..b"} {
+			_, err = ParseSemver(s)
+			assert.NotNil(err, s)
+		}
+	})
+
+	t.Run(`Semver.Compare should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		cmp := func(a, b string) int {
+			va, err := ParseSemver(a)
+			assert.Nil(err)
+			vb, err := ParseSemver(b)
+			assert.Nil(err)
+			return va.Compare(vb)
+		}
+		assert.Equal(0, cmp("1.2", "1.2.0"))
+		assert.Equal(-1, cmp("1.2.3", "1.10.0"))
+		assert.Equal(1, cmp("2.0.0", "1.99.99"))
+		assert.Equal(-1, cmp("1.0.0-alpha", "1.0.0"))
+		assert.Equal(-1, cmp("1.0.0-alpha", "1.0.0-alpha.1"))
+		assert.Equal(-1, cmp("1.0.0-alpha.2", "1.0.0-alpha.10"))
+		assert.Equal(-1, cmp("1.0.0-1", "1.0.0-alpha"))
+		assert.Equal(1, cmp("1.0.0-beta", "1.0.0-alpha.1"))
+	})
+
+	t.Run(`VersionInRange should work`, func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.True(VersionInRange("", "", ""))
+		assert.True(VersionInRange("x", "", ""))
+		assert.False(VersionInRange("", "1.0", ""))
+		assert.False(VersionInRange("x", "1.0", ""))
+
+		assert.True(VersionInRange("1.0.0", "1.0", "2.0"))
+		assert.True(VersionInRange("2.0.0", "1.0", "2.0"))
+		assert.True(VersionInRange("1.5", "1.0", ""))
+		assert.True(VersionInRange("0.9", "", "1.0"))
+		assert.False(VersionInRange("0.9.9", "1.0", "2.0"))
+		assert.False(VersionInRange("2.0.1", "1.0", "2.0"))
+		assert.False(VersionInRange("1.0.0-rc.1", "1.0.0", ""))
+	})
+}
+
+func TestProductConflictRank(t *testing.T) {
+	assert := assert.New(t)
+
+	latest := Product{}
+	assert.Equal(0, latest.ConflictRank(false, ""))
+	assert.Equal(0, latest.ConflictRank(true, "organization"))
+
+	user := Product{ConflictPolicy: ConflictPolicyUser}
+	assert.Equal(0, user.ConflictRank(false, ""))
+	assert.Equal(1, user.ConflictRank(true, "organization"))
+
+	groupKind := Product{ConflictPolicy: ConflictPolicyGroupKind, GroupKinds: "project,organization"}
+	assert.Equal(0, groupKind.ConflictRank(false, ""))
+	assert.Equal(1, groupKind.ConflictRank(true, "project"))
+	assert.Equal(2, groupKind.ConflictRank(true, "organization"))
+	assert.Equal(3, groupKind.ConflictRank(true, "team"))
+}
+
+func TestRuleRampStepValues(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(ValidPercent(0.01))
+	assert.True(ValidPercent(100))
+	assert.False(ValidPercent(0.005))
+	assert.False(ValidPercent(100.01))
+
+	ramp := RuleRamp{Steps: "0.01,0.5,25,100"}
+	assert.Equal([]float64{0.01, 0.5, 25, 100}, ramp.StepValues())
+}
@@ -11,14 +11,17 @@ const TableLabelRule = "label_rule"
 // LabelRule 详见 ./sql/schema.sql table `label_rule`
 // 环境标签发布规则
 type LabelRule struct {
-	ID        int64     `db:"id" goqu:"skipinsert"`
-	CreatedAt time.Time `db:"created_at" goqu:"skipinsert"`
-	UpdatedAt time.Time `db:"updated_at" goqu:"skipinsert"`
-	ProductID int64     `db:"product_id"` // 所从属的产品线 ID，与环境标签的产品线一致
-	LabelID   int64     `db:"label_id"`   // 规则所指向的环境标签 ID
-	Kind      string    `db:"kind"`       // 规则类型
-	Rule      string    `db:"rule"`       // varchar(1022)，规则值，JSON string，对于 percent 类，其格式为 {"value": percent}
-	Release   int64     `db:"rls"`        // 标签发布（被设置）计数批次
+	ID        int64      `db:"id" goqu:"skipinsert"`
+	CreatedAt time.Time  `db:"created_at" goqu:"skipinsert"`
+	UpdatedAt time.Time  `db:"updated_at" goqu:"skipinsert"`
+	ProductID int64      `db:"product_id"` // 所从属的产品线 ID，与环境标签的产品线一致
+	LabelID   int64      `db:"label_id"`   // 规则所指向的环境标签 ID
+	Kind      string     `db:"kind"`       // 规则类型
+	Rule      string     `db:"rule"`       // varchar(1022)，规则值，JSON string，对于 percent 类，其格式为 {"value": percent}，对于 userAttribute 类，另有 "conditions": [{"attr", "op", "values"}]
+	Release   int64      `db:"rls"`        // 标签发布（被设置）计数批次
+	StartAt   *time.Time `db:"start_at"`   // 规则生效时间，为空则创建后立即生效
+	EndAt     *time.Time `db:"end_at"`     // 规则失效时间，为空则一直有效
+	Salt      string     `db:"salt"`       // varchar(63)，分桶盐值，为空表示 legacy 分桶模式
 }
 
 // TableName retuns table name
@@ -27,6 +30,21 @@ func (LabelRule) TableName() string {
 }
 
 // ToPercent retuns table name
-func (l LabelRule) ToPercent() int {
+func (l LabelRule) ToPercent() float64 {
 	return ToPercentRule(l.Kind, l.Rule).Rule.Value
 }
+
+// ToRuleValue 返回解析后的规则值，规则无效时 Value 为 -1
+func (l LabelRule) ToRuleValue() RuleValue {
+	return ToPercentRule(l.Kind, l.Rule).Rule
+}
+
+// ScheduleState 返回规则在指定时间的生效状态
+func (l LabelRule) ScheduleState(now time.Time) string {
+	return RuleScheduleState(l.StartAt, l.EndAt, now)
+}
+
+// Bucket 返回用户在该规则中的分桶，见 RuleBucket
+func (l LabelRule) Bucket(id int64, key string) int {
+	return RuleBucket(l.Salt, l.CreatedAt, id, key)
+}
@@ -0,0 +1,61 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"time"
+)
+
+// TableLayer is a table name in db.
+const TableLayer = "urbs_layer"
+
+// Layer 详见 ./sql/schema.sql table `urbs_layer`
+// 产品线的实验层，加入同一实验层的环境标签、配置项共享一个分桶空间，用户最多进入其中一个实验
+type Layer struct {
+	ID        int64     `db:"id" json:"-" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt" goqu:"skipinsert"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt" goqu:"skipinsert"`
+	ProductID int64     `db:"product_id" json:"-"`     // 所从属的产品线 ID
+	Name      string    `db:"name" json:"name"`        // varchar(63) 实验层名称，产品线内唯一
+	Desc      string    `db:"description" json:"desc"` // varchar(1022) 实验层描述
+	Salt      string    `db:"salt" json:"-"`           // varchar(63) 实验层分桶盐值
+}
+
+// TableName retuns table name
+func (Layer) TableName() string {
+	return "urbs_layer"
+}
+
+// TableLayerExperiment is a table name in db.
+const TableLayerExperiment = "layer_experiment"
+
+// LayerExperiment 详见 ./sql/schema.sql table `layer_experiment`
+// 加入实验层的环境标签或配置项，占据实验层分桶空间中的 [BucketStart, BucketEnd) 区间
+type LayerExperiment struct {
+	ID          int64     `db:"id" goqu:"skipinsert"`
+	CreatedAt   time.Time `db:"created_at" goqu:"skipinsert"`
+	LayerID     int64     `db:"layer_id"`     // 所属实验层 ID
+	Target      string    `db:"target"`       // varchar(15)，实验对象，TableLabel 或 TableSetting
+	TargetID    int64     `db:"target_id"`    // 实验对象的 ID，一个环境标签或配置项最多加入一个实验层
+	BucketStart int       `db:"bucket_start"` // 分桶区间起点（包含）
+	BucketEnd   int       `db:"bucket_end"`   // 分桶区间终点（不包含）
+}
+
+// TableName retuns table name
+func (LayerExperiment) TableName() string {
+	return "layer_experiment"
+}
+
+// Percent 返回实验占据实验层流量的百分比
+func (e LayerExperiment) Percent() float64 {
+	return float64(e.BucketEnd-e.BucketStart) * 100 / RuleBuckets
+}
+
+// Bucket 计算用户在实验层中的分桶，并映射为其在本实验内的相对分桶 [0, RuleBuckets)，
+// 用户不在本实验的分桶区间内时返回 -1
+func (e LayerExperiment) Bucket(layerSalt, key string) int {
+	bucket := RuleBucket(layerSalt, time.Time{}, 0, key)
+	if bucket < e.BucketStart || bucket >= e.BucketEnd {
+		return -1
+	}
+	return (bucket - e.BucketStart) * RuleBuckets / (e.BucketEnd - e.BucketStart)
+}
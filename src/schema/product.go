@@ -2,6 +2,7 @@ package schema
 
 // schema 模块不要引入官方库以外的其它模块或内部模块
 import (
+	"strings"
 	"time"
 )
 
@@ -19,9 +20,51 @@ type Product struct {
 	Name      string     `db:"name" json:"name"`            // varchar(63) 产品线名称，表内唯一
 	Desc      string     `db:"description" json:"desc"`     // varchar(1022) 产品线描述
 	Status    int64      `db:"status" json:"status"`        // -1 下线弃用，未使用
+	// varchar(15) 用户与群组的配置项指派冲突时的优先策略，为空表示 latest
+	ConflictPolicy string `db:"conflict_policy" json:"conflictPolicy"`
+	// varchar(255) 策略为 groupKind 时群组类型的优先顺序，逗号分隔，靠前的优先
+	GroupKinds string `db:"group_kinds" json:"-"`
 }
 
 // TableName retuns table name
 func (Product) TableName() string {
 	return "urbs_product"
 }
+
+// 用户与群组的配置项指派冲突时的优先策略，同一优先级内最近指派的优先
+const (
+	// ConflictPolicyLatest 最近指派的优先，默认策略
+	ConflictPolicyLatest = "latest"
+	// ConflictPolicyUser 直接指派给用户的优先，其次是群组
+	ConflictPolicyUser = "user"
+	// ConflictPolicyGroupKind 直接指派给用户的优先，其次按 group_kinds 顺序的群组，未列出类型的群组最后
+	ConflictPolicyGroupKind = "groupKind"
+)
+
+// ConflictPolicies 有效的冲突优先策略
+var ConflictPolicies = []string{ConflictPolicyLatest, ConflictPolicyUser, ConflictPolicyGroupKind}
+
+// ConflictRank 返回指派来源在冲突时的优先级，值越小越优先。fromGroup 为 false 表示直接指派给用户，
+// 否则 groupKind 为来源群组的类型。
+func (p Product) ConflictRank(fromGroup bool, groupKind string) int {
+	switch p.ConflictPolicy {
+	case ConflictPolicyUser:
+		if fromGroup {
+			return 1
+		}
+	case ConflictPolicyGroupKind:
+		if fromGroup {
+			kinds := []string{}
+			if p.GroupKinds != "" {
+				kinds = strings.Split(p.GroupKinds, ",")
+			}
+			for i, kind := range kinds {
+				if kind == groupKind {
+					return i + 1
+				}
+			}
+			return len(kinds) + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"time"
+)
+
+// TableRuleBackfill is a table name in db.
+const TableRuleBackfill = "rule_backfill"
+
+const (
+	// BackfillStatusRunning 执行中
+	BackfillStatusRunning = "running"
+	// BackfillStatusCancelled 已取消
+	BackfillStatusCancelled = "cancelled"
+	// BackfillStatusCompleted 已完成
+	BackfillStatusCompleted = "completed"
+	// BackfillStatusFailed 执行失败，如规则已被删除
+	BackfillStatusFailed = "failed"
+)
+
+// RuleBackfill 详见 ./sql/schema.sql table `rule_backfill`
+// 发布规则的回填任务，由后台定时任务按 id 游标分批遍历 urbs_user，将规则应用到命中的用户；
+// groupPercent 规则遍历的是该类型的 urbs_group
+type RuleBackfill struct {
+	ID        int64     `db:"id" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" goqu:"skipinsert"`
+	UpdatedAt time.Time `db:"updated_at" goqu:"skipinsert"`
+	Target    string    `db:"target"`         // 规则所在的表，label_rule 或 setting_rule
+	RuleID    int64     `db:"rule_id"`        // 规则 ID
+	Status    string    `db:"status"`         // 任务状态
+	Cursor    int64     `db:"cursor_id"`      // 已处理的最大用户 ID 或群组 ID
+	Total     int64     `db:"total"`          // 任务创建时的用户或群组总数
+	Processed int64     `db:"processed"`      // 已处理的用户或群组数
+	Applied   int64     `db:"applied"`        // 新写入指派记录的用户或群组数
+	BatchSize int       `db:"batch_size"`     // 每批处理的用户或群组数
+	Interval  int64     `db:"batch_interval"` // 批次间隔毫秒数
+	NextAt    time.Time `db:"next_at"`        // 下一批次的执行时间
+	Message   string    `db:"message"`        // varchar(255)，备注，如失败原因
+}
+
+// TableName retuns table name
+func (RuleBackfill) TableName() string {
+	return "rule_backfill"
+}
+
+// Progress 返回任务进度百分比，仅在任务完成时为 100；新加入的用户可能使已处理数超过总数，此时按 99 计
+func (r RuleBackfill) Progress() int {
+	if r.Status == BackfillStatusCompleted {
+		return 100
+	}
+	if r.Total <= 0 || r.Processed >= r.Total {
+		if r.Processed > 0 {
+			return 99
+		}
+		return 0
+	}
+	return int(r.Processed * 100 / r.Total)
+}
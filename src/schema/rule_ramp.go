@@ -0,0 +1,88 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TableRuleRamp is a table name in db.
+const TableRuleRamp = "rule_ramp"
+
+// TableRuleRampLog is a table name in db.
+const TableRuleRampLog = "rule_ramp_log"
+
+const (
+	// RampStatusRunning 执行中
+	RampStatusRunning = "running"
+	// RampStatusPaused 已暂停
+	RampStatusPaused = "paused"
+	// RampStatusAborted 已终止
+	RampStatusAborted = "aborted"
+	// RampStatusCompleted 已完成
+	RampStatusCompleted = "completed"
+)
+
+const (
+	// RampActionStart ...
+	RampActionStart = "start"
+	// RampActionStep ...
+	RampActionStep = "step"
+	// RampActionPause ...
+	RampActionPause = "pause"
+	// RampActionResume ...
+	RampActionResume = "resume"
+	// RampActionAbort ...
+	RampActionAbort = "abort"
+	// RampActionComplete ...
+	RampActionComplete = "complete"
+)
+
+// RuleRamp 详见 ./sql/schema.sql table `rule_ramp`
+// 百分比规则的渐进发布计划，由后台定时任务按步骤更新规则的百分比
+type RuleRamp struct {
+	ID        int64     `db:"id" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" goqu:"skipinsert"`
+	UpdatedAt time.Time `db:"updated_at" goqu:"skipinsert"`
+	Target    string    `db:"target"`        // 规则所在的表，label_rule 或 setting_rule
+	RuleID    int64     `db:"rule_id"`       // 规则 ID
+	Steps     string    `db:"steps"`         // varchar(255)，百分比步骤，如 "0.1,5,25,100"
+	Interval  int64     `db:"step_interval"` // 步骤间隔秒数
+	Step      int       `db:"step"`          // 已执行的步骤数
+	Status    string    `db:"status"`        // 计划状态
+	NextAt    time.Time `db:"next_at"`       // 下一步骤的执行时间
+}
+
+// TableName retuns table name
+func (RuleRamp) TableName() string {
+	return "rule_ramp"
+}
+
+// StepValues 返回解析后的百分比步骤
+func (r RuleRamp) StepValues() []float64 {
+	vals := make([]float64, 0)
+	for _, s := range strings.Split(r.Steps, ",") {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// RuleRampLog 详见 ./sql/schema.sql table `rule_ramp_log`
+// 渐进发布计划的执行历史
+type RuleRampLog struct {
+	ID        int64     `db:"id" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" goqu:"skipinsert"`
+	RampID    int64     `db:"ramp_id"` // 渐进发布计划 ID
+	Action    string    `db:"action"`  // 执行动作
+	Value     float64   `db:"value"`   // 执行后规则的百分比
+	Release   int64     `db:"rls"`     // 执行后规则的发布批次
+	Message   string    `db:"message"` // varchar(255)，备注
+}
+
+// TableName retuns table name
+func (RuleRampLog) TableName() string {
+	return "rule_ramp_log"
+}
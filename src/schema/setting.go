@@ -2,6 +2,7 @@ package schema
 
 // schema 模块不要引入官方库以外的其它模块或内部模块
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -11,22 +12,91 @@ const TableSetting = "urbs_setting"
 // Setting 详见 ./sql/schema.sql table `urbs_setting`
 // 功能模块的配置项
 type Setting struct {
-	ID        int64      `db:"id" goqu:"skipinsert"`
-	CreatedAt time.Time  `db:"created_at" goqu:"skipinsert"`
-	UpdatedAt time.Time  `db:"updated_at" goqu:"skipinsert"`
-	OfflineAt *time.Time `db:"offline_at"`               // 计划下线时间，用于灰度管理
-	ModuleID  int64      `db:"module_id"`                // 配置项所从属的功能模块 ID
-	Module    string     `db:"module" goqu:"skipinsert"` // 仅为查询方便追加字段，数据库中没有该字段
-	Name      string     `db:"name"`                     // varchar(63) 配置项名称，功能模块内唯一
-	Desc      string     `db:"description"`              // varchar(1022) 配置项描述信息
-	Channels  string     `db:"channels"`                 // varchar(255) 配置项适用的版本通道，未配置表示都适用
-	Clients   string     `db:"clients"`                  // varchar(255) 配置项适用的客户端类型，未配置表示都适用
-	Values    string     `db:"vals"`                     // varchar(1022) 配置项可选值集合
-	Status    int64      `db:"status"`                   // -1 下线弃用，使用用户计数（被动异步计算，非精确值）
-	Release   int64      `db:"rls"`                      // 配置项发布（被设置）计数
+	ID               int64      `db:"id" goqu:"skipinsert"`
+	CreatedAt        time.Time  `db:"created_at" goqu:"skipinsert"`
+	UpdatedAt        time.Time  `db:"updated_at" goqu:"skipinsert"`
+	OfflineAt        *time.Time `db:"offline_at"`               // 计划下线时间，用于灰度管理
+	ModuleID         int64      `db:"module_id"`                // 配置项所从属的功能模块 ID
+	Module           string     `db:"module" goqu:"skipinsert"` // 仅为查询方便追加字段，数据库中没有该字段
+	Name             string     `db:"name"`                     // varchar(63) 配置项名称，功能模块内唯一
+	Desc             string     `db:"description"`              // varchar(1022) 配置项描述信息
+	Channels         string     `db:"channels"`                 // varchar(255) 配置项适用的版本通道，未配置表示都适用
+	Clients          string     `db:"clients"`                  // varchar(255) 配置项适用的客户端类型，未配置表示都适用
+	Values           string     `db:"vals"`                     // varchar(1022) 配置项可选值集合
+	Status           int64      `db:"status"`                   // -1 下线弃用，使用用户计数（被动异步计算，非精确值）
+	Release          int64      `db:"rls"`                      // 配置项发布（被设置）计数
+	PrereqID         int64      `db:"prereq_id"`                // 前置条件配置项 ID，0 表示没有前置条件
+	PrereqValue      string     `db:"prereq_value"`             // varchar(255) 前置条件配置项需取的值
+	DefaultValue     string     `db:"default_value"`            // varchar(255) 未被指派时的默认值，为空表示没有默认值
+	DefaultOverrides string     `db:"default_overrides"`        // varchar(1022) 按 channel、client 覆盖的默认值，JSON 数组
+	ValueType        string     `db:"value_type"`               // varchar(15) 值类型：string、bool、int、float、json，为空表示 string
+	ValueSchema      string     `db:"value_schema"`             // varchar(1022) 值的约束，JSON Schema 的子集，为空表示没有约束
+	MinVersion       string     `db:"min_version"`              // varchar(31) 适用的最低客户端版本（包含），语义化版本，为空表示不限制
+	MaxVersion       string     `db:"max_version"`              // varchar(31) 适用的最高客户端版本（包含），语义化版本，为空表示不限制
 }
 
 // TableName retuns table name
 func (Setting) TableName() string {
 	return "urbs_setting"
 }
+
+// ValidateValue 检查配置项值是否符合配置项的值类型及约束
+func (s Setting) ValidateValue(value string) error {
+	return ValidateSettingValue(s.ValueType, s.ValueSchema, value)
+}
+
+// SettingDefaultOverride 按 channel、client 覆盖的配置项默认值，channel 或 client 为空表示适用所有
+type SettingDefaultOverride struct {
+	Channel string `json:"channel,omitempty"`
+	Client  string `json:"client,omitempty"`
+	Value   string `json:"value"`
+}
+
+// ToDefaultOverrides 解析 default_overrides 字段，解析失败时返回空数组
+func ToDefaultOverrides(s string) []SettingDefaultOverride {
+	res := make([]SettingDefaultOverride, 0)
+	if s != "" {
+		if err := json.Unmarshal([]byte(s), &res); err != nil {
+			return []SettingDefaultOverride{}
+		}
+	}
+	return res
+}
+
+// DefaultOverridesToString 序列化为 default_overrides 字段，没有覆盖时返回空字符串
+func DefaultOverridesToString(overrides []SettingDefaultOverride) string {
+	if len(overrides) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(overrides)
+	return string(b)
+}
+
+// DefaultFor 返回配置项在指定 channel、client 下的默认值，没有默认值时返回 false。
+// 同时匹配 channel 和 client 的覆盖优先，其次是只匹配 client、只匹配 channel 的覆盖，最后是 DefaultValue。
+func (s Setting) DefaultFor(channel, client string) (string, bool) {
+	value, score := s.DefaultValue, 0
+	for _, o := range ToDefaultOverrides(s.DefaultOverrides) {
+		if sc := OverrideScore(o.Channel, o.Client, channel, client); sc > score {
+			value, score = o.Value, sc
+		}
+	}
+	return value, value != ""
+}
+
+// OverrideScore 返回按 channel、client 覆盖的匹配优先级，不匹配时返回 0。
+// 同时匹配 channel 和 client 为 3，只匹配 client 为 2，只匹配 channel 为 1。
+func OverrideScore(overrideChannel, overrideClient, channel, client string) int {
+	if (overrideChannel == "" && overrideClient == "") ||
+		(overrideChannel != "" && overrideChannel != channel) ||
+		(overrideClient != "" && overrideClient != client) {
+		return 0
+	}
+	switch {
+	case overrideChannel != "" && overrideClient != "":
+		return 3
+	case overrideClient != "":
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,26 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"time"
+)
+
+// TableSettingOverride is a table name in db.
+const TableSettingOverride = "setting_override"
+
+// SettingOverride 详见 ./sql/schema.sql table `setting_override`
+// 配置项按 channel、client 覆盖的值，用户通过指派或发布规则获得该配置项后，在匹配的 channel、client 下取覆盖值
+type SettingOverride struct {
+	ID        int64     `db:"id" json:"-" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt" goqu:"skipinsert"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt" goqu:"skipinsert"`
+	SettingID int64     `db:"setting_id" json:"-"`    // 所属配置项 ID
+	Channel   string    `db:"channel" json:"channel"` // varchar(31) 适用的版本通道，为空表示适用所有
+	Client    string    `db:"client" json:"client"`   // varchar(31) 适用的客户端类型，为空表示适用所有
+	Value     string    `db:"value" json:"value"`     // varchar(255) 覆盖值
+}
+
+// TableName retuns table name
+func (SettingOverride) TableName() string {
+	return "setting_override"
+}
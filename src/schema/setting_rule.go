@@ -11,15 +11,18 @@ const TableSettingRule = "setting_rule"
 // SettingRule 详见 ./sql/schema.sql table `setting_rule`
 // 环境标签发布规则
 type SettingRule struct {
-	ID        int64     `db:"id" goqu:"skipinsert"`
-	CreatedAt time.Time `db:"created_at" goqu:"skipinsert"`
-	UpdatedAt time.Time `db:"updated_at" goqu:"skipinsert"`
-	ProductID int64     `db:"product_id"` // 所从属的产品线 ID，与环境标签的产品线一致
-	SettingID int64     `db:"setting_id"` // 规则所指向的环境标签 ID
-	Kind      string    `db:"kind"`       // 规则类型
-	Rule      string    `db:"rule"`       // varchar(1022)，规则值，JSON string，对于 percent 类，其格式为 {"value": percent}
-	Value     string    `db:"value"`      // varchar(255)，配置值
-	Release   int64     `db:"rls"`        // 标签发布（被设置）计数批次
+	ID        int64      `db:"id" goqu:"skipinsert"`
+	CreatedAt time.Time  `db:"created_at" goqu:"skipinsert"`
+	UpdatedAt time.Time  `db:"updated_at" goqu:"skipinsert"`
+	ProductID int64      `db:"product_id"` // 所从属的产品线 ID，与环境标签的产品线一致
+	SettingID int64      `db:"setting_id"` // 规则所指向的环境标签 ID
+	Kind      string     `db:"kind"`       // 规则类型
+	Rule      string     `db:"rule"`       // varchar(1022)，规则值，JSON string，对于 percent 类，其格式为 {"value": percent}，对于 userAttribute 类，另有 "conditions": [{"attr", "op", "values"}]
+	Value     string     `db:"value"`      // varchar(255)，配置值
+	Release   int64      `db:"rls"`        // 标签发布（被设置）计数批次
+	StartAt   *time.Time `db:"start_at"`   // 规则生效时间，为空则创建后立即生效
+	EndAt     *time.Time `db:"end_at"`     // 规则失效时间，为空则一直有效
+	Salt      string     `db:"salt"`       // varchar(63)，分桶盐值，为空表示 legacy 分桶模式
 }
 
 // TableName retuns table name
@@ -28,6 +31,21 @@ func (SettingRule) TableName() string {
 }
 
 // ToPercent retuns table name
-func (l SettingRule) ToPercent() int {
+func (l SettingRule) ToPercent() float64 {
 	return ToPercentRule(l.Kind, l.Rule).Rule.Value
 }
+
+// ToRuleValue 返回解析后的规则值，规则无效时 Value 为 -1
+func (l SettingRule) ToRuleValue() RuleValue {
+	return ToPercentRule(l.Kind, l.Rule).Rule
+}
+
+// ScheduleState 返回规则在指定时间的生效状态
+func (l SettingRule) ScheduleState(now time.Time) string {
+	return RuleScheduleState(l.StartAt, l.EndAt, now)
+}
+
+// Bucket 返回用户在该规则中的分桶，见 RuleBucket
+func (l SettingRule) Bucket(id int64, key string) int {
+	return RuleBucket(l.Salt, l.CreatedAt, id, key)
+}
@@ -0,0 +1,268 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// 配置项的值类型，未设置时为 string
+const (
+	SettingTypeString = "string"
+	SettingTypeBool   = "bool"
+	SettingTypeInt    = "int"
+	SettingTypeFloat  = "float"
+	SettingTypeJSON   = "json"
+)
+
+// SettingTypes ...
+var SettingTypes = []string{SettingTypeString, SettingTypeBool, SettingTypeInt, SettingTypeFloat, SettingTypeJSON}
+
+// ParseSettingValue 按值类型解析配置项值
+func ParseSettingValue(valueType, value string) (interface{}, error) {
+	switch valueType {
+	case "", SettingTypeString:
+		return value, nil
+	case SettingTypeBool:
+		switch value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid bool value: %s", value)
+	case SettingTypeInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int value: %s", value)
+		}
+		return i, nil
+	case SettingTypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("invalid float value: %s", value)
+		}
+		return f, nil
+	case SettingTypeJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("invalid json value: %s", value)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid value type: %s", valueType)
+}
+
+// TypedSettingValue 返回按值类型编码的 JSON 值，值不符合类型时返回 null
+func TypedSettingValue(valueType, value string) json.RawMessage {
+	v, err := ParseSettingValue(valueType, value)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	if valueType == SettingTypeJSON {
+		buf := &bytes.Buffer{}
+		if err := json.Compact(buf, []byte(value)); err == nil {
+			return json.RawMessage(buf.Bytes())
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(b)
+}
+
+// ValidateSettingValue 检查配置项值是否符合值类型及约束，valueSchema 为空表示没有约束
+func ValidateSettingValue(valueType, valueSchema, value string) error {
+	v, err := ParseSettingValue(valueType, value)
+	if err != nil {
+		return err
+	}
+	if valueSchema == "" {
+		return nil
+	}
+	js, err := ParseJSONSchema(valueSchema)
+	if err != nil {
+		return err
+	}
+	// 转换为 JSON 解码后的值，数值统一为 float64
+	b, _ := json.Marshal(v)
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	return js.Validate("value", doc)
+}
+
+// JSONSchema 配置项值的约束，为 JSON Schema 的子集
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"` // object, array, string, number, integer, boolean, null
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var jsonSchemaTypes = []string{"", "object", "array", "string", "number", "integer", "boolean", "null"}
+
+// ParseJSONSchema 解析约束，不支持的关键字会返回错误
+func ParseJSONSchema(s string) (*JSONSchema, error) {
+	js := &JSONSchema{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(js); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	if err := js.compile(); err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+func (js *JSONSchema) compile() error {
+	has := false
+	for _, t := range jsonSchemaTypes {
+		if js.Type == t {
+			has = true
+		}
+	}
+	if !has {
+		return fmt.Errorf("invalid schema type: %s", js.Type)
+	}
+	if js.Pattern != "" {
+		reg, err := regexp.Compile(js.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern: %s", js.Pattern)
+		}
+		js.pattern = reg
+	}
+	for _, p := range js.Properties {
+		if p == nil {
+			continue
+		}
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if js.Items != nil {
+		return js.Items.compile()
+	}
+	return nil
+}
+
+// Validate 检查 JSON 解码后的值 v 是否符合约束，path 用于错误信息
+func (js *JSONSchema) Validate(path string, v interface{}) error {
+	if js.Type != "" && !jsonTypeIs(js.Type, v) {
+		return fmt.Errorf("%s should be %s", path, js.Type)
+	}
+	if len(js.Enum) > 0 {
+		has := false
+		for _, e := range js.Enum {
+			if reflect.DeepEqual(e, v) {
+				has = true
+				break
+			}
+		}
+		if !has {
+			return fmt.Errorf("%s is not in enum", path)
+		}
+	}
+
+	switch val := v.(type) {
+	case float64:
+		if js.Minimum != nil && val < *js.Minimum {
+			return fmt.Errorf("%s should be >= %v", path, *js.Minimum)
+		}
+		if js.Maximum != nil && val > *js.Maximum {
+			return fmt.Errorf("%s should be <= %v", path, *js.Maximum)
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if js.MinLength != nil && n < *js.MinLength {
+			return fmt.Errorf("%s length should be >= %d", path, *js.MinLength)
+		}
+		if js.MaxLength != nil && n > *js.MaxLength {
+			return fmt.Errorf("%s length should be <= %d", path, *js.MaxLength)
+		}
+		if js.pattern != nil && !js.pattern.MatchString(val) {
+			return fmt.Errorf("%s should match %s", path, js.Pattern)
+		}
+	case []interface{}:
+		if js.MinItems != nil && len(val) < *js.MinItems {
+			return fmt.Errorf("%s items should be >= %d", path, *js.MinItems)
+		}
+		if js.MaxItems != nil && len(val) > *js.MaxItems {
+			return fmt.Errorf("%s items should be <= %d", path, *js.MaxItems)
+		}
+		if js.Items != nil {
+			for i, item := range val {
+				if err := js.Items.Validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range js.Required {
+			if _, ok := val[key]; !ok {
+				return fmt.Errorf("%s.%s is required", path, key)
+			}
+		}
+		for key, item := range val {
+			p, ok := js.Properties[key]
+			if !ok {
+				if js.AdditionalProperties != nil && !*js.AdditionalProperties {
+					return fmt.Errorf("%s.%s is not allowed", path, key)
+				}
+				continue
+			}
+			if p != nil {
+				if err := p.Validate(path+"."+key, item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonTypeIs(t string, v interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// TableSettingVariant is a table name in db.
+const TableSettingVariant = "setting_variant"
+
+// SettingVariant 详见 ./sql/schema.sql table `setting_variant`
+// 配置项的变体内容，用户或群组被指派的配置项值为变体名称时，随配置项一起下发该内容
+type SettingVariant struct {
+	ID        int64     `db:"id" json:"-" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt" goqu:"skipinsert"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt" goqu:"skipinsert"`
+	SettingID int64     `db:"setting_id" json:"-"` // 所属配置项 ID
+	Name      string    `db:"name" json:"name"`    // varchar(255) 变体名称，为配置项的可选值之一
+	Payload   string    `db:"payload" json:"-"`    // mediumtext 变体内容，大小受配置 setting_variant.max_payload_size 限制
+	Size      int       `db:"size" json:"size"`    // 变体内容的字节数
+	Hash      string    `db:"hash" json:"hash"`    // varchar(63) 变体内容的 sha256 摘要，客户端可用于判断内容是否变化
+}
+
+// TableName retuns table name
+func (SettingVariant) TableName() string {
+	return "setting_variant"
+}
+
+// PayloadHash 返回变体内容的 sha256 摘要（hex 编码）
+func PayloadHash(payload string) string {
+	sum := sha256.Sum256([]byte(payload))
+	return hex.EncodeToString(sum[:])
+}
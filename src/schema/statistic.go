@@ -36,4 +36,6 @@ const (
 	SettingsTotalSize     StatisticKey = "SettingsTotalSize"
 	LabelRulesTotalSize   StatisticKey = "LabelRulesTotalSize"
 	SettingRulesTotalSize StatisticKey = "SettingRulesTotalSize"
+	// EntityCacheVersion 名称解析缓存的变更版本，产品、功能模块、配置项和标签变更时递增，用于跨实例失效缓存
+	EntityCacheVersion StatisticKey = "EntityCacheVersion"
 )
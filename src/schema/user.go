@@ -37,12 +37,14 @@ func (User) TableName() string {
 
 // MyLabelInfo ...
 type MyLabelInfo struct {
-	ID        int64     `db:"id"`
-	CreatedAt time.Time `db:"created_at"`
-	Name      string    `db:"name"`
-	Channels  string    `db:"channels"`
-	Clients   string    `db:"clients"`
-	Product   string    `db:"product"`
+	ID         int64     `db:"id"`
+	CreatedAt  time.Time `db:"created_at"`
+	Name       string    `db:"name"`
+	Channels   string    `db:"channels"`
+	Clients    string    `db:"clients"`
+	Product    string    `db:"product"`
+	MinVersion string    `db:"min_version"`
+	MaxVersion string    `db:"max_version"`
 }
 
 // UserCache 用于在 User 数据上缓存数据
@@ -53,9 +55,22 @@ type UserCache struct {
 
 // UserCacheLabel 用于在 User 数据上缓存 labels
 type UserCacheLabel struct {
-	Label    string   `json:"l"`
-	Clients  []string `json:"cls,omitempty"`
-	Channels []string `json:"chs,omitempty"`
+	Label      string   `json:"l"`
+	Clients    []string `json:"cls,omitempty"`
+	Channels   []string `json:"chs,omitempty"`
+	MinVersion string   `json:"minv,omitempty"`
+	MaxVersion string   `json:"maxv,omitempty"`
+}
+
+// FilterCacheLabelsByVersion 过滤掉版本范围不包含 version 的环境标签
+func FilterCacheLabelsByVersion(labels []UserCacheLabel, version string) []UserCacheLabel {
+	res := make([]UserCacheLabel, 0, len(labels))
+	for _, l := range labels {
+		if VersionInRange(version, l.MinVersion, l.MaxVersion) {
+			res = append(res, l)
+		}
+	}
+	return res
 }
 
 // UserCacheLabelMap 用于在 User 数据上缓存
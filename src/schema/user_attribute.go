@@ -0,0 +1,25 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"time"
+)
+
+// TableUserAttribute is a table name in db.
+const TableUserAttribute = "user_attribute"
+
+// UserAttribute 详见 ./sql/schema.sql table `user_attribute`
+// 记录用户的属性，如 plan、locale、tenant 等，用于规则匹配
+type UserAttribute struct {
+	ID        int64     `db:"id" json:"-" goqu:"skipinsert"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt" goqu:"skipinsert"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt" goqu:"skipinsert"`
+	UserID    int64     `db:"user_id" json:"-"`   // 用户内部 ID
+	Name      string    `db:"name" json:"name"`   // varchar(63)，属性名
+	Value     string    `db:"value" json:"value"` // varchar(255)，属性值
+}
+
+// TableName retuns table name
+func (UserAttribute) TableName() string {
+	return "user_attribute"
+}
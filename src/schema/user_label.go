@@ -16,4 +16,6 @@ type UserLabel struct {
 	UserID    int64     `db:"user_id"`  // 用户内部 ID
 	LabelID   int64     `db:"label_id"` // 环境标签内部 ID
 	Release   int64     `db:"rls"`      // 标签被设置计数批次
+	RuleID    int64     `db:"rule_id"`  // 由发布规则写入时为规则 ID，直接指派时为 0
+	Bucket    int       `db:"bucket"`   // 由发布规则写入时用户在规则中的分桶
 }
@@ -19,4 +19,6 @@ type UserSetting struct {
 	Value     string    `db:"value"`      // varchar(255)，配置值
 	LastValue string    `db:"last_value"` // varchar(255)，上一次配置值
 	Release   int64     `db:"rls"`        // 配置项被设置计数批次
+	RuleID    int64     `db:"rule_id"`    // 由发布规则写入时为规则 ID，直接指派时为 0
+	Bucket    int       `db:"bucket"`     // 由发布规则写入时用户在规则中的分桶
 }
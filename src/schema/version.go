@@ -0,0 +1,124 @@
+package schema
+
+// schema 模块不要引入官方库以外的其它模块或内部模块
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semver 语义化版本，支持 "1"、"1.2"、"v1.2.3"、"1.2.3-beta.1" 等形式，忽略 "+" 之后的构建信息
+type Semver struct {
+	Major int64
+	Minor int64
+	Patch int64
+	Pre   []string // 预发布标识，如 ["beta", "1"]
+}
+
+// ParseSemver 解析语义化版本
+func ParseSemver(s string) (Semver, error) {
+	v := Semver{}
+	str := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str = str[:i]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		for _, p := range strings.Split(str[i+1:], ".") {
+			if p == "" {
+				return v, fmt.Errorf("invalid version: %s", s)
+			}
+			v.Pre = append(v.Pre, p)
+		}
+		str = str[:i]
+	}
+
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version: %s", s)
+	}
+	nums := []*int64{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') {
+			return v, fmt.Errorf("invalid version: %s", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// Compare 比较两个版本，v 小于、等于、大于 o 时分别返回 -1、0、1。
+// 有预发布标识的版本小于对应的正式版本。
+func (v Semver) Compare(o Semver) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		if c := comparePre(v.Pre[i], o.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(int64(len(v.Pre)), int64(len(o.Pre)))
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePre 数字标识按数值比较，且小于非数字标识；非数字标识按字典序比较
+func comparePre(a, b string) int {
+	na, errA := strconv.ParseInt(a, 10, 64)
+	nb, errB := strconv.ParseInt(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInt(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// VersionInRange 判断 version 是否在 [min, max] 范围内，min、max 为空表示不限制。
+// 设置了范围时，version 为空或不合法视为不满足。
+func VersionInRange(version, min, max string) bool {
+	if min == "" && max == "" {
+		return true
+	}
+	v, err := ParseSemver(version)
+	if err != nil {
+		return false
+	}
+	if min != "" {
+		if m, err := ParseSemver(min); err != nil || v.Compare(m) < 0 {
+			return false
+		}
+	}
+	if max != "" {
+		if m, err := ParseSemver(max); err != nil || v.Compare(m) > 0 {
+			return false
+		}
+	}
+	return true
+}
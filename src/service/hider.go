@@ -11,6 +11,8 @@ func init() {
 	hIDer["setting"] = util.NewHID([]byte("setting" + conf.Config.HIDKey))
 	hIDer["label_rule"] = util.NewHID([]byte("label_rule" + conf.Config.HIDKey))
 	hIDer["setting_rule"] = util.NewHID([]byte("setting_rule" + conf.Config.HIDKey))
+	hIDer["layer_experiment"] = util.NewHID([]byte("layer_experiment" + conf.Config.HIDKey))
+	hIDer["setting_override"] = util.NewHID([]byte("setting_override" + conf.Config.HIDKey))
 }
 
 // HIDer 全局 HID 转换器，目前仅支持 schema.Label,  schema.setting 的 ID 转换。
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
 )
 
 var validIDReg = regexp.MustCompile(`^[0-9A-Za-z._=-]{3,63}$`)
@@ -88,6 +89,7 @@ func (t *UIDURL) Validate() error {
 type UIDAndProductURL struct {
 	UID     string `json:"uid" param:"uid"`
 	Product string `json:"product" query:"product"`
+	Key     string `json:"key" query:"key"` // 可选，用于 bucketBy 为 "key" 的发布规则的分桶 key
 }
 
 // Validate 实现 gear.BodyTemplate。
@@ -98,6 +100,9 @@ func (t *UIDAndProductURL) Validate() error {
 	if t.Product != "" && !validNameReg.MatchString(t.Product) {
 		return gear.ErrBadRequest.WithMsgf("invalid product name: %s", t.Product)
 	}
+	if t.Key != "" && !validIDReg.MatchString(t.Key) {
+		return gear.ErrBadRequest.WithMsgf("invalid key: %s", t.Key)
+	}
 	return nil
 }
 
@@ -209,6 +214,50 @@ func (t *RecallBody) Validate() error {
 	return nil
 }
 
+// ValidateVersion 检查客户端版本，为空表示未指定
+func ValidateVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	if len(version) > 31 {
+		return gear.ErrBadRequest.WithMsgf("version too long: %d (<= 31)", len(version))
+	}
+	if _, err := schema.ParseSemver(version); err != nil {
+		return gear.ErrBadRequest.WithMsg(err.Error())
+	}
+	return nil
+}
+
+// ValidateVersionRange 检查适用的客户端版本范围，min、max 为空表示不限制
+func ValidateVersionRange(min, max string) error {
+	if err := ValidateVersion(min); err != nil {
+		return err
+	}
+	if err := ValidateVersion(max); err != nil {
+		return err
+	}
+	if min != "" && max != "" {
+		a, _ := schema.ParseSemver(min)
+		b, _ := schema.ParseSemver(max)
+		if a.Compare(b) > 0 {
+			return gear.ErrBadRequest.WithMsgf("minVersion %s greater than maxVersion %s", min, max)
+		}
+	}
+	return nil
+}
+
+// validateVersionPtrs 检查更新请求中的版本范围，完整的范围由 bll 合并已有值后检查
+func validateVersionPtrs(min, max *string) error {
+	a, b := "", ""
+	if min != nil {
+		a = *min
+	}
+	if max != nil {
+		b = *max
+	}
+	return ValidateVersionRange(a, b)
+}
+
 // StringToSlice ...
 func StringToSlice(s string) []string {
 	if s == "" {
@@ -0,0 +1,16 @@
+package tpl
+
+// EstimateInfo 指派或发布规则的影响范围预估结果
+type EstimateInfo struct {
+	Total     int64 `json:"total"`     // 规则预估时为用户总数；指派预估时为去重前的用户数，即直接指派的用户数与群组成员数之和
+	Sampled   int64 `json:"sampled"`   // 规则预估时实际参与计算的用户数
+	Affected  int64 `json:"affected"`  // 受影响的用户数，已按群组成员关系去重
+	Overlap   int64 `json:"overlap"`   // 受影响的用户中已被指派（直接或通过群组）该环境标签或配置项的用户数
+	Estimated bool  `json:"estimated"` // 为 true 时 affected 和 overlap 是按抽样结果推算的近似值
+}
+
+// EstimateInfoRes ...
+type EstimateInfoRes struct {
+	SuccessResponseType
+	Result EstimateInfo `json:"result"`
+}
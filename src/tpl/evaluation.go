@@ -0,0 +1,115 @@
+package tpl
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/conf"
+)
+
+// 评估结果中环境标签或配置项的来源
+const (
+	// EvaluationSourceUser 直接指派给用户
+	EvaluationSourceUser = "user"
+	// EvaluationSourceGroup 继承自用户所属的群组
+	EvaluationSourceGroup = "group"
+	// EvaluationSourceRule 命中发布规则（尚未写入指派记录）
+	EvaluationSourceRule = "rule"
+	// EvaluationSourceDefault 未被指派，使用默认值
+	EvaluationSourceDefault = "default"
+)
+
+// UserEvaluationURL ...
+type UserEvaluationURL struct {
+	UID     string `json:"uid" param:"uid"`
+	Product string `json:"product" query:"product"`
+	Channel string `json:"channel" query:"channel"`
+	Client  string `json:"client" query:"client"`
+	Version string `json:"version" query:"version"` // 可选，客户端版本，用于过滤限定了版本范围的配置项
+	Key     string `json:"key" query:"key"`         // 可选，用于 bucketBy 为 "key" 的发布规则的分桶 key
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *UserEvaluationURL) Validate() error {
+	if !validIDReg.MatchString(t.UID) {
+		return gear.ErrBadRequest.WithMsgf("invalid user: %s", t.UID)
+	}
+	if err := ValidateVersion(t.Version); err != nil {
+		return err
+	}
+	if t.Key != "" && !validIDReg.MatchString(t.Key) {
+		return gear.ErrBadRequest.WithMsgf("invalid key: %s", t.Key)
+	}
+	if !validNameReg.MatchString(t.Product) {
+		return gear.ErrBadRequest.WithMsgf("invalid product name: %s", t.Product)
+	}
+	if t.Channel != "" && !StringSliceHas(conf.Config.Channels, t.Channel) {
+		return gear.ErrBadRequest.WithMsgf("invalid channel: %s", t.Channel)
+	}
+	if t.Client != "" && !StringSliceHas(conf.Config.Clients, t.Client) {
+		return gear.ErrBadRequest.WithMsgf("invalid client: %s", t.Client)
+	}
+	return nil
+}
+
+// EvaluationGroup 评估结果来源于群组时的群组信息
+type EvaluationGroup struct {
+	UID  string `json:"uid"`
+	Kind string `json:"kind"`
+}
+
+// EvaluationRule 评估结果来源于发布规则，或指派记录由发布规则写入时的规则信息
+type EvaluationRule struct {
+	HID    string `json:"hid"`
+	Kind   string `json:"kind"`
+	Bucket int    `json:"bucket"` // 用户在规则中的分桶，规则加入实验层时为实验层中的相对分桶
+}
+
+// EvaluationReason ...
+type EvaluationReason struct {
+	Source     string           `json:"source"`
+	Group      *EvaluationGroup `json:"group,omitempty"`
+	Rule       *EvaluationRule  `json:"rule,omitempty"`
+	AssignedAt *time.Time       `json:"assignedAt,omitempty"` // 指派时间，来源为 rule 或 default 时为空
+}
+
+// EvaluatedLabel ...
+type EvaluatedLabel struct {
+	HID     string           `json:"hid"`
+	Label   string           `json:"label"`
+	Release int64            `json:"release"`
+	Reason  EvaluationReason `json:"reason"`
+}
+
+// EvaluatedSetting 与 settings:unionAll 返回的 MySetting 取值一致
+type EvaluatedSetting struct {
+	HID         string            `json:"hid"`
+	Module      string            `json:"module"`
+	Setting     string            `json:"setting"`
+	Value       string            `json:"value"`
+	ValueType   string            `json:"valueType"`
+	TypedValue  json.RawMessage   `json:"typedValue"`
+	Payload     *string           `json:"payload,omitempty"`
+	PayloadHash string            `json:"payloadHash,omitempty"`
+	Release     int64             `json:"release"`
+	Conflicts   []SettingConflict `json:"conflicts,omitempty"`
+	Reason      EvaluationReason  `json:"reason"`
+}
+
+// UserEvaluation 用户在产品线下的环境标签和配置项评估结果
+type UserEvaluation struct {
+	UID      string             `json:"uid"`
+	Product  string             `json:"product"`
+	Channel  string             `json:"channel"`
+	Client   string             `json:"client"`
+	Version  string             `json:"version"`
+	Labels   []EvaluatedLabel   `json:"labels"`   // 空数组也保留
+	Settings []EvaluatedSetting `json:"settings"` // 空数组也保留
+}
+
+// UserEvaluationRes ...
+type UserEvaluationRes struct {
+	SuccessResponseType
+	Result UserEvaluation `json:"result"`
+}
@@ -16,6 +16,9 @@ type LabelBody struct {
 	Desc     string    `json:"desc"`
 	Channels *[]string `json:"channels"`
 	Clients  *[]string `json:"clients"`
+	// 适用的客户端版本范围（包含），为空表示不限制
+	MinVersion string `json:"minVersion"`
+	MaxVersion string `json:"maxVersion"`
 }
 
 // Validate 实现 gear.BodyTemplate。
@@ -52,7 +55,7 @@ func (t *LabelBody) Validate() error {
 			}
 		}
 	}
-	return nil
+	return ValidateVersionRange(t.MinVersion, t.MaxVersion)
 }
 
 // LabelUpdateBody ...
@@ -60,12 +63,15 @@ type LabelUpdateBody struct {
 	Desc     *string   `json:"desc"`
 	Channels *[]string `json:"channels"`
 	Clients  *[]string `json:"clients"`
+	// 空字符串表示移除限制，合并后的版本范围由 bll 检查
+	MinVersion *string `json:"minVersion"`
+	MaxVersion *string `json:"maxVersion"`
 }
 
 // Validate 实现 gear.BodyTemplate。
 func (t *LabelUpdateBody) Validate() error {
-	if t.Desc == nil && t.Channels == nil && t.Clients == nil {
-		return gear.ErrBadRequest.WithMsgf("desc or channels or clients required")
+	if t.Desc == nil && t.Channels == nil && t.Clients == nil && t.MinVersion == nil && t.MaxVersion == nil {
+		return gear.ErrBadRequest.WithMsgf("desc or channels or clients or minVersion or maxVersion required")
 	}
 	if t.Desc != nil && len(*t.Desc) > 1022 {
 		return gear.ErrBadRequest.WithMsgf("desc too long: %d", len(*t.Desc))
@@ -96,7 +102,7 @@ func (t *LabelUpdateBody) Validate() error {
 			}
 		}
 	}
-	return nil
+	return validateVersionPtrs(t.MinVersion, t.MaxVersion)
 }
 
 // ToMap ...
@@ -111,6 +117,12 @@ func (t *LabelUpdateBody) ToMap() map[string]interface{} {
 	if t.Clients != nil {
 		changed["clients"] = strings.Join(*t.Clients, ",")
 	}
+	if t.MinVersion != nil {
+		changed["min_version"] = *t.MinVersion
+	}
+	if t.MaxVersion != nil {
+		changed["max_version"] = *t.MaxVersion
+	}
 	return changed
 }
 
@@ -128,23 +140,28 @@ type LabelInfo struct {
 	CreatedAt time.Time  `json:"createdAt"`
 	UpdatedAt time.Time  `json:"updatedAt"`
 	OfflineAt *time.Time `json:"offlineAt"`
+	// 适用的客户端版本范围，未限制时不返回
+	MinVersion string `json:"minVersion,omitempty"`
+	MaxVersion string `json:"maxVersion,omitempty"`
 }
 
 // LabelInfoFrom create a LabelInfo from schema.Label
 func LabelInfoFrom(label schema.Label, product string) LabelInfo {
 	return LabelInfo{
-		ID:        label.ID,
-		HID:       service.IDToHID(label.ID, "label"),
-		Product:   product,
-		Name:      label.Name,
-		Desc:      label.Desc,
-		Channels:  StringToSlice(label.Channels),
-		Clients:   StringToSlice(label.Clients),
-		Status:    label.Status,
-		Release:   label.Release,
-		CreatedAt: label.CreatedAt,
-		UpdatedAt: label.UpdatedAt,
-		OfflineAt: label.OfflineAt,
+		ID:         label.ID,
+		HID:        service.IDToHID(label.ID, "label"),
+		Product:    product,
+		Name:       label.Name,
+		Desc:       label.Desc,
+		Channels:   StringToSlice(label.Channels),
+		Clients:    StringToSlice(label.Clients),
+		Status:     label.Status,
+		Release:    label.Release,
+		CreatedAt:  label.CreatedAt,
+		UpdatedAt:  label.UpdatedAt,
+		OfflineAt:  label.OfflineAt,
+		MinVersion: label.MinVersion,
+		MaxVersion: label.MaxVersion,
 	}
 }
 
@@ -193,6 +210,65 @@ type CacheLabelsInfoRes struct {
 	Result    []schema.UserCacheLabel `json:"result"`    // 空数组也保留
 }
 
+// MaxCacheLabelsUsers 批量读取用户环境标签时单次最多的用户数
+const MaxCacheLabelsUsers = 100
+
+// UsersCacheLabelsBody 用于网关批量读取用户环境标签
+type UsersCacheLabelsBody struct {
+	Product string            `json:"product"`
+	Users   []string          `json:"users"`
+	Version string            `json:"version"` // 可选，客户端版本，指定时过滤掉版本范围不包含该版本的环境标签
+	Keys    map[string]string `json:"keys"`    // 可选，uid 到分桶 key 的映射，用于 bucketBy 为 "key" 的发布规则，如各用户的设备 ID
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *UsersCacheLabelsBody) Validate() error {
+	if !validNameReg.MatchString(t.Product) {
+		return gear.ErrBadRequest.WithMsgf("invalid product name: %s", t.Product)
+	}
+	if len(t.Users) == 0 {
+		return gear.ErrBadRequest.WithMsg("users emtpy")
+	}
+	if len(t.Users) > MaxCacheLabelsUsers {
+		return gear.ErrBadRequest.WithMsgf("too many users: %d", len(t.Users))
+	}
+	for _, uid := range t.Users {
+		if !validIDReg.MatchString(uid) {
+			return gear.ErrBadRequest.WithMsgf("invalid user: %s", uid)
+		}
+	}
+	if err := ValidateBucketKeys(t.Users, t.Keys); err != nil {
+		return err
+	}
+	return ValidateVersion(t.Version)
+}
+
+// ValidateBucketKeys 校验批量请求中各用户的分桶 key，keys 只能包含 users 中的用户
+func ValidateBucketKeys(users []string, keys map[string]string) error {
+	for uid, key := range keys {
+		if !StringSliceHas(users, uid) {
+			return gear.ErrBadRequest.WithMsgf("key for unknown user: %s", uid)
+		}
+		if !validIDReg.MatchString(key) {
+			return gear.ErrBadRequest.WithMsgf("invalid key: %s", key)
+		}
+	}
+	return nil
+}
+
+// UserCacheLabels 单个用户的环境标签
+type UserCacheLabels struct {
+	UID       string                  `json:"uid"`
+	Timestamp int64                   `json:"timestamp"` // labels 数组生成时间
+	Labels    []schema.UserCacheLabel `json:"labels"`    // 空数组也保留
+}
+
+// UsersCacheLabelsRes ...
+type UsersCacheLabelsRes struct {
+	SuccessResponseType
+	Result []UserCacheLabels `json:"result"` // 按请求的用户顺序，重复的用户只返回一次
+}
+
 // LabelReleaseInfo ...
 type LabelReleaseInfo struct {
 	Release int64    `json:"release"`
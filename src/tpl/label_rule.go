@@ -3,13 +3,154 @@ package tpl
 import (
 	"time"
 
+	"github.com/teambition/gear"
+
 	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/service"
 )
 
+// RuleScheduleBody 规则的生效时间窗口 [startAt, endAt)，均为可选
+type RuleScheduleBody struct {
+	StartAt *time.Time `json:"startAt"`
+	EndAt   *time.Time `json:"endAt"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *RuleScheduleBody) Validate() error {
+	// 统一精确到秒，与 API 文档一致
+	if t.StartAt != nil {
+		*t.StartAt = t.StartAt.UTC().Truncate(time.Second)
+	}
+	if t.EndAt != nil {
+		*t.EndAt = t.EndAt.UTC().Truncate(time.Second)
+	}
+	if t.StartAt != nil && t.EndAt != nil && !t.EndAt.After(*t.StartAt) {
+		return gear.ErrBadRequest.WithMsg("endAt should be after startAt")
+	}
+	return nil
+}
+
+// ToMap 返回需要更新的时间窗口字段
+func (t *RuleScheduleBody) ToMap(startAt, endAt *time.Time) map[string]interface{} {
+	changed := make(map[string]interface{})
+	if !timePtrEqual(t.StartAt, startAt) {
+		changed["start_at"] = t.StartAt
+	}
+	if !timePtrEqual(t.EndAt, endAt) {
+		changed["end_at"] = t.EndAt
+	}
+	return changed
+}
+
+// RuleBucketingBody 规则的分桶模式，可选 "hash"、"legacy"，新建规则默认为 "hash"
+type RuleBucketingBody struct {
+	Bucketing string `json:"bucketing"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *RuleBucketingBody) Validate() error {
+	switch t.Bucketing {
+	case "", schema.RuleBucketingHash, schema.RuleBucketingLegacy:
+		return nil
+	}
+	return gear.ErrBadRequest.WithMsgf("invalid bucketing: %s", t.Bucketing)
+}
+
+// ToSalt 返回规则的分桶盐值，current 为规则当前的盐值，新建规则时为 nil。
+// 未指定 bucketing 或者模式未变化时保持原盐值不变。
+func (t *RuleBucketingBody) ToSalt(current *string) string {
+	switch {
+	case t.Bucketing == schema.RuleBucketingLegacy:
+		return ""
+	case current == nil:
+		return schema.NewRuleSalt()
+	case t.Bucketing == schema.RuleBucketingHash && *current == "":
+		// 由 legacy 模式迁移到 hash 模式，规则覆盖的用户会重新分布
+		return schema.NewRuleSalt()
+	}
+	return *current
+}
+
+// ValidateRulePercent 校验规则的百分比是否适用于分桶盐值 salt 对应的分桶模式
+func ValidateRulePercent(salt string, percent float64) error {
+	if !schema.ValidBucketingPercent(salt, percent) {
+		return gear.ErrBadRequest.WithMsgf("fractional percent %v is not supported for %s bucketing", percent, schema.RuleBucketingLegacy)
+	}
+	return nil
+}
+
+func timePtrEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
 // LabelRuleBody ...
 type LabelRuleBody struct {
 	schema.PercentRule
+	RuleScheduleBody
+	RuleBucketingBody
+	Backfill *RuleBackfillBody `json:"backfill,omitempty"` // 可选，创建或更新规则后对已有用户回填
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *LabelRuleBody) Validate() error {
+	if t.Kind == schema.RuleUserVariant {
+		return gear.ErrBadRequest.WithMsgf("kind %s is not supported for label rule", t.Kind)
+	}
+	if err := validateRule(&t.PercentRule); err != nil {
+		return err
+	}
+	if err := validateGroupRule(&t.PercentRule, &t.RuleScheduleBody, &t.RuleBucketingBody); err != nil {
+		return err
+	}
+	if err := t.RuleBucketingBody.Validate(); err != nil {
+		return err
+	}
+	if t.Bucketing == schema.RuleBucketingLegacy {
+		if err := ValidateRulePercent("", t.Rule.Value); err != nil {
+			return err
+		}
+	}
+	if t.Backfill != nil {
+		if t.Kind == schema.RuleChildLabelUserPercent {
+			return gear.ErrBadRequest.WithMsgf("backfill is not supported for kind %s", t.Kind)
+		}
+		if err := t.Backfill.Validate(); err != nil {
+			return err
+		}
+	}
+	return t.RuleScheduleBody.Validate()
+}
+
+// validateRule 校验 label rule 与 setting rule 共用的规则部分
+func validateRule(r *schema.PercentRule) error {
+	if err := r.Validate(); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	if len(r.ToRule()) > 1022 {
+		return gear.ErrBadRequest.WithMsg("rule too long")
+	}
+	return nil
+}
+
+// validateGroupRule 校验 groupPercent 规则。群组指派在规则创建或更新时由回填任务写入，
+// 因此不支持生效时间窗口与 legacy 分桶
+func validateGroupRule(r *schema.PercentRule, schedule *RuleScheduleBody, bucketing *RuleBucketingBody) error {
+	if r.Kind != schema.RuleGroupPercent {
+		return nil
+	}
+	if !validLabelReg.MatchString(r.Rule.GroupKind) {
+		return gear.ErrBadRequest.WithMsgf("invalid groupKind: %s", r.Rule.GroupKind)
+	}
+	if schedule.StartAt != nil || schedule.EndAt != nil {
+		return gear.ErrBadRequest.WithMsgf("startAt and endAt are not supported for kind %s", r.Kind)
+	}
+	if bucketing.Bucketing == schema.RuleBucketingLegacy {
+		return gear.ErrBadRequest.WithMsgf("legacy bucketing is not supported for kind %s", r.Kind)
+	}
+	return nil
 }
 
 // LabelRuleInfo ...
@@ -20,6 +161,10 @@ type LabelRuleInfo struct {
 	Kind      string      `json:"kind"`
 	Rule      interface{} `json:"rule"`
 	Release   int64       `json:"release"`
+	StartAt   *time.Time  `json:"startAt,omitempty"`
+	EndAt     *time.Time  `json:"endAt,omitempty"`
+	State     string      `json:"state"`
+	Bucketing string      `json:"bucketing"`
 	CreatedAt time.Time   `json:"createdAt"`
 	UpdatedAt time.Time   `json:"updatedAt"`
 }
@@ -33,6 +178,10 @@ func LabelRuleInfoFrom(labelRule schema.LabelRule) LabelRuleInfo {
 		Kind:      labelRule.Kind,
 		Rule:      schema.ToRuleObject(labelRule.Kind, labelRule.Rule),
 		Release:   labelRule.Release,
+		StartAt:   labelRule.StartAt,
+		EndAt:     labelRule.EndAt,
+		State:     labelRule.ScheduleState(time.Now().UTC()),
+		Bucketing: schema.RuleBucketing(labelRule.Salt),
 		CreatedAt: labelRule.CreatedAt,
 		UpdatedAt: labelRule.UpdatedAt,
 	}
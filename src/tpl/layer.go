@@ -0,0 +1,136 @@
+package tpl
+
+import (
+	"math"
+	"time"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+)
+
+// LayerUpdateBody ...
+type LayerUpdateBody struct {
+	Desc *string `json:"desc"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *LayerUpdateBody) Validate() error {
+	if t.Desc == nil {
+		return gear.ErrBadRequest.WithMsgf("desc required")
+	}
+
+	if len(*t.Desc) > 1022 {
+		return gear.ErrBadRequest.WithMsgf("desc too long: %d", len(*t.Desc))
+	}
+	return nil
+}
+
+// ToMap ...
+func (t *LayerUpdateBody) ToMap() map[string]interface{} {
+	changed := make(map[string]interface{})
+	if t.Desc != nil {
+		changed["description"] = *t.Desc
+	}
+	return changed
+}
+
+// LayerExperimentBody 将环境标签或配置项加入实验层，label 与 module + setting 二选一
+type LayerExperimentBody struct {
+	Label   string  `json:"label"`
+	Module  string  `json:"module"`
+	Setting string  `json:"setting"`
+	Percent float64 `json:"percent"` // 实验占据实验层流量的百分比，最多两位小数
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *LayerExperimentBody) Validate() error {
+	if t.Label != "" {
+		if t.Module != "" || t.Setting != "" {
+			return gear.ErrBadRequest.WithMsg("label and setting should not be both provided")
+		}
+		if !validLabelReg.MatchString(t.Label) {
+			return gear.ErrBadRequest.WithMsgf("invalid label: %s", t.Label)
+		}
+	} else {
+		if !validNameReg.MatchString(t.Module) {
+			return gear.ErrBadRequest.WithMsgf("invalid module name: %s", t.Module)
+		}
+		if !validNameReg.MatchString(t.Setting) {
+			return gear.ErrBadRequest.WithMsgf("invalid setting name: %s", t.Setting)
+		}
+	}
+	if size := t.Buckets(); size <= 0 || size > schema.RuleBuckets ||
+		math.Abs(t.Percent*schema.RuleBuckets/100-float64(size)) > 1e-6 {
+		return gear.ErrBadRequest.WithMsgf("invalid percent: %v", t.Percent)
+	}
+	return nil
+}
+
+// Buckets 返回实验需要占据的分桶数
+func (t *LayerExperimentBody) Buckets() int {
+	return int(math.Round(t.Percent * schema.RuleBuckets / 100))
+}
+
+// LayerExperimentInfo ...
+type LayerExperimentInfo struct {
+	ID          int64     `json:"-" db:"id"`
+	HID         string    `json:"hid" db:"-"`
+	Label       string    `json:"label,omitempty" db:"label"`
+	Module      string    `json:"module,omitempty" db:"module"`
+	Setting     string    `json:"setting,omitempty" db:"setting"`
+	Percent     float64   `json:"percent" db:"-"`
+	BucketStart int       `json:"bucketStart" db:"bucket_start"`
+	BucketEnd   int       `json:"bucketEnd" db:"bucket_end"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+}
+
+// Fill 根据查询结果补全 HID 和 Percent
+func (t *LayerExperimentInfo) Fill() {
+	t.HID = service.IDToHID(t.ID, "layer_experiment")
+	t.Percent = float64(t.BucketEnd-t.BucketStart) * 100 / schema.RuleBuckets
+}
+
+// LayerInfo ...
+type LayerInfo struct {
+	schema.Layer
+	FreePercent float64               `json:"freePercent"` // 实验层剩余可分配的流量百分比
+	Experiments []LayerExperimentInfo `json:"experiments"`
+}
+
+// LayerInfoFrom ...
+func LayerInfoFrom(layer schema.Layer, experiments []LayerExperimentInfo) LayerInfo {
+	used := 0
+	for _, e := range experiments {
+		used += e.BucketEnd - e.BucketStart
+	}
+	return LayerInfo{
+		Layer:       layer,
+		FreePercent: float64(schema.RuleBuckets-used) * 100 / schema.RuleBuckets,
+		Experiments: experiments,
+	}
+}
+
+// LayerRes ...
+type LayerRes struct {
+	SuccessResponseType
+	Result schema.Layer `json:"result"` // 空数组也保留
+}
+
+// LayersRes ...
+type LayersRes struct {
+	SuccessResponseType
+	Result []schema.Layer `json:"result"` // 空数组也保留
+}
+
+// LayerInfoRes ...
+type LayerInfoRes struct {
+	SuccessResponseType
+	Result LayerInfo `json:"result"` // 空数组也保留
+}
+
+// LayerExperimentInfoRes ...
+type LayerExperimentInfoRes struct {
+	SuccessResponseType
+	Result LayerExperimentInfo `json:"result"` // 空数组也保留
+}
@@ -105,3 +105,16 @@ func TimeToPageToken(t time.Time) string {
 	}
 	return "t." + service.IDToHID(s)
 }
+
+// DefaultsPageToken 返回 settings:unionAll 追加默认值阶段的 pageToken，afterID 为上一页最后一个默认值配置项的 ID
+func DefaultsPageToken(afterID int64) string {
+	return "d." + service.IDToHID(afterID)
+}
+
+// PageTokenToDefaults 解析 DefaultsPageToken 生成的 pageToken，不是该类 pageToken 时 ok 为 false
+func PageTokenToDefaults(pageToken string) (afterID int64, ok bool) {
+	if !strings.HasPrefix(pageToken, "d.") {
+		return 0, false
+	}
+	return service.HIDToID(pageToken[2:]), true
+}
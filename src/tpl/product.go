@@ -1,6 +1,8 @@
 package tpl
 
 import (
+	"strings"
+
 	"github.com/teambition/gear"
 	"github.com/teambition/urbs-setting/src/schema"
 )
@@ -8,17 +10,38 @@ import (
 // ProductUpdateBody ...
 type ProductUpdateBody struct {
 	Desc *string `json:"desc"`
+	// 用户与群组的配置项指派冲突时的优先策略，及策略为 groupKind 时群组类型的优先顺序
+	ConflictPolicy *string   `json:"conflictPolicy"`
+	GroupKinds     *[]string `json:"groupKinds"`
 }
 
 // Validate 实现 gear.BodyTemplate。
 func (t *ProductUpdateBody) Validate() error {
-	if t.Desc == nil {
-		return gear.ErrBadRequest.WithMsgf("desc required")
+	if t.Desc == nil && t.ConflictPolicy == nil && t.GroupKinds == nil {
+		return gear.ErrBadRequest.WithMsgf("desc or conflictPolicy or groupKinds required")
 	}
 
-	if len(*t.Desc) > 1022 {
+	if t.Desc != nil && len(*t.Desc) > 1022 {
 		return gear.ErrBadRequest.WithMsgf("desc too long: %d", len(*t.Desc))
 	}
+	if t.ConflictPolicy != nil && !StringSliceHas(schema.ConflictPolicies, *t.ConflictPolicy) {
+		return gear.ErrBadRequest.WithMsgf("invalid conflictPolicy: %s", *t.ConflictPolicy)
+	}
+	if t.GroupKinds != nil {
+		if len(*t.GroupKinds) > 10 {
+			return gear.ErrBadRequest.WithMsgf("too many groupKinds: %d", len(*t.GroupKinds))
+		}
+		set := make(map[string]struct{}, len(*t.GroupKinds))
+		for _, kind := range *t.GroupKinds {
+			if !validLabelReg.MatchString(kind) {
+				return gear.ErrBadRequest.WithMsgf("invalid group kind: %s", kind)
+			}
+			if _, ok := set[kind]; ok {
+				return gear.ErrBadRequest.WithMsgf("duplicate group kind: %s", kind)
+			}
+			set[kind] = struct{}{}
+		}
+	}
 	return nil
 }
 
@@ -28,6 +51,12 @@ func (t *ProductUpdateBody) ToMap() map[string]interface{} {
 	if t.Desc != nil {
 		changed["description"] = *t.Desc
 	}
+	if t.ConflictPolicy != nil {
+		changed["conflict_policy"] = *t.ConflictPolicy
+	}
+	if t.GroupKinds != nil {
+		changed["group_kinds"] = strings.Join(*t.GroupKinds, ",")
+	}
 	return changed
 }
 
@@ -49,6 +78,8 @@ type UIDProductURL struct {
 	Pagination
 	UID     string `json:"uid" param:"uid"`
 	Product string `json:"product" query:"product"`
+	Key     string `json:"key" query:"key"`         // 可选，用于 bucketBy 为 "key" 的发布规则的分桶 key，如设备 ID
+	Version string `json:"version" query:"version"` // 可选，客户端版本，指定时过滤掉版本范围不包含该版本的环境标签
 }
 
 // Validate 实现 gear.BodyTemplate。
@@ -59,6 +90,12 @@ func (t *UIDProductURL) Validate() error {
 	if !validNameReg.MatchString(t.Product) {
 		return gear.ErrBadRequest.WithMsgf("invalid product name: %s", t.Product)
 	}
+	if t.Key != "" && !validIDReg.MatchString(t.Key) {
+		return gear.ErrBadRequest.WithMsgf("invalid key: %s", t.Key)
+	}
+	if err := ValidateVersion(t.Version); err != nil {
+		return err
+	}
 
 	if err := t.Pagination.Validate(); err != nil {
 		return err
@@ -202,16 +239,40 @@ func (t *ProductModuleSettingUIDURL) Validate() error {
 	return nil
 }
 
+// ProductInfo ...
+type ProductInfo struct {
+	schema.Product
+	GroupKinds []string `json:"groupKinds"`
+}
+
+// ProductInfoFrom create a ProductInfo from schema.Product
+func ProductInfoFrom(product schema.Product) ProductInfo {
+	info := ProductInfo{Product: product, GroupKinds: StringToSlice(product.GroupKinds)}
+	if info.ConflictPolicy == "" {
+		info.ConflictPolicy = schema.ConflictPolicyLatest
+	}
+	return info
+}
+
+// ProductsInfoFrom create a slice of ProductInfo from a slice of schema.Product
+func ProductsInfoFrom(products []schema.Product) []ProductInfo {
+	res := make([]ProductInfo, len(products))
+	for i, p := range products {
+		res[i] = ProductInfoFrom(p)
+	}
+	return res
+}
+
 // ProductsRes ...
 type ProductsRes struct {
 	SuccessResponseType
-	Result []schema.Product `json:"result"`
+	Result []ProductInfo `json:"result"`
 }
 
 // ProductRes ...
 type ProductRes struct {
 	SuccessResponseType
-	Result schema.Product `json:"result"`
+	Result ProductInfo `json:"result"`
 }
 
 // ProductStatistics ...
@@ -228,3 +289,37 @@ type ProductStatisticsRes struct {
 	SuccessResponseType
 	Result ProductStatistics `json:"result"`
 }
+
+// ProductLayerURL ...
+type ProductLayerURL struct {
+	ProductPaginationURL
+	Layer string `json:"layer" param:"layer"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *ProductLayerURL) Validate() error {
+	if !validNameReg.MatchString(t.Layer) {
+		return gear.ErrBadRequest.WithMsgf("invalid layer name: %s", t.Layer)
+	}
+	if err := t.ProductPaginationURL.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ProductLayerHIDURL ...
+type ProductLayerHIDURL struct {
+	ProductLayerURL
+	HID string `json:"hid" param:"hid"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *ProductLayerHIDURL) Validate() error {
+	if !validHIDReg.MatchString(t.HID) {
+		return gear.ErrBadRequest.WithMsgf("invalid hid: %s", t.HID)
+	}
+	if err := t.ProductLayerURL.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
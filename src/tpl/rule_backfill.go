@@ -0,0 +1,93 @@
+package tpl
+
+import (
+	"time"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+)
+
+// RuleBackfillBody ...
+type RuleBackfillBody struct {
+	BatchSize int    `json:"batchSize"` // 每批处理的用户或群组数，默认 1000
+	Interval  string `json:"interval"`  // 批次间隔，如 "1s"、"10s"，默认 "1s"
+}
+
+// DefaultRuleBackfillBody 返回默认的回填参数，用于 groupPercent 规则自动创建的回填任务
+func DefaultRuleBackfillBody() RuleBackfillBody {
+	return RuleBackfillBody{BatchSize: 1000, Interval: "1s"}
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *RuleBackfillBody) Validate() error {
+	if t.BatchSize == 0 {
+		t.BatchSize = 1000
+	}
+	if t.BatchSize < 1 || t.BatchSize > 5000 {
+		return gear.ErrBadRequest.WithMsgf("batchSize should be in [1, 5000]: %d", t.BatchSize)
+	}
+	if t.Interval == "" {
+		t.Interval = "1s"
+	}
+	du, err := time.ParseDuration(t.Interval)
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid interval: %s", t.Interval)
+	}
+	if du < time.Second || du > time.Hour {
+		return gear.ErrBadRequest.WithMsgf("interval should be in [1s, 1h]: %s", t.Interval)
+	}
+	return nil
+}
+
+// IntervalMilliseconds ...
+func (t *RuleBackfillBody) IntervalMilliseconds() int64 {
+	du, _ := time.ParseDuration(t.Interval)
+	return int64(du / time.Millisecond)
+}
+
+// RuleBackfillInfo ...
+type RuleBackfillInfo struct {
+	ID        int64      `json:"-"`
+	RuleHID   string     `json:"ruleHID"`
+	Status    string     `json:"status"`
+	Total     int64      `json:"total"`     // 任务创建时的用户总数，groupPercent 规则为该类型的群组总数
+	Processed int64      `json:"processed"` // 已处理的用户或群组数
+	Applied   int64      `json:"applied"`   // 新写入指派记录的用户或群组数
+	Progress  int        `json:"progress"`  // 进度百分比
+	BatchSize int        `json:"batchSize"`
+	Interval  string     `json:"interval"`
+	Message   string     `json:"message,omitempty"`
+	NextAt    *time.Time `json:"nextAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// RuleBackfillInfoFrom ...
+func RuleBackfillInfoFrom(backfill schema.RuleBackfill) RuleBackfillInfo {
+	info := RuleBackfillInfo{
+		ID:        backfill.ID,
+		RuleHID:   service.IDToHID(backfill.RuleID, backfill.Target),
+		Status:    backfill.Status,
+		Total:     backfill.Total,
+		Processed: backfill.Processed,
+		Applied:   backfill.Applied,
+		Progress:  backfill.Progress(),
+		BatchSize: backfill.BatchSize,
+		Interval:  (time.Duration(backfill.Interval) * time.Millisecond).String(),
+		Message:   backfill.Message,
+		CreatedAt: backfill.CreatedAt,
+		UpdatedAt: backfill.UpdatedAt,
+	}
+	if backfill.Status == schema.BackfillStatusRunning {
+		nextAt := backfill.NextAt
+		info.NextAt = &nextAt
+	}
+	return info
+}
+
+// RuleBackfillInfoRes ...
+type RuleBackfillInfoRes struct {
+	SuccessResponseType
+	Result RuleBackfillInfo `json:"result"`
+}
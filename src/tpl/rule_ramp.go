@@ -0,0 +1,113 @@
+package tpl
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+)
+
+// RuleRampBody ...
+type RuleRampBody struct {
+	Steps    []float64 `json:"steps"`    // 百分比步骤，最多精确到两位小数，legacy 分桶的规则只支持整数，如 [0.1, 5, 25, 100]
+	Interval string    `json:"interval"` // 步骤间隔，如 "30m"、"24h"
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *RuleRampBody) Validate() error {
+	if len(t.Steps) == 0 || len(t.Steps) > 20 {
+		return gear.ErrBadRequest.WithMsgf("steps should have 1 to 20 items")
+	}
+	for i, v := range t.Steps {
+		if v <= 0 || !schema.ValidPercent(v) {
+			return gear.ErrBadRequest.WithMsgf("invalid step value: %v", v)
+		}
+		if i > 0 && v <= t.Steps[i-1] {
+			return gear.ErrBadRequest.WithMsgf("steps should be increasing: %v", t.Steps)
+		}
+	}
+	du, err := time.ParseDuration(t.Interval)
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid interval: %s", t.Interval)
+	}
+	if du < time.Minute {
+		return gear.ErrBadRequest.WithMsgf("interval should not less than 1m: %s", t.Interval)
+	}
+	return nil
+}
+
+// StepsString ...
+func (t *RuleRampBody) StepsString() string {
+	ss := make([]string, len(t.Steps))
+	for i, v := range t.Steps {
+		ss[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strings.Join(ss, ",")
+}
+
+// IntervalSeconds ...
+func (t *RuleRampBody) IntervalSeconds() int64 {
+	du, _ := time.ParseDuration(t.Interval)
+	return int64(du / time.Second)
+}
+
+// RuleRampLogInfo ...
+type RuleRampLogInfo struct {
+	Action    string    `json:"action"`
+	Value     float64   `json:"value"`
+	Release   int64     `json:"release"`
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RuleRampInfo ...
+type RuleRampInfo struct {
+	ID        int64             `json:"-"`
+	RuleHID   string            `json:"ruleHID"`
+	Steps     []float64         `json:"steps"`
+	Interval  string            `json:"interval"`
+	Step      int               `json:"step"`
+	Status    string            `json:"status"`
+	NextAt    *time.Time        `json:"nextAt,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	History   []RuleRampLogInfo `json:"history"`
+}
+
+// RuleRampInfoFrom ...
+func RuleRampInfoFrom(ramp schema.RuleRamp, logs []schema.RuleRampLog) RuleRampInfo {
+	info := RuleRampInfo{
+		ID:        ramp.ID,
+		RuleHID:   service.IDToHID(ramp.RuleID, ramp.Target),
+		Steps:     ramp.StepValues(),
+		Interval:  (time.Duration(ramp.Interval) * time.Second).String(),
+		Step:      ramp.Step,
+		Status:    ramp.Status,
+		CreatedAt: ramp.CreatedAt,
+		UpdatedAt: ramp.UpdatedAt,
+		History:   make([]RuleRampLogInfo, len(logs)),
+	}
+	if ramp.Status == schema.RampStatusRunning || ramp.Status == schema.RampStatusPaused {
+		nextAt := ramp.NextAt
+		info.NextAt = &nextAt
+	}
+	for i, l := range logs {
+		info.History[i] = RuleRampLogInfo{
+			Action:    l.Action,
+			Value:     l.Value,
+			Release:   l.Release,
+			Message:   l.Message,
+			CreatedAt: l.CreatedAt,
+		}
+	}
+	return info
+}
+
+// RuleRampInfoRes ...
+type RuleRampInfoRes struct {
+	SuccessResponseType
+	Result RuleRampInfo `json:"result"`
+}
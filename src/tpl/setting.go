@@ -1,6 +1,8 @@
 package tpl
 
 import (
+	"bytes"
+	"encoding/json"
 	"strings"
 	"time"
 
@@ -12,11 +14,42 @@ import (
 
 // SettingBody ...
 type SettingBody struct {
-	Name     string    `json:"name"`
-	Desc     string    `json:"desc"`
-	Channels *[]string `json:"channels"`
-	Clients  *[]string `json:"clients"`
-	Values   *[]string `json:"values"`
+	Name         string                   `json:"name"`
+	Desc         string                   `json:"desc"`
+	Channels     *[]string                `json:"channels"`
+	Clients      *[]string                `json:"clients"`
+	Values       *[]string                `json:"values"`
+	Prerequisite *SettingPrerequisiteBody `json:"prerequisite"`
+	// 未被指派时的默认值，及按 channel、client 覆盖的默认值
+	DefaultValue     *string                          `json:"defaultValue"`
+	DefaultOverrides *[]schema.SettingDefaultOverride `json:"defaultOverrides"`
+	// 值类型，默认为 string，及值的约束（JSON Schema 子集）
+	ValueType   string          `json:"valueType"`
+	ValueSchema json.RawMessage `json:"valueSchema"`
+	// 适用的客户端版本范围（包含），为空表示不限制
+	MinVersion string `json:"minVersion"`
+	MaxVersion string `json:"maxVersion"`
+}
+
+// SettingPrerequisiteBody 配置项的前置条件：同一产品线下另一配置项取指定值时，该配置项才生效
+type SettingPrerequisiteBody struct {
+	Module  string `json:"module"`
+	Setting string `json:"setting"`
+	Value   string `json:"value"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *SettingPrerequisiteBody) Validate() error {
+	if !validNameReg.MatchString(t.Module) {
+		return gear.ErrBadRequest.WithMsgf("invalid prerequisite module: %s", t.Module)
+	}
+	if !validNameReg.MatchString(t.Setting) {
+		return gear.ErrBadRequest.WithMsgf("invalid prerequisite setting: %s", t.Setting)
+	}
+	if !validValueReg.MatchString(t.Value) {
+		return gear.ErrBadRequest.WithMsgf("invalid prerequisite value: %s", t.Value)
+	}
+	return nil
 }
 
 // Validate 实现 gear.BodyTemplate。
@@ -66,6 +99,85 @@ func (t *SettingBody) Validate() error {
 			}
 		}
 	}
+	if t.Prerequisite != nil {
+		if err := t.Prerequisite.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateSettingDefaults(t.DefaultValue, t.DefaultOverrides); err != nil {
+		return err
+	}
+	if err := ValidateVersionRange(t.MinVersion, t.MaxVersion); err != nil {
+		return err
+	}
+
+	if t.ValueType == "" {
+		t.ValueType = schema.SettingTypeString
+	}
+	valueSchema, err := ValueSchemaToString(t.ValueSchema)
+	if err != nil {
+		return err
+	}
+	if err := validateSettingType(t.ValueType, t.Values); err != nil {
+		return err
+	}
+	check := func(value string) error {
+		if err := schema.ValidateSettingValue(t.ValueType, valueSchema, value); err != nil {
+			return gear.ErrBadRequest.WithMsg(err.Error())
+		}
+		return nil
+	}
+	if t.Values != nil {
+		for _, value := range *t.Values {
+			if err := check(value); err != nil {
+				return err
+			}
+		}
+	}
+	if t.DefaultValue != nil && *t.DefaultValue != "" {
+		if err := check(*t.DefaultValue); err != nil {
+			return err
+		}
+	}
+	if t.DefaultOverrides != nil {
+		for _, o := range *t.DefaultOverrides {
+			if err := check(o.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValueSchemaToString 检查并压缩配置项值的约束，空值、null 或 {} 表示没有约束
+func ValueSchemaToString(raw json.RawMessage) (string, error) {
+	buf := &bytes.Buffer{}
+	if len(raw) > 0 {
+		if err := json.Compact(buf, raw); err != nil {
+			return "", gear.ErrBadRequest.WithMsgf("invalid valueSchema: %v", err)
+		}
+	}
+	s := buf.String()
+	if s == "" || s == "null" || s == "{}" {
+		return "", nil
+	}
+	if len(s) > 1022 {
+		return "", gear.ErrBadRequest.WithMsgf("valueSchema too long: %d (<= 1022)", len(s))
+	}
+	if _, err := schema.ParseJSONSchema(s); err != nil {
+		return "", gear.ErrBadRequest.WithMsg(err.Error())
+	}
+	return s, nil
+}
+
+// validateSettingType 检查值类型，json 类型的值可能包含逗号，不支持可选值列表
+func validateSettingType(valueType string, values *[]string) error {
+	if !StringSliceHas(schema.SettingTypes, valueType) {
+		return gear.ErrBadRequest.WithMsgf("invalid valueType: %s", valueType)
+	}
+	if valueType == schema.SettingTypeJSON && values != nil && len(*values) > 0 {
+		return gear.ErrBadRequest.WithMsg("values not supported for json valueType")
+	}
 	return nil
 }
 
@@ -75,12 +187,26 @@ type SettingUpdateBody struct {
 	Channels *[]string `json:"channels"`
 	Clients  *[]string `json:"clients"`
 	Values   *[]string `json:"values"`
+	// 前置条件，setting 为空表示移除前置条件
+	Prerequisite *SettingPrerequisiteBody `json:"prerequisite"`
+	// 默认值，空字符串表示移除默认值
+	DefaultValue *string `json:"defaultValue"`
+	// 按 channel、client 覆盖的默认值，空数组表示移除覆盖
+	DefaultOverrides *[]schema.SettingDefaultOverride `json:"defaultOverrides"`
+	// 值类型及约束，已有的值是否符合新类型由 bll 检查
+	ValueType   *string          `json:"valueType"`
+	ValueSchema *json.RawMessage `json:"valueSchema"`
+	// 适用的客户端版本范围，空字符串表示移除限制，合并后的范围由 bll 检查
+	MinVersion *string `json:"minVersion"`
+	MaxVersion *string `json:"maxVersion"`
 }
 
 // Validate 实现 gear.BodyTemplate。
 func (t *SettingUpdateBody) Validate() error {
-	if t.Desc == nil && t.Channels == nil && t.Clients == nil && t.Values == nil {
-		return gear.ErrBadRequest.WithMsgf("desc or channels or clients or values required")
+	if t.Desc == nil && t.Channels == nil && t.Clients == nil && t.Values == nil && t.Prerequisite == nil &&
+		t.DefaultValue == nil && t.DefaultOverrides == nil && t.ValueType == nil && t.ValueSchema == nil &&
+		t.MinVersion == nil && t.MaxVersion == nil {
+		return gear.ErrBadRequest.WithMsgf("desc or channels or clients or values or prerequisite or defaults or valueType or version range required")
 	}
 	if t.Desc != nil && len(*t.Desc) > 1022 {
 		return gear.ErrBadRequest.WithMsgf("desc too long: %d", len(*t.Desc))
@@ -124,6 +250,61 @@ func (t *SettingUpdateBody) Validate() error {
 			}
 		}
 	}
+	if t.Prerequisite != nil && t.Prerequisite.Setting != "" {
+		if err := t.Prerequisite.Validate(); err != nil {
+			return err
+		}
+	}
+	if t.ValueType != nil && !StringSliceHas(schema.SettingTypes, *t.ValueType) {
+		return gear.ErrBadRequest.WithMsgf("invalid valueType: %s", *t.ValueType)
+	}
+	if t.ValueSchema != nil {
+		if _, err := ValueSchemaToString(*t.ValueSchema); err != nil {
+			return err
+		}
+	}
+	if err := validateVersionPtrs(t.MinVersion, t.MaxVersion); err != nil {
+		return err
+	}
+	return validateSettingDefaults(t.DefaultValue, t.DefaultOverrides)
+}
+
+// validateSettingDefaults 检查配置项的默认值及覆盖，是否在可选值列表中由 bll 检查
+func validateSettingDefaults(defaultValue *string, overrides *[]schema.SettingDefaultOverride) error {
+	if defaultValue != nil && *defaultValue != "" {
+		if len(*defaultValue) > 255 || !validValueReg.MatchString(*defaultValue) {
+			return gear.ErrBadRequest.WithMsgf("invalid defaultValue: %s", *defaultValue)
+		}
+	}
+	if overrides == nil {
+		return nil
+	}
+	if len(*overrides) > 10 {
+		return gear.ErrBadRequest.WithMsgf("too many defaultOverrides: %d", len(*overrides))
+	}
+	set := make(map[string]struct{}, len(*overrides))
+	for _, o := range *overrides {
+		if o.Channel == "" && o.Client == "" {
+			return gear.ErrBadRequest.WithMsg("channel or client required for defaultOverrides")
+		}
+		if o.Channel != "" && !StringSliceHas(conf.Config.Channels, o.Channel) {
+			return gear.ErrBadRequest.WithMsgf("invalid channel: %s", o.Channel)
+		}
+		if o.Client != "" && !StringSliceHas(conf.Config.Clients, o.Client) {
+			return gear.ErrBadRequest.WithMsgf("invalid client: %s", o.Client)
+		}
+		if len(o.Value) > 255 || !validValueReg.MatchString(o.Value) {
+			return gear.ErrBadRequest.WithMsgf("invalid value: %s", o.Value)
+		}
+		key := o.Channel + ":" + o.Client
+		if _, ok := set[key]; ok {
+			return gear.ErrBadRequest.WithMsgf("duplicate defaultOverrides: %s", key)
+		}
+		set[key] = struct{}{}
+	}
+	if len(schema.DefaultOverridesToString(*overrides)) > 1022 {
+		return gear.ErrBadRequest.WithMsg("defaultOverrides too long (<= 1022)")
+	}
 	return nil
 }
 
@@ -142,6 +323,24 @@ func (t *SettingUpdateBody) ToMap() map[string]interface{} {
 	if t.Values != nil {
 		changed["vals"] = strings.Join(*t.Values, ",")
 	}
+	if t.DefaultValue != nil {
+		changed["default_value"] = *t.DefaultValue
+	}
+	if t.DefaultOverrides != nil {
+		changed["default_overrides"] = schema.DefaultOverridesToString(*t.DefaultOverrides)
+	}
+	if t.ValueType != nil {
+		changed["value_type"] = *t.ValueType
+	}
+	if t.ValueSchema != nil {
+		changed["value_schema"], _ = ValueSchemaToString(*t.ValueSchema)
+	}
+	if t.MinVersion != nil {
+		changed["min_version"] = *t.MinVersion
+	}
+	if t.MaxVersion != nil {
+		changed["max_version"] = *t.MaxVersion
+	}
 	return changed
 }
 
@@ -161,6 +360,21 @@ type SettingInfo struct {
 	CreatedAt time.Time  `json:"createdAt"`
 	UpdatedAt time.Time  `json:"updatedAt"`
 	OfflineAt *time.Time `json:"offlineAt"`
+	// 前置条件，没有时不返回
+	Prerequisite     *SettingPrerequisite            `json:"prerequisite,omitempty"`
+	DefaultValue     string                          `json:"defaultValue"`
+	DefaultOverrides []schema.SettingDefaultOverride `json:"defaultOverrides"`
+	ValueType        string                          `json:"valueType"`
+	ValueSchema      json.RawMessage                 `json:"valueSchema,omitempty"`
+	// 适用的客户端版本范围，未限制时不返回
+	MinVersion string `json:"minVersion,omitempty"`
+	MaxVersion string `json:"maxVersion,omitempty"`
+}
+
+// SettingPrerequisite 配置项的前置条件
+type SettingPrerequisite struct {
+	SettingHID string `json:"settingHID"`
+	Value      string `json:"value"`
 }
 
 // SettingInfoFrom create a SettingInfo from schema.Setting
@@ -169,22 +383,40 @@ func SettingInfoFrom(setting schema.Setting, product, module string) SettingInfo
 		setting.Module = module
 	}
 
-	return SettingInfo{
-		ID:        setting.ID,
-		HID:       service.IDToHID(setting.ID, "setting"),
-		Product:   product,
-		Module:    setting.Module,
-		Name:      setting.Name,
-		Desc:      setting.Desc,
-		Channels:  StringToSlice(setting.Channels),
-		Clients:   StringToSlice(setting.Clients),
-		Values:    StringToSlice(setting.Values),
-		Status:    setting.Status,
-		Release:   setting.Release,
-		CreatedAt: setting.CreatedAt,
-		UpdatedAt: setting.UpdatedAt,
-		OfflineAt: setting.OfflineAt,
+	info := SettingInfo{
+		ID:               setting.ID,
+		HID:              service.IDToHID(setting.ID, "setting"),
+		Product:          product,
+		Module:           setting.Module,
+		Name:             setting.Name,
+		Desc:             setting.Desc,
+		Channels:         StringToSlice(setting.Channels),
+		Clients:          StringToSlice(setting.Clients),
+		Values:           StringToSlice(setting.Values),
+		Status:           setting.Status,
+		Release:          setting.Release,
+		CreatedAt:        setting.CreatedAt,
+		UpdatedAt:        setting.UpdatedAt,
+		OfflineAt:        setting.OfflineAt,
+		DefaultValue:     setting.DefaultValue,
+		DefaultOverrides: schema.ToDefaultOverrides(setting.DefaultOverrides),
+		ValueType:        setting.ValueType,
+		MinVersion:       setting.MinVersion,
+		MaxVersion:       setting.MaxVersion,
+	}
+	if info.ValueType == "" {
+		info.ValueType = schema.SettingTypeString
+	}
+	if setting.ValueSchema != "" {
+		info.ValueSchema = json.RawMessage(setting.ValueSchema)
+	}
+	if setting.PrereqID > 0 {
+		info.Prerequisite = &SettingPrerequisite{
+			SettingHID: service.IDToHID(setting.PrereqID, "setting"),
+			Value:      setting.PrereqValue,
+		}
 	}
+	return info
 }
 
 // SettingsInfoFrom create a slice of SettingInfo from a slice of schema.Setting
@@ -210,18 +442,46 @@ type SettingInfoRes struct {
 
 // MySetting ...
 type MySetting struct {
-	ID         int64     `json:"-" db:"id"`
-	HID        string    `json:"hid"`
-	Product    string    `json:"product" db:"product"`
-	Module     string    `json:"module" db:"module"`
-	Name       string    `json:"name" db:"name"`
-	Desc       string    `json:"desc" db:"description"`
-	Value      string    `json:"value" db:"value"`
-	LastValue  string    `json:"lastValue" db:"last_value"`
-	Release    int64     `json:"release" db:"rls"`
-	AssignedAt time.Time `json:"assignedAt" db:"assigned_at"`
-	Channels   string    `json:"-" db:"channels"`
-	Clients    string    `json:"-" db:"clients"`
+	ID         int64           `json:"-" db:"id"`
+	HID        string          `json:"hid"`
+	Product    string          `json:"product" db:"product"`
+	Module     string          `json:"module" db:"module"`
+	Name       string          `json:"name" db:"name"`
+	Desc       string          `json:"desc" db:"description"`
+	Value      string          `json:"value" db:"value"`
+	LastValue  string          `json:"lastValue" db:"last_value"`
+	Release    int64           `json:"release" db:"rls"`
+	AssignedAt time.Time       `json:"assignedAt" db:"assigned_at"`
+	Channels   string          `json:"-" db:"channels"`
+	Clients    string          `json:"-" db:"clients"`
+	MinVersion string          `json:"-" db:"min_version"`
+	MaxVersion string          `json:"-" db:"max_version"`
+	Source     string          `json:"source,omitempty"` // 未被指派而返回默认值时为 "default"
+	ValueType  string          `json:"valueType" db:"value_type"`
+	TypedValue json.RawMessage `json:"typedValue"` // 按值类型编码的值，值不符合类型时为 null
+	// 取值为变体名称时下发的变体内容及其 sha256 摘要
+	Payload     *string `json:"payload,omitempty"`
+	PayloadHash string  `json:"payloadHash,omitempty"`
+	// 用户与其群组对配置项的指派冲突时，按产品线的优先策略落选的来源
+	Conflicts []SettingConflict `json:"conflicts,omitempty"`
+}
+
+// SettingConflict 配置项指派冲突中落选的来源
+type SettingConflict struct {
+	Source     string           `json:"source"` // user 或 group
+	Group      *EvaluationGroup `json:"group,omitempty"`
+	Value      string           `json:"value"`
+	AssignedAt time.Time        `json:"assignedAt"`
+}
+
+// SetTypedValues 按值类型填充配置项的 typedValue
+func SetTypedValues(settings []MySetting) {
+	for i := range settings {
+		if settings[i].ValueType == "" {
+			settings[i].ValueType = schema.SettingTypeString
+		}
+		settings[i].TypedValue = schema.TypedSettingValue(settings[i].ValueType, settings[i].Value)
+	}
 }
 
 // MySettingsRes ...
@@ -230,6 +490,12 @@ type MySettingsRes struct {
 	Result []MySetting `json:"result"` // 空数组也保留
 }
 
+// UserMySettings 批量读取时单个用户的配置项
+type UserMySettings struct {
+	UID    string      `json:"uid"`
+	Result []MySetting `json:"result"`
+}
+
 // MySettingsQueryURL ...
 type MySettingsQueryURL struct {
 	Pagination
@@ -240,6 +506,8 @@ type MySettingsQueryURL struct {
 	Setting string `json:"setting" query:"setting"`
 	Channel string `json:"channel" query:"channel"`
 	Client  string `json:"client" query:"client"`
+	Version string `json:"version" query:"version"` // 可选，客户端版本，用于过滤限定了版本范围的配置项
+	Key     string `json:"key" query:"key"`         // 可选，用于 bucketBy 为 "key" 的发布规则的分桶 key
 }
 
 // Validate 实现 gear.BodyTemplate。
@@ -247,6 +515,12 @@ func (t *MySettingsQueryURL) Validate() error {
 	if !validIDReg.MatchString(t.UID) {
 		return gear.ErrBadRequest.WithMsgf("invalid user: %s", t.UID)
 	}
+	if err := ValidateVersion(t.Version); err != nil {
+		return err
+	}
+	if t.Key != "" && !validIDReg.MatchString(t.Key) {
+		return gear.ErrBadRequest.WithMsgf("invalid key: %s", t.Key)
+	}
 	if t.Product != "" && !validNameReg.MatchString(t.Product) {
 		return gear.ErrBadRequest.WithMsgf("invalid product name: %s", t.Product)
 	}
@@ -283,6 +557,9 @@ type SettingReleaseInfo struct {
 	Users   []string `json:"users"`
 	Groups  []string `json:"groups"`
 	Value   string   `json:"value"`
+	// 前置条件不满足而未指派的用户和群组，都满足时不返回
+	SkippedUsers  []string `json:"skippedUsers,omitempty"`
+	SkippedGroups []string `json:"skippedGroups,omitempty"`
 }
 
 // SettingReleaseInfoRes ...
@@ -0,0 +1,76 @@
+package tpl
+
+import (
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/conf"
+	"github.com/teambition/urbs-setting/src/schema"
+	"github.com/teambition/urbs-setting/src/service"
+)
+
+// SettingOverrideBody 创建或更新配置项按 channel、client 覆盖的值，channel 与 client 至少提供一个
+type SettingOverrideBody struct {
+	Channel string `json:"channel"`
+	Client  string `json:"client"`
+	Value   string `json:"value"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *SettingOverrideBody) Validate() error {
+	if t.Channel == "" && t.Client == "" {
+		return gear.ErrBadRequest.WithMsg("channel or client required")
+	}
+	if t.Channel != "" && !StringSliceHas(conf.Config.Channels, t.Channel) {
+		return gear.ErrBadRequest.WithMsgf("invalid channel: %s", t.Channel)
+	}
+	if t.Client != "" && !StringSliceHas(conf.Config.Clients, t.Client) {
+		return gear.ErrBadRequest.WithMsgf("invalid client: %s", t.Client)
+	}
+	if len(t.Value) > 255 || !validValueReg.MatchString(t.Value) {
+		return gear.ErrBadRequest.WithMsgf("invalid value: %s", t.Value)
+	}
+	return nil
+}
+
+// ToMap ...
+func (t *SettingOverrideBody) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"channel": t.Channel,
+		"client":  t.Client,
+		"value":   t.Value,
+	}
+}
+
+// SettingOverrideInfo ...
+type SettingOverrideInfo struct {
+	HID string `json:"hid"`
+	schema.SettingOverride
+}
+
+// SettingOverrideInfoFrom ...
+func SettingOverrideInfoFrom(override schema.SettingOverride) SettingOverrideInfo {
+	return SettingOverrideInfo{
+		HID:             service.IDToHID(override.ID, "setting_override"),
+		SettingOverride: override,
+	}
+}
+
+// SettingOverridesInfoFrom ...
+func SettingOverridesInfoFrom(overrides []schema.SettingOverride) []SettingOverrideInfo {
+	res := make([]SettingOverrideInfo, len(overrides))
+	for i, o := range overrides {
+		res[i] = SettingOverrideInfoFrom(o)
+	}
+	return res
+}
+
+// SettingOverrideInfoRes ...
+type SettingOverrideInfoRes struct {
+	SuccessResponseType
+	Result SettingOverrideInfo `json:"result"`
+}
+
+// SettingOverridesInfoRes ...
+type SettingOverridesInfoRes struct {
+	SuccessResponseType
+	Result []SettingOverrideInfo `json:"result"` // 空数组也保留
+}
@@ -3,6 +3,8 @@ package tpl
 import (
 	"time"
 
+	"github.com/teambition/gear"
+
 	"github.com/teambition/urbs-setting/src/schema"
 	"github.com/teambition/urbs-setting/src/service"
 )
@@ -10,7 +12,49 @@ import (
 // SettingRuleBody ...
 type SettingRuleBody struct {
 	schema.PercentRule
-	Value string `json:"value"`
+	RuleScheduleBody
+	RuleBucketingBody
+	Value    string            `json:"value"`
+	Backfill *RuleBackfillBody `json:"backfill,omitempty"` // 可选，创建或更新规则后对已有用户回填
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *SettingRuleBody) Validate() error {
+	if t.Kind == schema.RuleUserVariant {
+		// 多变量规则的覆盖百分比由各 variant 权重决定，配置值由 variants 给出
+		t.Rule.Value = float64(t.Rule.VariantsWeight())
+		if t.Value != "" {
+			return gear.ErrBadRequest.WithMsgf("value should be empty for kind %s", t.Kind)
+		}
+		for _, v := range t.Rule.Variants {
+			if !validValueReg.MatchString(v.Value) {
+				return gear.ErrBadRequest.WithMsgf("invalid variant value: %s", v.Value)
+			}
+		}
+	}
+	if err := validateRule(&t.PercentRule); err != nil {
+		return err
+	}
+	if err := validateGroupRule(&t.PercentRule, &t.RuleScheduleBody, &t.RuleBucketingBody); err != nil {
+		return err
+	}
+	if t.Value != "" && !validValueReg.MatchString(t.Value) {
+		return gear.ErrBadRequest.WithMsgf("invalid value: %s", t.Value)
+	}
+	if err := t.RuleBucketingBody.Validate(); err != nil {
+		return err
+	}
+	if t.Bucketing == schema.RuleBucketingLegacy {
+		if err := ValidateRulePercent("", t.Rule.Value); err != nil {
+			return err
+		}
+	}
+	if t.Backfill != nil {
+		if err := t.Backfill.Validate(); err != nil {
+			return err
+		}
+	}
+	return t.RuleScheduleBody.Validate()
 }
 
 // SettingRuleInfo ...
@@ -22,6 +66,10 @@ type SettingRuleInfo struct {
 	Rule       interface{} `json:"rule"`
 	Value      string      `json:"value"`
 	Release    int64       `json:"release"`
+	StartAt    *time.Time  `json:"startAt,omitempty"`
+	EndAt      *time.Time  `json:"endAt,omitempty"`
+	State      string      `json:"state"`
+	Bucketing  string      `json:"bucketing"`
 	CreatedAt  time.Time   `json:"createdAt"`
 	UpdatedAt  time.Time   `json:"updatedAt"`
 }
@@ -36,6 +84,10 @@ func SettingRuleInfoFrom(settingRule schema.SettingRule) SettingRuleInfo {
 		Rule:       schema.ToRuleObject(settingRule.Kind, settingRule.Rule),
 		Value:      settingRule.Value,
 		Release:    settingRule.Release,
+		StartAt:    settingRule.StartAt,
+		EndAt:      settingRule.EndAt,
+		State:      settingRule.ScheduleState(time.Now().UTC()),
+		Bucketing:  schema.RuleBucketing(settingRule.Salt),
 		CreatedAt:  settingRule.CreatedAt,
 		UpdatedAt:  settingRule.UpdatedAt,
 	}
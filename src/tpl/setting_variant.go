@@ -0,0 +1,98 @@
+package tpl
+
+import (
+	"github.com/teambition/gear"
+	"github.com/teambition/urbs-setting/src/conf"
+	"github.com/teambition/urbs-setting/src/schema"
+)
+
+// SettingVariantBody ...
+type SettingVariantBody struct {
+	Name    string `json:"name"`    // 变体名称，须为配置项的可选值之一
+	Payload string `json:"payload"` // 变体内容，如 JSON 文档
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *SettingVariantBody) Validate() error {
+	if !validValueReg.MatchString(t.Name) || len(t.Name) > 255 {
+		return gear.ErrBadRequest.WithMsgf("invalid variant name: %s", t.Name)
+	}
+	return validateVariantPayload(t.Payload)
+}
+
+// SettingVariantUpdateBody ...
+type SettingVariantUpdateBody struct {
+	Payload *string `json:"payload"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *SettingVariantUpdateBody) Validate() error {
+	if t.Payload == nil {
+		return gear.ErrBadRequest.WithMsg("payload required")
+	}
+	return validateVariantPayload(*t.Payload)
+}
+
+func validateVariantPayload(payload string) error {
+	if payload == "" {
+		return gear.ErrBadRequest.WithMsg("payload required")
+	}
+	if max := conf.Config.SettingVariant.MaxPayloadSize; len(payload) > max {
+		return gear.ErrBadRequest.WithMsgf("payload too large: %d (<= %d)", len(payload), max)
+	}
+	return nil
+}
+
+// ProductModuleSettingVariantURL ...
+type ProductModuleSettingVariantURL struct {
+	ProductModuleSettingURL
+	Variant string `json:"variant" param:"variant"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *ProductModuleSettingVariantURL) Validate() error {
+	if !validValueReg.MatchString(t.Variant) || len(t.Variant) > 255 {
+		return gear.ErrBadRequest.WithMsgf("invalid variant name: %s", t.Variant)
+	}
+	if err := t.ProductModuleSettingURL.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SettingVariantInfo ...
+type SettingVariantInfo struct {
+	schema.SettingVariant
+	Payload *string `json:"payload,omitempty"` // 列表接口不返回变体内容
+}
+
+// SettingVariantInfoFrom ...
+func SettingVariantInfoFrom(variant schema.SettingVariant, withPayload bool) SettingVariantInfo {
+	info := SettingVariantInfo{SettingVariant: variant}
+	if withPayload {
+		payload := variant.Payload
+		info.Payload = &payload
+	}
+	return info
+}
+
+// SettingVariantsInfoFrom ...
+func SettingVariantsInfoFrom(variants []schema.SettingVariant) []SettingVariantInfo {
+	res := make([]SettingVariantInfo, len(variants))
+	for i, v := range variants {
+		res[i] = SettingVariantInfoFrom(v, false)
+	}
+	return res
+}
+
+// SettingVariantInfoRes ...
+type SettingVariantInfoRes struct {
+	SuccessResponseType
+	Result SettingVariantInfo `json:"result"`
+}
+
+// SettingVariantsInfoRes ...
+type SettingVariantsInfoRes struct {
+	SuccessResponseType
+	Result []SettingVariantInfo `json:"result"` // 空数组也保留
+}
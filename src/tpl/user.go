@@ -23,6 +23,30 @@ func (t *UsersBody) Validate() error {
 	return nil
 }
 
+// UsersURL ...
+type UsersURL struct {
+	Pagination
+	Attr      string `json:"attr" query:"attr"`
+	AttrValue string `json:"attrValue" query:"attrValue"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *UsersURL) Validate() error {
+	if t.Attr != "" && !schema.ValidAttrName(t.Attr) {
+		return gear.ErrBadRequest.WithMsgf("invalid attr: %s", t.Attr)
+	}
+	if t.Attr == "" && t.AttrValue != "" {
+		return gear.ErrBadRequest.WithMsg("attr required with attrValue")
+	}
+	if t.Attr != "" && t.Q != "" {
+		return gear.ErrBadRequest.WithMsg("q can not be used with attr")
+	}
+	if err := t.Pagination.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
 // UsersRes ...
 type UsersRes struct {
 	SuccessResponseType
@@ -49,5 +73,64 @@ func (t *ApplyRulesBody) Validate() error {
 	if t.Kind == "" || !StringSliceHas(schema.RuleKinds, t.Kind) {
 		return gear.ErrBadRequest.WithMsgf("invalid kind: %s", t.Kind)
 	}
+	if t.Kind == schema.RuleGroupPercent {
+		// groupPercent 规则作用于群组，不对用户计算
+		return gear.ErrBadRequest.WithMsgf("kind %s can not be applied to users", t.Kind)
+	}
+	return nil
+}
+
+// UserAttributesBody ...
+type UserAttributesBody struct {
+	UID        string            `json:"uid"`
+	Attributes map[string]string `json:"attributes"` // 属性值为空字符串时删除该属性
+}
+
+// UsersAttributesBody ...
+type UsersAttributesBody struct {
+	Users []UserAttributesBody `json:"users"`
+}
+
+// Validate 实现 gear.BodyTemplate。
+func (t *UsersAttributesBody) Validate() error {
+	if len(t.Users) == 0 {
+		return gear.ErrBadRequest.WithMsg("users emtpy")
+	}
+	if len(t.Users) > 1000 {
+		return gear.ErrBadRequest.WithMsgf("too many users: %d", len(t.Users))
+	}
+	for _, u := range t.Users {
+		if !validIDReg.MatchString(u.UID) {
+			return gear.ErrBadRequest.WithMsgf("invalid user: %s", u.UID)
+		}
+		if len(u.Attributes) == 0 {
+			return gear.ErrBadRequest.WithMsgf("attributes emtpy for user: %s", u.UID)
+		}
+		if len(u.Attributes) > 100 {
+			return gear.ErrBadRequest.WithMsgf("too many attributes for user: %s", u.UID)
+		}
+		for name, value := range u.Attributes {
+			if !schema.ValidAttrName(name) {
+				return gear.ErrBadRequest.WithMsgf("invalid attribute name: %s", name)
+			}
+			if name == schema.AttrUID || name == schema.AttrCreatedAt {
+				return gear.ErrBadRequest.WithMsgf("attribute %s is reserved", name)
+			}
+			if len(value) > 255 {
+				return gear.ErrBadRequest.WithMsgf("attribute %s value too long", name)
+			}
+		}
+	}
 	return nil
 }
+
+// UserAttributesRes ...
+type UserAttributesRes struct {
+	SuccessResponseType
+	Result []schema.UserAttribute `json:"result"` // 空数组也保留
+}
+
+// WatchSettingsEvent 变更通知流中的 settings 事件，客户端收到后应重新读取配置项
+type WatchSettingsEvent struct {
+	Timestamp int64 `json:"timestamp"` // 事件生成时间
+}